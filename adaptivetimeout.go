@@ -0,0 +1,150 @@
+package onet
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultAdaptiveWindow is the number of latencies kept per handler when
+// AdaptiveTimeout.Window is not set.
+const DefaultAdaptiveWindow = 100
+
+// DefaultAdaptiveMinSamples is the number of latencies needed before the
+// timeout of a handler is set, when AdaptiveTimeout.MinSamples is not set.
+const DefaultAdaptiveMinSamples = 20
+
+// AdaptiveTimeout sets the timeout of the requests of each handler from the
+// latencies of its last requests, instead of a static timeout: it is
+// Multiplier times the Percentile of the latencies, e.g. 3 times the p99, so
+// that the hanging requests are caught without tuning the timeout of each
+// handler. As with RegisterHandlerWithTimeout, the context of the handler is
+// cancelled and the request fails as soon as the timeout elapses. Such a
+// request counts as a latency of the timeout, so that the timeout grows when
+// the latencies do.
+//
+// Until a handler has MinSamples latencies, its requests use
+// ServiceProcessor.HandlerTimeout. The handlers registered with
+// RegisterHandlerWithTimeout keep their own timeout, and the streaming
+// handlers have none.
+type AdaptiveTimeout struct {
+	Percentile float64
+	Multiplier float64
+	// Window is the number of the last latencies of a handler that are
+	// kept. If zero, DefaultAdaptiveWindow is used.
+	Window int
+	// MinSamples is the number of latencies needed to set the timeout of a
+	// handler. If zero, DefaultAdaptiveMinSamples is used.
+	MinSamples int
+	// Min and Max, if not zero, bound the timeouts.
+	Min time.Duration
+	Max time.Duration
+
+	latencies     map[string]*latencyWindow
+	latenciesLock sync.Mutex
+}
+
+// NewAdaptiveTimeout returns an AdaptiveTimeout of multiplier times the
+// percentile, between 0 and 1, of the latencies.
+func NewAdaptiveTimeout(percentile, multiplier float64) *AdaptiveTimeout {
+	return &AdaptiveTimeout{Percentile: percentile, Multiplier: multiplier,
+		latencies: make(map[string]*latencyWindow)}
+}
+
+// latencyWindow is a ring of the last latencies of a handler.
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+// Timeout returns the current timeout of the handler of the message msgName,
+// or zero if it doesn't have enough latencies yet.
+func (at *AdaptiveTimeout) Timeout(msgName string) time.Duration {
+	at.latenciesLock.Lock()
+	lw, ok := at.latencies[msgName]
+	var samples []time.Duration
+	if ok {
+		samples = append(samples, lw.samples...)
+	}
+	at.latenciesLock.Unlock()
+
+	minSamples := at.MinSamples
+	if minSamples == 0 {
+		minSamples = DefaultAdaptiveMinSamples
+	}
+	if len(samples) == 0 || len(samples) < minSamples {
+		return 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	i := int(math.Ceil(at.Percentile*float64(len(samples)))) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(samples) {
+		i = len(samples) - 1
+	}
+	timeout := time.Duration(at.Multiplier * float64(samples[i]))
+	if at.Min > 0 && timeout < at.Min {
+		timeout = at.Min
+	}
+	if at.Max > 0 && timeout > at.Max {
+		timeout = at.Max
+	}
+	return timeout
+}
+
+// record adds the latency of a request of the handler of msgName.
+func (at *AdaptiveTimeout) record(msgName string, latency time.Duration) {
+	window := at.Window
+	if window == 0 {
+		window = DefaultAdaptiveWindow
+	}
+	at.latenciesLock.Lock()
+	defer at.latenciesLock.Unlock()
+	if at.latencies == nil {
+		at.latencies = make(map[string]*latencyWindow)
+	}
+	lw, ok := at.latencies[msgName]
+	if !ok {
+		lw = &latencyWindow{}
+		at.latencies[msgName] = lw
+	}
+	if len(lw.samples) < window {
+		lw.samples = append(lw.samples, latency)
+		return
+	}
+	lw.samples[lw.next%len(lw.samples)] = latency
+	lw.next++
+}
+
+// handlerTimeout returns the timeout of a request of the handler of msgName
+// whose own timeout is own: own if it is set, or else the adaptive timeout
+// if there is one.
+func (p *ServiceProcessor) handlerTimeout(msgName string, own time.Duration) time.Duration {
+	if own > 0 || p.AdaptiveTimeout == nil {
+		return own
+	}
+	timeout := p.AdaptiveTimeout.Timeout(msgName)
+	if p.Metrics != nil {
+		p.Metrics.setTimeout(msgName, timeout)
+	}
+	return timeout
+}
+
+// recordLatency adds the latency of a request of the handler of msgName to
+// the AdaptiveTimeout, if any. A request that timed out adds the timeout,
+// a lower bound of its latency, so that the timeout grows with the
+// latencies instead of only keeping the faster requests. A request
+// interrupted otherwise adds nothing.
+func (p *ServiceProcessor) recordLatency(msgName string, start time.Time, timeout time.Duration, ctxErr error) {
+	if p.AdaptiveTimeout == nil {
+		return
+	}
+	switch {
+	case ctxErr == nil:
+		p.AdaptiveTimeout.record(msgName, time.Since(start))
+	case ctxErr == context.DeadlineExceeded && timeout > 0:
+		p.AdaptiveTimeout.record(msgName, timeout)
+	}
+}
@@ -0,0 +1,112 @@
+package onet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+func TestAdaptiveTimeout_Timeout(t *testing.T) {
+	at := NewAdaptiveTimeout(0.9, 3)
+	at.Window = 10
+	at.MinSamples = 5
+
+	for i := 1; i <= 4; i++ {
+		at.record("msg", time.Duration(i)*time.Millisecond)
+	}
+	require.Equal(t, time.Duration(0), at.Timeout("msg"))
+	at.record("msg", 5*time.Millisecond)
+	// the p90 of 1..5ms is 5ms
+	require.Equal(t, 15*time.Millisecond, at.Timeout("msg"))
+	require.Equal(t, time.Duration(0), at.Timeout("other"))
+
+	// the window only keeps the last latencies
+	for i := 0; i < 10; i++ {
+		at.record("msg", time.Millisecond)
+	}
+	require.Equal(t, 3*time.Millisecond, at.Timeout("msg"))
+
+	at.Min = 10 * time.Millisecond
+	require.Equal(t, 10*time.Millisecond, at.Timeout("msg"))
+	at.Min = 0
+	at.Max = 2 * time.Millisecond
+	require.Equal(t, 2*time.Millisecond, at.Timeout("msg"))
+}
+
+func TestServiceProcessor_AdaptiveTimeout(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	p.AdaptiveTimeout = NewAdaptiveTimeout(0.99, 3)
+	p.AdaptiveTimeout.MinSamples = 5
+	p.Metrics = NewProcessorMetrics("")
+
+	hang := make(chan struct{})
+	defer close(hang)
+	require.NoError(t, p.RegisterHandlerWithContext(func(ctx context.Context, msg *testMsg) (*testMsg, error) {
+		if msg.I == 1 {
+			select {
+			case <-hang:
+			case <-ctx.Done():
+			}
+		}
+		return msg, nil
+	}))
+	buf, err := protobuf.Encode(&testMsg{})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, _, err := p.ProcessClientRequest(nil, "testMsg", buf)
+		require.NoError(t, err)
+	}
+	require.True(t, p.AdaptiveTimeout.Timeout("testMsg") > 0)
+
+	// The hanging request fails once the timeout elapses.
+	buf, err = protobuf.Encode(&testMsg{I: 1})
+	require.NoError(t, err)
+	p.AdaptiveTimeout.Min = 50 * time.Millisecond
+	start := time.Now()
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	require.Error(t, err)
+	require.True(t, time.Since(start) < 5*time.Second)
+	require.Equal(t, 0.05, testutil.ToFloat64(p.Metrics.timeout.WithLabelValues("testMsg")))
+}
+
+func TestServiceProcessor_AdaptiveTimeoutGrows(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	p.AdaptiveTimeout = NewAdaptiveTimeout(0.99, 3)
+	p.AdaptiveTimeout.MinSamples = 5
+	p.AdaptiveTimeout.Min = 20 * time.Millisecond
+
+	require.NoError(t, p.RegisterHandlerWithContext(func(ctx context.Context, msg *testMsg) (*testMsg, error) {
+		select {
+		case <-time.After(time.Duration(msg.I) * time.Millisecond):
+		case <-ctx.Done():
+		}
+		return msg, nil
+	}))
+	buf, err := protobuf.Encode(&testMsg{})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, _, err := p.ProcessClientRequest(nil, "testMsg", buf)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 20*time.Millisecond, p.AdaptiveTimeout.Timeout("testMsg"))
+
+	// The latency goes above the timeout, which grows with the requests
+	// that time out until they succeed again.
+	buf, err = protobuf.Encode(&testMsg{I: 100})
+	require.NoError(t, err)
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	require.Error(t, err)
+	for i := 0; i < 5 && err != nil; i++ {
+		_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	}
+	require.NoError(t, err)
+	require.True(t, p.AdaptiveTimeout.Timeout("testMsg") > 100*time.Millisecond)
+}
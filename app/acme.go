@@ -0,0 +1,51 @@
+package app
+
+import (
+	"crypto/tls"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/xerrors"
+)
+
+// ACMEConfig configures the automatic provisioning of the TLS certificate of
+// the websocket with ACME, e.g. by Let's Encrypt. The certificates are
+// obtained on the first connection of a client and renewed before they
+// expire. The ACME server checks the domains with the tls-alpn-01 challenge,
+// so the websocket must be reachable on the port 443 of the domains.
+// - Domains: The DNS names of the conode, the only ones certificates are asked for
+// - CacheDir: The directory where the certificates and the account key are kept
+// - Email: The contact address given to the ACME server, optional
+// - DirectoryURL: The ACME server, Let's Encrypt if empty
+type ACMEConfig struct {
+	Domains      []string `yaml:"Domains"`
+	CacheDir     string   `yaml:"CacheDir"`
+	Email        string   `toml:",omitempty" yaml:"Email,omitempty"`
+	DirectoryURL string   `toml:",omitempty" yaml:"DirectoryURL,omitempty"`
+}
+
+// tlsConfig returns the TLS configuration of the websocket, which gets its
+// certificates from the ACME server.
+func (ac *ACMEConfig) tlsConfig() (*tls.Config, error) {
+	if len(ac.Domains) == 0 {
+		return nil, xerrors.New("no domain")
+	}
+	if ac.CacheDir == "" {
+		return nil, xerrors.New("no cache directory")
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(ac.Domains...),
+		Cache:      autocert.DirCache(ac.CacheDir),
+		Email:      ac.Email,
+	}
+	if ac.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: ac.DirectoryURL}
+	}
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		// Unlike the one of autocert.Manager.TLSConfig, HTTP/2 is not
+		// offered as the websockets need HTTP/1.1.
+		NextProtos: []string{"http/1.1", acme.ALPNProto},
+	}, nil
+}
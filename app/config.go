@@ -3,12 +3,17 @@ package app
 import (
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"go.dedis.ch/kyber/v3"
@@ -24,68 +29,142 @@ import (
 // - Suite: The cryptographic suite
 // - Public: The public key
 // - Private: The Private key
+// - PrivateEncryption: How Private is encrypted, empty if it is in clear, see
+//   EncryptPrivate
 // - Address: The external address of the conode, used by others to connect to this one
 // - ListenAddress: The address this conode is listening on
 // - Description: The description
 // - URL: The URL where this server can be contacted externally.
 // - WebSocketTLSCertificate: TLS certificate for the WebSocket
 // - WebSocketTLSCertificateKey: TLS certificate key for the WebSocket
+// - WebSocketTLSCertificateCA: CA certificate pinned when the certificate or
+//   its key are fetched from an https:// CertificateURL
+// - WebSocketACME: Obtains the TLS certificate of the WebSocket with ACME,
+//   instead of WebSocketTLSCertificate and WebSocketTLSCertificateKey
+// - WebSocketClientAuth: How the WebSocket asks the clients for a TLS
+//   certificate: "request", "require", "verify-if-given" or
+//   "require-and-verify", as the tls.ClientAuthType of the same name, or not
+//   at all if it is empty
+// - WebSocketClientCA: The CA certificates the certificates of the clients are
+//   verified with, needed by "verify-if-given" and "require-and-verify"
+// - WebSocketUpgradeTimeout: The time given to the clients to complete the
+//   websocket handshake, e.g. "10s", no limit if empty
+// - Roles: The roles of the conode, see onet.ServiceProcessor.RequireRoles
+// - AdminToken: The token giving access to the effective configuration on the
+//   /config endpoint of the WebSocket, which is disabled if it is empty
+// - Transport: The timeouts and keepalive of the connections to the other
+//   conodes, see TransportConfig
+// - HealthProbes: Enables the /healthz and /readyz endpoints of the WebSocket,
+//   see onet.Server.HandleHealthProbes
+// - Listener: The backlog and the options of the socket accepting the
+//   connections of the other conodes, see ListenerConfig
 type CothorityConfig struct {
-	Suite                      string
-	Public                     string
-	Services                   map[string]ServiceConfig
-	Private                    string
-	Address                    network.Address
-	ListenAddress              string
-	Description                string
-	URL                        string
-	WebSocketTLSCertificate    CertificateURL
-	WebSocketTLSCertificateKey CertificateURL
+	Suite                      string                   `yaml:"Suite"`
+	Public                     string                   `yaml:"Public"`
+	Services                   map[string]ServiceConfig `yaml:"Services,omitempty"`
+	Private                    string                   `yaml:"Private"`
+	PrivateEncryption          string                   `toml:",omitempty" yaml:"PrivateEncryption,omitempty"`
+	Address                    network.Address          `yaml:"Address"`
+	ListenAddress              string                   `yaml:"ListenAddress"`
+	Description                string                   `yaml:"Description"`
+	URL                        string                   `yaml:"URL"`
+	WebSocketTLSCertificate    CertificateURL           `yaml:"WebSocketTLSCertificate"`
+	WebSocketTLSCertificateKey CertificateURL           `yaml:"WebSocketTLSCertificateKey"`
+	WebSocketTLSCertificateCA  CertificateURL           `toml:",omitempty" yaml:"WebSocketTLSCertificateCA,omitempty"`
+	WebSocketACME              *ACMEConfig              `toml:",omitempty" yaml:"WebSocketACME,omitempty"`
+	WebSocketClientAuth        string                   `toml:",omitempty" yaml:"WebSocketClientAuth,omitempty"`
+	WebSocketClientCA          CertificateURL           `toml:",omitempty" yaml:"WebSocketClientCA,omitempty"`
+	WebSocketUpgradeTimeout    string                   `toml:",omitempty" yaml:"WebSocketUpgradeTimeout,omitempty"`
+	Roles                      []string                 `toml:",omitempty" yaml:"Roles,omitempty"`
+	AdminToken                 string                   `toml:",omitempty" yaml:"AdminToken,omitempty"`
+	Transport                  *TransportConfig         `toml:",omitempty" yaml:"Transport,omitempty"`
+	HealthProbes               bool                     `toml:",omitempty" yaml:"HealthProbes,omitempty"`
+	Listener                   *ListenerConfig          `toml:",omitempty" yaml:"Listener,omitempty"`
 }
 
 // ServiceConfig is the configuration of a specific service to override
 // default parameters as the key pair
 type ServiceConfig struct {
-	Suite   string
-	Public  string
-	Private string
+	Suite   string `yaml:"Suite"`
+	Public  string `yaml:"Public"`
+	Private string `yaml:"Private"`
 }
 
 // Save will save this CothorityConfig to the given file name. It
 // will return an error if the file couldn't be created or if
-// there is an error in the encoding.
+// there is an error in the encoding. The config is written in YAML if the
+// file has a .yaml or .yml extension, and in TOML otherwise. In both cases,
+// the services are sorted by name.
 func (hc *CothorityConfig) Save(file string) error {
+	if isYAML(file) {
+		return hc.SaveYAML(file)
+	}
+	return hc.save(file, func(w io.Writer) error {
+		err := toml.NewEncoder(w).Encode(hc)
+		if err != nil {
+			return xerrors.Errorf("toml encoding: %v", err)
+		}
+		return nil
+	})
+}
+
+// save writes the private key warning to the file, followed by the config
+// written by encode.
+func (hc *CothorityConfig) save(file string, encode func(io.Writer) error) error {
 	fd, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return xerrors.Errorf("opening config file: %v", err)
 	}
+	defer fd.Close()
 	fd.WriteString("# This file contains your private key.\n")
 	fd.WriteString("# Do not give it away lightly!\n")
-	err = toml.NewEncoder(fd).Encode(hc)
+	return encode(fd)
+}
+
+// LoadCothority loads a conode config from the given file, which is decoded
+// as YAML if it has a .yaml or .yml extension, and as TOML otherwise.
+func LoadCothority(file string) (*CothorityConfig, error) {
+	if isYAML(file) {
+		return loadCothorityFile(file, loadCothorityYAML)
+	}
+	return loadCothorityFile(file, loadCothority)
+}
+
+// loadCothorityFile opens the file and decodes it with load.
+func loadCothorityFile(file string, load func(io.Reader) (*CothorityConfig, error)) (*CothorityConfig, error) {
+	f, err := os.Open(file)
 	if err != nil {
-		return xerrors.Errorf("toml encoding: %v", err)
+		return nil, xerrors.Errorf("opening config: %v", err)
 	}
-	return nil
+	defer f.Close()
+	return load(f)
 }
 
-// LoadCothority loads a conode config from the given file.
-func LoadCothority(file string) (*CothorityConfig, error) {
+// loadCothority decodes a conode config from r.
+func loadCothority(r io.Reader) (*CothorityConfig, error) {
 	hc := &CothorityConfig{}
-	_, err := toml.DecodeFile(file, hc)
+	_, err := toml.DecodeReader(r, hc)
 	if err != nil {
 		return nil, xerrors.Errorf("toml decoding: %v", err)
 	}
+	hc.setDefaults()
+	return hc, nil
+}
 
+// setDefaults fills in the fields left empty in the decoded config.
+func (hc *CothorityConfig) setDefaults() {
 	// Backwards compatibility with configs before we included the suite name
 	if hc.Suite == "" {
 		hc.Suite = "Ed25519"
 	}
-	return hc, nil
 }
 
 // GetServerIdentity will convert a CothorityConfig into a *network.ServerIdentity.
 // It can give an error if there is a problem parsing the strings from the CothorityConfig.
 func (hc *CothorityConfig) GetServerIdentity() (*network.ServerIdentity, error) {
+	if hc.PrivateEncryption != "" {
+		return nil, xerrors.New("private key is encrypted")
+	}
 	suite, err := suites.Find(hc.Suite)
 	if err != nil {
 		return nil, xerrors.Errorf("kyber suite: %v", err)
@@ -104,7 +183,7 @@ func (hc *CothorityConfig) GetServerIdentity() (*network.ServerIdentity, error)
 	si.SetPrivate(private)
 	si.Description = hc.Description
 	si.ServiceIdentities = parseServiceConfig(hc.Services)
-	if hc.WebSocketTLSCertificateKey != "" {
+	if hc.WebSocketTLSCertificateKey != "" || hc.WebSocketACME != nil {
 		if hc.URL != "" {
 			si.URL = strings.Replace(hc.URL, "http://", "https://", 0)
 		} else {
@@ -112,7 +191,7 @@ func (hc *CothorityConfig) GetServerIdentity() (*network.ServerIdentity, error)
 			if err != nil {
 				return nil, xerrors.Errorf("port conversion: %v")
 			}
-			si.URL = fmt.Sprintf("https://%s:%d", si.Address.Host(), p+1)
+			si.URL = "https://" + net.JoinHostPort(si.Address.Host(), strconv.Itoa(p+1))
 		}
 	} else {
 		si.URL = hc.URL
@@ -123,24 +202,131 @@ func (hc *CothorityConfig) GetServerIdentity() (*network.ServerIdentity, error)
 
 // ParseCothority parses the config file into a CothorityConfig.
 // It returns the CothorityConfig, the Host so we can already use it, and an error if
-// the file is inaccessible or has wrong values in it.
+// the file is inaccessible or has wrong values in it. An encrypted private key
+// is decrypted with the passphrase of the PassphraseEnv environment variable.
 func ParseCothority(file string) (*CothorityConfig, *onet.Server, error) {
 	hc, err := LoadCothority(file)
 	if err != nil {
 		return nil, nil, xerrors.Errorf("reading config: %v", err)
 	}
+	return parseCothority(hc, passphraseFromEnv())
+}
+
+// ParseCothorityWithPassphrase is like ParseCothority, but decrypts the
+// private key with the given passphrase instead of the one of PassphraseEnv.
+func ParseCothorityWithPassphrase(file, passphrase string) (*CothorityConfig, *onet.Server, error) {
+	hc, err := LoadCothority(file)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("reading config: %v", err)
+	}
+	return parseCothority(hc, passphrase)
+}
+
+// ParseCothorityReader is like ParseCothority, but reads the config from r,
+// e.g. when it is given in an environment variable instead of a file.
+func ParseCothorityReader(r io.Reader) (*CothorityConfig, *onet.Server, error) {
+	hc, err := loadCothority(r)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("reading config: %v", err)
+	}
+	return parseCothority(hc, passphraseFromEnv())
+}
+
+// ValidateCothority is like ParseCothority, but only checks the config file
+// and returns its ServerIdentity, without creating the server, so that
+// nothing listens or is reserved. The certificates are still read, to check
+// them.
+func ValidateCothority(file string) (*CothorityConfig, *network.ServerIdentity, error) {
+	return ValidateCothorityWithPassphrase(file, passphraseFromEnv())
+}
+
+// ValidateCothorityWithPassphrase is like ValidateCothority, but decrypts the
+// private key with the given passphrase instead of the one of PassphraseEnv.
+func ValidateCothorityWithPassphrase(file, passphrase string) (*CothorityConfig, *network.ServerIdentity, error) {
+	hc, err := LoadCothority(file)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("reading config: %v", err)
+	}
+	setup, err := hc.validate(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hc, setup.si, nil
+}
+
+// cothoritySetup holds what is needed to create the server of a
+// CothorityConfig, once it is validated.
+type cothoritySetup struct {
+	suite          suites.Suite
+	si             *network.ServerIdentity
+	listenAddress  string
+	upgradeTimeout time.Duration
+	// tlsConfig of the WebSocket, nil if it doesn't use TLS
+	tlsConfig  *tls.Config
+	clientAuth tls.ClientAuthType
+	clientCAs  *x509.CertPool
+	transport  network.TransportConfig
+	listen     network.ListenConfig
+}
+
+// validate checks the config, decrypting its private key with the passphrase
+// if needed, and returns the setup of its server.
+func (hc *CothorityConfig) validate(passphrase string) (*cothoritySetup, error) {
 	suite, err := suites.Find(hc.Suite)
 	if err != nil {
-		return nil, nil, xerrors.Errorf("kyber suite: %v", err)
+		return nil, xerrors.Errorf("kyber suite: %v", err)
 	}
+	setup := &cothoritySetup{suite: suite}
 
-	si, err := hc.GetServerIdentity()
+	setup.listenAddress, err = hc.checkAddresses()
 	if err != nil {
-		return nil, nil, xerrors.Errorf("parse server identity: %v", err)
+		return nil, err
 	}
 
-	// Same as `NewServerTCP` if `hc.ListenAddress` is empty
-	server := onet.NewServerTCPWithListenAddr(si, suite, hc.ListenAddress)
+	decrypted := *hc
+	if err := decrypted.DecryptPrivate(passphrase); err != nil {
+		return nil, xerrors.Errorf("decrypting private key: %v", err)
+	}
+	setup.si, err = decrypted.GetServerIdentity()
+	if err != nil {
+		return nil, xerrors.Errorf("parse server identity: %v", err)
+	}
+
+	if hc.WebSocketUpgradeTimeout != "" {
+		setup.upgradeTimeout, err = time.ParseDuration(hc.WebSocketUpgradeTimeout)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing WebSocketUpgradeTimeout: %v", err)
+		}
+	}
+
+	if hc.WebSocketACME != nil {
+		if hc.WebSocketTLSCertificate != "" || hc.WebSocketTLSCertificateKey != "" {
+			return nil, xerrors.New("WebSocketACME cannot be used with a WebSocketTLSCertificate")
+		}
+		setup.tlsConfig, err = hc.WebSocketACME.tlsConfig()
+		if err != nil {
+			return nil, xerrors.Errorf("WebSocketACME: %v", err)
+		}
+	}
+
+	setup.clientAuth, setup.clientCAs, err = hc.clientAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	if hc.Transport != nil {
+		setup.transport, err = hc.Transport.config()
+		if err != nil {
+			return nil, xerrors.Errorf("Transport: %v", err)
+		}
+	}
+
+	if hc.Listener != nil {
+		setup.listen, err = hc.Listener.config()
+		if err != nil {
+			return nil, xerrors.Errorf("Listener: %v", err)
+		}
+	}
 
 	// Set Websocket TLS if possible
 	if hc.WebSocketTLSCertificate != "" && hc.WebSocketTLSCertificateKey != "" {
@@ -154,41 +340,250 @@ func ParseCothority(file string) (*CothorityConfig, *onet.Server, error) {
 				hc.WebSocketTLSCertificateKey.blobPart(),
 			)
 			if err != nil {
-				return nil, nil, xerrors.Errorf("certificate: %v", err)
+				return nil, xerrors.Errorf("certificate: %v", err)
 			}
 
-			server.WebSocket.Lock()
-			server.WebSocket.TLSConfig = &tls.Config{
+			setup.tlsConfig = &tls.Config{
 				GetCertificate: cr.GetCertificateFunc(),
 			}
-			server.WebSocket.Unlock()
 		} else {
-			tlsCertificate, err := hc.WebSocketTLSCertificate.Content()
+			rootCAs, err := hc.certificateRootCAs()
 			if err != nil {
-				return nil, nil, xerrors.Errorf("getting WebSocketTLSCertificate content: %v", err)
+				return nil, xerrors.Errorf("getting WebSocketTLSCertificateCA: %v", err)
 			}
-			tlsCertificateKey, err := hc.WebSocketTLSCertificateKey.Content()
+			tlsCertificate, err := hc.WebSocketTLSCertificate.ContentWithRootCAs(rootCAs)
 			if err != nil {
-				return nil, nil, xerrors.Errorf("getting WebSocketTLSCertificateKey content: %v", err)
+				return nil, xerrors.Errorf("getting WebSocketTLSCertificate content: %v", err)
+			}
+			tlsCertificateKey, err := hc.WebSocketTLSCertificateKey.ContentWithRootCAs(rootCAs)
+			if err != nil {
+				return nil, xerrors.Errorf("getting WebSocketTLSCertificateKey content: %v", err)
 			}
 			cert, err := tls.X509KeyPair(tlsCertificate, tlsCertificateKey)
 			if err != nil {
-				return nil, nil, xerrors.Errorf("loading X509KeyPair: %v", err)
+				return nil, xerrors.Errorf("loading X509KeyPair: %v", err)
 			}
 
-			server.WebSocket.Lock()
-			server.WebSocket.TLSConfig = &tls.Config{
+			setup.tlsConfig = &tls.Config{
 				Certificates: []tls.Certificate{cert},
 			}
-			server.WebSocket.Unlock()
 		}
 	}
+	return setup, nil
+}
+
+// parseCothority creates the server of the config, decrypting its private key
+// with the passphrase if needed. The returned config keeps the key encrypted.
+func parseCothority(hc *CothorityConfig, passphrase string) (*CothorityConfig, *onet.Server, error) {
+	setup, err := hc.validate(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Same as `NewServerTCP` if `hc.ListenAddress` is empty
+	server, err := onet.NewServerTCPWithListenConfig(setup.si, setup.suite,
+		setup.listenAddress, setup.listen)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("creating server: %v", err)
+	}
+	server.SetRoles(hc.Roles...)
+	err = server.Router.SetTransport(setup.transport)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("Transport: %v", err)
+	}
+	server.WebSocket.Lock()
+	server.WebSocket.UpgradeTimeout = setup.upgradeTimeout
+	if setup.tlsConfig != nil {
+		server.WebSocket.TLSConfig = setup.tlsConfig
+	}
+	if setup.clientAuth != tls.NoClientCert || setup.clientCAs != nil {
+		server.WebSocket.TLSConfig.ClientAuth = setup.clientAuth
+		server.WebSocket.TLSConfig.ClientCAs = setup.clientCAs
+	}
+	server.WebSocket.Unlock()
+	server.HandleConfig(hc.AdminToken, func() interface{} {
+		return hc.Effective(server)
+	})
+	if hc.HealthProbes {
+		server.HandleHealthProbes()
+	}
 	return hc, server, nil
 }
 
+// clientAuthTypes maps the values of WebSocketClientAuth to the TLS client
+// authentication modes.
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify-if-given":    tls.VerifyClientCertIfGiven,
+	"require-and-verify": tls.RequireAndVerifyClientCert,
+}
+
+// clientAuth returns the TLS client authentication mode of the WebSocket and
+// the pool of the CAs verifying the client certificates, nil if there is no
+// WebSocketClientCA.
+func (hc *CothorityConfig) clientAuth() (tls.ClientAuthType, *x509.CertPool, error) {
+	auth, ok := clientAuthTypes[hc.WebSocketClientAuth]
+	if !ok {
+		return tls.NoClientCert, nil, xerrors.Errorf("unknown WebSocketClientAuth '%s'",
+			hc.WebSocketClientAuth)
+	}
+	if auth == tls.NoClientCert && hc.WebSocketClientCA == "" {
+		return auth, nil, nil
+	}
+
+	if hc.WebSocketACME == nil &&
+		(hc.WebSocketTLSCertificate == "" || hc.WebSocketTLSCertificateKey == "") {
+		return tls.NoClientCert, nil, xerrors.New("client certificates need a TLS WebSocket")
+	}
+	// The ACME server doesn't give a certificate when it checks the domains.
+	if hc.WebSocketACME != nil &&
+		(auth == tls.RequireAnyClientCert || auth == tls.RequireAndVerifyClientCert) {
+		return tls.NoClientCert, nil, xerrors.Errorf("WebSocketClientAuth '%s' cannot be used with WebSocketACME",
+			hc.WebSocketClientAuth)
+	}
+
+	if hc.WebSocketClientCA == "" {
+		if auth == tls.VerifyClientCertIfGiven || auth == tls.RequireAndVerifyClientCert {
+			return tls.NoClientCert, nil, xerrors.Errorf("WebSocketClientAuth '%s' needs a WebSocketClientCA",
+				hc.WebSocketClientAuth)
+		}
+		return auth, nil, nil
+	}
+	rootCAs, err := hc.certificateRootCAs()
+	if err != nil {
+		return tls.NoClientCert, nil, xerrors.Errorf("getting WebSocketTLSCertificateCA: %v", err)
+	}
+	ca, err := hc.WebSocketClientCA.ContentWithRootCAs(rootCAs)
+	if err != nil {
+		return tls.NoClientCert, nil, xerrors.Errorf("getting WebSocketClientCA content: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return tls.NoClientCert, nil, xerrors.New("no certificate found in WebSocketClientCA")
+	}
+	return auth, pool, nil
+}
+
+// redacted replaces the secrets in the effective configuration.
+const redacted = "<redacted>"
+
+// Effective returns the configuration the server, created from hc by
+// ParseCothority, runs with: the defaults are applied, the values changed at
+// runtime, such as the roles, are the current ones, and the secrets are
+// redacted.
+func (hc *CothorityConfig) Effective(server *onet.Server) *CothorityConfig {
+	ec := *hc
+	ec.Private = redacted
+	if ec.AdminToken != "" {
+		ec.AdminToken = redacted
+	}
+	if hc.Services != nil {
+		ec.Services = make(map[string]ServiceConfig, len(hc.Services))
+	}
+	for name, sc := range hc.Services {
+		if sc.Private != "" {
+			sc.Private = redacted
+		}
+		ec.Services[name] = sc
+	}
+	if ec.WebSocketTLSCertificateKey.CertificateURLType() == String {
+		ec.WebSocketTLSCertificateKey = CertificateURL(String + typeCertificateURLSep + redacted)
+	}
+
+	ec.Description = server.ServerIdentity.Description
+	ec.URL = server.ServerIdentity.URL
+	ec.Roles = server.Roles()
+	server.WebSocket.Lock()
+	ec.WebSocketUpgradeTimeout = ""
+	if server.WebSocket.UpgradeTimeout > 0 {
+		ec.WebSocketUpgradeTimeout = server.WebSocket.UpgradeTimeout.String()
+	}
+	server.WebSocket.Unlock()
+	return &ec
+}
+
+// checkAddresses checks that Address and ListenAddress can be used by a TCP
+// server, so that the mistakes are reported with the faulty field instead of
+// failing when listening. It returns the ListenAddress to give to the server,
+// which can be written with the connection type of Address, e.g.
+// tls://127.0.0.1:7770.
+func (hc *CothorityConfig) checkAddresses() (string, error) {
+	if err := checkAddress(hc.Address, 1); err != nil {
+		return "", xerrors.Errorf("Address %q: %v", hc.Address, err)
+	}
+	listen := hc.ListenAddress
+	if listen == "" {
+		return "", nil
+	}
+	if strings.Contains(listen, "://") {
+		la := network.Address(listen)
+		if err := checkAddress(la, 0); err != nil {
+			return "", xerrors.Errorf("ListenAddress %q: %v", listen, err)
+		}
+		if la.ConnType() != hc.Address.ConnType() {
+			return "", xerrors.Errorf("ListenAddress %q: connection type %s doesn't match the one of Address (%s)",
+				listen, la.ConnType(), hc.Address.ConnType())
+		}
+		return la.NetworkAddress(), nil
+	}
+	hostPort := listen
+	if host, ok := network.ParseHost(listen); ok {
+		// only the host, the port is the one of Address
+		hostPort = net.JoinHostPort(host, hc.Address.Port())
+	}
+	if err := checkAddress(network.NewAddress(hc.Address.ConnType(), hostPort), 0); err != nil {
+		return "", xerrors.Errorf("ListenAddress %q: %v", listen, err)
+	}
+	return listen, nil
+}
+
+// checkAddress returns an error telling what is wrong with a, if a is not a
+// valid address of a TCP server with a port of at least minPort.
+func checkAddress(a network.Address, minPort int) error {
+	vals := strings.Split(string(a), "://")
+	if len(vals) != 2 {
+		return xerrors.New("missing connection type, e.g. tls://")
+	}
+	ct := network.ConnType(vals[0])
+	if ct != network.PlainTCP && ct != network.TLS {
+		return xerrors.Errorf("unsupported connection type %q", vals[0])
+	}
+	host, port, err := net.SplitHostPort(vals[1])
+	if err != nil {
+		return xerrors.Errorf("splitting host and port: %v", err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < minPort || p > 65535 {
+		return xerrors.Errorf("port %q is not between %d and 65535", port, minPort)
+	}
+	if !a.Valid() {
+		return xerrors.Errorf("invalid host %q", host)
+	}
+	return nil
+}
+
+// certificateRootCAs returns the pool with WebSocketTLSCertificateCA, or nil
+// if it is not set.
+func (hc *CothorityConfig) certificateRootCAs() (*x509.CertPool, error) {
+	if hc.WebSocketTLSCertificateCA == "" {
+		return nil, nil
+	}
+	ca, err := hc.WebSocketTLSCertificateCA.Content()
+	if err != nil {
+		return nil, xerrors.Errorf("content: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, xerrors.New("no certificate found")
+	}
+	return pool, nil
+}
+
 // GroupToml holds the data of the group.toml file.
 type GroupToml struct {
-	Servers []*ServerToml `toml:"servers"`
+	Servers []*ServerToml `toml:"servers" yaml:"servers"`
 }
 
 // NewGroupToml creates a new GroupToml struct from the given ServerTomls.
@@ -203,19 +598,19 @@ func NewGroupToml(servers ...*ServerToml) *GroupToml {
 // ServerToml is one entry in the group.toml file describing one server to use for
 // the cothority.
 type ServerToml struct {
-	Address     network.Address
-	Suite       string
-	Public      string
-	Description string
-	Services    map[string]ServerServiceConfig
-	URL         string `toml:"URL,omitempty"`
+	Address     network.Address                `yaml:"Address"`
+	Suite       string                         `yaml:"Suite"`
+	Public      string                         `yaml:"Public"`
+	Description string                         `yaml:"Description"`
+	Services    map[string]ServerServiceConfig `yaml:"Services,omitempty"`
+	URL         string                         `toml:"URL,omitempty" yaml:"URL,omitempty"`
 }
 
 // ServerServiceConfig is a public configuration for a server (i.e. private key
 // is missing)
 type ServerServiceConfig struct {
-	Public string
-	Suite  string
+	Public string `yaml:"Public"`
+	Suite  string `yaml:"Suite"`
 }
 
 // Group holds the Roster and the server-description.
@@ -229,6 +624,96 @@ func (g *Group) GetDescription(e *network.ServerIdentity) string {
 	return g.Description[e]
 }
 
+// Subset returns the roster of the n first servers of the group, or of all of
+// them if there are less than n. As the order of the servers is kept, the
+// first server of the group, which many protocols take as their root, is
+// always the first server of the subset. The aggregate key of the subset is
+// the sum of the keys of its servers. It returns nil if n is not positive.
+func (g *Group) Subset(n int) *onet.Roster {
+	if n > len(g.Roster.List) {
+		n = len(g.Roster.List)
+	}
+	if n <= 0 {
+		return nil
+	}
+	return onet.NewRoster(g.Roster.List[:n])
+}
+
+// ShardByIndex splits the servers of the group into the given number of
+// shards and returns the roster of the shard idx. The server i of the group
+// is in the shard i % shards, so that the shards differ at most by one server
+// and the servers keep their order in their shard. The first server of the
+// group is thus only the first server of the shard 0, the other shards start
+// with their server of lowest index. As every server is in exactly one shard,
+// the aggregate keys of the shards add up to the one of the group. It returns
+// nil if idx is not in [0, shards) or if the shard is empty.
+func (g *Group) ShardByIndex(shards, idx int) *onet.Roster {
+	if shards <= 0 || idx < 0 || idx >= shards {
+		return nil
+	}
+	var list []*network.ServerIdentity
+	for i := idx; i < len(g.Roster.List); i += shards {
+		list = append(list, g.Roster.List[i])
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return onet.NewRoster(list)
+}
+
+// MergeGroups concatenates the rosters of the groups into one group, in their
+// order. A server present in more than one group, as told by its public key,
+// is kept once, at its first position, with the first non-empty description
+// and the service identities of all its entries. It returns an error if the
+// entries of a server disagree on its address or on the key of one of its
+// services, or if there is no server.
+func MergeGroups(groups ...*Group) (*Group, error) {
+	var list []*network.ServerIdentity
+	descs := make(map[*network.ServerIdentity]string)
+	merged := make(map[string]*network.ServerIdentity)
+	for _, g := range groups {
+		if g == nil || g.Roster == nil {
+			continue
+		}
+		for _, si := range g.Roster.List {
+			key := si.Public.String()
+			first, ok := merged[key]
+			if !ok {
+				// Copy the server identity, so that merging its services
+				// doesn't change the group it comes from.
+				cp := *si
+				cp.ServiceIdentities = append([]network.ServiceIdentity{},
+					si.ServiceIdentities...)
+				first = &cp
+				merged[key] = first
+				list = append(list, first)
+				descs[first] = g.Description[si]
+				continue
+			}
+
+			if first.Address != si.Address {
+				return nil, xerrors.Errorf("server %s has the addresses %s and %s",
+					key, first.Address, si.Address)
+			}
+			if descs[first] == "" {
+				descs[first] = g.Description[si]
+			}
+			for _, sid := range si.ServiceIdentities {
+				if !first.HasServicePublic(sid.Name) {
+					first.ServiceIdentities = append(first.ServiceIdentities, sid)
+				} else if !first.ServicePublic(sid.Name).Equal(sid.Public) {
+					return nil, xerrors.Errorf("server %s has different keys for the service %s",
+						key, sid.Name)
+				}
+			}
+		}
+	}
+	if len(list) == 0 {
+		return nil, xerrors.New("no server in the groups")
+	}
+	return &Group{Roster: onet.NewRoster(list), Description: descs}, nil
+}
+
 // Toml returns the GroupToml instance of this Group
 func (g *Group) Toml(suite suites.Suite) (*GroupToml, error) {
 	servers := make([]*ServerToml, len(g.Roster.List))
@@ -263,34 +748,153 @@ func (g *Group) Toml(suite suites.Suite) (*GroupToml, error) {
 	return &GroupToml{Servers: servers}, nil
 }
 
+// SaveOption configures how Group.Save writes the group.
+type SaveOption func(*saveOptions)
+
+type saveOptions struct {
+	roundTripCheck bool
+}
+
+// WithRoundTripCheck makes Group.Save read back the group it generated before
+// writing it, and return an error instead if the public keys of the servers
+// or of their services don't match the ones of the group, e.g. because a
+// service isn't registered with the suite of its key.
+func WithRoundTripCheck() SaveOption {
+	return func(o *saveOptions) {
+		o.roundTripCheck = true
+	}
+}
+
 // Save converts the group into a toml structure and save it to the file
-func (g *Group) Save(suite suites.Suite, filename string) error {
+func (g *Group) Save(suite suites.Suite, filename string, options ...SaveOption) error {
+	var opts saveOptions
+	for _, o := range options {
+		o(&opts)
+	}
+
 	gt, err := g.Toml(suite)
 	if err != nil {
 		return xerrors.Errorf("toml encoding: %v", err)
 	}
 
+	if opts.roundTripCheck {
+		err = g.checkRoundTrip(gt, isYAML(filename))
+		if err != nil {
+			return xerrors.Errorf("round-trip check: %v", err)
+		}
+	}
+
 	return gt.Save(filename)
 }
 
+// checkRoundTrip reads back the group from its TOML, or YAML, encoding and
+// compares the public keys of its roster to the ones of g.
+func (g *Group) checkRoundTrip(gt *GroupToml, inYAML bool) (err error) {
+	// Reading a service key of the wrong suite panics.
+	defer func() {
+		if r := recover(); r != nil {
+			err = xerrors.Errorf("reading group: %v", r)
+		}
+	}()
+
+	var read *Group
+	if inYAML {
+		content, err := gt.YAML()
+		if err != nil {
+			return err
+		}
+		read, err = ReadGroupDescYAML(strings.NewReader(content))
+		if err != nil {
+			return xerrors.Errorf("reading group: %v", err)
+		}
+	} else {
+		read, err = ReadGroupDescToml(strings.NewReader(gt.String()))
+		if err != nil {
+			return xerrors.Errorf("reading group: %v", err)
+		}
+	}
+
+	if len(read.Roster.List) != len(g.Roster.List) {
+		return xerrors.Errorf("read %d servers instead of %d",
+			len(read.Roster.List), len(g.Roster.List))
+	}
+	for i, si := range g.Roster.List {
+		rsi := read.Roster.List[i]
+		if !si.Public.Equal(rsi.Public) {
+			return xerrors.Errorf("public key of %s differs", si.Address)
+		}
+
+		publics := make(map[string]kyber.Point)
+		for _, sid := range rsi.ServiceIdentities {
+			publics[sid.Name] = sid.Public
+		}
+		for _, sid := range si.ServiceIdentities {
+			public, ok := publics[sid.Name]
+			if !ok {
+				return xerrors.Errorf("key of service %s of %s is missing",
+					sid.Name, si.Address)
+			}
+			if !sid.Public.Equal(public) {
+				return xerrors.Errorf("key of service %s of %s differs",
+					sid.Name, si.Address)
+			}
+		}
+	}
+	return nil
+}
+
+// ReadOption configures how a group file is read.
+type ReadOption func(*readOptions)
+
+type readOptions struct {
+	normalizeAddresses bool
+}
+
+// WithNormalizedAddresses replaces the addresses of the servers of the group
+// by their CanonicalAddress, so that the ServerIdentities read from group
+// files compare equal, whatever the way their addresses are written. The
+// reading fails if an address can't be normalized.
+func WithNormalizedAddresses() ReadOption {
+	return func(o *readOptions) {
+		o.normalizeAddresses = true
+	}
+}
+
 // ReadGroupDescToml reads a group.toml file and returns the list of ServerIdentities
 // and descriptions in the file.
 // If the file couldn't be decoded or doesn't hold valid ServerIdentities,
 // an error is returned.
-func ReadGroupDescToml(f io.Reader) (*Group, error) {
+func ReadGroupDescToml(f io.Reader, options ...ReadOption) (*Group, error) {
 	group := &GroupToml{}
 	_, err := toml.DecodeReader(f, group)
 	if err != nil {
 		return nil, xerrors.Errorf("toml decoding: %v", err)
 	}
+	return group.group(options)
+}
+
+// group converts the ServerTomls of the GroupToml to a Group.
+func (gt *GroupToml) group(options []ReadOption) (*Group, error) {
+	var opts readOptions
+	for _, o := range options {
+		o(&opts)
+	}
+
 	// convert from ServerTomls to entities
-	var entities = make([]*network.ServerIdentity, len(group.Servers))
+	var entities = make([]*network.ServerIdentity, len(gt.Servers))
 	var descs = make(map[*network.ServerIdentity]string)
-	for i, s := range group.Servers {
+	for i, s := range gt.Servers {
 		// Backwards compatibility with old group files.
 		if s.Suite == "" {
 			s.Suite = "Ed25519"
 		}
+		if opts.normalizeAddresses {
+			addr, err := CanonicalAddress(s.Address)
+			if err != nil {
+				return nil, xerrors.Errorf("normalizing address: %v", err)
+			}
+			s.Address = addr
+		}
 		en, err := s.ToServerIdentity()
 		if err != nil {
 			return nil, xerrors.Errorf("server identity encoding: %v", err)
@@ -304,14 +908,23 @@ func ReadGroupDescToml(f io.Reader) (*Group, error) {
 
 // Save writes the GroupToml definition into the file given by its name.
 // It will return an error if the file couldn't be created or if writing
-// to it failed.
+// to it failed. The definition is written in YAML if the file has a .yaml or
+// .yml extension, and in TOML otherwise.
 func (gt *GroupToml) Save(fname string) error {
+	if isYAML(fname) {
+		return gt.SaveYAML(fname)
+	}
+	return writeFile(fname, gt.String())
+}
+
+// writeFile creates the file given by its name and writes content into it.
+func writeFile(fname, content string) error {
 	file, err := os.Create(fname)
 	if err != nil {
 		return xerrors.Errorf("creating file: %v", err)
 	}
 	defer file.Close()
-	_, err = file.WriteString(gt.String())
+	_, err = file.WriteString(content)
 	if err != nil {
 		return xerrors.Errorf("writing file: %v", err)
 	}
@@ -319,14 +932,11 @@ func (gt *GroupToml) Save(fname string) error {
 	return nil
 }
 
-// String returns the TOML representation of this GroupToml.
+// String returns the TOML representation of this GroupToml. The services of
+// the servers are sorted by name, so that the output is stable.
 func (gt *GroupToml) String() string {
 	var buff bytes.Buffer
-	for _, s := range gt.Servers {
-		if s.Description == "" {
-			s.Description = "Description of your server"
-		}
-	}
+	gt.setDefaults()
 	enc := toml.NewEncoder(&buff)
 	if err := enc.Encode(gt); err != nil {
 		return "Error encoding grouptoml" + err.Error()
@@ -334,6 +944,16 @@ func (gt *GroupToml) String() string {
 	return buff.String()
 }
 
+// setDefaults fills in the descriptions left empty before the GroupToml is
+// written.
+func (gt *GroupToml) setDefaults() {
+	for _, s := range gt.Servers {
+		if s.Description == "" {
+			s.Description = "Description of your server"
+		}
+	}
+}
+
 // ToServerIdentity converts this ServerToml struct to a ServerIdentity.
 func (s *ServerToml) ToServerIdentity() (*network.ServerIdentity, error) {
 	suite, err := suites.Find(s.Suite)
@@ -354,6 +974,35 @@ func (s *ServerToml) ToServerIdentity() (*network.ServerIdentity, error) {
 	return si, err
 }
 
+// CanonicalAddress returns the canonical form of an address written by hand:
+// the connection type, if any, is lowercased, the IP addresses are in their
+// shortest form, with the IPv6 ones in brackets, and the host names are
+// lowercased, without a trailing dot. The host names are not resolved. It
+// returns an error if the address has no port, or if it is an IPv6 address
+// without brackets, whose port can't be told apart.
+// ex: "TLS://[2001:DB8:0::1]:7770" => "tls://[2001:db8::1]:7770"
+func CanonicalAddress(addr network.Address) (network.Address, error) {
+	s := strings.TrimSpace(string(addr))
+	prefix := ""
+	if i := strings.Index(s, "://"); i >= 0 {
+		prefix = strings.ToLower(s[:i+len("://")])
+		s = s[i+len("://"):]
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", xerrors.Errorf("invalid address %q: %v", addr, err)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	} else {
+		host = strings.TrimSuffix(strings.ToLower(host), ".")
+	}
+	if p, err := strconv.Atoi(port); err == nil {
+		port = strconv.Itoa(p)
+	}
+	return network.Address(prefix + net.JoinHostPort(host, port)), nil
+}
+
 // NewServerToml takes a public key and an address and returns
 // the corresponding ServerToml.
 // If an error occurs, it will be printed to StdErr and nil
@@ -381,7 +1030,8 @@ func NewServerToml(suite network.Suite, public kyber.Point, addr network.Address
 	}
 }
 
-// String returns the TOML representation of the ServerToml.
+// String returns the TOML representation of the ServerToml, with its services
+// sorted by name.
 func (s *ServerToml) String() string {
 	var buff bytes.Buffer
 	if s.Description == "" {
@@ -409,6 +1059,11 @@ const (
 	// File is a CertificateURL type that contains the path to a file
 	// containing a certificate.
 	File = "file"
+	// HTTP is a CertificateURL type that contains the URL where the
+	// certificate can be fetched.
+	HTTP = "http"
+	// HTTPS is like HTTP, but the certificate is fetched over TLS.
+	HTTPS = "https"
 	// InvalidCertificateURLType is an invalid CertificateURL type.
 	InvalidCertificateURLType = "wrong"
 	// DefaultCertificateURLType is the default type when no type is specified
@@ -420,6 +1075,10 @@ const (
 // filepath, content).
 const typeCertificateURLSep = "://"
 
+// CertificateURLTimeout is the time given to fetch the certificates of the
+// HTTP and HTTPS CertificateURL types.
+var CertificateURLTimeout = 10 * time.Second
+
 // certificateURLType converts a string to a CertificateURLType. In case of
 // failure, it returns InvalidCertificateURLType.
 func certificateURLType(t string) CertificateURLType {
@@ -427,7 +1086,7 @@ func certificateURLType(t string) CertificateURLType {
 		return DefaultCertificateURLType
 	}
 	cuType := CertificateURLType(t)
-	types := []CertificateURLType{String, File}
+	types := []CertificateURLType{String, File, HTTP, HTTPS}
 	for _, t := range types {
 		if t == cuType {
 			return cuType
@@ -467,7 +1126,17 @@ func (cu CertificateURL) Valid() bool {
 
 // Content returns the bytes representing the certificate.
 func (cu CertificateURL) Content() ([]byte, error) {
+	return cu.ContentWithRootCAs(nil)
+}
+
+// ContentWithRootCAs is like Content, but the server of an HTTPS
+// CertificateURL must have a certificate signed by one of rootCAs, instead
+// of one of the system. rootCAs is not used by the other types.
+func (cu CertificateURL) ContentWithRootCAs(rootCAs *x509.CertPool) ([]byte, error) {
 	cuType := cu.CertificateURLType()
+	if cuType == HTTP || cuType == HTTPS {
+		return cu.fetch(rootCAs)
+	}
 	if cuType == String {
 		return []byte(cu.blobPart()), nil
 	}
@@ -481,6 +1150,29 @@ func (cu CertificateURL) Content() ([]byte, error) {
 	return nil, xerrors.Errorf("Unknown CertificateURL type (%s), cannot get its content", cuType)
 }
 
+// fetch gets the certificate of an HTTP or HTTPS CertificateURL.
+func (cu CertificateURL) fetch(rootCAs *x509.CertPool) ([]byte, error) {
+	client := &http.Client{Timeout: CertificateURLTimeout}
+	if rootCAs != nil {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: rootCAs},
+		}
+	}
+	resp, err := client.Get(cu.String())
+	if err != nil {
+		return nil, xerrors.Errorf("fetching certificate: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("fetching certificate: %s", resp.Status)
+	}
+	dat, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, xerrors.Errorf("reading certificate: %v", err)
+	}
+	return dat, nil
+}
+
 // typePart returns only the string representing the type of a CertificateURL
 // (empty string for no type specified)
 func (cu CertificateURL) typePart() string {
@@ -515,6 +1207,7 @@ func parseServiceConfig(configs map[string]ServiceConfig) []network.ServiceIdent
 			si = append(si, sid)
 		}
 	}
+	sortServiceIdentities(si)
 
 	return si
 }
@@ -533,10 +1226,19 @@ func parseServerServiceConfig(configs map[string]ServerServiceConfig) []network.
 			si = append(si, sid)
 		}
 	}
+	sortServiceIdentities(si)
 
 	return si
 }
 
+// sortServiceIdentities sorts the service identities by name, so that they
+// don't depend on the iteration order of the map they are parsed from.
+func sortServiceIdentities(si []network.ServiceIdentity) {
+	sort.Slice(si, func(i, j int) bool {
+		return si[i].Name < si[j].Name
+	})
+}
+
 // parseServiceIdentity creates the service identity
 func parseServiceIdentity(name string, suiteName string, pub string, priv string) (srvid network.ServiceIdentity, err error) {
 	suite := onet.ServiceFactory.Suite(name)
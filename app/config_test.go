@@ -2,12 +2,18 @@ package app
 
 import (
 	"bytes"
+	"crypto/tls"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/kyber/v3/pairing"
@@ -132,6 +138,268 @@ func TestSaveGroup(t *testing.T) {
 	require.Contains(t, string(data), serverGroup[strings.LastIndex(serverGroup, "[[servers]]"):])
 }
 
+// TestSaveGroup_RoundTripCheck checks that a group which can't be read back
+// is not written
+func TestSaveGroup_IPv6(t *testing.T) {
+	group, err := ReadGroupDescToml(strings.NewReader(`
+		[[servers]]
+		  Address = "tcp://[2001:db8::1]:7770"
+		  Public = "94b8255379e11df5167b8a7ae3b85f7e7eb5f13894abee85bd31b3270f1e4c65"
+		[[servers]]
+		  Address = "tls://[::1]:7772"
+		  Public = "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4"`))
+	require.NoError(t, err)
+	require.Equal(t, network.Address("tcp://[2001:db8::1]:7770"), group.Roster.List[0].Address)
+	require.Equal(t, "2001:db8::1", group.Roster.List[0].Address.Host())
+
+	tmp, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+	filename := path.Join(tmp, "public.toml")
+	require.NoError(t, group.Save(suites.MustFind("Ed25519"), filename))
+
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	defer f.Close()
+	read, err := ReadGroupDescToml(f)
+	require.NoError(t, err)
+	require.Equal(t, len(group.Roster.List), len(read.Roster.List))
+	for i, si := range group.Roster.List {
+		require.Equal(t, si.Address, read.Roster.List[i].Address)
+	}
+}
+
+func TestGetServerIdentity_IPv6URL(t *testing.T) {
+	hc := &CothorityConfig{
+		Suite:                      "Ed25519",
+		Public:                     "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4",
+		Private:                    "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4",
+		Address:                    "tls://[2001:db8::1]:7770",
+		WebSocketTLSCertificateKey: "string://key",
+	}
+	si, err := hc.GetServerIdentity()
+	require.NoError(t, err)
+	require.Equal(t, "https://[2001:db8::1]:7771", si.URL)
+}
+
+func TestSaveGroup_RoundTripCheck(t *testing.T) {
+	registerService()
+	defer unregisterService()
+
+	group, err := ReadGroupDescToml(strings.NewReader(serverGroup))
+	require.NoError(t, err)
+
+	tmp, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	suite := suites.MustFind("ed25519")
+	for _, name := range []string{"public.toml", "public.yaml"} {
+		filename := path.Join(tmp, name)
+		require.NoError(t, group.Save(suite, filename, WithRoundTripCheck()))
+		_, err = os.Stat(filename)
+		require.NoError(t, err)
+	}
+
+	// The service key is written with a suite it can't be read with.
+	unregisterService()
+	onet.RegisterNewServiceWithSuite(testServiceName, suite, func(c *onet.Context) (onet.Service, error) {
+		return nil, nil
+	})
+	filename := path.Join(tmp, "corrupted.toml")
+	err = group.Save(suite, filename, WithRoundTripCheck())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), testServiceName)
+	_, err = os.Stat(filename)
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, group.Save(suite, filename))
+}
+
+// TestServicesOrder checks that the services are written and read in the
+// order of their names
+func TestServicesOrder(t *testing.T) {
+	names := []string{"a", "b", "c", "d", "e", "f"}
+	services := make(map[string]ServiceConfig)
+	for _, name := range names {
+		services[name] = ServiceConfig{Suite: "Ed25519", Public: "public" + name, Private: "private" + name}
+	}
+	requireOrder := func(out string) {
+		last := -1
+		for _, name := range names {
+			i := strings.Index(out, "public"+name)
+			require.True(t, i > last, out)
+			last = i
+		}
+	}
+
+	suite := suites.MustFind("Ed25519")
+	st := NewServerToml(suite, suite.Point().Base(), network.NewTCPAddress("1.2.3.4:1234"), "", services)
+	gt := NewGroupToml(st, st)
+	out := gt.String()
+	requireOrder(st.String())
+	requireOrder(out)
+	for i := 0; i < 10; i++ {
+		require.Equal(t, out, gt.String())
+	}
+
+	tmp, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+	hc := &CothorityConfig{Suite: "Ed25519", Services: services}
+	for _, name := range []string{"private.toml", "private.yaml"} {
+		file := path.Join(tmp, name)
+		require.NoError(t, hc.Save(file))
+		data, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		requireOrder(string(data))
+		for i := 0; i < 10; i++ {
+			require.NoError(t, hc.Save(file))
+			again, err := ioutil.ReadFile(file)
+			require.NoError(t, err)
+			require.Equal(t, data, again)
+		}
+	}
+
+	sis := []network.ServiceIdentity{{Name: "c"}, {Name: "a"}, {Name: "b"}}
+	sortServiceIdentities(sis)
+	require.Equal(t, []network.ServiceIdentity{{Name: "a"}, {Name: "b"}, {Name: "c"}}, sis)
+}
+
+func TestGroup_SubsetAndShards(t *testing.T) {
+	suite := suites.MustFind("Ed25519")
+	list := make([]*network.ServerIdentity, 7)
+	for i := range list {
+		list[i] = network.NewServerIdentity(suite.Point().Pick(suite.RandomStream()),
+			network.NewTCPAddress(fmt.Sprintf("1.2.3.4:%d", 2000+i)))
+	}
+	group := &Group{Roster: onet.NewRoster(list)}
+
+	sub := group.Subset(3)
+	require.Equal(t, list[:3], sub.List)
+	require.True(t, sub.Aggregate.Equal(onet.NewRoster(list[:3]).Aggregate))
+	require.Equal(t, sub.ID, group.Subset(3).ID)
+	require.Equal(t, list, group.Subset(10).List)
+	require.Nil(t, group.Subset(0))
+
+	agg := suite.Point().Null()
+	var sizes []int
+	for idx := 0; idx < 3; idx++ {
+		shard := group.ShardByIndex(3, idx)
+		require.Equal(t, list[idx], shard.List[0])
+		agg.Add(agg, shard.Aggregate)
+		sizes = append(sizes, len(shard.List))
+	}
+	require.Equal(t, []int{3, 2, 2}, sizes)
+	require.True(t, agg.Equal(group.Roster.Aggregate))
+	require.Equal(t, []*network.ServerIdentity{list[1], list[4]}, group.ShardByIndex(3, 1).List)
+
+	require.Nil(t, group.ShardByIndex(0, 0))
+	require.Nil(t, group.ShardByIndex(3, 3))
+	require.Nil(t, group.ShardByIndex(3, -1))
+	require.Nil(t, group.ShardByIndex(10, 8))
+}
+
+func TestMergeGroups(t *testing.T) {
+	registerService()
+	defer unregisterService()
+
+	group1, err := ReadGroupDescToml(strings.NewReader(serverGroup))
+	require.NoError(t, err)
+	// The first server again, without its services and its description,
+	// and a new server.
+	group2, err := ReadGroupDescToml(strings.NewReader(`
+		[[servers]]
+		  Address = "tcp://5.135.161.91:2000"
+		  Public = "94b8255379e11df5167b8a7ae3b85f7e7eb5f13894abee85bd31b3270f1e4c65"
+		[[servers]]
+		  Address = "tcp://1.2.3.4:2000"
+		  Public = "0ffa9e2ba2c7d7e0b7ee2b8de0ac1fa3e4ea4b7ac37d2ef3dbc6c4e3b64fc1e7"
+		  Description = "New server"`))
+	require.NoError(t, err)
+
+	merged, err := MergeGroups(group2, nil, group1)
+	require.NoError(t, err)
+	list := merged.Roster.List
+	require.Equal(t, 3, len(list))
+	require.True(t, list[0].Public.Equal(group1.Roster.List[0].Public))
+	require.True(t, list[1].Public.Equal(group2.Roster.List[1].Public))
+	require.True(t, list[2].Public.Equal(group1.Roster.List[1].Public))
+	require.Equal(t, "Nikkolasg's server: spreading the love of singing", merged.GetDescription(list[0]))
+	require.Equal(t, "New server", merged.GetDescription(list[1]))
+	require.Equal(t, "Ismail's server", merged.GetDescription(list[2]))
+	require.Equal(t, group1.Roster.List[0].ServiceIdentities, list[0].ServiceIdentities)
+	require.Equal(t, 0, len(group2.Roster.List[0].ServiceIdentities))
+	require.True(t, merged.Roster.Aggregate.Equal(onet.NewRoster(list).Aggregate))
+
+	// The same server at another address.
+	group3, err := ReadGroupDescToml(strings.NewReader(`
+		[[servers]]
+		  Address = "tcp://5.135.161.91:2001"
+		  Public = "94b8255379e11df5167b8a7ae3b85f7e7eb5f13894abee85bd31b3270f1e4c65"`))
+	require.NoError(t, err)
+	_, err = MergeGroups(group1, group3)
+	require.Error(t, err)
+
+	// The same server with another key for its service.
+	group4 := &Group{Roster: onet.NewRoster([]*network.ServerIdentity{group1.Roster.List[0]})}
+	cp := *group1.Roster.List[0]
+	cp.ServiceIdentities = []network.ServiceIdentity{group1.Roster.List[0].ServiceIdentities[0]}
+	cp.ServiceIdentities[0].Public = cp.ServiceIdentities[0].Public.Clone().Add(
+		cp.ServiceIdentities[0].Public, cp.ServiceIdentities[0].Public)
+	group5 := &Group{Roster: onet.NewRoster([]*network.ServerIdentity{&cp})}
+	_, err = MergeGroups(group4, group5)
+	require.Error(t, err)
+
+	_, err = MergeGroups()
+	require.Error(t, err)
+}
+
+func TestCanonicalAddress(t *testing.T) {
+	for in, out := range map[string]string{
+		"tls://127.0.0.1:7770":            "tls://127.0.0.1:7770",
+		" TCP://127.0.0.1:7770 ":          "tcp://127.0.0.1:7770",
+		"127.0.0.1:7770":                  "127.0.0.1:7770",
+		"tls://[2001:DB8:0::1]:7770":      "tls://[2001:db8::1]:7770",
+		"tls://Conode.Example.COM.:07770": "tls://conode.example.com:7770",
+	} {
+		addr, err := CanonicalAddress(network.Address(in))
+		require.NoError(t, err, in)
+		require.Equal(t, network.Address(out), addr, in)
+	}
+
+	for _, in := range []string{"tls://2001:db8::1:7770", "tls://[2001:db8::1]",
+		"tls://not an address"} {
+		_, err := CanonicalAddress(network.Address(in))
+		require.Error(t, err, in)
+	}
+}
+
+func TestReadGroupDescToml_NormalizeAddress(t *testing.T) {
+	read := func(addr string, options ...ReadOption) (*network.ServerIdentity, error) {
+		group, err := ReadGroupDescToml(strings.NewReader(`
+			[[servers]]
+			  Address = "`+addr+`"
+			  Public = "94b8255379e11df5167b8a7ae3b85f7e7eb5f13894abee85bd31b3270f1e4c65"`),
+			options...)
+		if err != nil {
+			return nil, err
+		}
+		return group.Roster.List[0], nil
+	}
+
+	// The addresses are kept as written by default.
+	si, err := read("TLS://[2001:DB8::1]:7770")
+	require.NoError(t, err)
+	require.Equal(t, network.Address("TLS://[2001:DB8::1]:7770"), si.Address)
+
+	si, err = read("TLS://[2001:DB8::1]:7770", WithNormalizedAddresses())
+	require.NoError(t, err)
+	require.Equal(t, network.Address("tls://[2001:db8::1]:7770"), si.Address)
+	_, err = read("tls://2001:db8::1:7770", WithNormalizedAddresses())
+	require.Error(t, err)
+}
+
 func TestParseCothority(t *testing.T) {
 	registerService()
 	defer unregisterService()
@@ -151,6 +419,10 @@ func TestParseCothority(t *testing.T) {
         Address = "%s"
         ListenAddress = "%s"
 		    Description = "%s"
+        Roles = ["indexer", "archive"]
+        WebSocketUpgradeTimeout = "5s"
+        AdminToken = "admin"
+        HealthProbes = true
 		[services]
 			[services.%s]
 			suite = "bn256.adapter"
@@ -178,12 +450,363 @@ func TestParseCothority(t *testing.T) {
 	require.Equal(t, address, cothConfig.Address.String())
 	require.Equal(t, listenAddr, cothConfig.ListenAddress)
 	require.Equal(t, description, cothConfig.Description)
+	require.Equal(t, []string{"indexer", "archive"}, cothConfig.Roles)
+	require.Equal(t, []string{"archive", "indexer"}, srv.Roles())
+	require.Equal(t, 5*time.Second, srv.WebSocket.UpgradeTimeout)
+	require.True(t, cothConfig.HealthProbes)
+
+	srv.SetRoles("archive")
+	ec := cothConfig.Effective(srv)
+	require.Equal(t, "<redacted>", ec.Private)
+	require.Equal(t, "<redacted>", ec.AdminToken)
+	require.Equal(t, "<redacted>", ec.Services[testServiceName].Private)
+	require.Equal(t, scPublic, ec.Services[testServiceName].Public)
+	require.Equal(t, []string{"archive"}, ec.Roles)
+	require.Equal(t, "5s", ec.WebSocketUpgradeTimeout)
+	require.Equal(t, private, cothConfig.Private)
+	require.Equal(t, scPrivate, cothConfig.Services[testServiceName].Private)
+	srv.SetRoles("indexer", "archive")
 	require.Equal(t, 1, len(srv.ServerIdentity.ServiceIdentities))
 	require.Equal(t, "bn256.adapter", cothConfig.Services[testServiceName].Suite)
 	require.Equal(t, scPublic, cothConfig.Services[testServiceName].Public)
 	require.Equal(t, scPrivate, cothConfig.Services[testServiceName].Private)
 
 	srv.Close()
+
+	// The same config without a file.
+	readerConfig, srv, err := ParseCothorityReader(strings.NewReader(privateInfo))
+	require.NoError(t, err)
+	require.Equal(t, cothConfig, readerConfig)
+	require.Equal(t, []string{"archive", "indexer"}, srv.Roles())
+	srv.Close()
+
+	_, _, err = ParseCothorityReader(strings.NewReader("Suite = "))
+	require.Error(t, err)
+	_, _, err = ParseCothorityReader(strings.NewReader(
+		strings.Replace(privateInfo, `"5s"`, `"soon"`, 1)))
+	require.Error(t, err)
+	_, _, err = ParseCothority(privateToml.Name() + ".missing")
+	require.Error(t, err)
+}
+
+func TestValidateCothority(t *testing.T) {
+	// The listen address is taken, as the server is not created.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	config := fmt.Sprintf(`Suite = "Ed25519"
+		Public = "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4"
+		Private = "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4"
+		Address = "tcp://1.2.3.4:1234"
+		ListenAddress = "%s"
+		Description = "Validated"`, l.Addr())
+	file, err := ioutil.TempFile("", "temp_private.toml")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString(config)
+	file.Close()
+
+	hc, si, err := ValidateCothority(file.Name())
+	require.NoError(t, err)
+	require.Equal(t, l.Addr().String(), hc.ListenAddress)
+	require.Equal(t, network.Address("tcp://1.2.3.4:1234"), si.Address)
+	require.Equal(t, "Validated", si.Description)
+	require.Equal(t, "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4",
+		si.Public.String())
+
+	require.NoError(t, ioutil.WriteFile(file.Name(),
+		[]byte(strings.Replace(config, "tcp://1.2.3.4:1234", "1.2.3.4:1234", 1)), 0600))
+	_, _, err = ValidateCothority(file.Name())
+	require.Error(t, err)
+}
+
+func TestParseCothority_EncryptedPrivate(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conode")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	suite := suites.MustFind("Ed25519")
+	privStr, pubStr := createKeyPair(suite)
+	hc := &CothorityConfig{
+		Suite:         suite.String(),
+		Public:        pubStr,
+		Private:       privStr,
+		Address:       "tcp://127.0.0.1:7770",
+		ListenAddress: "127.0.0.1:0",
+	}
+	file := path.Join(tmp, "private.toml")
+	require.NoError(t, hc.SaveWithPassphrase(file, "secret"))
+	require.Equal(t, privStr, hc.Private)
+
+	saved, err := LoadCothority(file)
+	require.NoError(t, err)
+	require.Equal(t, EncryptionScryptSecretbox, saved.PrivateEncryption)
+	require.NotContains(t, saved.Private, privStr)
+	_, err = saved.GetServerIdentity()
+	require.Error(t, err)
+
+	_, _, err = ParseCothorityWithPassphrase(file, "wrong")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "wrong passphrase")
+
+	cc, srv, err := ParseCothorityWithPassphrase(file, "secret")
+	require.NoError(t, err)
+	require.Equal(t, EncryptionScryptSecretbox, cc.PrivateEncryption)
+	require.Equal(t, pubStr, srv.ServerIdentity.Public.String())
+	srv.Close()
+
+	require.NoError(t, os.Setenv(PassphraseEnv, "secret"))
+	defer os.Unsetenv(PassphraseEnv)
+	_, srv, err = ParseCothority(file)
+	require.NoError(t, err)
+	srv.Close()
+
+	// A plain key still loads, whatever the passphrase.
+	require.NoError(t, hc.Save(file))
+	_, srv, err = ParseCothority(file)
+	require.NoError(t, err)
+	srv.Close()
+}
+
+func TestCertificateURL_HTTP(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cert" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("certificate"))
+	})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	tlsSrv := httptest.NewTLSServer(handler)
+	defer tlsSrv.Close()
+
+	cu := CertificateURL(srv.URL + "/cert")
+	require.True(t, cu.Valid())
+	require.Equal(t, CertificateURLType(HTTP), cu.CertificateURLType())
+	content, err := cu.Content()
+	require.NoError(t, err)
+	require.Equal(t, "certificate", string(content))
+	_, err = CertificateURL(srv.URL + "/missing").Content()
+	require.Error(t, err)
+
+	cu = CertificateURL(tlsSrv.URL + "/cert")
+	require.Equal(t, CertificateURLType(HTTPS), cu.CertificateURLType())
+	// The certificate of the test server is not trusted by the system.
+	_, err = cu.Content()
+	require.Error(t, err)
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsSrv.Certificate().Raw})
+	hc := &CothorityConfig{WebSocketTLSCertificateCA: CertificateURL("string://" + string(ca))}
+	rootCAs, err := hc.certificateRootCAs()
+	require.NoError(t, err)
+	content, err = cu.ContentWithRootCAs(rootCAs)
+	require.NoError(t, err)
+	require.Equal(t, "certificate", string(content))
+
+	hc.WebSocketTLSCertificateCA = "string://not a certificate"
+	_, err = hc.certificateRootCAs()
+	require.Error(t, err)
+}
+
+func TestParseCothority_ACME(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conode")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	config := func(extra string) string {
+		return fmt.Sprintf(`Suite = "Ed25519"
+			Public = "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4"
+			Private = "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4"
+			Address = "tcp://1.2.3.4:1234"
+			ListenAddress = "127.0.0.1:0"
+			%s
+			[WebSocketACME]
+			Domains = ["conode.example.com"]
+			CacheDir = "%s"`, extra, tmp)
+	}
+
+	cc, srv, err := ParseCothorityReader(strings.NewReader(config("")))
+	require.NoError(t, err)
+	defer srv.Close()
+	require.Equal(t, []string{"conode.example.com"}, cc.WebSocketACME.Domains)
+	require.Equal(t, "https://1.2.3.4:1235", srv.ServerIdentity.URL)
+	tlsConfig := srv.WebSocket.TLSConfig
+	require.NotNil(t, tlsConfig.GetCertificate)
+	require.Equal(t, []string{"http/1.1", "acme-tls/1"}, tlsConfig.NextProtos)
+	// Only the domains of the config are accepted.
+	_, err = tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "evil.com"})
+	require.Error(t, err)
+
+	_, _, err = ParseCothorityReader(strings.NewReader(
+		config(`WebSocketTLSCertificate = "file:///cert.pem"`)))
+	require.Error(t, err)
+	_, _, err = ParseCothorityReader(strings.NewReader(
+		strings.Replace(config(""), `"conode.example.com"`, "", 1)))
+	require.Error(t, err)
+}
+
+func TestParseCothority_ClientAuth(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conode")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+
+	config := func(extra string) string {
+		return fmt.Sprintf(`Suite = "Ed25519"
+			Public = "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4"
+			Private = "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4"
+			Address = "tcp://1.2.3.4:1234"
+			ListenAddress = "127.0.0.1:0"
+			%s
+			[WebSocketACME]
+			Domains = ["conode.example.com"]
+			CacheDir = "%s"`, extra, tmp)
+	}
+
+	_, srv, err := ParseCothorityReader(strings.NewReader(config(fmt.Sprintf(`
+			WebSocketClientAuth = "verify-if-given"
+			WebSocketClientCA = """string://%s"""`, ca))))
+	require.NoError(t, err)
+	require.Equal(t, tls.VerifyClientCertIfGiven, srv.WebSocket.TLSConfig.ClientAuth)
+	require.NotNil(t, srv.WebSocket.TLSConfig.ClientCAs)
+	srv.Close()
+
+	_, srv, err = ParseCothorityReader(strings.NewReader(config(`WebSocketClientAuth = "request"`)))
+	require.NoError(t, err)
+	require.Equal(t, tls.RequestClientCert, srv.WebSocket.TLSConfig.ClientAuth)
+	require.Nil(t, srv.WebSocket.TLSConfig.ClientCAs)
+	srv.Close()
+
+	for _, extra := range []string{
+		`WebSocketClientAuth = "bogus"`,
+		`WebSocketClientAuth = "verify-if-given"`,
+		`WebSocketClientAuth = "require"`,
+		`WebSocketClientAuth = "request"
+			WebSocketClientCA = "string://not a certificate"`,
+	} {
+		_, _, err = ParseCothorityReader(strings.NewReader(config(extra)))
+		require.Error(t, err, extra)
+	}
+
+	hc := &CothorityConfig{
+		WebSocketClientAuth:        "require-and-verify",
+		WebSocketClientCA:          CertificateURL("string://" + string(ca)),
+		WebSocketTLSCertificate:    "file:///cert.pem",
+		WebSocketTLSCertificateKey: "file:///key.pem",
+	}
+	auth, pool, err := hc.clientAuth()
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, auth)
+	require.NotNil(t, pool)
+	hc.WebSocketTLSCertificate = ""
+	_, _, err = hc.clientAuth()
+	require.Error(t, err)
+}
+
+func TestParseCothority_Transport(t *testing.T) {
+	config := func(transport string) string {
+		return `Suite = "Ed25519"
+			Public = "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4"
+			Private = "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4"
+			Address = "tcp://1.2.3.4:1234"
+			ListenAddress = "127.0.0.1:0"
+			` + transport
+	}
+
+	hc, srv, err := ParseCothorityReader(strings.NewReader(config("")))
+	require.NoError(t, err)
+	require.Nil(t, hc.Transport)
+	srv.Close()
+
+	hc, srv, err = ParseCothorityReader(strings.NewReader(config(`[Transport]
+			DialTimeout = "5s"
+			KeepAlive = "-1s"`)))
+	require.NoError(t, err)
+	require.Equal(t, &TransportConfig{DialTimeout: "5s", KeepAlive: "-1s"}, hc.Transport)
+	srv.Close()
+
+	tc, err := hc.Transport.config()
+	require.NoError(t, err)
+	require.Equal(t, network.TransportConfig{
+		DialTimeout: 5 * time.Second,
+		KeepAlive:   -time.Second,
+	}, tc)
+
+	for _, transport := range []string{
+		`DialTimeout = "5"`,
+		`MaxIdle = "-1m"`,
+		`KeepAlive = "forever"`,
+	} {
+		_, _, err = ParseCothorityReader(strings.NewReader(config("[Transport]\n" + transport)))
+		require.Error(t, err, transport)
+	}
+}
+
+func TestParseCothority_Listener(t *testing.T) {
+	config := func(listener string) string {
+		return `Suite = "Ed25519"
+			Public = "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4"
+			Private = "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4"
+			Address = "tcp://1.2.3.4:1234"
+			ListenAddress = "127.0.0.1:0"
+			[Listener]
+			` + listener
+	}
+
+	hc, srv, err := ParseCothorityReader(strings.NewReader(config(`Backlog = 64
+			ReusePort = true`)))
+	require.NoError(t, err)
+	require.Equal(t, &ListenerConfig{Backlog: 64, ReusePort: true}, hc.Listener)
+	srv.Close()
+
+	lc, err := hc.Listener.config()
+	require.NoError(t, err)
+	require.Equal(t, network.ListenConfig{Backlog: 64, ReusePort: true}, lc)
+
+	_, _, err = ParseCothorityReader(strings.NewReader(config("Backlog = -1")))
+	require.Error(t, err)
+}
+
+func TestCothorityConfig_checkAddresses(t *testing.T) {
+	tests := []struct {
+		address, listen, expected, err string
+	}{
+		{"tls://1.2.3.4:7770", "", "", ""},
+		{"tcp://conode.example.com:7770", "127.0.0.1:0", "127.0.0.1:0", ""},
+		{"tls://1.2.3.4:7770", "0.0.0.0", "0.0.0.0", ""},
+		{"tls://1.2.3.4:7770", "tls://127.0.0.1:7770", "127.0.0.1:7770", ""},
+		{"tls://[2001:db8::1]:7770", "", "", ""},
+		{"tls://[2001:db8::1]:7770", "::1", "::1", ""},
+		{"tls://[2001:db8::1]:7770", "[::1]", "[::1]", ""},
+		{"tls://[2001:db8::1]:7770", "[::1]:7771", "[::1]:7771", ""},
+		{"tls://[2001:db8::1]:7770", "tls://[::1]:7771", "[::1]:7771", ""},
+		{"tls://2001:db8::1:7770", "", "", "splitting host and port"},
+		{"1.2.3.4:7770", "", "", "Address \"1.2.3.4:7770\": missing connection type"},
+		{"local://1.2.3.4:7770", "", "", "unsupported connection type \"local\""},
+		{"tls://1.2.3.4", "", "", "splitting host and port"},
+		{"tls://1.2.3.4:0", "", "", "port \"0\" is not between 1 and 65535"},
+		{"tls://1.2.3.4:70000", "", "", "port \"70000\" is not between 1 and 65535"},
+		{"tls://bad!.example.com:7770", "", "", "invalid host \"bad!.example.com\""},
+		{"tls://1.2.3.4:7770", "127.0.0.1:port", "", "ListenAddress \"127.0.0.1:port\": port"},
+		{"tls://1.2.3.4:7770", "bad!.example.com", "", "ListenAddress \"bad!.example.com\": invalid host"},
+		{"tls://1.2.3.4:7770", "tcp://127.0.0.1:7770", "", "doesn't match the one of Address (tls)"},
+	}
+	for _, test := range tests {
+		hc := &CothorityConfig{Address: network.Address(test.address), ListenAddress: test.listen}
+		listen, err := hc.checkAddresses()
+		if test.err != "" {
+			require.Error(t, err, test.address+" "+test.listen)
+			require.Contains(t, err.Error(), test.err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, test.expected, listen)
+	}
 }
 
 func TestParseCothorityWithTLSWebSocket(t *testing.T) {
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/xerrors"
+)
+
+// PassphraseEnv is the environment variable giving the passphrase of the
+// encrypted private keys to ParseCothority and ParseCothorityReader.
+const PassphraseEnv = "CONODE_PASSPHRASE"
+
+// EncryptionScryptSecretbox is the PrivateEncryption of a private key
+// encrypted with NaCl secretbox, using a key derived from the passphrase with
+// scrypt. The private key is then the hex encoding of the salt, the nonce and
+// the sealed box.
+const EncryptionScryptSecretbox = "scrypt-secretbox"
+
+const (
+	scryptSaltSize = 16
+	// parameters recommended for interactive logins in the scrypt package
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+// passphraseKey derives the key of the secretbox from the passphrase.
+func passphraseKey(passphrase string, salt []byte) (*[32]byte, error) {
+	buf, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, xerrors.Errorf("deriving key: %v", err)
+	}
+	var key [32]byte
+	copy(key[:], buf)
+	return &key, nil
+}
+
+// EncryptPrivate encrypts the private key of the config with the passphrase.
+// It fails if the key is already encrypted.
+func (hc *CothorityConfig) EncryptPrivate(passphrase string) error {
+	if hc.PrivateEncryption != "" {
+		return xerrors.New("private key is already encrypted")
+	}
+	var nonce [24]byte
+	salt := make([]byte, scryptSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return xerrors.Errorf("salt: %v", err)
+	}
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return xerrors.Errorf("nonce: %v", err)
+	}
+	key, err := passphraseKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	out := append(salt, nonce[:]...)
+	out = secretbox.Seal(out, []byte(hc.Private), &nonce, key)
+	hc.Private = hex.EncodeToString(out)
+	hc.PrivateEncryption = EncryptionScryptSecretbox
+	return nil
+}
+
+// DecryptPrivate decrypts the private key of the config with the passphrase,
+// if it is encrypted.
+func (hc *CothorityConfig) DecryptPrivate(passphrase string) error {
+	switch hc.PrivateEncryption {
+	case "":
+		return nil
+	case EncryptionScryptSecretbox:
+	default:
+		return xerrors.Errorf("unknown private key encryption: %s", hc.PrivateEncryption)
+	}
+	buf, err := hex.DecodeString(hc.Private)
+	if err != nil {
+		return xerrors.Errorf("decoding private key: %v", err)
+	}
+	if len(buf) < scryptSaltSize+24+secretbox.Overhead {
+		return xerrors.New("encrypted private key is too short")
+	}
+	var nonce [24]byte
+	copy(nonce[:], buf[scryptSaltSize:])
+	key, err := passphraseKey(passphrase, buf[:scryptSaltSize])
+	if err != nil {
+		return err
+	}
+	private, ok := secretbox.Open(nil, buf[scryptSaltSize+24:], &nonce, key)
+	if !ok {
+		return xerrors.New("wrong passphrase")
+	}
+	hc.Private = string(private)
+	hc.PrivateEncryption = ""
+	return nil
+}
+
+// SaveWithPassphrase is like Save, but stores the private key encrypted with
+// the passphrase. The config itself keeps its private key unchanged.
+func (hc *CothorityConfig) SaveWithPassphrase(file, passphrase string) error {
+	encrypted := *hc
+	if err := encrypted.EncryptPrivate(passphrase); err != nil {
+		return xerrors.Errorf("encrypting private key: %v", err)
+	}
+	return encrypted.Save(file)
+}
+
+// passphraseFromEnv returns the passphrase given in PassphraseEnv.
+func passphraseFromEnv() string {
+	return os.Getenv(PassphraseEnv)
+}
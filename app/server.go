@@ -97,7 +97,8 @@ func InteractiveConfig(suite network.Suite, binaryName string) {
 				log.Error("Could not parse your public IP address", err)
 				failedPublic = true
 			} else {
-				publicAddress = network.NewAddress(network.TLS, strings.TrimSpace(string(buff))+":"+portStr)
+				publicAddress = network.NewAddress(network.TLS,
+					net.JoinHostPort(strings.TrimSpace(string(buff)), portStr))
 			}
 		}
 	} else {
@@ -249,20 +250,19 @@ func saveFiles(conf *CothorityConfig, fileConf string, group *GroupToml, fileGro
 func askReachableAddress(port string) network.Address {
 	ipStr := Input(DefaultAddress, "IP-address where your server can be reached")
 
-	splitted := strings.Split(ipStr, ":")
-	if len(splitted) == 2 && splitted[1] != port {
-		// if the client gave a port number, it must be the same
-		log.Fatal("The port you gave is not the same as the one your server will be listening. Abort.")
-	} else if len(splitted) == 2 && net.ParseIP(splitted[0]) == nil {
-		// of if the IP address is wrong
-		log.Fatal("Invalid IP:port address given:", ipStr)
-	} else if len(splitted) == 1 {
+	if ip, ok := network.ParseHost(ipStr); ok {
 		// check if the ip is valid
-		if net.ParseIP(ipStr) == nil {
+		if net.ParseIP(ip) == nil {
 			log.Fatal("Invalid IP address given:", ipStr)
 		}
 		// add the port
-		ipStr = ipStr + ":" + port
+		ipStr = net.JoinHostPort(ip, port)
+	} else if ip, p, err := net.SplitHostPort(ipStr); err != nil || net.ParseIP(ip) == nil {
+		// the IP address is wrong
+		log.Fatal("Invalid IP:port address given:", ipStr)
+	} else if p != port {
+		// if the client gave a port number, it must be the same
+		log.Fatal("The port you gave is not the same as the one your server will be listening. Abort.")
 	}
 	return network.NewAddress(network.TLS, ipStr)
 }
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"time"
+
+	"go.dedis.ch/onet/v3/network"
+	"golang.org/x/xerrors"
+)
+
+// TransportConfig configures the TCP connections between the conodes, e.g.
+// for conodes on flaky networks. The durations are written as "10s" or "1m",
+// and the default is kept if one is empty.
+// - DialTimeout: The time given to a connection to another conode to be
+//   established, one minute by default
+// - KeepAlive: The interval between the TCP keepalive probes, "-1s" to
+//   disable them, 15 seconds by default
+// - MaxIdle: The time after which a connection that receives nothing is
+//   closed, one minute by default
+type TransportConfig struct {
+	DialTimeout string `toml:",omitempty" yaml:"DialTimeout,omitempty"`
+	KeepAlive   string `toml:",omitempty" yaml:"KeepAlive,omitempty"`
+	MaxIdle     string `toml:",omitempty" yaml:"MaxIdle,omitempty"`
+}
+
+// config returns the network.TransportConfig of the durations.
+func (tc *TransportConfig) config() (network.TransportConfig, error) {
+	var c network.TransportConfig
+	durations := []struct {
+		name  string
+		value string
+		d     *time.Duration
+	}{
+		{"DialTimeout", tc.DialTimeout, &c.DialTimeout},
+		{"KeepAlive", tc.KeepAlive, &c.KeepAlive},
+		{"MaxIdle", tc.MaxIdle, &c.MaxIdle},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		var err error
+		*d.d, err = time.ParseDuration(d.value)
+		if err != nil {
+			return c, xerrors.Errorf("parsing %s: %v", d.name, err)
+		}
+		if *d.d < 0 && d.name != "KeepAlive" {
+			return c, xerrors.Errorf("%s cannot be negative", d.name)
+		}
+	}
+	return c, nil
+}
+
+// ListenerConfig sets the options of the socket where the conode accepts the
+// connections of the other conodes, e.g. for the conodes that accept many
+// connections. The keepalive of the connections is set by TransportConfig.
+// - Backlog: The maximum number of connections waiting to be accepted, the
+//   default of the system if zero
+// - ReuseAddr: Sets SO_REUSEADDR on the socket
+// - ReusePort: Sets SO_REUSEPORT on the socket, so that a new conode can
+//   start listening on the same port before the old one stops, for hot
+//   restarts
+// The options are only supported on Linux, macOS and FreeBSD.
+type ListenerConfig struct {
+	Backlog   int  `toml:",omitempty" yaml:"Backlog,omitempty"`
+	ReuseAddr bool `toml:",omitempty" yaml:"ReuseAddr,omitempty"`
+	ReusePort bool `toml:",omitempty" yaml:"ReusePort,omitempty"`
+}
+
+// config returns the network.ListenConfig of the options.
+func (lc *ListenerConfig) config() (network.ListenConfig, error) {
+	if lc.Backlog < 0 {
+		return network.ListenConfig{}, xerrors.New("Backlog cannot be negative")
+	}
+	return network.ListenConfig{
+		Backlog:   lc.Backlog,
+		ReuseAddr: lc.ReuseAddr,
+		ReusePort: lc.ReusePort,
+	}, nil
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"go.dedis.ch/onet/v3"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+// The YAML encoding of the configs uses the same keys as their TOML encoding,
+// so that a config can be converted from one to the other without renaming
+// its fields. Unlike in TOML, the keys are case-sensitive. Once decoded, both
+// encodings go through the same defaults and the same parsing of the keys.
+
+// isYAML returns true if the file has a .yaml or .yml extension.
+func isYAML(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// SaveYAML is like Save, but always writes the config in YAML.
+func (hc *CothorityConfig) SaveYAML(file string) error {
+	return hc.save(file, func(w io.Writer) error {
+		buf, err := yaml.Marshal(hc)
+		if err != nil {
+			return xerrors.Errorf("yaml encoding: %v", err)
+		}
+		_, err = w.Write(buf)
+		if err != nil {
+			return xerrors.Errorf("writing config: %v", err)
+		}
+		return nil
+	})
+}
+
+// loadCothorityYAML decodes a conode config written in YAML from r.
+func loadCothorityYAML(r io.Reader) (*CothorityConfig, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, xerrors.Errorf("reading config: %v", err)
+	}
+	hc := &CothorityConfig{}
+	err = yaml.Unmarshal(buf, hc)
+	if err != nil {
+		return nil, xerrors.Errorf("yaml decoding: %v", err)
+	}
+	hc.setDefaults()
+	return hc, nil
+}
+
+// ParseCothorityYAML is like ParseCothority, but always decodes the file as
+// YAML, whatever its extension.
+func ParseCothorityYAML(file string) (*CothorityConfig, *onet.Server, error) {
+	hc, err := loadCothorityFile(file, loadCothorityYAML)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("reading config: %v", err)
+	}
+	return parseCothority(hc, passphraseFromEnv())
+}
+
+// ReadGroupDescYAML is like ReadGroupDescToml, but decodes a group file
+// written in YAML.
+func ReadGroupDescYAML(f io.Reader, options ...ReadOption) (*Group, error) {
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, xerrors.Errorf("reading group: %v", err)
+	}
+	group := &GroupToml{}
+	err = yaml.Unmarshal(buf, group)
+	if err != nil {
+		return nil, xerrors.Errorf("yaml decoding: %v", err)
+	}
+	return group.group(options)
+}
+
+// YAML returns the YAML representation of this GroupToml.
+func (gt *GroupToml) YAML() (string, error) {
+	gt.setDefaults()
+	buf, err := yaml.Marshal(gt)
+	if err != nil {
+		return "", xerrors.Errorf("yaml encoding: %v", err)
+	}
+	return string(buf), nil
+}
+
+// SaveYAML is like Save, but always writes the GroupToml in YAML.
+func (gt *GroupToml) SaveYAML(fname string) error {
+	content, err := gt.YAML()
+	if err != nil {
+		return err
+	}
+	return writeFile(fname, content)
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3/suites"
+)
+
+const privateYAML = `Public: 6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4
+Private: 6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4
+Address: tcp://1.2.3.4:1234
+ListenAddress: 127.0.0.1:0
+Description: This is a description.
+Roles: [indexer, archive]
+Services:
+  OnetConfigTestService:
+    Suite: bn256.adapter
+    Public: 593c700babf825b6056a2339ce437f73f717226a77d618a5e8f0251c00273b38557c3cda8dbde5431d062804275f8757a2c942d888ac09f2df34f806e35e660a3c6f13dc64a7cf112865807450ccbd9f75bb3aadb98599f7034cf377a9b976045df374f840e9ee617631257fc9611def6c7c2e5cf23f5ab36cf72f68f14b6686
+    Private: 622f20fbc7995dd48bab00b0f3d7d13220a9d71716c6be7a45b4b284836041a8
+`
+
+func TestParseCothorityYAML(t *testing.T) {
+	registerService()
+	defer unregisterService()
+
+	tmp, err := ioutil.TempDir("", "conode")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	// The extension is not the one of a YAML file.
+	file := path.Join(tmp, "private.conf")
+	require.NoError(t, ioutil.WriteFile(file, []byte(privateYAML), 0600))
+	hc, srv, err := ParseCothorityYAML(file)
+	require.NoError(t, err)
+	srv.Close()
+	require.Equal(t, "Ed25519", hc.Suite)
+	require.Equal(t, "tcp://1.2.3.4:1234", hc.Address.String())
+	require.Equal(t, []string{"indexer", "archive"}, hc.Roles)
+	require.Equal(t, "bn256.adapter", hc.Services[testServiceName].Suite)
+	require.Equal(t, 1, len(srv.ServerIdentity.ServiceIdentities))
+
+	_, _, err = ParseCothority(file)
+	require.Error(t, err)
+
+	// Both encodings are chosen by the extension and give the same config.
+	for _, name := range []string{"private.toml", "private.yaml", "private.yml"} {
+		file = path.Join(tmp, name)
+		require.NoError(t, hc.Save(file))
+		data, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(data), "# This file contains your private key."))
+		require.Equal(t, isYAML(name), strings.Contains(string(data), "Suite: Ed25519"))
+
+		saved, srv, err := ParseCothority(file)
+		require.NoError(t, err)
+		srv.Close()
+		require.Equal(t, hc, saved)
+	}
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("Public: [a"), 0600))
+	_, _, err = ParseCothorityYAML(file)
+	require.Error(t, err)
+}
+
+func TestReadGroupDescYAML(t *testing.T) {
+	registerService()
+	defer unregisterService()
+
+	group, err := ReadGroupDescToml(strings.NewReader(serverGroup))
+	require.NoError(t, err)
+
+	tmp, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	filename := path.Join(tmp, "public.yaml")
+	require.NoError(t, group.Save(suites.MustFind("ed25519"), filename))
+
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	defer f.Close()
+	yamlGroup, err := ReadGroupDescYAML(f)
+	require.NoError(t, err)
+
+	require.Equal(t, len(group.Roster.List), len(yamlGroup.Roster.List))
+	for i, si := range group.Roster.List {
+		ysi := yamlGroup.Roster.List[i]
+		require.True(t, si.Equal(ysi))
+		require.Equal(t, si.Address, ysi.Address)
+		require.Equal(t, si.URL, ysi.URL)
+		require.Equal(t, si.ServiceIdentities, ysi.ServiceIdentities)
+		require.Equal(t, group.Description[si], yamlGroup.Description[ysi])
+	}
+
+	// The suite defaults to Ed25519, as in the group.toml files.
+	yamlGroup, err = ReadGroupDescYAML(strings.NewReader(`servers:
+- Address: tcp://185.26.156.40:61117
+  Public: 6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4
+`))
+	require.NoError(t, err)
+	require.True(t, group.Roster.List[1].Equal(yamlGroup.Roster.List[0]))
+
+	_, err = ReadGroupDescYAML(strings.NewReader("servers: {"))
+	require.Error(t, err)
+}
@@ -0,0 +1,26 @@
+package onet
+
+import (
+	"net/http"
+
+	"golang.org/x/xerrors"
+)
+
+// checkAuth returns the error of the Authenticator if it rejects the request
+// of the client for the message msgName. The StatusErrors keep their code,
+// e.g. 401 for a missing token, and the other errors get the code 403. The
+// requests that don't come from HTTP are not checked.
+func (p *ServiceProcessor) checkAuth(r *http.Request, msgName string) error {
+	if p.Authenticator == nil || r == nil {
+		return nil
+	}
+	err := p.Authenticator(r, msgName)
+	if err == nil {
+		return nil
+	}
+	var se StatusError
+	if xerrors.As(err, &se) {
+		return err
+	}
+	return StatusError{Code: http.StatusForbidden, Msg: err.Error()}
+}
@@ -0,0 +1,69 @@
+package onet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+func TestServiceProcessor_Authenticator(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(t, p.RegisterHandler(procMsg))
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", 3, 3))
+
+	var names []string
+	p.Authenticator = func(r *http.Request, msgName string) error {
+		names = append(names, msgName)
+		switch r.Header.Get("Authorization") {
+		case "":
+			return StatusError{Code: http.StatusUnauthorized, Msg: "missing token"}
+		case "Bearer secret":
+			return nil
+		}
+		return xerrors.New("invalid token")
+	}
+
+	buf, err := protobuf.Encode(&testMsg{11})
+	require.NoError(t, err)
+	process := func(token string) error {
+		r := httptest.NewRequest("GET", "/", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		_, _, err := p.ProcessClientRequest(r, "testMsg", buf)
+		return err
+	}
+	require.NoError(t, process("secret"))
+	for token, expected := range map[string]int{
+		"":      http.StatusUnauthorized,
+		"wrong": http.StatusForbidden,
+	} {
+		code, ok := statusErrorCode(process(token))
+		require.True(t, ok)
+		require.Equal(t, expected, code)
+	}
+	// The requests that don't come from HTTP are not checked.
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	require.NoError(t, err)
+	require.Equal(t, []string{"testMsg", "testMsg", "testMsg"}, names)
+
+	get := func(token string) int {
+		r := httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/42", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w.Code
+	}
+	require.Equal(t, http.StatusOK, get("secret"))
+	require.Equal(t, http.StatusUnauthorized, get(""))
+	require.Equal(t, http.StatusForbidden, get("wrong"))
+	require.Equal(t, "restMsgGET2", names[len(names)-1])
+}
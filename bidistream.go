@@ -0,0 +1,113 @@
+package onet
+
+import (
+	"context"
+	"reflect"
+
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+// inboundBufferSize is the capacity of the inbound channels of the
+// bidirectional streaming handlers.
+const inboundBufferSize = 10
+
+// RegisterBidirectionalStreamingHandler stores a streaming handler that also
+// receives the messages the client sends after the first one. It is reached
+// like the handlers of RegisterStreamingHandler, and f must be in the
+// following form:
+// func(msg interface{}, inChan chan interface{})(retChan chan interface{}, closeChan chan bool, err error)
+//
+//  * msg is a pointer to a structure to the first message sent.
+//  * inChan is a channel of pointers to structures, which can also be
+//    receive-only, where the next messages of the client are decoded.
+//  * retChan and closeChan are the ones of RegisterStreamingHandler.
+//  * err is an error, it can be nil, or any type that implements error.
+//
+// The handler is called once, with the first message. When the client goes
+// away, inChan is closed along with closeChan. The handler must keep reading
+// inChan, as a full inChan blocks the messages of the client, including the
+// close of the connection. The messages of inChan that cannot be decoded or
+// that break their validation rules are logged and dropped.
+//
+// This method is experimental.
+func (p *ServiceProcessor) RegisterBidirectionalStreamingHandler(f interface{}) error {
+	sh, err := newBidirectionalHandler(f)
+	if err != nil {
+		return err
+	}
+	if err := p.checkReplyTypes(f); err != nil {
+		return err
+	}
+
+	log.Lvl4("Registering bidirectional streaming handler", sh.msgType.String())
+	pm, err := messageName(sh.msgType)
+	if err != nil {
+		return err
+	}
+	p.handlersLock.Lock()
+	p.handlers[pm] = sh
+	p.handlersLock.Unlock()
+	return nil
+}
+
+// newBidirectionalHandler checks that f is a bidirectional streaming handler
+// and returns it.
+func newBidirectionalHandler(f interface{}) (serviceHandler, error) {
+	if err := bidirectionalInputCheck(f); err != nil {
+		return serviceHandler{}, err
+	}
+	if err := streamingOutputCheck(f); err != nil {
+		return serviceHandler{}, err
+	}
+	ft := reflect.TypeOf(f)
+	msgType, inType := ft.In(0).Elem(), ft.In(1)
+	for _, t := range []reflect.Type{msgType, inType.Elem().Elem()} {
+		if err := checkMessageType(t); err != nil {
+			return serviceHandler{}, err
+		}
+	}
+	return serviceHandler{handler: f, msgType: msgType, streaming: true,
+		inType: inType}, nil
+}
+
+// bidirectionalInputCheck checks that f takes a pointer to a struct and a
+// channel of pointers to structs.
+func bidirectionalInputCheck(f interface{}) error {
+	ft := reflect.TypeOf(f)
+	if ft.Kind() != reflect.Func || ft.NumIn() != 2 {
+		return xerrors.New("Need a function with two arguments")
+	}
+	if ft.In(0).Kind() != reflect.Ptr || ft.In(0).Elem().Kind() != reflect.Struct {
+		return xerrors.New("1st argument must be a pointer to a struct")
+	}
+	in := ft.In(1)
+	if in.Kind() != reflect.Chan || in.ChanDir()&reflect.RecvDir == 0 {
+		return xerrors.New("2nd argument must be a channel the handler receives from")
+	}
+	if in.Elem().Kind() != reflect.Ptr || in.Elem().Elem().Kind() != reflect.Struct {
+		return xerrors.New("2nd argument must be a channel of pointers to structs")
+	}
+	return nil
+}
+
+// sendInbound decodes the next message of the client into the inbound channel
+// of the handler mh, unless the handler is done.
+func (p *ServiceProcessor) sendInbound(ctx context.Context, codec Codec, msgName string,
+	mh serviceHandler, inbound reflect.Value, buf []byte, handlerDone chan struct{}) {
+	msg := reflect.New(mh.inType.Elem().Elem()).Interface()
+	if err := p.decodeMessage(codec, buf, msg); err != nil {
+		log.Error(xerrors.Errorf("failed to decode inbound message: %v", err))
+		return
+	}
+	p.reportDeprecated(msgName, msg)
+	if err := prepareMessage(msg); err != nil {
+		log.Error(xerrors.Errorf("invalid inbound message: %v", err))
+		return
+	}
+	reflect.Select([]reflect.SelectCase{
+		{Dir: reflect.SelectSend, Chan: inbound, Send: reflect.ValueOf(msg)},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(handlerDone)},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+	})
+}
@@ -0,0 +1,97 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type bidiStreamingService struct {
+	*ServiceProcessor
+	inboundClosed chan bool
+}
+
+func newBidiStreamingService(c *Context) (Service, error) {
+	s := &bidiStreamingService{
+		ServiceProcessor: NewServiceProcessor(c),
+		inboundClosed:    make(chan bool, 1),
+	}
+	if err := s.RegisterBidirectionalStreamingHandler(s.Multiply); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Multiply sends back the values of the client multiplied by the value of
+// the first message.
+func (s *bidiStreamingService) Multiply(msg *SimpleRequest, in <-chan *SimpleResponse) (chan *SimpleResponse, chan bool, error) {
+	out := make(chan *SimpleResponse)
+	stop := make(chan bool)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					s.inboundClosed <- true
+					return
+				}
+				select {
+				case out <- &SimpleResponse{Val: v.Val * msg.Val}:
+				case <-stop:
+				}
+			case <-stop:
+			}
+		}
+	}()
+	return out, stop, nil
+}
+
+func TestBidirectionalInputCheck(t *testing.T) {
+	for _, f := range []interface{}{
+		func(*SimpleRequest) (chan *SimpleResponse, chan bool, error) { return nil, nil, nil },
+		func(SimpleRequest, chan *SimpleResponse) (chan *SimpleResponse, chan bool, error) { return nil, nil, nil },
+		func(*SimpleRequest, chan<- *SimpleResponse) (chan *SimpleResponse, chan bool, error) { return nil, nil, nil },
+		func(*SimpleRequest, chan SimpleResponse) (chan *SimpleResponse, chan bool, error) { return nil, nil, nil },
+		func(*SimpleRequest, []*SimpleResponse) (chan *SimpleResponse, chan bool, error) { return nil, nil, nil },
+	} {
+		require.Error(t, bidirectionalInputCheck(f))
+	}
+	require.NoError(t, bidirectionalInputCheck(
+		func(*SimpleRequest, chan *SimpleResponse) (chan *SimpleResponse, chan bool, error) { return nil, nil, nil }))
+}
+
+func TestWebSocket_Streaming_Bidirectional(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+
+	serName := "bidiStreamingService"
+	_, err := RegisterNewService(serName, newBidiStreamingService)
+	require.NoError(t, err)
+	defer UnregisterService(serName)
+
+	servers, el, _ := local.GenTree(1, false)
+	client := local.NewClientKeep(serName)
+	conn, err := client.Stream(servers[0].ServerIdentity, &SimpleRequest{
+		ServerIdentities: el,
+		Val:              3,
+	})
+	require.NoError(t, err)
+
+	for i := int64(1); i <= 3; i++ {
+		require.NoError(t, conn.WriteMessage(&SimpleResponse{Val: i}))
+		sr := &SimpleResponse{}
+		require.NoError(t, conn.ReadMessage(sr))
+		require.Equal(t, 3*i, sr.Val)
+	}
+
+	// The inbound channel is closed when the client goes away.
+	require.NoError(t, client.Close())
+	s := servers[0].Service(serName).(*bidiStreamingService)
+	select {
+	case <-s.inboundClosed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the inbound channel was not closed")
+	}
+}
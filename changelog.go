@@ -0,0 +1,84 @@
+package onet
+
+import (
+	"sort"
+
+	"golang.org/x/xerrors"
+)
+
+// The kinds of the changes of the changelog of the REST API.
+const (
+	ChangeAdded      = "added"
+	ChangeChanged    = "changed"
+	ChangeDeprecated = "deprecated"
+	ChangeRemoved    = "removed"
+)
+
+// ChangelogEntry describes a change of the REST API of a service in a
+// version, such as a resource added in v4 or a field deprecated in v5.
+type ChangelogEntry struct {
+	Version int `json:"version"`
+	// Change is one of ChangeAdded, ChangeChanged, ChangeDeprecated and
+	// ChangeRemoved.
+	Change string `json:"change"`
+	// Resource is the name of the message, and Field the name of its field
+	// if the change is about a single field.
+	Resource    string `json:"resource"`
+	Field       string `json:"field,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Changelog is the message of the handler registered by
+// RegisterChangelogHandler.
+type Changelog struct{}
+
+// ChangelogReply holds the changelog of the REST API, ordered by version.
+type ChangelogReply struct {
+	Entries []ChangelogEntry `json:"entries"`
+}
+
+// AddChangelog adds the entries to the changelog of the REST API, which is
+// served by RegisterChangelogHandler and included in OpenAPISpec, so that
+// the clients can check what changed between the versions they support. The
+// versions of the entries must be valid versions of the API.
+func (p *ServiceProcessor) AddChangelog(entries ...ChangelogEntry) error {
+	for _, e := range entries {
+		if e.Version < 3 || e.Version > CurrentAPIVersion {
+			return xerrors.Errorf("invalid version %d of %s", e.Version, e.Resource)
+		}
+		switch e.Change {
+		case ChangeAdded, ChangeChanged, ChangeDeprecated, ChangeRemoved:
+		default:
+			return xerrors.Errorf("invalid change '%s' of %s", e.Change, e.Resource)
+		}
+		if e.Resource == "" {
+			return xerrors.New("missing resource")
+		}
+	}
+
+	p.handlersLock.Lock()
+	defer p.handlersLock.Unlock()
+	p.changelog = append(p.changelog, entries...)
+	// stable, so that the entries of a version keep the order they were
+	// added in
+	sort.SliceStable(p.changelog, func(i, j int) bool {
+		return p.changelog[i].Version < p.changelog[j].Version
+	})
+	return nil
+}
+
+// GetChangelog returns the entries of the changelog, ordered by version.
+func (p *ServiceProcessor) GetChangelog() []ChangelogEntry {
+	p.handlersLock.RLock()
+	defer p.handlersLock.RUnlock()
+	return append([]ChangelogEntry{}, p.changelog...)
+}
+
+// RegisterChangelogHandler registers a REST handler answering the GET
+// requests on /v$version/$namespace/Changelog with the changelog, in every
+// version of the API.
+func (p *ServiceProcessor) RegisterChangelogHandler(namespace string) error {
+	return p.RegisterRESTHandlerAllVersions(func(*Changelog) (*ChangelogReply, error) {
+		return &ChangelogReply{Entries: p.GetChangelog()}, nil
+	}, namespace, "GET", 3)
+}
@@ -0,0 +1,49 @@
+package onet
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceProcessor_Changelog(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+
+	require.Error(t, p.AddChangelog(ChangelogEntry{Version: 2, Change: ChangeAdded, Resource: "A"}))
+	require.Error(t, p.AddChangelog(ChangelogEntry{Version: 3, Change: "renamed", Resource: "A"}))
+	require.Error(t, p.AddChangelog(ChangelogEntry{Version: 3, Change: ChangeAdded}))
+	require.Empty(t, p.GetChangelog())
+
+	require.NoError(t, p.AddChangelog(
+		ChangelogEntry{Version: 3, Change: ChangeDeprecated, Resource: "A", Field: "Old"},
+		ChangelogEntry{Version: 3, Change: ChangeAdded, Resource: "B"}))
+	require.NoError(t, p.AddChangelog(ChangelogEntry{Version: 3, Change: ChangeAdded, Resource: "C"}))
+	require.NoError(t, p.RegisterChangelogHandler("dummyService"))
+
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, httptest.NewRequest("GET", "/v3/dummyService/Changelog", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var reply ChangelogReply
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+	require.Equal(t, p.GetChangelog(), reply.Entries)
+	require.Len(t, reply.Entries, 3)
+	require.Equal(t, "Old", reply.Entries[0].Field)
+	require.Equal(t, "C", reply.Entries[2].Resource)
+
+	buf, err := p.OpenAPISpec()
+	require.NoError(t, err)
+	var spec struct {
+		Info struct {
+			Changelog []ChangelogEntry `json:"x-changelog"`
+		}
+		Paths map[string]interface{}
+	}
+	require.NoError(t, json.Unmarshal(buf, &spec))
+	require.Equal(t, reply.Entries, spec.Info.Changelog)
+	require.Contains(t, spec.Paths, "/v3/dummyService/Changelog")
+}
@@ -0,0 +1,94 @@
+package onet
+
+import (
+	"net/http"
+	"strings"
+
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+)
+
+// Codec encodes and decodes the messages exchanged with the clients of a
+// ServiceProcessor over the websocket, i.e. the requests given to
+// ProcessClientRequest and ProcessClientStreamRequest and their replies. The
+// REST API is not concerned, as it negotiates its format with the clients.
+type Codec interface {
+	// Encode returns the wire form of msg, a pointer to a reply.
+	Encode(msg interface{}) ([]byte, error)
+	// Decode fills msg, a pointer to a new request, from buf.
+	Decode(buf []byte, msg interface{}) error
+}
+
+// protobufCodec is the default Codec, which uses protobuf with the
+// constructors of the suite of the server.
+type protobufCodec struct {
+	cons protobuf.Constructors
+}
+
+func (c protobufCodec) Encode(msg interface{}) ([]byte, error) {
+	return protobuf.Encode(msg)
+}
+
+func (c protobufCodec) Decode(buf []byte, msg interface{}) error {
+	return protobuf.DecodeWithConstructors(buf, msg, c.cons)
+}
+
+// codec returns the Codec of the websocket messages of the request req, which
+// may be nil.
+func (p *ServiceProcessor) codec(req *http.Request) Codec {
+	if p.Codec != nil {
+		return p.Codec
+	}
+	return protobufCodec{cons: p.suiteConstructors(p.requestSuite(req))}
+}
+
+// requestSuite returns the suite of the points and scalars of the request
+// req, which may be nil: the one returned by SuiteForRequest, if any, or the
+// suite of the server.
+func (p *ServiceProcessor) requestSuite(req *http.Request) network.Suite {
+	if p.SuiteForRequest != nil {
+		if suite := p.SuiteForRequest(req); suite != nil {
+			return suite
+		}
+	}
+	return p.Context.server.Suite()
+}
+
+// jsonTagCodec is the Codec of the websocket clients that ask for JSON. The
+// names of the fields are taken from the struct tag, if it is not empty.
+type jsonTagCodec struct {
+	tag string
+}
+
+func (c jsonTagCodec) Encode(msg interface{}) ([]byte, error) {
+	return marshalJSON(msg, c.tag)
+}
+
+func (c jsonTagCodec) Decode(buf []byte, msg interface{}) error {
+	return unmarshalJSON(buf, msg, c.tag)
+}
+
+// requestCodec returns the Codec of the messages of the websocket request
+// req: JSON if the client asked for it, as on the REST API, with the format
+// query parameter, e.g. ?format=json, or with the Accept header, and the
+// Codec of codec otherwise.
+func (p *ServiceProcessor) requestCodec(req *http.Request) Codec {
+	if req != nil && wantsJSON(req) {
+		return jsonTagCodec{tag: p.RESTTagName}
+	}
+	return p.codec(req)
+}
+
+// wantsJSON returns true if the client of the request explicitly asked for
+// JSON.
+func wantsJSON(r *http.Request) bool {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f == formatJSON
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if strings.TrimSpace(strings.Split(accept, ";")[0]) == formatContentTypes[formatJSON][0] {
+			return true
+		}
+	}
+	return false
+}
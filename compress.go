@@ -0,0 +1,249 @@
+package onet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/xerrors"
+)
+
+// maxDecompressedSize is the maximum size of a compressed request body once
+// it has been decompressed, when no other limit is given. It protects
+// against decompression bombs.
+const maxDecompressedSize = 32 * 1024 * 1024
+
+// Supported content encodings of the REST API, in order of preference for
+// the responses.
+const (
+	encodingZstd = "zstd"
+	encodingGzip = "gzip"
+)
+
+var supportedEncodings = []string{encodingZstd, encodingGzip}
+
+// readBody reads the body of the request and decompresses it according to
+// the Content-Encoding header. An error is returned if the encoding is not
+// supported, and errBodyTooLarge if the decompressed body is bigger than
+// maxSize or if the body exceeds the limit of an http.MaxBytesReader. A
+// maxSize of zero or less is no limit, except for a compressed body that is
+// then limited to maxDecompressedSize.
+func readBody(r *http.Request, maxSize int64) ([]byte, error) {
+	body, err := bodyReader(r, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	buf, err := ioutil.ReadAll(body)
+	if err != nil {
+		if body.tooLarge() || isMaxBytesError(err) {
+			return nil, errBodyTooLarge
+		}
+		return nil, xerrors.Errorf("reading body: %v", err)
+	}
+	return buf, nil
+}
+
+// errBodyTooLarge is returned when reading more than the maximum size of a
+// request body.
+var errBodyTooLarge = xerrors.New("request body is too big")
+
+// isMaxBytesError tells if err comes from reading more than the limit of an
+// http.MaxBytesReader, whose error has no type before Go 1.19.
+func isMaxBytesError(err error) bool {
+	return strings.Contains(err.Error(), "http: request body too large")
+}
+
+// bodyReader returns a reader of the body of the request, decompressed
+// according to the Content-Encoding header. Reading more than maxSize bytes
+// from it returns errBodyTooLarge. A maxSize of zero or less is no limit,
+// except for a compressed body that is then limited to maxDecompressedSize.
+func bodyReader(r *http.Request, maxSize int64) (*limitedReader, error) {
+	var body io.Reader
+	closer := func() {}
+	enc := r.Header.Get("Content-Encoding")
+	switch {
+	case maxSize > 0:
+	case enc == "" || enc == "identity":
+		// One less than the largest size, so that reading one more byte
+		// than allowed doesn't overflow.
+		maxSize = math.MaxInt64 - 1
+	default:
+		maxSize = maxDecompressedSize
+	}
+	switch enc {
+	case "", "identity":
+		body = r.Body
+	case encodingGzip:
+		gr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, xerrors.Errorf("gzip reader: %v", err)
+		}
+		body = gr
+		closer = func() { gr.Close() }
+	case encodingZstd:
+		// The window of the frames may be bigger than a small limit,
+		// which the limitedReader still enforces.
+		mem := uint64(maxDecompressedSize)
+		if maxSize > maxDecompressedSize {
+			mem = uint64(maxSize)
+		}
+		zr, err := zstd.NewReader(r.Body, zstd.WithDecoderMaxMemory(mem))
+		if err != nil {
+			return nil, xerrors.Errorf("zstd reader: %v", err)
+		}
+		body = zr
+		closer = zr.Close
+	default:
+		return nil, xerrors.Errorf("unsupported content encoding: %s", enc)
+	}
+	return &limitedReader{r: body, n: maxSize, close: closer}, nil
+}
+
+// limitedReader reads at most n bytes from r, and fails with
+// errBodyTooLarge if more are available, unlike io.LimitedReader that
+// silently stops.
+type limitedReader struct {
+	r     io.Reader
+	n     int64
+	close func()
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, errBodyTooLarge
+	}
+	// Read one more byte than allowed to detect an oversized body.
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n + int(l.n), errBodyTooLarge
+	}
+	return n, err
+}
+
+// tooLarge tells if more than the maximum size has been read.
+func (l *limitedReader) tooLarge() bool {
+	return l.n < 0
+}
+
+func (l *limitedReader) Close() error {
+	l.close()
+	return nil
+}
+
+// acceptedEncoding returns the preferred encoding supported by both the
+// client, as given in its Accept-Encoding header, and the server. It returns
+// an empty string if none of them is accepted.
+func acceptedEncoding(r *http.Request) string {
+	return preferredEncoding(r.Header.Get("Accept-Encoding"))
+}
+
+// preferredEncoding returns the preferred encoding of the server among the
+// ones of the header, a list in the form of Accept-Encoding. It returns an
+// empty string if none of them is supported.
+func preferredEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, enc := range strings.Split(header, ",") {
+		parts := strings.Split(enc, ";")
+		if len(parts) > 1 && strings.TrimSpace(parts[1]) == "q=0" {
+			continue
+		}
+		accepted[strings.TrimSpace(parts[0])] = true
+	}
+	for _, enc := range supportedEncodings {
+		if accepted[enc] {
+			return enc
+		}
+	}
+	return ""
+}
+
+// compress encodes buf with the given encoding.
+func compress(enc string, buf []byte) ([]byte, error) {
+	var out bytes.Buffer
+	var w io.WriteCloser
+	switch enc {
+	case encodingGzip:
+		w = gzip.NewWriter(&out)
+	case encodingZstd:
+		zw, err := zstd.NewWriter(&out)
+		if err != nil {
+			return nil, xerrors.Errorf("zstd writer: %v", err)
+		}
+		w = zw
+	default:
+		return nil, xerrors.Errorf("unsupported content encoding: %s", enc)
+	}
+	if _, err := w.Write(buf); err != nil {
+		return nil, xerrors.Errorf("compressing: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, xerrors.Errorf("closing compressor: %v", err)
+	}
+	return out.Bytes(), nil
+}
+
+// decompress decodes buf with the given encoding. It returns errBodyTooLarge
+// if the decompressed buffer is bigger than maxDecompressedSize.
+func decompress(enc string, buf []byte) ([]byte, error) {
+	var r io.Reader
+	switch enc {
+	case encodingGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(buf))
+		if err != nil {
+			return nil, xerrors.Errorf("gzip reader: %v", err)
+		}
+		defer gr.Close()
+		r = gr
+	case encodingZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(buf),
+			zstd.WithDecoderMaxMemory(maxDecompressedSize))
+		if err != nil {
+			return nil, xerrors.Errorf("zstd reader: %v", err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, xerrors.Errorf("unsupported content encoding: %s", enc)
+	}
+	out, err := ioutil.ReadAll(&limitedReader{r: r, n: maxDecompressedSize})
+	if err != nil {
+		if err == errBodyTooLarge {
+			return nil, err
+		}
+		return nil, xerrors.Errorf("decompressing: %v", err)
+	}
+	return out, nil
+}
+
+// writeReply writes the reply of a REST request, compressed with the
+// encoding negotiated with the client if any. The reply of a HEAD request
+// only has the headers, with the Content-Length of the body a GET would get.
+func writeReply(w http.ResponseWriter, r *http.Request, reply []byte) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if enc := acceptedEncoding(r); enc != "" {
+		compressed, err := compress(enc, reply)
+		if err != nil {
+			http.Error(w, wrapJSONMsg(err.Error()), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Encoding", enc)
+		reply = compressed
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(reply)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(reply)
+}
@@ -0,0 +1,125 @@
+package onet
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompress_ReadBody(t *testing.T) {
+	body := []byte(`{"S": "42"}`)
+	for _, enc := range supportedEncodings {
+		buf, err := compress(enc, body)
+		require.NoError(t, err)
+
+		r := httptest.NewRequest("POST", "/", bytes.NewReader(buf))
+		r.Header.Set("Content-Encoding", enc)
+		dec, err := readBody(r, 0)
+		require.NoError(t, err)
+		require.Equal(t, body, dec)
+	}
+
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	dec, err := readBody(r, 0)
+	require.NoError(t, err)
+	require.Equal(t, body, dec)
+
+	r = httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	r.Header.Set("Content-Encoding", "br")
+	_, err = readBody(r, 0)
+	require.Error(t, err)
+}
+
+func TestCompress_ReadBodyBomb(t *testing.T) {
+	for _, enc := range supportedEncodings {
+		buf, err := compress(enc, make([]byte, maxDecompressedSize+1))
+		require.NoError(t, err)
+		require.True(t, len(buf) < maxDecompressedSize/100)
+
+		r := httptest.NewRequest("POST", "/", bytes.NewReader(buf))
+		r.Header.Set("Content-Encoding", enc)
+		_, err = readBody(r, 0)
+		require.Error(t, err)
+	}
+}
+
+func TestCompress_ReadBodyLimit(t *testing.T) {
+	body := make([]byte, 1024)
+	for _, enc := range supportedEncodings {
+		buf, err := compress(enc, body)
+		require.NoError(t, err)
+
+		// The limit is on the decompressed body.
+		r := httptest.NewRequest("POST", "/", bytes.NewReader(buf))
+		r.Header.Set("Content-Encoding", enc)
+		_, err = readBody(r, int64(len(body)-1))
+		require.Equal(t, errBodyTooLarge, err)
+
+		r = httptest.NewRequest("POST", "/", bytes.NewReader(buf))
+		r.Header.Set("Content-Encoding", enc)
+		dec, err := readBody(r, int64(len(body)))
+		require.NoError(t, err)
+		require.Equal(t, body, dec)
+	}
+
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	_, err := readBody(r, int64(len(body)-1))
+	require.Equal(t, errBodyTooLarge, err)
+
+	// Without a limit, a plain body can be bigger than the default limit
+	// of the compressed ones, which a bigger limit also lifts.
+	big := make([]byte, maxDecompressedSize+1)
+	r = httptest.NewRequest("POST", "/", bytes.NewReader(big))
+	dec, err := readBody(r, 0)
+	require.NoError(t, err)
+	require.Equal(t, len(big), len(dec))
+
+	buf, err := compress(encodingGzip, big)
+	require.NoError(t, err)
+	r = httptest.NewRequest("POST", "/", bytes.NewReader(buf))
+	r.Header.Set("Content-Encoding", encodingGzip)
+	dec, err = readBody(r, int64(len(big)))
+	require.NoError(t, err)
+	require.Equal(t, len(big), len(dec))
+}
+
+func TestCompress_AcceptedEncoding(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	require.Equal(t, "", acceptedEncoding(r))
+	r.Header.Set("Accept-Encoding", "gzip, deflate")
+	require.Equal(t, encodingGzip, acceptedEncoding(r))
+	r.Header.Set("Accept-Encoding", "gzip, zstd")
+	require.Equal(t, encodingZstd, acceptedEncoding(r))
+	r.Header.Set("Accept-Encoding", "gzip, zstd;q=0")
+	require.Equal(t, encodingGzip, acceptedEncoding(r))
+	r.Header.Set("Accept-Encoding", "br")
+	require.Equal(t, "", acceptedEncoding(r))
+}
+
+func TestCompress_REST(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgPOSTString, "dummyService", "POST", 3, 3))
+
+	body, err := compress(encodingZstd, []byte(`{"S": "42"}`))
+	require.NoError(t, err)
+	r := httptest.NewRequest("POST", "/v3/dummyService/restMsgPOSTString", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-Encoding", encodingZstd)
+	r.Header.Set("Accept-Encoding", encodingZstd)
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, encodingZstd, w.Header().Get("Content-Encoding"))
+
+	zr, err := zstd.NewReader(w.Body)
+	require.NoError(t, err)
+	defer zr.Close()
+	require.NoError(t, json.NewDecoder(zr).Decode(&testMsg{}))
+}
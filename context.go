@@ -122,6 +122,14 @@ func (c *Context) RegisterStatusReporter(name string, s StatusReporter) {
 	c.server.statusReporterStruct.RegisterStatusReporter(name, s)
 }
 
+// RegisterReadinessProbe registers a probe telling whether the service is
+// ready to serve the requests. The /ready endpoint of the websocket answers
+// with 200 OK only when all the probes are ready, and with 503 Service
+// Unavailable otherwise, while /ok only tells that the server is alive.
+func (c *Context) RegisterReadinessProbe(name string, probe ReadinessProbe) {
+	c.server.WebSocket.readiness.register(name, probe)
+}
+
 // RegisterProcessor overrides the RegisterProcessor methods of the Dispatcher.
 // It delegates the dispatching to the serviceManager.
 func (c *Context) RegisterProcessor(p network.Processor, msgType network.MessageTypeID) {
@@ -0,0 +1,68 @@
+package onet
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig configures the Cross-Origin Resource Sharing headers of the REST
+// API, so that the browsers let the web pages of other origins call it. Only
+// the whitelisted origins are allowed, as the services of a conode can be
+// sensitive.
+type CORSConfig struct {
+	// AllowedOrigins are the origins that can call the REST API, such as
+	// "https://example.com". "*" allows all the origins.
+	AllowedOrigins []string
+	// AllowedHeaders are the headers the requests can have in addition to
+	// the ones always allowed by the browsers. Content-Type is always
+	// allowed.
+	AllowedHeaders []string
+	// MaxAge, if not zero, is how long the browsers can cache the answer to
+	// a preflight request.
+	MaxAge time.Duration
+}
+
+// allowOrigin tells if the origin is whitelisted.
+func (c *CORSConfig) allowOrigin(origin string) bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// handle sets the CORS headers of the response to a request on a route
+// accepting method. It returns true if the request is a preflight request,
+// which has then been answered.
+func (c *CORSConfig) handle(w http.ResponseWriter, r *http.Request, method string) bool {
+	origin := r.Header.Get("Origin")
+	preflight := r.Method == http.MethodOptions &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+	w.Header().Add("Vary", "Origin")
+	if origin == "" {
+		return false
+	}
+	if !c.allowOrigin(origin) {
+		if preflight {
+			http.Error(w, wrapJSONMsg("origin not allowed"), http.StatusForbidden)
+		}
+		return preflight
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if !preflight {
+		return false
+	}
+	headers := append([]string{"Content-Type"}, c.AllowedHeaders...)
+	w.Header().Set("Access-Control-Allow-Methods", method+", OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	if c.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age",
+			strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
@@ -0,0 +1,63 @@
+package onet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceProcessor_CORS(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", 3, 3))
+
+	request := func(method, origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/v3/dummyService/restMsgGET2/42", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if method == http.MethodOptions {
+			r.Header.Set("Access-Control-Request-Method", "GET")
+		}
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w
+	}
+
+	// Without configuration, nothing changes.
+	w := request("GET", "https://example.com")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+
+	p.CORS = &CORSConfig{
+		AllowedOrigins: []string{"https://example.com"},
+		AllowedHeaders: []string{"Authorization"},
+		MaxAge:         time.Hour,
+	}
+	w = request("GET", "https://example.com")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = request("GET", "https://evil.com")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = request(http.MethodOptions, "https://example.com")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "GET, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
+	require.Equal(t, "Content-Type, Authorization", w.Header().Get("Access-Control-Allow-Headers"))
+	require.Equal(t, "3600", w.Header().Get("Access-Control-Max-Age"))
+
+	w = request(http.MethodOptions, "https://evil.com")
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	p.CORS.AllowedOrigins = []string{"*"}
+	w = request(http.MethodOptions, "https://evil.com")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, "https://evil.com", w.Header().Get("Access-Control-Allow-Origin"))
+}
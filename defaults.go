@@ -0,0 +1,17 @@
+package onet
+
+// Defaulter is implemented by the messages that fill in the default values of
+// the fields the client left empty, e.g. a Limit of 100 when it is zero.
+// Defaults is called on every decoded message, from the websocket or the REST
+// API, before it is given to the middlewares and to the handler, so that the
+// handlers don't need to check each optional field themselves.
+type Defaulter interface {
+	Defaults()
+}
+
+// applyDefaults calls the Defaults method of msg if it has one.
+func applyDefaults(msg interface{}) {
+	if d, ok := msg.(Defaulter); ok {
+		d.Defaults()
+	}
+}
@@ -0,0 +1,60 @@
+package onet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+type defaultedMsg struct {
+	Limit int64
+	Name  string
+}
+
+func (m *defaultedMsg) Defaults() {
+	if m.Limit == 0 {
+		m.Limit = 100
+	}
+}
+
+func TestServiceProcessor_Defaults(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	srv := local.GenServers(1)[0]
+	p := NewServiceProcessor(&Context{server: srv})
+
+	var received []defaultedMsg
+	h := func(msg *defaultedMsg) (*defaultedMsg, error) {
+		received = append(received, *msg)
+		return msg, nil
+	}
+	require.NoError(t, p.RegisterHandler(h))
+	require.NoError(t, p.RegisterRESTHandler(h, "dummyService", "POST", 3, 3))
+
+	for _, msg := range []*defaultedMsg{{Name: "a"}, {Limit: 5, Name: "b"}} {
+		buf, err := protobuf.Encode(msg)
+		require.NoError(t, err)
+		_, _, err = p.ProcessClientRequest(nil, "defaultedMsg", buf)
+		require.NoError(t, err)
+	}
+
+	for _, body := range []string{`{"Name": "c"}`, `{"Limit": 7, "Name": "d"}`, `{"Name": "e"}`} {
+		r := httptest.NewRequest("POST", "/v3/dummyService/defaultedMsg", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	require.Equal(t, []defaultedMsg{
+		{Limit: 100, Name: "a"},
+		{Limit: 5, Name: "b"},
+		{Limit: 100, Name: "c"},
+		{Limit: 7, Name: "d"},
+		{Limit: 100, Name: "e"},
+	}, received)
+}
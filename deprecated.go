@@ -0,0 +1,71 @@
+package onet
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+// The fields of the messages can be deprecated with the deprecated option of
+// the onet struct tag, e.g. `onet:"deprecated"`, or `onet:"name,deprecated"`
+// if RESTTagName is "onet". When a client sends a message with a deprecated
+// field that is not empty, the reply of the REST API gets a Warning header,
+// the use is counted by the metrics, if enabled, and logged the first time.
+// The deprecated fields are also marked in the schemas of OpenAPISpec.
+
+// isDeprecated tells if the field f has the deprecated option of the onet tag.
+func isDeprecated(f reflect.StructField) bool {
+	for _, opt := range strings.Split(f.Tag.Get("onet"), ",") {
+		if opt == "deprecated" {
+			return true
+		}
+	}
+	return false
+}
+
+// deprecatedFields returns the paths of the deprecated fields of the decoded
+// message msg that are not empty.
+func deprecatedFields(msg interface{}) []string {
+	var paths []string
+	// the tags are checked when the handlers are registered
+	walkRules(reflect.ValueOf(msg), "", func(f reflect.Value, path string, r fieldRules) error {
+		if r.deprecated && !isEmpty(f) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths
+}
+
+// pathIndices matches the indices of the slices and the keys of the maps in
+// the paths of the fields.
+var pathIndices = regexp.MustCompile(`\[[^]]*\]`)
+
+// reportDeprecated counts and logs the uses of the deprecated fields of the
+// decoded message msg of the handler msgName, and returns their paths.
+func (p *ServiceProcessor) reportDeprecated(msgName string, msg interface{}) []string {
+	paths := deprecatedFields(msg)
+	for _, path := range paths {
+		// The indices would make a new metric for each item.
+		field := pathIndices.ReplaceAllString(path, "[]")
+		if p.Metrics != nil {
+			p.Metrics.deprecated.WithLabelValues(msgName, field).Inc()
+		}
+		if _, logged := p.deprecationsLogged.LoadOrStore(msgName+"."+field, true); !logged {
+			log.Warnf("a client uses the deprecated field %s of %s", field, msgName)
+		}
+	}
+	return paths
+}
+
+// warnDeprecated is reportDeprecated for the REST API, which adds a Warning
+// header for each deprecated field to the reply.
+func (p *ServiceProcessor) warnDeprecated(w http.ResponseWriter, msgName string, msg interface{}) {
+	for _, path := range p.reportDeprecated(msgName, msg) {
+		w.Header().Add("Warning", fmt.Sprintf(`299 - "the field %s is deprecated"`, path))
+	}
+}
@@ -0,0 +1,97 @@
+package onet
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+type deprecatedItem struct {
+	Old int64 `onet:"deprecated"`
+}
+
+type deprecatedMsg struct {
+	Name  string
+	Limit int64 `onet:"deprecated" validate:"default=10"`
+	Items []*deprecatedItem
+}
+
+func TestDeprecatedFields(t *testing.T) {
+	require.Empty(t, deprecatedFields(&deprecatedMsg{Name: "a"}))
+	require.Equal(t, []string{"Limit", "Items[1].Old"}, deprecatedFields(&deprecatedMsg{
+		Limit: 3,
+		Items: []*deprecatedItem{{}, {Old: 1}},
+	}))
+
+	// With "onet" as RESTTagName, the option doesn't rename the field.
+	type renamed struct {
+		A int `onet:"deprecated"`
+		B int `onet:"b,deprecated"`
+	}
+	fields := taggedFields(reflect.TypeOf(renamed{}), "onet")
+	require.Equal(t, "A", fields[0].name)
+	require.Equal(t, "b", fields[1].name)
+	require.Equal(t, []string{"A", "B"}, deprecatedFields(&renamed{A: 1, B: 2}))
+}
+
+func TestServiceProcessor_DeprecatedFields(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	srv := local.GenServers(1)[0]
+	p := NewServiceProcessor(&Context{server: srv})
+	p.Metrics = NewProcessorMetrics("onet")
+
+	var received []deprecatedMsg
+	h := func(msg *deprecatedMsg) (*deprecatedMsg, error) {
+		received = append(received, *msg)
+		return msg, nil
+	}
+	require.NoError(t, p.RegisterHandler(h))
+	require.NoError(t, p.RegisterRESTHandler(h, "dummyService", "POST", 3, 3))
+
+	post := func(body string) http.Header {
+		r := httptest.NewRequest("POST", "/v3/dummyService/deprecatedMsg", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return w.Header()
+	}
+
+	// The defaults don't count as uses.
+	require.Empty(t, post(`{"Name": "a"}`)["Warning"])
+	require.Equal(t, []string{
+		`299 - "the field Limit is deprecated"`,
+		`299 - "the field Items[0].Old is deprecated"`,
+	}, post(`{"Limit": 5, "Items": [{"Old": 1}]}`)["Warning"])
+
+	buf, err := protobuf.Encode(&deprecatedMsg{Limit: 7})
+	require.NoError(t, err)
+	_, _, err = p.ProcessClientRequest(nil, "deprecatedMsg", buf)
+	require.NoError(t, err)
+
+	require.Equal(t, 2.0, testutil.ToFloat64(p.Metrics.deprecated.WithLabelValues("deprecatedMsg", "Limit")))
+	require.Equal(t, 1.0, testutil.ToFloat64(p.Metrics.deprecated.WithLabelValues("deprecatedMsg", "Items[].Old")))
+	require.Equal(t, int64(10), received[0].Limit)
+
+	spec, err := p.OpenAPISpec()
+	require.NoError(t, err)
+	var s struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]interface{}
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal(spec, &s))
+	require.Equal(t, true, s.Components.Schemas["deprecatedMsg"].Properties["Limit"]["deprecated"])
+	require.NotContains(t, s.Components.Schemas["deprecatedMsg"].Properties["Name"], "deprecated")
+	require.Equal(t, true, s.Components.Schemas["deprecatedItem"].Properties["Old"]["deprecated"])
+}
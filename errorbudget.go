@@ -0,0 +1,177 @@
+package onet
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// errorBudgetBuckets is the number of buckets of the sliding window of an
+// error budget.
+const errorBudgetBuckets = 60
+
+// ErrorBudgetStatus is the state of the error budget of a handler over its
+// sliding window.
+type ErrorBudgetStatus struct {
+	// Requests is the number of requests in the window.
+	Requests int64
+	// ErrorRate is the ratio of the requests that failed.
+	ErrorRate float64
+	// Remaining is the ratio of the error budget that is left: 1 without
+	// any error, 0 when the error rate reaches the one allowed by the
+	// target, and negative beyond.
+	Remaining float64
+}
+
+// errorBudget counts the requests and the errors of a handler in a ring of
+// buckets covering the window.
+type errorBudget struct {
+	sync.Mutex
+	target     float64
+	bucketSize time.Duration
+	buckets    [errorBudgetBuckets]budgetBucket
+	now        func() time.Time
+}
+
+type budgetBucket struct {
+	// index is the number of bucketSize since the epoch of the bucket
+	index    int64
+	requests int64
+	errors   int64
+}
+
+func newErrorBudget(target float64, window time.Duration) *errorBudget {
+	bucketSize := window / errorBudgetBuckets
+	if bucketSize <= 0 {
+		bucketSize = 1
+	}
+	return &errorBudget{target: target, bucketSize: bucketSize, now: time.Now}
+}
+
+// record counts a request, which failed if failed is true.
+func (eb *errorBudget) record(failed bool) {
+	eb.Lock()
+	defer eb.Unlock()
+	index := eb.now().UnixNano() / int64(eb.bucketSize)
+	b := &eb.buckets[index%errorBudgetBuckets]
+	if b.index != index {
+		// the bucket is from a previous turn of the ring
+		*b = budgetBucket{index: index}
+	}
+	b.requests++
+	if failed {
+		b.errors++
+	}
+}
+
+// status returns the state of the budget over the window.
+func (eb *errorBudget) status() ErrorBudgetStatus {
+	eb.Lock()
+	defer eb.Unlock()
+	index := eb.now().UnixNano() / int64(eb.bucketSize)
+	var requests, errors int64
+	for _, b := range eb.buckets {
+		if b.index > index-errorBudgetBuckets {
+			requests += b.requests
+			errors += b.errors
+		}
+	}
+	st := ErrorBudgetStatus{Requests: requests, Remaining: 1}
+	if requests > 0 {
+		st.ErrorRate = float64(errors) / float64(requests)
+		st.Remaining = 1 - st.ErrorRate/(1-eb.target)
+	}
+	return st
+}
+
+// SetErrorBudget tracks the error rate of the handler of the message msgName,
+// on the websocket and on the REST API, over a sliding window. target is the
+// ratio of the requests that must succeed, e.g. 0.999, which leaves an error
+// budget of 0.1% of the requests. The errors of the clients, i.e. the
+// StatusError with a 4xx code, are not counted. The state of the budget is
+// given by ErrorBudget and, for the services, in their status under
+// "ErrorBudget_<service name>". It returns an error if nothing is registered
+// under msgName.
+func (p *ServiceProcessor) SetErrorBudget(msgName string, target float64, window time.Duration) error {
+	if target <= 0 || target >= 1 {
+		return xerrors.New("target must be between 0 and 1")
+	}
+	if window <= 0 {
+		return xerrors.New("window must be positive")
+	}
+	p.handlersLock.Lock()
+	defer p.handlersLock.Unlock()
+
+	_, found := p.handlers[msgName]
+	for _, route := range p.restRoutes {
+		if route.msgName == msgName && route.handler != nil {
+			found = true
+		}
+	}
+	if !found {
+		return xerrors.Errorf("no handler registered for %s", msgName)
+	}
+	if p.errorBudgets == nil {
+		p.errorBudgets = make(map[string]*errorBudget)
+		if p.Context != nil && p.server != nil {
+			name := ServiceFactory.Name(p.ServiceID())
+			p.RegisterStatusReporter("ErrorBudget_"+name, errorBudgetReporter{p})
+		}
+	}
+	p.errorBudgets[msgName] = newErrorBudget(target, window)
+	return nil
+}
+
+// ErrorBudget returns the state of the error budget of the handler of the
+// message msgName, if it has one.
+func (p *ServiceProcessor) ErrorBudget(msgName string) (ErrorBudgetStatus, bool) {
+	p.handlersLock.RLock()
+	eb, ok := p.errorBudgets[msgName]
+	p.handlersLock.RUnlock()
+	if !ok {
+		return ErrorBudgetStatus{}, false
+	}
+	return eb.status(), true
+}
+
+// recordOutcome counts the request of the message msgName in its error
+// budget, if it has one.
+func (p *ServiceProcessor) recordOutcome(msgName string, err error) {
+	p.handlersLock.RLock()
+	eb, ok := p.errorBudgets[msgName]
+	p.handlersLock.RUnlock()
+	if !ok {
+		return
+	}
+	failed := err != nil
+	if code, isStatus := statusErrorCode(err); isStatus && code < 500 {
+		failed = false
+	}
+	eb.record(failed)
+}
+
+// errorBudgetReporter gives the error budgets of a ServiceProcessor in the
+// status of the server.
+type errorBudgetReporter struct {
+	p *ServiceProcessor
+}
+
+func (r errorBudgetReporter) GetStatus() *Status {
+	r.p.handlersLock.RLock()
+	budgets := make(map[string]*errorBudget, len(r.p.errorBudgets))
+	for name, eb := range r.p.errorBudgets {
+		budgets[name] = eb
+	}
+	r.p.handlersLock.RUnlock()
+
+	st := &Status{Field: make(map[string]string)}
+	for name, eb := range budgets {
+		s := eb.status()
+		st.Field[name+"_requests"] = strconv.FormatInt(s.Requests, 10)
+		st.Field[name+"_error_rate"] = strconv.FormatFloat(s.ErrorRate, 'f', -1, 64)
+		st.Field[name+"_error_budget_remaining"] = strconv.FormatFloat(s.Remaining, 'f', -1, 64)
+	}
+	return st
+}
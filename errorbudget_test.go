@@ -0,0 +1,95 @@
+package onet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+func TestErrorBudget_Window(t *testing.T) {
+	now := time.Unix(1000, 0)
+	eb := newErrorBudget(0.9, time.Minute)
+	eb.now = func() time.Time { return now }
+
+	require.Equal(t, ErrorBudgetStatus{Remaining: 1}, eb.status())
+	for i := 0; i < 19; i++ {
+		eb.record(false)
+	}
+	eb.record(true)
+	st := eb.status()
+	require.Equal(t, int64(20), st.Requests)
+	require.InDelta(t, 0.05, st.ErrorRate, 1e-9)
+	require.InDelta(t, 0.5, st.Remaining, 1e-9)
+
+	// The errors of the second half of the window exceed the budget.
+	now = now.Add(30 * time.Second)
+	for i := 0; i < 5; i++ {
+		eb.record(true)
+	}
+	st = eb.status()
+	require.Equal(t, int64(25), st.Requests)
+	require.InDelta(t, 0.24, st.ErrorRate, 1e-9)
+	require.True(t, st.Remaining < 0)
+
+	// The first requests leave the window.
+	now = now.Add(45 * time.Second)
+	st = eb.status()
+	require.Equal(t, int64(5), st.Requests)
+	require.InDelta(t, 1, st.ErrorRate, 1e-9)
+
+	now = now.Add(time.Minute)
+	require.Equal(t, ErrorBudgetStatus{Remaining: 1}, eb.status())
+}
+
+func TestServiceProcessor_ErrorBudget(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	srv := local.GenServers(1)[0]
+	p := NewServiceProcessor(&Context{server: srv})
+	require.NoError(t, p.RegisterHandler(func(msg *testMsg) (*testMsg, error) {
+		switch msg.I {
+		case 1:
+			return nil, xerrors.New("internal failure")
+		case 2:
+			return nil, StatusError{Code: http.StatusNotFound, Msg: "unknown"}
+		}
+		return msg, nil
+	}))
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", 3, 3))
+
+	require.Error(t, p.SetErrorBudget("unknown", 0.99, time.Minute))
+	require.Error(t, p.SetErrorBudget("testMsg", 1.5, time.Minute))
+	require.NoError(t, p.SetErrorBudget("testMsg", 0.5, time.Minute))
+	require.NoError(t, p.SetErrorBudget("restMsgGET2", 0.99, time.Minute))
+	_, ok := p.ErrorBudget("testMsg2")
+	require.False(t, ok)
+
+	for _, i := range []int64{0, 0, 1, 2} {
+		buf, err := protobuf.Encode(&testMsg{i})
+		require.NoError(t, err)
+		p.ProcessClientRequest(nil, "testMsg", buf)
+	}
+	st, ok := p.ErrorBudget("testMsg")
+	require.True(t, ok)
+	require.Equal(t, int64(4), st.Requests)
+	require.InDelta(t, 0.25, st.ErrorRate, 1e-9)
+	require.InDelta(t, 0.5, st.Remaining, 1e-9)
+
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/42", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	st, _ = p.ErrorBudget("restMsgGET2")
+	require.Equal(t, int64(1), st.Requests)
+
+	status := srv.statusReporterStruct.ReportStatus()["ErrorBudget_"]
+	require.NotNil(t, status)
+	require.Equal(t, "4", status.Field["testMsg_requests"])
+	require.Equal(t, "0.25", status.Field["testMsg_error_rate"])
+	require.Equal(t, "0.5", status.Field["testMsg_error_budget_remaining"])
+	require.Equal(t, "1", status.Field["restMsgGET2_error_budget_remaining"])
+}
@@ -0,0 +1,184 @@
+package onet
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// DefaultFairQueueWorkers is the number of requests handled at the same time
+// when FairQueue.Workers is not positive.
+const DefaultFairQueueWorkers = 8
+
+// FairQueue limits the number of requests of a ServiceProcessor that are
+// handled at the same time to Workers. The other requests wait in a queue per
+// handler, and the free workers take the requests of the handlers in turn, so
+// that a flood of requests to a slow handler doesn't delay the requests of
+// the other handlers behind it.
+//
+// The time spent in the queue counts in the timeout of the request. The
+// streaming handlers don't wait for a worker.
+type FairQueue struct {
+	// Workers is the number of requests handled at the same time. If not
+	// positive, DefaultFairQueueWorkers is used.
+	Workers int
+	// MaxQueue, if positive, is the maximum number of requests waiting for
+	// each handler. The requests beyond it fail with a StatusError of code
+	// 503.
+	MaxQueue int
+
+	queues map[string][]*queued
+	// ring holds the handlers with waiting requests, in the order they are
+	// served, and next is the index of the next one.
+	ring    []string
+	next    int
+	running int
+	lock    sync.Mutex
+}
+
+// queued is a request waiting for a worker. turn is closed when it gets
+// one, and report, if not nil, records the depth of its queue.
+type queued struct {
+	turn   chan struct{}
+	report func(msgName string, depth int)
+}
+
+// NewFairQueue returns a FairQueue of the given number of workers, or of
+// DefaultFairQueueWorkers if it is not positive.
+func NewFairQueue(workers int) *FairQueue {
+	return &FairQueue{Workers: workers, queues: make(map[string][]*queued)}
+}
+
+// Depth returns the number of requests of the handler of msgName waiting for
+// a worker.
+func (q *FairQueue) Depth(msgName string) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.queues[msgName])
+}
+
+// acquire waits for a worker for a request of the handler of msgName, and
+// returns the function to call to release it. It fails if ctx is done first.
+// report, if not nil, is called with the depth of the queue of msgName when
+// the request enters or leaves it.
+func (q *FairQueue) acquire(ctx context.Context, msgName string,
+	report func(msgName string, depth int)) (func(), error) {
+	q.lock.Lock()
+	if q.queues == nil {
+		q.queues = make(map[string][]*queued)
+	}
+	if q.MaxQueue > 0 && len(q.queues[msgName]) >= q.MaxQueue {
+		q.lock.Unlock()
+		return nil, StatusError{Code: http.StatusServiceUnavailable,
+			Msg: "too many requests waiting for " + msgName}
+	}
+	req := &queued{turn: make(chan struct{}), report: report}
+	turn := req.turn
+	if len(q.queues[msgName]) == 0 {
+		q.ring = append(q.ring, msgName)
+	}
+	q.queues[msgName] = append(q.queues[msgName], req)
+	req.reportDepth(msgName, len(q.queues[msgName]))
+	q.dispatch()
+	q.lock.Unlock()
+
+	select {
+	case <-turn:
+		return q.release, nil
+	case <-ctx.Done():
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	select {
+	case <-turn:
+		// The worker was given in the meantime.
+		q.running--
+		q.dispatch()
+	default:
+		q.remove(msgName, req)
+	}
+	return nil, xerrors.Errorf("waiting for a worker: %v", ctx.Err())
+}
+
+// release frees the worker of a request.
+func (q *FairQueue) release() {
+	q.lock.Lock()
+	q.running--
+	q.dispatch()
+	q.lock.Unlock()
+}
+
+// dispatch gives the free workers to the waiting requests, taking the
+// handlers in turn. It must be called with the lock.
+func (q *FairQueue) dispatch() {
+	workers := q.Workers
+	if workers <= 0 {
+		workers = DefaultFairQueueWorkers
+	}
+	for q.running < workers && len(q.ring) > 0 {
+		if q.next >= len(q.ring) {
+			q.next = 0
+		}
+		name := q.ring[q.next]
+		req := q.queues[name][0]
+		q.queues[name] = q.queues[name][1:]
+		req.reportDepth(name, len(q.queues[name]))
+		if len(q.queues[name]) == 0 {
+			delete(q.queues, name)
+			q.ring = append(q.ring[:q.next], q.ring[q.next+1:]...)
+		} else {
+			q.next++
+		}
+		q.running++
+		close(req.turn)
+	}
+}
+
+// remove takes the waiting request req out of the queue of msgName. It must
+// be called with the lock.
+func (q *FairQueue) remove(msgName string, req *queued) {
+	queue := q.queues[msgName]
+	for i, r := range queue {
+		if r == req {
+			q.queues[msgName] = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	req.reportDepth(msgName, len(q.queues[msgName]))
+	if len(q.queues[msgName]) > 0 {
+		return
+	}
+	delete(q.queues, msgName)
+	for i, name := range q.ring {
+		if name == msgName {
+			q.ring = append(q.ring[:i], q.ring[i+1:]...)
+			if i < q.next {
+				q.next--
+			}
+			break
+		}
+	}
+}
+
+func (r *queued) reportDepth(msgName string, depth int) {
+	if r.report != nil {
+		r.report(msgName, depth)
+	}
+}
+
+// waitWorker waits for a worker of the FairQueue, if any, for a request of
+// the handler of msgName, and returns the function to call once the request
+// is handled.
+func (p *ServiceProcessor) waitWorker(ctx context.Context, msgName string) (func(), error) {
+	if p.FairQueue == nil {
+		return func() {}, nil
+	}
+	var report func(string, int)
+	if p.Metrics != nil {
+		report = p.Metrics.setQueueDepth
+	}
+	return p.FairQueue.acquire(ctx, msgName, report)
+}
@@ -0,0 +1,164 @@
+package onet
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+func TestFairQueue(t *testing.T) {
+	q := NewFairQueue(1)
+	type grant struct {
+		name    string
+		release func()
+	}
+	granted := make(chan grant)
+	wait := func(name string, depth int) {
+		go func() {
+			release, err := q.acquire(context.Background(), name, nil)
+			if err == nil {
+				granted <- grant{name, release}
+			}
+		}()
+		for q.Depth(name) != depth {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	release, err := q.acquire(context.Background(), "slow", nil)
+	require.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		wait("slow", i)
+	}
+	wait("fast", 1)
+
+	// The fast handler doesn't wait for all the requests of the slow one.
+	var order []string
+	for i := 0; i < 4; i++ {
+		release()
+		g := <-granted
+		order = append(order, g.name)
+		release = g.release
+	}
+	release()
+	require.Equal(t, []string{"slow", "fast", "slow", "slow"}, order)
+
+	// The requests beyond MaxQueue fail, and the ones whose context is done
+	// leave the queue.
+	q.MaxQueue = 1
+	release, err = q.acquire(context.Background(), "slow", nil)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := q.acquire(ctx, "slow", nil)
+		done <- err
+	}()
+	for q.Depth("slow") != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	_, err = q.acquire(context.Background(), "slow", nil)
+	var se StatusError
+	require.True(t, xerrors.As(err, &se), err)
+	require.Equal(t, http.StatusServiceUnavailable, se.Code)
+	cancel()
+	require.Error(t, <-done)
+	require.Equal(t, 0, q.Depth("slow"))
+	release()
+}
+
+func TestFairQueue_DefaultWorkers(t *testing.T) {
+	for _, q := range []*FairQueue{NewFairQueue(0), {}} {
+		var releases []func()
+		for i := 0; i < DefaultFairQueueWorkers; i++ {
+			release, err := q.acquire(context.Background(), "msg", nil)
+			require.NoError(t, err)
+			releases = append(releases, release)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := q.acquire(ctx, "msg", nil)
+		cancel()
+		require.Error(t, err)
+		for _, release := range releases {
+			release()
+		}
+	}
+}
+
+func TestServiceProcessor_FairQueue(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	p.FairQueue = NewFairQueue(1)
+	p.Metrics = NewProcessorMetrics("")
+
+	unblock := make(chan struct{})
+	started := make(chan struct{}, 2)
+	require.NoError(t, p.RegisterHandler(func(msg *testMsg) (*testMsg, error) {
+		started <- struct{}{}
+		<-unblock
+		return msg, nil
+	}))
+	buf, err := protobuf.Encode(&testMsg{})
+	require.NoError(t, err)
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, _, err := p.ProcessClientRequest(nil, "testMsg", buf)
+			errs <- err
+		}()
+	}
+	<-started
+	for p.FairQueue.Depth("testMsg") != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	require.Equal(t, 1.0, testutil.ToFloat64(p.Metrics.queueDepth.WithLabelValues("testMsg")))
+	close(unblock)
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+	require.Equal(t, 0.0, testutil.ToFloat64(p.Metrics.queueDepth.WithLabelValues("testMsg")))
+}
+
+func TestServiceProcessor_FairQueueTimeout(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	p.FairQueue = NewFairQueue(1)
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+	started := make(chan struct{}, 2)
+	require.NoError(t, p.RegisterHandlerWithTimeout(func(_ context.Context, msg *testMsg) (*testMsg, error) {
+		started <- struct{}{}
+		<-unblock
+		return msg, nil
+	}, 100*time.Millisecond))
+	buf, err := protobuf.Encode(&testMsg{})
+	require.NoError(t, err)
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	require.Error(t, err)
+	<-started
+
+	// The handler that timed out still has the worker until it returns.
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := p.ProcessClientRequest(nil, "testMsg", buf)
+		errs <- err
+	}()
+	select {
+	case <-started:
+		require.Fail(t, "the worker should not be released yet")
+	case <-time.After(20 * time.Millisecond):
+	}
+	require.Equal(t, 1, p.FairQueue.Depth("testMsg"))
+	unblock <- struct{}{}
+	<-started
+	unblock <- struct{}{}
+	require.NoError(t, <-errs)
+}
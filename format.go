@@ -0,0 +1,121 @@
+package onet
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/vmihailenco/msgpack"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// Formats supported for the responses of the REST API. The format can be
+// selected with the format query parameter, e.g. ?format=msgpack, or with the
+// Accept header of the request. The bodies of the requests can be encoded in
+// any of them.
+const (
+	formatJSON     = "json"
+	formatProtobuf = "protobuf"
+	formatMsgpack  = "msgpack"
+)
+
+// formatContentTypes maps the formats to the content types understood in the
+// Accept header. The first content type is the one used in the responses.
+var formatContentTypes = map[string][]string{
+	formatJSON:     {"application/json"},
+	formatProtobuf: {"application/protobuf", "application/x-protobuf"},
+	formatMsgpack:  {"application/msgpack", "application/x-msgpack"},
+}
+
+// responseFormat returns the format of the response to a REST request. The
+// format query parameter takes precedence over the Accept header, for the
+// clients that cannot set the latter. An error is returned if the format
+// query parameter is unknown. JSON is used if nothing else is requested.
+func responseFormat(r *http.Request) (string, error) {
+	if f, ok := r.URL.Query()["format"]; ok {
+		if len(f) != 1 {
+			return "", xerrors.New("format must be given only once")
+		}
+		if _, ok := formatContentTypes[f[0]]; !ok {
+			return "", xerrors.Errorf("unknown format: %s", f[0])
+		}
+		return f[0], nil
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		ct := strings.TrimSpace(strings.Split(accept, ";")[0])
+		for format, cts := range formatContentTypes {
+			for _, c := range cts {
+				if ct == c {
+					return format, nil
+				}
+			}
+		}
+	}
+	return formatJSON, nil
+}
+
+// encodeReply encodes the reply of a handler in the given format and returns
+// it along with its content type. The JSON field names are taken from the
+// struct tag jsonTag if it is not empty.
+func encodeReply(format string, msg interface{}, jsonTag string) ([]byte, string, error) {
+	var buf []byte
+	var err error
+	switch format {
+	case formatJSON:
+		buf, err = marshalJSON(msg, jsonTag)
+	case formatProtobuf:
+		buf, err = protobuf.Encode(msg)
+	case formatMsgpack:
+		buf, err = msgpack.Marshal(msg)
+	default:
+		return nil, "", xerrors.Errorf("unknown format: %s", format)
+	}
+	if err != nil {
+		return nil, "", xerrors.Errorf("encoding %s: %v", format, err)
+	}
+	return buf, formatContentTypes[format][0], nil
+}
+
+// requestFormats are the formats supported in the bodies of the requests.
+var requestFormats = []string{formatJSON, formatProtobuf, formatMsgpack}
+
+// contentTypeFormat returns the format of the request body of the given
+// content type, if it is supported.
+func contentTypeFormat(contentType string) (string, bool) {
+	ct, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+	for _, format := range requestFormats {
+		for _, c := range formatContentTypes[format] {
+			if ct == c {
+				return format, true
+			}
+		}
+	}
+	return "", false
+}
+
+// decodeBody decodes the body of a request in the given format into msg. The
+// JSON field names are taken from the struct tag jsonTag if it is not empty,
+// and the interfaces of the protobuf messages are created with cons.
+func decodeBody(format string, buf []byte, msg interface{}, jsonTag string,
+	cons protobuf.Constructors) error {
+	var err error
+	switch format {
+	case formatJSON:
+		err = unmarshalJSON(buf, msg, jsonTag)
+	case formatProtobuf:
+		err = protobuf.DecodeWithConstructors(buf, msg, cons)
+	case formatMsgpack:
+		err = msgpack.Unmarshal(buf, msg)
+	default:
+		return xerrors.Errorf("unknown format: %s", format)
+	}
+	if err != nil {
+		return xerrors.Errorf("decoding %s: %v", format, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package onet
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack"
+	"go.dedis.ch/protobuf"
+)
+
+func TestFormat_ResponseFormat(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	f, err := responseFormat(r)
+	require.NoError(t, err)
+	require.Equal(t, formatJSON, f)
+
+	r.Header.Set("Accept", "text/html, application/x-protobuf;q=0.9")
+	f, err = responseFormat(r)
+	require.NoError(t, err)
+	require.Equal(t, formatProtobuf, f)
+
+	r = httptest.NewRequest("GET", "/?format=msgpack", nil)
+	r.Header.Set("Accept", "application/protobuf")
+	f, err = responseFormat(r)
+	require.NoError(t, err)
+	require.Equal(t, formatMsgpack, f)
+
+	r = httptest.NewRequest("GET", "/?format=xml", nil)
+	_, err = responseFormat(r)
+	require.Error(t, err)
+
+	r = httptest.NewRequest("GET", "/?format=json&format=msgpack", nil)
+	_, err = responseFormat(r)
+	require.Error(t, err)
+}
+
+func TestFormat_REST(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", 3, 3))
+
+	get := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/42"+query, nil)
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("?format=protobuf")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/protobuf", w.Header().Get("Content-Type"))
+	msg := testMsg{}
+	require.NoError(t, protobuf.Decode(w.Body.Bytes(), &msg))
+	require.Equal(t, int64(42), msg.I)
+
+	w = get("?format=msgpack")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/msgpack", w.Header().Get("Content-Type"))
+	msg = testMsg{}
+	require.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &msg))
+	require.Equal(t, int64(42), msg.I)
+
+	w = get("?format=xml")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestFormat_ContentTypes(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+	require.Error(t, p.RegisterRESTHandler(procRestMsgPOSTString, "dummyService", "POST", 3, 3,
+		WithContentTypes("application/xml")))
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgPOSTString, "dummyService", "POST", 3, 3,
+		WithContentTypes("application/protobuf", "application/x-protobuf")))
+
+	post := func(contentType string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/v3/dummyService/restMsgPOSTString", bytes.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		r.Header.Set("Accept", contentType)
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w
+	}
+
+	body, err := protobuf.Encode(&restMsgPOSTString{S: "42"})
+	require.NoError(t, err)
+	w := post("application/x-protobuf", body)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/protobuf", w.Header().Get("Content-Type"))
+	require.NoError(t, protobuf.Decode(w.Body.Bytes(), &testMsg{}))
+	w = post("application/json", []byte(`{"S": "42"}`))
+	require.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgPOSTString, "dummyService", "POST", 3, 3,
+		WithContentTypes("application/msgpack")))
+	body, err = msgpack.Marshal(&restMsgPOSTString{S: "42"})
+	require.NoError(t, err)
+	w = post("application/x-msgpack", body)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = post("application/json", []byte(`{"S": "42"}`))
+	require.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	require.Equal(t, "application/msgpack", w.Header().Get("Accept-Post"))
+
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgPOSTString, "dummyService", "POST", 3, 3,
+		WithContentTypes("application/json", "application/msgpack")))
+	w = post("application/json; charset=utf-8", []byte(`{"S": "42"}`))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = post("text/plain", []byte(`{"S": "42"}`))
+	require.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	require.Equal(t, "application/json, application/msgpack", w.Header().Get("Accept-Post"))
+}
@@ -11,21 +11,23 @@ require (
 	github.com/gorilla/websocket v1.4.0
 	github.com/honeycombio/beeline-go v0.4.11
 	github.com/honeycombio/libhoney-go v1.12.3 // indirect
-	github.com/klauspost/compress v1.10.3 // indirect
+	github.com/klauspost/compress v1.10.3
 	github.com/kr/pretty v0.1.0 // indirect
 	github.com/montanaflynn/stats v0.5.0
+	github.com/prometheus/client_golang v1.5.1
 	github.com/shirou/gopsutil v2.20.2+incompatible
 	github.com/stretchr/testify v1.5.1
 	github.com/urfave/cli v1.22.2
+	github.com/vmihailenco/msgpack v4.0.4+incompatible
 	go.dedis.ch/kyber/v3 v3.0.12
 	go.dedis.ch/protobuf v1.0.11
 	go.etcd.io/bbolt v1.3.3
-	golang.org/x/crypto v0.0.0-20190325154230-a5d413f7728c // indirect
-	golang.org/x/sys v0.0.0-20190813064441-fde4db37ae7a
-	golang.org/x/xerrors v0.0.0-20191011141410-1b5146add898
-	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
+	golang.org/x/crypto v0.0.0-20190325154230-a5d413f7728c
+	golang.org/x/sys v0.0.0-20200122134326-e047566fdf82
+	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543
 	gopkg.in/satori/go.uuid.v1 v1.2.0
 	gopkg.in/tylerb/graceful.v1 v1.2.15
+	gopkg.in/yaml.v2 v2.2.5
 	rsc.io/goversion v1.2.0
 )
 
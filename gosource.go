@@ -0,0 +1,270 @@
+package onet
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// GoDefinition returns the Go source of a package pkg declaring the messages
+// of the handlers of the ServiceProcessor and their replies, so that the
+// clients can use the very same structs as the service. The types of the
+// packages of the messages that the messages refer to are declared too,
+// while the types of the other packages, such as network.ServerIdentity or
+// kyber.Point, are imported. Only the types are copied, not their methods.
+func (p *ServiceProcessor) GoDefinition(pkg string) (string, error) {
+	// The types are visited in a fixed order so that the names of the
+	// imports don't change from one call to the other.
+	var roots []reflect.Type
+	p.handlersLock.RLock()
+	names := make([]string, 0, len(p.handlers))
+	for name := range p.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mh := p.handlers[name]
+		roots = append(roots, mh.msgType, reflect.TypeOf(mh.handler).Out(0))
+	}
+	patterns := make([]string, 0, len(p.restRoutes))
+	for pattern := range p.restRoutes {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		route := p.restRoutes[pattern]
+		if route.handler != nil && route.msgType != nil {
+			roots = append(roots, route.msgType, route.replyType)
+		}
+	}
+	p.handlersLock.RUnlock()
+
+	var msgs []reflect.Type
+	g := newGoGenerator()
+	for _, t := range roots {
+		if t.Kind() == reflect.Chan {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Interface {
+			// no fixed reply type
+			continue
+		}
+		msgs = append(msgs, t)
+		if t.PkgPath() != "" {
+			g.local[t.PkgPath()] = true
+		}
+	}
+	for _, t := range msgs {
+		if _, err := g.typeExpr(t); err != nil {
+			return "", err
+		}
+	}
+	return g.source(pkg)
+}
+
+// goGenerator collects the declarations of the types and the imports.
+type goGenerator struct {
+	// local are the package paths whose types are declared.
+	local map[string]bool
+	// types detects two different types with the same name.
+	types map[string]reflect.Type
+	decls map[string]string
+	// imports maps the package paths to their names.
+	imports map[string]string
+}
+
+func newGoGenerator() *goGenerator {
+	return &goGenerator{
+		local:   make(map[string]bool),
+		types:   make(map[string]reflect.Type),
+		decls:   make(map[string]string),
+		imports: make(map[string]string),
+	}
+}
+
+// The major versions in the import paths are not part of the names of the
+// packages, e.g. go.dedis.ch/kyber/v3 and gopkg.in/yaml.v2.
+var (
+	majorVersion  = regexp.MustCompile(`^v[0-9]+$`)
+	versionSuffix = regexp.MustCompile(`\.v[0-9]+$`)
+)
+
+// importName returns the name the package pkgPath is imported with.
+func (g *goGenerator) importName(pkgPath string) string {
+	if name, ok := g.imports[pkgPath]; ok {
+		return name
+	}
+	elems := strings.Split(pkgPath, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && majorVersion.MatchString(name) {
+		name = elems[len(elems)-2]
+	}
+	name = versionSuffix.ReplaceAllString(name, "")
+	name = strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, name)
+
+	// two packages with the same name get different ones
+	taken := make(map[string]bool)
+	for _, n := range g.imports {
+		taken[n] = true
+	}
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	g.imports[pkgPath] = unique
+	return unique
+}
+
+// typeExpr returns the Go expression of the type t, adding the declarations
+// and the imports it needs.
+func (g *goGenerator) typeExpr(t reflect.Type) (string, error) {
+	if t.Name() != "" {
+		if t.PkgPath() == "" {
+			// predeclared types
+			return t.Name(), nil
+		}
+		if !g.local[t.PkgPath()] {
+			return g.importName(t.PkgPath()) + "." + t.Name(), nil
+		}
+		if err := g.declare(t); err != nil {
+			return "", err
+		}
+		return t.Name(), nil
+	}
+	return g.underlying(t)
+}
+
+// declare adds the declaration of the named type t.
+func (g *goGenerator) declare(t reflect.Type) error {
+	if other, ok := g.types[t.Name()]; ok {
+		if other != t {
+			return xerrors.Errorf("%v and %v have the same name", t, other)
+		}
+		return nil
+	}
+	g.types[t.Name()] = t
+	def, err := g.underlying(t)
+	if err != nil {
+		return xerrors.Errorf("type %s: %v", t.Name(), err)
+	}
+	g.decls[t.Name()] = fmt.Sprintf("// %s is a copy of %s.\ntype %s %s\n",
+		t.Name(), t.String(), t.Name(), def)
+	return nil
+}
+
+// underlying returns the Go expression of the structure of t, ignoring its
+// name.
+func (g *goGenerator) underlying(t reflect.Type) (string, error) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem, err := g.typeExpr(t.Elem())
+		return "*" + elem, err
+	case reflect.Slice:
+		elem, err := g.typeExpr(t.Elem())
+		return "[]" + elem, err
+	case reflect.Array:
+		elem, err := g.typeExpr(t.Elem())
+		return fmt.Sprintf("[%d]%s", t.Len(), elem), err
+	case reflect.Map:
+		key, err := g.typeExpr(t.Key())
+		if err != nil {
+			return "", err
+		}
+		elem, err := g.typeExpr(t.Elem())
+		return fmt.Sprintf("map[%s]%s", key, elem), err
+	case reflect.Chan:
+		elem, err := g.typeExpr(t.Elem())
+		return "chan " + elem, err
+	case reflect.Interface:
+		if t.NumMethod() > 0 {
+			return "", xerrors.Errorf("unnamed interface with methods %v", t)
+		}
+		return "interface{}", nil
+	case reflect.Struct:
+		return g.structExpr(t)
+	case reflect.Func, reflect.UnsafePointer, reflect.Invalid:
+		return "", xerrors.Errorf("unsupported type %v", t)
+	}
+	// the kinds of the basic types are named after them
+	return t.Kind().String(), nil
+}
+
+// structExpr returns the Go expression of the struct t.
+func (g *goGenerator) structExpr(t reflect.Type) (string, error) {
+	var b bytes.Buffer
+	b.WriteString("struct {\n")
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			// unexported fields are not part of the messages
+			continue
+		}
+		typ, err := g.typeExpr(f.Type)
+		if err != nil {
+			return "", xerrors.Errorf("field %s: %v", f.Name, err)
+		}
+		if f.Anonymous {
+			fmt.Fprintf(&b, "%s", typ)
+		} else {
+			fmt.Fprintf(&b, "%s %s", f.Name, typ)
+		}
+		if f.Tag != "" {
+			if strings.Contains(string(f.Tag), "`") {
+				fmt.Fprintf(&b, " %q", f.Tag)
+			} else {
+				fmt.Fprintf(&b, " `%s`", f.Tag)
+			}
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("}")
+	return b.String(), nil
+}
+
+// source returns the formatted source of the package.
+func (g *goGenerator) source(pkg string) (string, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by onet. DO NOT EDIT.\n\npackage %s\n", pkg)
+
+	paths := make([]string, 0, len(g.imports))
+	for p := range g.imports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	if len(paths) > 0 {
+		b.WriteString("\nimport (\n")
+		for _, p := range paths {
+			fmt.Fprintf(&b, "%s %q\n", g.imports[p], p)
+		}
+		b.WriteString(")\n")
+	}
+
+	names := make([]string, 0, len(g.decls))
+	for name := range g.decls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "\n%s", g.decls[name])
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return "", xerrors.Errorf("formatting: %v", err)
+	}
+	return string(src), nil
+}
@@ -0,0 +1,99 @@
+package onet
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3/network"
+)
+
+type goRequest struct {
+	protoNested
+	Server  *network.ServerIdentity
+	Expires time.Time `json:"expires,omitempty"`
+	Matrix  [2][]goID
+	private int
+}
+
+type goID []byte
+
+func TestServiceProcessor_GoDefinition(t *testing.T) {
+	p := NewServiceProcessor(&Context{})
+	require.NoError(t, p.RegisterHandler(func(*protoRequest) (*protoReply, error) {
+		return nil, nil
+	}))
+	require.NoError(t, p.RegisterStreamingHandler(func(*goRequest) (chan *testMsg, chan bool, error) {
+		return nil, nil, nil
+	}))
+	require.NoError(t, p.RegisterHandler(func(*testMsg2) (network.Message, error) {
+		return nil, nil
+	}))
+
+	src, err := p.GoDefinition("test")
+	require.NoError(t, err)
+	require.Equal(t, "// Code generated by onet. DO NOT EDIT.\n\npackage test\n"+`
+import (
+	kyber "go.dedis.ch/kyber/v3"
+	network "go.dedis.ch/onet/v3/network"
+	time "time"
+)
+
+// goID is a copy of onet.goID.
+type goID []uint8
+
+// goRequest is a copy of onet.goRequest.
+type goRequest struct {
+	protoNested
+	Server  *network.ServerIdentity
+	Expires time.Time `+"`json:\"expires,omitempty\"`"+`
+	Matrix  [2][]goID
+}
+
+// protoNested is a copy of onet.protoNested.
+type protoNested struct {
+	Names  []string
+	Scores map[string]int32
+	Flag   bool `+"`protobuf:\"opt\"`"+`
+}
+
+// protoReply is a copy of onet.protoReply.
+type protoReply struct {
+	Nested protoNested
+}
+
+// protoRequest is a copy of onet.protoRequest.
+type protoRequest struct {
+	ID     []uint8
+	Count  int
+	Nested *protoNested
+	Points []kyber.Point
+}
+
+// testMsg is a copy of onet.testMsg.
+type testMsg struct {
+	I int64
+}
+
+// testMsg2 is a copy of onet.testMsg2.
+type testMsg2 struct {
+	I int64
+}
+`, src)
+	_, err = parser.ParseFile(token.NewFileSet(), "test.go", src, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, p.RegisterHandler(func(*protoUnsupported) (*testMsg, error) {
+		return nil, nil
+	}))
+	src, err = p.GoDefinition("test")
+	require.NoError(t, err)
+	require.Contains(t, src, "C chan int")
+	require.NoError(t, p.RegisterHandlerWithName("unsupported", func(*struct{ F func() }) (*testMsg, error) {
+		return nil, nil
+	}))
+	_, err = p.GoDefinition("test")
+	require.Error(t, err)
+}
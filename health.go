@@ -0,0 +1,64 @@
+package onet
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang.org/x/xerrors"
+)
+
+// HandleHealthProbes registers the /healthz and /readyz endpoints on the
+// websocket, for the liveness and readiness probes of the orchestrators such
+// as Kubernetes. /healthz always answers with 200 OK while the process is up.
+// /readyz is the same endpoint as /ready, to which it adds the probes
+// "server", ready once the server has started, which happens after its
+// services and their protocols are registered, and "websocket", ready while
+// the websocket is actually listening. Both answer with a small JSON body.
+// Calling it more than once has no effect.
+func (c *Server) HandleHealthProbes() {
+	c.WebSocket.Lock()
+	defer c.WebSocket.Unlock()
+	if c.WebSocket.healthProbes {
+		return
+	}
+	c.WebSocket.healthProbes = true
+	c.WebSocket.readiness.register("server", c.startedProbe)
+	c.WebSocket.readiness.register("websocket", c.WebSocket.servingProbe)
+	c.WebSocket.mux.HandleFunc("/healthz", serveHealthz)
+	c.WebSocket.mux.Handle("/readyz", c.WebSocket.readiness)
+}
+
+// serveHealthz answers the liveness probes.
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+}
+
+// startedProbe is the readiness probe of the start of the server.
+func (c *Server) startedProbe() error {
+	c.Lock()
+	defer c.Unlock()
+	if !c.IsStarted {
+		return xerrors.New("not started")
+	}
+	return nil
+}
+
+// servingProbe is the readiness probe of the listener of the websocket.
+func (w *WebSocket) servingProbe() error {
+	if !w.isServing() {
+		return xerrors.New("not listening")
+	}
+	return nil
+}
+
+// writeJSON writes the JSON encoding of body with the status code.
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, wrapJSONMsg(err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(buf)
+}
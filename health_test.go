@@ -0,0 +1,68 @@
+package onet
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestServer_HandleHealthProbes(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	srv := local.GenServers(1)[0]
+
+	get := func(path string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		srv.WebSocket.mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		body := make(map[string]interface{})
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		return w.Code, body
+	}
+
+	// The endpoints are opt-in.
+	w := httptest.NewRecorder()
+	srv.WebSocket.mux.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	require.NotEqual(t, "application/json", w.Header().Get("Content-Type"))
+
+	srv.HandleHealthProbes()
+	srv.HandleHealthProbes()
+
+	code, body := get("/healthz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "ok", body["status"])
+
+	// The websocket starts listening in the background.
+	for i := 0; i < 50 && !srv.WebSocket.isServing(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	code, body = get("/readyz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, true, body["ready"])
+	require.Equal(t, map[string]interface{}{"server": "ready", "websocket": "ready"}, body["probes"])
+
+	// /ready is the same endpoint.
+	readyCode, readyBody := get("/ready")
+	require.Equal(t, code, readyCode)
+	require.Equal(t, body, readyBody)
+
+	c := &Context{server: srv}
+	c.RegisterReadinessProbe("cache", func() error { return xerrors.New("cache is cold") })
+	code, body = get("/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "cache is cold", body["probes"].(map[string]interface{})["cache"])
+
+	c.RegisterReadinessProbe("cache", func() error { return nil })
+	srv.WebSocket.stop()
+	code, body = get("/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "not listening", body["probes"].(map[string]interface{})["websocket"])
+
+	// The process is still alive.
+	code, _ = get("/healthz")
+	require.Equal(t, http.StatusOK, code)
+}
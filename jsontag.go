@@ -0,0 +1,291 @@
+package onet
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// This file implements the JSON encoding of the REST API when
+// ServiceProcessor.RESTTagName is set: it works like encoding/json, but the
+// names of the fields are taken from the given struct tag, falling back to
+// the json tag and then to the name of the field. The options of the tag are
+// the ones of encoding/json, "-" and "omitempty".
+
+var (
+	jsonMarshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	emptyInterfaceType  = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// marshalJSON encodes msg in JSON, naming the fields after the tag if it is
+// not empty.
+func marshalJSON(msg interface{}, tag string) ([]byte, error) {
+	if tag == "" {
+		return json.Marshal(msg)
+	}
+	return json.Marshal(toTagged(reflect.ValueOf(msg), tag))
+}
+
+// unmarshalJSON decodes buf into msg, which must be a pointer, naming the
+// fields after the tag if it is not empty.
+func unmarshalJSON(buf []byte, msg interface{}, tag string) error {
+	if tag == "" {
+		return json.Unmarshal(buf, msg)
+	}
+	v := reflect.ValueOf(msg)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return xerrors.New("need a non-nil pointer")
+	}
+	return fromTagged(buf, v.Elem(), tag)
+}
+
+// taggedField is a field of a struct, possibly promoted from an embedded
+// struct, with its name in JSON.
+type taggedField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// taggedFields returns the fields of the struct t that are encoded.
+func taggedFields(t reflect.Type, tag string) []taggedField {
+	return taggedFieldsRec(t, tag, map[reflect.Type]bool{t: true})
+}
+
+// taggedFieldsRec returns the fields of the struct t. embedding holds the
+// structs t is embedded in: embedding them again in t would never end.
+func taggedFieldsRec(t reflect.Type, tag string, embedding map[reflect.Type]bool) []taggedField {
+	var fields []taggedField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		value, ok := f.Tag.Lookup(tag)
+		// `onet:"deprecated"` only deprecates the field, see isDeprecated
+		if !ok || (tag == "onet" && value == "deprecated") {
+			value, ok = f.Tag.Lookup("json")
+		}
+		if value == "-" {
+			continue
+		}
+		opts := strings.Split(value, ",")
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && opts[0] == "" && ft.Kind() == reflect.Struct {
+			if embedding[ft] {
+				continue
+			}
+			embedding[ft] = true
+			for _, inner := range taggedFieldsRec(ft, tag, embedding) {
+				inner.index = append([]int{i}, inner.index...)
+				fields = append(fields, inner)
+			}
+			delete(embedding, ft)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		tf := taggedField{name: f.Name, index: []int{i}}
+		if opts[0] != "" {
+			tf.name = opts[0]
+		}
+		for _, opt := range opts[1:] {
+			if opt == "omitempty" {
+				tf.omitEmpty = true
+			}
+		}
+		fields = append(fields, tf)
+	}
+	return fields
+}
+
+// fieldByIndex returns the field of the struct v at the index. If alloc is
+// true, the nil embedded pointers are allocated, else an invalid value is
+// returned when one is met.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// toTagged returns a value that encoding/json encodes like v, with the names
+// of the fields of the structs taken from the tag.
+func toTagged(v reflect.Value, tag string) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type().Implements(jsonMarshalerType) {
+		return v.Interface()
+	}
+	if v.CanAddr() && reflect.PtrTo(v.Type()).Implements(jsonMarshalerType) {
+		return v.Addr().Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return toTagged(v.Elem(), tag)
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		for _, f := range taggedFields(v.Type(), tag) {
+			fv := fieldByIndex(v, f.index, false)
+			if !fv.IsValid() || (f.omitEmpty && isEmptyValue(fv)) {
+				continue
+			}
+			m[f.name] = toTagged(fv, tag)
+		}
+		return m
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		fallthrough
+	case reflect.Array:
+		s := make([]interface{}, v.Len())
+		for i := range s {
+			s[i] = toTagged(v.Index(i), tag)
+		}
+		return s
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		m := reflect.MakeMap(reflect.MapOf(v.Type().Key(), emptyInterfaceType))
+		iter := v.MapRange()
+		for iter.Next() {
+			m.SetMapIndex(iter.Key(), reflect.ValueOf(toTagged(iter.Value(), tag)))
+		}
+		return m.Interface()
+	}
+	return v.Interface()
+}
+
+// fromTagged decodes the JSON data into v, which must be settable, with the
+// names of the fields of the structs taken from the tag.
+func fromTagged(data []byte, v reflect.Value, tag string) error {
+	if v.Kind() != reflect.Ptr && reflect.PtrTo(v.Type()).Implements(jsonUnmarshalerType) {
+		return json.Unmarshal(data, v.Addr().Interface())
+	}
+	isNull := bytes.Equal(bytes.TrimSpace(data), []byte("null"))
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if isNull {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return fromTagged(data, v.Elem(), tag)
+	case reflect.Struct:
+		if isNull {
+			return nil
+		}
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		for _, f := range taggedFields(v.Type(), tag) {
+			value, ok := raw[f.name]
+			if !ok {
+				for k, r := range raw {
+					if strings.EqualFold(k, f.name) {
+						value, ok = r, true
+						break
+					}
+				}
+			}
+			if !ok {
+				continue
+			}
+			if err := fromTagged(value, fieldByIndex(v, f.index, true), tag); err != nil {
+				return xerrors.Errorf("field %s: %v", f.name, err)
+			}
+		}
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 || isNull {
+			break
+		}
+		var raw []json.RawMessage
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(v.Type(), len(raw), len(raw))
+		for i, r := range raw {
+			if err := fromTagged(r, s.Index(i), tag); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	case reflect.Map:
+		if isNull {
+			break
+		}
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		m := reflect.MakeMap(v.Type())
+		for k, r := range raw {
+			key := reflect.New(v.Type().Key()).Elem()
+			if key.Kind() == reflect.String {
+				key.SetString(k)
+			} else if err := json.Unmarshal([]byte(k), key.Addr().Interface()); err != nil {
+				return err
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := fromTagged(r, elem, tag); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+		return nil
+	}
+	return json.Unmarshal(data, v.Addr().Interface())
+}
+
+// isEmptyValue tells if v is empty according to the omitempty option of
+// encoding/json.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
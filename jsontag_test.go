@@ -0,0 +1,81 @@
+package onet
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type taggedInner struct {
+	Value int `onet:"value"`
+}
+
+type taggedMsg struct {
+	FirstName string `onet:"first_name"`
+	Age       int    `json:"age_json"`
+	Plain     []byte
+	Skipped   string               `onet:"-"`
+	Empty     string               `onet:"empty,omitempty"`
+	Inner     *taggedInner         `onet:"inner"`
+	List      []taggedInner        `onet:"list"`
+	Map       map[int]*taggedInner `onet:"map"`
+	taggedInner
+}
+
+func TestJSONTag_RoundTrip(t *testing.T) {
+	msg := &taggedMsg{
+		FirstName:   "alice",
+		Age:         42,
+		Plain:       []byte{1, 2},
+		Skipped:     "skipped",
+		Inner:       &taggedInner{1},
+		List:        []taggedInner{{2}, {3}},
+		Map:         map[int]*taggedInner{4: {5}},
+		taggedInner: taggedInner{6},
+	}
+	buf, err := marshalJSON(msg, "onet")
+	require.NoError(t, err)
+	require.Equal(t, `{"Plain":"AQI=","age_json":42,"first_name":"alice",`+
+		`"inner":{"value":1},"list":[{"value":2},{"value":3}],"map":{"4":{"value":5}},"value":6}`,
+		string(buf))
+
+	dec := &taggedMsg{}
+	require.NoError(t, unmarshalJSON(buf, dec, "onet"))
+	msg.Skipped = ""
+	require.Equal(t, msg, dec)
+
+	// The names are matched case-insensitively, like encoding/json.
+	dec = &taggedMsg{}
+	require.NoError(t, unmarshalJSON([]byte(`{"FIRST_NAME": "bob", "inner": null}`), dec, "onet"))
+	require.Equal(t, "bob", dec.FirstName)
+	require.Nil(t, dec.Inner)
+
+	require.Error(t, unmarshalJSON([]byte(`{"first_name": 1}`), &taggedMsg{}, "onet"))
+
+	// Without a tag, encoding/json is used.
+	buf, err = marshalJSON(&taggedInner{1}, "")
+	require.NoError(t, err)
+	require.Equal(t, `{"Value":1}`, string(buf))
+}
+
+func TestJSONTag_REST(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+	p.RESTTagName = "onet"
+	require.NoError(t, p.RegisterRESTHandler(func(msg *taggedMsg) (*taggedMsg, error) {
+		return msg, nil
+	}, "dummyService", "POST", 3, 3))
+
+	r := httptest.NewRequest("POST", "/v3/dummyService/taggedMsg",
+		bytes.NewReader([]byte(`{"first_name": "alice", "value": 6}`)))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `{"Plain":null,"age_json":0,"first_name":"alice","inner":null,"list":null,"map":null,"value":6}`,
+		w.Body.String())
+}
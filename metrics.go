@@ -0,0 +1,240 @@
+package onet
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/xerrors"
+)
+
+// ProcessorMetrics instruments the requests of the handlers of a
+// ServiceProcessor for Prometheus. It is a prometheus.Collector, to be
+// registered by the caller, e.g. with prometheus.MustRegister, and given to
+// ServiceProcessor.Metrics. It can be shared by several ServiceProcessors.
+//
+// The metrics are labeled by the name of the message of the handler, and by
+// the method and the version of the REST requests. The labels method and
+// version are empty for the requests of the websocket, and the requests of
+// the websocket without a handler have the message "unregistered". They are:
+//  * requests_total: the number of requests
+//  * request_errors_total: the number of requests that failed, i.e. whose
+//    handler returned an error, or that got an HTTP status of at least 400
+//  * requests_in_flight: the number of requests being handled
+//  * request_duration_seconds: the histogram of the time taken to answer
+//    the requests
+//  * deprecated_fields_total: the number of uses of the deprecated fields of
+//    the messages, labeled by message and field instead
+//  * handler_timeout_seconds: the current timeout of the handlers set by
+//    ServiceProcessor.AdaptiveTimeout, zero until it is set, labeled by
+//    message only
+//  * handler_queue_depth: the number of requests waiting for a worker of
+//    ServiceProcessor.FairQueue, labeled by message only
+//  * decoded_requests_total and decoded_request_bytes_total: the number of
+//    requests decoded, and the sum of their encoded sizes, labeled by the Go
+//    type of their message only, e.g. "onet.SimpleRequest", whatever the
+//    handlers sharing it
+type ProcessorMetrics struct {
+	requests   *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	inFlight   *prometheus.GaugeVec
+	duration   *prometheus.HistogramVec
+	deprecated *prometheus.CounterVec
+	timeout    *prometheus.GaugeVec
+	queueDepth *prometheus.GaugeVec
+	decoded    *prometheus.CounterVec
+	bytes      *prometheus.CounterVec
+}
+
+// NewProcessorMetrics returns the metrics of the requests, whose names start
+// with the namespace, e.g. "onet", if it is not empty.
+func NewProcessorMetrics(namespace string) *ProcessorMetrics {
+	labels := []string{"message", "method", "version"}
+	return &ProcessorMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Number of requests to the service handlers.",
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "request_errors_total",
+			Help:      "Number of requests to the service handlers that failed.",
+		}, labels),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "requests_in_flight",
+			Help:      "Number of requests being handled by the service handlers.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Time taken by the service handlers to answer the requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+		deprecated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "deprecated_fields_total",
+			Help:      "Number of uses of the deprecated fields of the messages.",
+		}, []string{"message", "field"}),
+		timeout: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "handler_timeout_seconds",
+			Help:      "Current adaptive timeout of the service handlers.",
+		}, []string{"message"}),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "handler_queue_depth",
+			Help:      "Number of requests waiting for a worker, per service handler.",
+		}, []string{"message"}),
+		decoded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "decoded_requests_total",
+			Help:      "Number of requests decoded, per type of message.",
+		}, []string{"type"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "decoded_request_bytes_total",
+			Help:      "Sum of the sizes of the requests decoded, per type of message.",
+		}, []string{"type"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *ProcessorMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.errors.Describe(ch)
+	m.inFlight.Describe(ch)
+	m.duration.Describe(ch)
+	m.deprecated.Describe(ch)
+	m.timeout.Describe(ch)
+	m.queueDepth.Describe(ch)
+	m.decoded.Describe(ch)
+	m.bytes.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *ProcessorMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.errors.Collect(ch)
+	m.inFlight.Collect(ch)
+	m.duration.Collect(ch)
+	m.deprecated.Collect(ch)
+	m.timeout.Collect(ch)
+	m.queueDepth.Collect(ch)
+	m.decoded.Collect(ch)
+	m.bytes.Collect(ch)
+}
+
+// begin records the start of a request, and returns the function to call
+// when it is answered.
+func (m *ProcessorMetrics) begin(msgName, method, version string) func(failed bool) {
+	labels := prometheus.Labels{"message": msgName, "method": method, "version": version}
+	start := time.Now()
+	m.requests.With(labels).Inc()
+	inFlight := m.inFlight.With(labels)
+	inFlight.Inc()
+	return func(failed bool) {
+		inFlight.Dec()
+		m.duration.With(labels).Observe(time.Since(start).Seconds())
+		if failed {
+			m.errors.With(labels).Inc()
+		}
+	}
+}
+
+// setTimeout records the current timeout of the handler of msgName.
+func (m *ProcessorMetrics) setTimeout(msgName string, timeout time.Duration) {
+	m.timeout.With(prometheus.Labels{"message": msgName}).Set(timeout.Seconds())
+}
+
+// setQueueDepth records the number of requests of the handler of msgName
+// waiting for a worker.
+func (m *ProcessorMetrics) setQueueDepth(msgName string, depth int) {
+	m.queueDepth.With(prometheus.Labels{"message": msgName}).Set(float64(depth))
+}
+
+// recordDecoded records a request decoded into msg from size bytes.
+func (p *ServiceProcessor) recordDecoded(msg interface{}, size int) {
+	if p.Metrics == nil {
+		return
+	}
+	labels := prometheus.Labels{"type": reflect.TypeOf(msg).Elem().String()}
+	p.Metrics.decoded.With(labels).Inc()
+	p.Metrics.bytes.With(labels).Add(float64(size))
+}
+
+// unregisteredLabel is the message label of the requests of the websocket
+// for which no handler is registered.
+const unregisteredLabel = "unregistered"
+
+// beginRequest records the start of a request of the websocket if the
+// metrics are enabled.
+func (p *ServiceProcessor) beginRequest(msgName string) func(failed bool) {
+	if p.Metrics == nil {
+		return func(bool) {}
+	}
+	return p.Metrics.begin(msgName, "", "")
+}
+
+// instrumentREST returns the handler h of the REST requests of a version,
+// recording them if the metrics are enabled.
+func (p *ServiceProcessor) instrumentREST(msgName string, version int, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p.Metrics == nil {
+			h(w, r)
+			return
+		}
+		end := p.Metrics.begin(msgName, r.Method, strconv.Itoa(version))
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h(sw, r)
+		end(sw.code >= http.StatusBadRequest)
+	}
+}
+
+// statusWriter remembers the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// HandleMetrics serves the metrics of gatherer, or of
+// prometheus.DefaultGatherer if it is nil, on the /metrics endpoint of the
+// websocket, so that they are scraped without opening another port. If token
+// is not empty, the requests must give it in an "Authorization: Bearer
+// <token>" header. Calling it again replaces the gatherer and the token. An
+// error is returned if /metrics is already taken, by a route registered with
+// ServiceProcessor.HandleFunc or by a service named metrics.
+func (c *Server) HandleMetrics(token string, gatherer prometheus.Gatherer) (err error) {
+	if gatherer == nil {
+		gatherer = prometheus.DefaultGatherer
+	}
+	h := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+
+	w := c.WebSocket
+	w.Lock()
+	defer w.Unlock()
+	if w.metrics == nil {
+		if _, ok := w.services["metrics"]; ok {
+			return xerrors.New("/metrics is taken by the service metrics")
+		}
+		// http.ServeMux panics on the duplicate patterns.
+		defer func() {
+			if r := recover(); r != nil {
+				err = xerrors.Errorf("registering /metrics: %v", r)
+			}
+		}()
+		w.mux.HandleFunc("/metrics", w.serveMetrics)
+	}
+	w.metrics = h
+	w.metricsToken = token
+	return nil
+}
@@ -0,0 +1,129 @@
+package onet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+func TestServiceProcessor_Metrics(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	srv := local.GenServers(1)[0]
+	p := NewServiceProcessor(&Context{server: srv})
+	require.NoError(t, p.RegisterHandler(func(msg *testMsg) (*testMsg, error) {
+		if msg.I == 1 {
+			return nil, xerrors.New("internal failure")
+		}
+		return msg, nil
+	}))
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", 3, 4))
+
+	// Nothing is recorded without the metrics.
+	buf, err := protobuf.Encode(&testMsg{0})
+	require.NoError(t, err)
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	require.NoError(t, err)
+
+	m := NewProcessorMetrics("onet")
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(m))
+	p.Metrics = m
+
+	for _, i := range []int64{0, 1, 2} {
+		buf, err := protobuf.Encode(&testMsg{i})
+		require.NoError(t, err)
+		p.ProcessClientRequest(nil, "testMsg", buf)
+	}
+	for _, path := range []string{"/v3/dummyService/restMsgGET2/1",
+		"/v4/dummyService/restMsgGET2/2", "/v4/dummyService/restMsgGET2/x"} {
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	}
+
+	require.Equal(t, 3.0, testutil.ToFloat64(m.requests.WithLabelValues("testMsg", "", "")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.errors.WithLabelValues("testMsg", "", "")))
+	require.Equal(t, 0.0, testutil.ToFloat64(m.inFlight.WithLabelValues("testMsg", "", "")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("restMsgGET2", http.MethodGet, "3")))
+	require.Equal(t, 0.0, testutil.ToFloat64(m.errors.WithLabelValues("restMsgGET2", http.MethodGet, "3")))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.requests.WithLabelValues("restMsgGET2", http.MethodGet, "4")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.errors.WithLabelValues("restMsgGET2", http.MethodGet, "4")))
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	names := make(map[string]bool)
+	for _, f := range families {
+		names[f.GetName()] = true
+	}
+	require.True(t, names["onet_request_duration_seconds"])
+	require.True(t, names["onet_requests_in_flight"])
+	require.Equal(t, 3, testutil.CollectAndCount(m.duration))
+
+	// The unregistered messages share a single label.
+	for _, path := range []string{"unknown1", "unknown2"} {
+		_, _, err = p.ProcessClientRequest(nil, path, buf)
+		require.Error(t, err)
+	}
+	require.Equal(t, 2.0, testutil.ToFloat64(m.errors.WithLabelValues("unregistered", "", "")))
+	require.Equal(t, 4, testutil.CollectAndCount(m.duration))
+
+	// The decoded requests are counted by type of message, whatever the
+	// handler. The REST GET requests have no body.
+	require.NoError(t, p.RegisterHandler(func(msg *testMsg2) (*testMsg, error) {
+		return &testMsg{msg.I}, nil
+	}))
+	buf, err = protobuf.Encode(&testMsg2{42})
+	require.NoError(t, err)
+	_, _, err = p.ProcessClientRequest(nil, "testMsg2", buf)
+	require.NoError(t, err)
+	require.Equal(t, 3.0, testutil.ToFloat64(m.decoded.WithLabelValues("onet.testMsg")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.decoded.WithLabelValues("onet.testMsg2")))
+	require.Equal(t, float64(len(buf)), testutil.ToFloat64(m.bytes.WithLabelValues("onet.testMsg2")))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.decoded.WithLabelValues("onet.restMsgGET2")))
+	require.Equal(t, 0.0, testutil.ToFloat64(m.bytes.WithLabelValues("onet.restMsgGET2")))
+}
+
+func TestServer_HandleMetrics(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	srvs := local.GenServers(2)
+	srv := srvs[0]
+
+	reg := prometheus.NewRegistry()
+	m := NewProcessorMetrics("onet")
+	reg.MustRegister(m)
+	m.requests.WithLabelValues("testMsg", "", "").Inc()
+
+	get := func(auth string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		srv.WebSocket.mux.ServeHTTP(w, r)
+		return w
+	}
+
+	require.NoError(t, srv.HandleMetrics("", reg))
+	w := get("")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `onet_requests_total{message="testMsg",method="",version=""} 1`)
+
+	require.NoError(t, srv.HandleMetrics("secret", reg))
+	require.Equal(t, http.StatusUnauthorized, get("").Code)
+	require.Equal(t, http.StatusOK, get("Bearer secret").Code)
+
+	// The endpoint collides with the custom routes of the services.
+	noop := func(http.ResponseWriter, *http.Request) {}
+	p := NewServiceProcessor(&Context{server: srv})
+	require.Error(t, p.HandleFunc("/metrics", noop))
+	p = NewServiceProcessor(&Context{server: srvs[1]})
+	require.NoError(t, p.HandleFunc("/metrics", noop))
+	require.Error(t, srvs[1].HandleMetrics("", reg))
+}
@@ -0,0 +1,57 @@
+package onet
+
+import (
+	"net/http"
+	"reflect"
+	"time"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+// Handler is the normalized form of the handlers of a ServiceProcessor, as
+// seen by the middlewares. req is the HTTP request of the websocket or of the
+// REST call, and can be nil if the request doesn't come from HTTP, e.g. in
+// tests. msg is a pointer to the decoded message, or to the io.Reader of the
+// body for the handlers of RegisterUploadHandler. The reply of the handlers
+// of RegisterStreamingRESTHandler is their channel, that a middleware must
+// not replace.
+type Handler func(req *http.Request, msg interface{}) (interface{}, error)
+
+// Use adds a middleware around the handlers registered with RegisterHandler,
+// RegisterHandlerWithContext, RegisterHandlerWithTimeout,
+// RegisterRESTHandler, RegisterStreamingRESTHandler and
+// RegisterUploadHandler. A middleware can check or log the request before
+// calling next, and inspect or replace the reply and the error afterwards.
+// The middlewares are run in the order they have been added, the first one
+// being the outermost.
+func (p *ServiceProcessor) Use(mw func(next Handler) Handler) {
+	p.handlersLock.Lock()
+	p.middlewares = append(p.middlewares, mw)
+	p.handlersLock.Unlock()
+}
+
+// chain wraps h in the middlewares.
+func (p *ServiceProcessor) chain(h Handler) Handler {
+	p.handlersLock.RLock()
+	defer p.handlersLock.RUnlock()
+	for i := len(p.middlewares) - 1; i >= 0; i-- {
+		h = p.middlewares[i](h)
+	}
+	return h
+}
+
+// LogDuration is a middleware, to give to ServiceProcessor.Use, that logs the
+// time taken by every request along with its message type and its outcome.
+func LogDuration(next Handler) Handler {
+	return func(req *http.Request, msg interface{}) (interface{}, error) {
+		start := time.Now()
+		reply, err := next(req, msg)
+		name := reflect.TypeOf(msg).Elem().Name()
+		if err != nil {
+			log.Lvlf2("%s failed after %v: %v", name, time.Since(start), err)
+		} else {
+			log.Lvlf2("%s took %v", name, time.Since(start))
+		}
+		return reply, err
+	}
+}
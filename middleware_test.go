@@ -0,0 +1,119 @@
+package onet
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+func TestServiceProcessor_Use(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(t, p.RegisterHandler(procMsg))
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", 3, 3))
+
+	var calls []string
+	p.Use(LogDuration)
+	p.Use(func(next Handler) Handler {
+		return func(req *http.Request, msg interface{}) (interface{}, error) {
+			calls = append(calls, "outer")
+			return next(req, msg)
+		}
+	})
+	p.Use(func(next Handler) Handler {
+		return func(req *http.Request, msg interface{}) (interface{}, error) {
+			calls = append(calls, "inner")
+			if req != nil && req.Header.Get("Authorization") != "token" {
+				return nil, StatusError{Code: http.StatusUnauthorized, Msg: "invalid token"}
+			}
+			if m, ok := msg.(*testMsg); ok && m.I == 0 {
+				return nil, xerrors.New("rejected by middleware")
+			}
+			return next(req, msg)
+		}
+	})
+
+	buf, err := protobuf.Encode(&testMsg{11})
+	require.NoError(t, err)
+	rep, _, err := p.ProcessClientRequest(nil, "testMsg", buf)
+	require.NoError(t, err)
+	val := &testMsg{}
+	require.NoError(t, protobuf.Decode(rep, val))
+	require.Equal(t, int64(11), val.I)
+	require.Equal(t, []string{"outer", "inner"}, calls)
+
+	buf, err = protobuf.Encode(&testMsg{0})
+	require.NoError(t, err)
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "rejected by middleware")
+
+	get := func(token string) int {
+		r := httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/42", nil)
+		r.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w.Code
+	}
+	require.Equal(t, http.StatusUnauthorized, get(""))
+	require.Equal(t, http.StatusOK, get("token"))
+}
+
+func TestServiceProcessor_UseStreamAndUpload(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(t, p.RegisterStreamingRESTHandler(func(msg *restMsgGET2) (chan *testMsg, chan bool, error) {
+		out := make(chan *testMsg, 1)
+		out <- &testMsg{int64(msg.X)}
+		close(out)
+		return out, make(chan bool), nil
+	}, "dummyService", 3, 3))
+	require.NoError(t, p.RegisterUploadHandler(func(body io.Reader) (*testMsg, error) {
+		n, err := io.Copy(ioutil.Discard, body)
+		return &testMsg{n}, err
+	}, "dummyService", "upload", "POST", 3, 3, 10))
+
+	var msgs []interface{}
+	p.Use(func(next Handler) Handler {
+		return func(req *http.Request, msg interface{}) (interface{}, error) {
+			msgs = append(msgs, msg)
+			if req.Header.Get("Authorization") != "token" {
+				return nil, StatusError{Code: http.StatusUnauthorized, Msg: "invalid token"}
+			}
+			return next(req, msg)
+		}
+	})
+
+	send := func(r *http.Request, token string) *httptest.ResponseRecorder {
+		r.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w
+	}
+	get := func(token string) *httptest.ResponseRecorder {
+		return send(httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/42", nil), token)
+	}
+	post := func(token string) *httptest.ResponseRecorder {
+		return send(httptest.NewRequest("POST", "/v3/dummyService/upload",
+			bytes.NewReader(make([]byte, 10))), token)
+	}
+
+	require.Equal(t, http.StatusUnauthorized, get("").Code)
+	w := get("token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "data: {\"I\":42}\n\n", w.Body.String())
+	require.IsType(t, &restMsgGET2{}, msgs[0])
+
+	require.Equal(t, http.StatusUnauthorized, post("").Code)
+	require.Equal(t, http.StatusOK, post("token").Code)
+	require.IsType(t, new(io.Reader), msgs[2])
+}
@@ -0,0 +1,128 @@
+package onet
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+// DefaultMaxDecodedSize is the maximum size of the decoded messages when
+// ServiceProcessor.MaxDecodedSize is not set.
+const DefaultMaxDecodedSize = 64 * 1024 * 1024
+
+// maxDecodedSize returns the maximum size of the decoded messages, 0 if there
+// is none.
+func (p *ServiceProcessor) maxDecodedSize() int64 {
+	switch {
+	case p.MaxDecodedSize == 0:
+		return DefaultMaxDecodedSize
+	case p.MaxDecodedSize < 0:
+		return 0
+	}
+	return p.MaxDecodedSize
+}
+
+// decodeMessage decodes buf, a message of the websocket, into msg with the
+// codec, after checking its size, and then checks the size of msg.
+func (p *ServiceProcessor) decodeMessage(codec Codec, buf []byte, msg interface{}) error {
+	if max := p.maxMessageSize(); max > 0 && int64(len(buf)) > max {
+		return StatusError{Code: http.StatusRequestEntityTooLarge,
+			Msg: fmt.Sprintf("message of %d bytes is bigger than the maximum of %d bytes", len(buf), max)}
+	}
+	if err := codec.Decode(buf, msg); err != nil {
+		return err
+	}
+	if err := p.checkDecodedSize(msg); err != nil {
+		return err
+	}
+	p.recordDecoded(msg, len(buf))
+	return nil
+}
+
+// checkReplySize returns a StatusError of code 500 if the encoded reply of
+// the handler msgName is bigger than MaxReplySize, and logs it.
+func (p *ServiceProcessor) checkReplySize(msgName string, reply []byte) error {
+	if p.MaxReplySize <= 0 || int64(len(reply)) <= p.MaxReplySize {
+		return nil
+	}
+	log.Errorf("reply of %d bytes of handler %s is bigger than the maximum of %d bytes",
+		len(reply), msgName, p.MaxReplySize)
+	return StatusError{Code: http.StatusInternalServerError, Msg: "reply is too large"}
+}
+
+// checkDecodedSize returns a StatusError if the decoded message msg takes more
+// memory than the maximum.
+func (p *ServiceProcessor) checkDecodedSize(msg interface{}) error {
+	max := p.maxDecodedSize()
+	if max == 0 {
+		return nil
+	}
+	if decodedSize(reflect.ValueOf(msg), max) > max {
+		return StatusError{Code: http.StatusRequestEntityTooLarge,
+			Msg: fmt.Sprintf("decoded message is bigger than the maximum of %d bytes", max)}
+	}
+	return nil
+}
+
+// decodedSize returns an estimate of the memory taken by v and the values it
+// points to, or a number bigger than limit once it is reached.
+func decodedSize(v reflect.Value, limit int64) int64 {
+	var size int64
+	var add func(v reflect.Value, inline bool)
+	add = func(v reflect.Value, inline bool) {
+		if size > limit {
+			return
+		}
+		if !inline {
+			size += int64(v.Type().Size())
+		}
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			if !v.IsNil() {
+				add(v.Elem(), false)
+			}
+		case reflect.String:
+			size += int64(v.Len())
+		case reflect.Slice:
+			size += int64(v.Len()) * int64(v.Type().Elem().Size())
+			fallthrough
+		case reflect.Array:
+			if hasPointers(v.Type().Elem()) {
+				for i := 0; i < v.Len() && size <= limit; i++ {
+					add(v.Index(i), true)
+				}
+			}
+		case reflect.Map:
+			iter := v.MapRange()
+			for iter.Next() && size <= limit {
+				add(iter.Key(), false)
+				add(iter.Value(), false)
+			}
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				add(v.Field(i), true)
+			}
+		}
+	}
+	add(v, false)
+	return size
+}
+
+// hasPointers tells if the values of type t can refer to more memory.
+func hasPointers(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.String, reflect.Slice, reflect.Map:
+		return true
+	case reflect.Array:
+		return hasPointers(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasPointers(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
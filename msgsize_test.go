@@ -0,0 +1,119 @@
+package onet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+type sizedItem struct {
+	I int64
+}
+
+type sizedMsg struct {
+	Name  string
+	Items []*sizedItem
+}
+
+func TestDecodedSize(t *testing.T) {
+	msg := &sizedMsg{Name: "abcd", Items: []*sizedItem{{1}, {2}}}
+	ptr := int64(reflect.TypeOf(msg).Size())
+	header := int64(reflect.TypeOf(*msg).Size())
+	expected := ptr + header + 4 + 2*ptr + 2*8
+	require.Equal(t, expected, decodedSize(reflect.ValueOf(msg), 1<<20))
+
+	// The walk stops once the limit is reached.
+	msg.Items = make([]*sizedItem, 1000)
+	for i := range msg.Items {
+		msg.Items[i] = &sizedItem{}
+	}
+	full := decodedSize(reflect.ValueOf(msg), 1<<20)
+	require.Equal(t, ptr+header+4+1000*(ptr+8), full)
+	size := decodedSize(reflect.ValueOf(msg), 100)
+	require.True(t, size > 100 && size < full, size)
+
+	require.Equal(t, int64(24), decodedSize(reflect.ValueOf([]byte{}), 100))
+	require.Equal(t, int64(24+100), decodedSize(reflect.ValueOf(make([]byte, 100)), 1000))
+}
+
+func TestServiceProcessor_MaxDecodedSize(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	srv := local.GenServers(1)[0]
+	p := NewServiceProcessor(&Context{server: srv})
+	h := func(msg *sizedMsg) (*sizedMsg, error) { return &sizedMsg{}, nil }
+	require.NoError(t, p.RegisterHandler(h))
+	require.NoError(t, p.RegisterRESTHandler(h, "dummyService", "POST", 3, 3))
+
+	msg := &sizedMsg{Items: make([]*sizedItem, 200)}
+	for i := range msg.Items {
+		msg.Items[i] = &sizedItem{}
+	}
+	buf, err := protobuf.Encode(msg)
+	require.NoError(t, err)
+	_, _, err = p.ProcessClientRequest(nil, "sizedMsg", buf)
+	require.NoError(t, err)
+
+	// The encoded message is checked before it is decoded.
+	p.MaxBodySize = int64(len(buf) - 1)
+	_, _, err = p.ProcessClientRequest(nil, "sizedMsg", buf)
+	var se StatusError
+	require.True(t, xerrors.As(err, &se), err)
+	require.Equal(t, http.StatusRequestEntityTooLarge, se.Code)
+	require.Contains(t, se.Msg, "bigger than the maximum")
+	p.MaxBodySize = 0
+
+	// The decoded message takes more memory than the encoded one.
+	p.MaxDecodedSize = int64(len(buf))
+	_, _, err = p.ProcessClientRequest(nil, "sizedMsg", buf)
+	require.True(t, xerrors.As(err, &se), err)
+	require.Equal(t, http.StatusRequestEntityTooLarge, se.Code)
+	require.Contains(t, se.Msg, "decoded message")
+
+	body := `{"Items": [` + strings.Repeat(`{},`, 199) + `{}]}`
+	r := httptest.NewRequest("POST", "/v3/dummyService/sizedMsg", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, r)
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+
+	p.MaxDecodedSize = -1
+	_, _, err = p.ProcessClientRequest(nil, "sizedMsg", buf)
+	require.NoError(t, err)
+}
+
+func TestServiceProcessor_MaxReplySize(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	h := func(msg *sizedMsg) (*sizedMsg, error) {
+		return &sizedMsg{Name: strings.Repeat("a", 100)}, nil
+	}
+	require.NoError(t, p.RegisterHandler(h))
+	require.NoError(t, p.RegisterRESTHandler(h, "dummyService", "POST", 3, 3))
+
+	buf, err := protobuf.Encode(&sizedMsg{})
+	require.NoError(t, err)
+	_, _, err = p.ProcessClientRequest(nil, "sizedMsg", buf)
+	require.NoError(t, err)
+
+	p.MaxReplySize = 50
+	_, _, err = p.ProcessClientRequest(nil, "sizedMsg", buf)
+	var se StatusError
+	require.True(t, xerrors.As(err, &se), err)
+	require.Equal(t, http.StatusInternalServerError, se.Code)
+
+	r := httptest.NewRequest("POST", "/v3/dummyService/sizedMsg", strings.NewReader("{}"))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, r)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Contains(t, w.Body.String(), "reply is too large")
+	require.NotContains(t, w.Body.String(), "aaaa")
+}
@@ -14,8 +14,8 @@ type ConnType string
 // Address contains the ConnType and the actual network address. It is used to connect
 // to a remote host with a Conn and to listen by a Listener.
 // A network address holds an IP address and the port number joined
-// by a colon.
-// It doesn't support IPv6 yet.
+// by a colon. An IPv6 address is written in brackets, e.g.
+// tcp://[2001:db8::1]:7770.
 type Address string
 
 var lookupHost = net.LookupHost
@@ -260,6 +260,20 @@ func (a Address) Public() bool {
 	return !private && a.Valid()
 }
 
+// ParseHost returns the host of s, and true, if s is a host without a port: a
+// hostname, an IPv4 address, or an IPv6 address with or without brackets.
+// ex: "[2001:db8::1]" => "2001:db8::1"
+func ParseHost(s string) (string, bool) {
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		host := s[1 : len(s)-1]
+		return host, strings.Contains(host, ":") && net.ParseIP(host) != nil
+	}
+	if !strings.Contains(s, ":") {
+		return s, s != ""
+	}
+	return s, net.ParseIP(s) != nil
+}
+
 // NewAddress takes a connection type and the raw address. It returns a
 // correctly formatted address, which will be of type t.
 // It doesn't do any checking of ConnType or network.
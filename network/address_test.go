@@ -63,6 +63,9 @@ func TestAddress(t *testing.T) {
 		{"tcp://10.0.0.4:2000", true, PlainTCP, "10.0.0.4:2000", "10.0.0.4", "2000", false, "10.0.0.4", "10.0.0.4:2000"},
 		{"tcp://67.43.129.85:2000", true, PlainTCP, "67.43.129.85:2000", "67.43.129.85", "2000", true, "67.43.129.85", "67.43.129.85:2000"},
 		{"tls://[::]:1000", true, TLS, "[::]:1000", "::", "1000", true, "::", "[::]:1000"},
+		{"tcp://[2001:db8::1]:7770", true, PlainTCP, "[2001:db8::1]:7770", "2001:db8::1", "7770", true, "2001:db8::1", "[2001:db8::1]:7770"},
+		{"tls://[::1]:7770", true, TLS, "[::1]:7770", "::1", "7770", false, "::1", "[::1]:7770"},
+		{"tls://2001:db8::1:7770", false, InvalidConnType, "", "", "", false, "", ""},
 		{"tls4://10.0.0.4:2000", false, InvalidConnType, "", "", "", false, "", ""},
 		{"tls://1000.0.0.4:2000", false, InvalidConnType, "", "", "", false, "", ""},
 		{"tls://10.0.0.4:20000000", false, InvalidConnType, "", "", "", false, "", ""},
@@ -99,6 +102,23 @@ func TestAddress(t *testing.T) {
 }
 
 // Isolated test case for validHostname
+func TestParseHost(t *testing.T) {
+	for in, host := range map[string]string{
+		"1.2.3.4":           "1.2.3.4",
+		"conode.example.ch": "conode.example.ch",
+		"2001:db8::1":       "2001:db8::1",
+		"[2001:db8::1]":     "2001:db8::1",
+	} {
+		h, ok := ParseHost(in)
+		require.True(t, ok, in)
+		require.Equal(t, host, h)
+	}
+	for _, in := range []string{"", "1.2.3.4:80", "[2001:db8::1]:80", "[1.2.3.4]", "a:b"} {
+		_, ok := ParseHost(in)
+		require.False(t, ok, in)
+	}
+}
+
 func TestDNSNames(t *testing.T) {
 	assert.True(t, validHostname("myhost.secondlabel.org"))
 	assert.True(t, validHostname("www.asd.lol.xd"))
@@ -0,0 +1,50 @@
+package network
+
+import (
+	"context"
+	"net"
+
+	"golang.org/x/xerrors"
+)
+
+// ListenConfig holds the options of the socket of a TCPListener, e.g. for
+// the nodes that accept many connections. The zero values keep the defaults
+// of the system, so that a zero ListenConfig changes nothing. The keepalive
+// of the accepted connections is set by TransportConfig.
+type ListenConfig struct {
+	// Backlog is the maximum number of the connections waiting to be
+	// accepted. If zero, the default of the system is used, e.g.
+	// net.core.somaxconn on Linux, which also caps it.
+	Backlog int
+	// ReuseAddr sets SO_REUSEADDR, so that the listener can bind to an
+	// address whose previous connections are still closing. The net
+	// package already sets it on Unix.
+	ReuseAddr bool
+	// ReusePort sets SO_REUSEPORT, so that several processes can listen on
+	// the same port, e.g. for a hot restart where the new process starts
+	// listening before the old one stops.
+	ReusePort bool
+}
+
+// listen returns a TCP listener on the address with the options of lc. The
+// options are only supported on Linux, macOS and FreeBSD.
+func (lc ListenConfig) listen(address string) (net.Listener, error) {
+	if lc.Backlog < 0 {
+		return nil, xerrors.New("negative backlog")
+	}
+	if lc == (ListenConfig{}) {
+		return net.Listen("tcp", address)
+	}
+	nlc := net.ListenConfig{Control: lc.control}
+	ln, err := nlc.Listen(context.Background(), "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if lc.Backlog > 0 {
+		if err := setBacklog(ln, lc.Backlog); err != nil {
+			ln.Close()
+			return nil, xerrors.Errorf("setting backlog: %v", err)
+		}
+	}
+	return ln, nil
+}
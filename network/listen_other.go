@@ -0,0 +1,24 @@
+// +build !linux,!darwin,!freebsd
+
+package network
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/xerrors"
+)
+
+// control rejects the socket options, which are not supported on this
+// platform.
+func (lc ListenConfig) control(network, address string, c syscall.RawConn) error {
+	if lc.ReuseAddr || lc.ReusePort {
+		return xerrors.New("socket options are not supported on this platform")
+	}
+	return nil
+}
+
+// setBacklog rejects the backlog, which is not supported on this platform.
+func setBacklog(ln net.Listener, backlog int) error {
+	return xerrors.New("backlog is not supported on this platform")
+}
@@ -0,0 +1,59 @@
+// +build linux darwin freebsd
+
+package network
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenConfig_listen(t *testing.T) {
+	ln, err := ListenConfig{}.listen("127.0.0.1:0")
+	require.NoError(t, err)
+	// Without SO_REUSEPORT, the port can only be bound once.
+	_, err = ListenConfig{}.listen(ln.Addr().String())
+	require.Error(t, err)
+	require.NoError(t, ln.Close())
+
+	lc := ListenConfig{Backlog: 16, ReuseAddr: true, ReusePort: true}
+	ln1, err := lc.listen("127.0.0.1:0")
+	require.NoError(t, err)
+	ln2, err := lc.listen(ln1.Addr().String())
+	require.NoError(t, err)
+
+	accepted := make(chan error, 1)
+	go func() {
+		c, err := ln1.Accept()
+		if err == nil {
+			c.Close()
+		}
+		accepted <- err
+	}()
+	// The port is shared: ln2 is closed so that the connection goes to ln1.
+	require.NoError(t, ln2.Close())
+	c, err := net.Dial("tcp", ln1.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, <-accepted)
+	require.NoError(t, c.Close())
+	require.NoError(t, ln1.Close())
+
+	_, err = ListenConfig{Backlog: -1}.listen("127.0.0.1:0")
+	require.Error(t, err)
+}
+
+func TestNewTCPHostWithListenConfig(t *testing.T) {
+	addr := NewTCPAddress("127.0.0.1:0")
+	h, err := NewTCPHostWithListenConfig(NewTestServerIdentity(addr), tSuite, "",
+		ListenConfig{Backlog: 8, ReusePort: true})
+	require.NoError(t, err)
+	go h.Listen(func(c Conn) {})
+	c, err := NewTestTCPHost(0)
+	require.NoError(t, err)
+	conn, err := c.Connect(NewTestServerIdentity(h.Address()))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	require.NoError(t, c.Stop())
+	require.NoError(t, h.Stop())
+}
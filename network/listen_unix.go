@@ -0,0 +1,48 @@
+// +build linux darwin freebsd
+
+package network
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+)
+
+// control sets the socket options of lc before the socket is bound.
+func (lc ListenConfig) control(network, address string, c syscall.RawConn) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		if lc.ReuseAddr {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+		}
+		if err == nil && lc.ReusePort {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// setBacklog listens again on the socket of ln, which changes the size of its
+// queue of connections.
+func setBacklog(ln net.Listener, backlog int) error {
+	tl, ok := ln.(*net.TCPListener)
+	if !ok {
+		return xerrors.New("not a TCP listener")
+	}
+	rc, err := tl.SyscallConn()
+	if err != nil {
+		return err
+	}
+	cerr := rc.Control(func(fd uintptr) {
+		err = unix.Listen(int(fd), backlog)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -49,7 +49,14 @@ func NewTCPRouter(sid *ServerIdentity, suite Suite) (*Router, error) {
 // given listen address as the underlying Host.
 func NewTCPRouterWithListenAddr(sid *ServerIdentity, suite Suite,
 	listenAddr string) (*Router, error) {
-	h, err := NewTCPHostWithListenAddr(sid, suite, listenAddr)
+	return NewTCPRouterWithListenConfig(sid, suite, listenAddr, ListenConfig{})
+}
+
+// NewTCPRouterWithListenConfig returns a new Router using TCPHost with the
+// given listen address and socket options as the underlying Host.
+func NewTCPRouterWithListenConfig(sid *ServerIdentity, suite Suite,
+	listenAddr string, lc ListenConfig) (*Router, error) {
+	h, err := NewTCPHostWithListenConfig(sid, suite, listenAddr, lc)
 	if err != nil {
 		return nil, xerrors.Errorf("tcp router: %v", err)
 	}
@@ -83,19 +90,29 @@ type TCPConn struct {
 
 	// a hook to let us test dead servers
 	receiveRawTest func() ([]byte, error)
+
+	// maxIdle replaces the global timeout if it is not zero
+	maxIdle time.Duration
 }
 
 // NewTCPConn will open a TCPConn to the given address.
 // In case of an error it returns a nil TCPConn and the error.
 func NewTCPConn(addr Address, suite Suite) (conn *TCPConn, err error) {
+	return newTCPConn(addr, suite, TransportConfig{})
+}
+
+// newTCPConn is like NewTCPConn, but with the settings of tc.
+func newTCPConn(addr Address, suite Suite, tc TransportConfig) (conn *TCPConn, err error) {
 	netAddr := addr.NetworkAddress()
+	dialer := tc.dialer(dialTimeout)
 	for i := 1; i <= MaxRetryConnect; i++ {
 		var c net.Conn
-		c, err = net.DialTimeout("tcp", netAddr, dialTimeout)
+		c, err = dialer.Dial("tcp", netAddr)
 		if err == nil {
 			conn = &TCPConn{
-				conn:  c,
-				suite: suite,
+				conn:    c,
+				suite:   suite,
+				maxIdle: tc.MaxIdle,
 			}
 			return
 		}
@@ -142,9 +159,7 @@ func (c *TCPConn) receiveRaw() ([]byte, error) {
 func (c *TCPConn) receiveRawProd() ([]byte, error) {
 	c.receiveMutex.Lock()
 	defer c.receiveMutex.Unlock()
-	timeoutLock.RLock()
-	c.conn.SetReadDeadline(time.Now().Add(timeout))
-	timeoutLock.RUnlock()
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout()))
 	// First read the size
 	var total Size
 	if err := binary.Read(c.conn, globalOrder, &total); err != nil {
@@ -160,9 +175,7 @@ func (c *TCPConn) receiveRawProd() ([]byte, error) {
 	var buffer bytes.Buffer
 	for read < total {
 		// Read the size of the next packet.
-		timeoutLock.RLock()
-		c.conn.SetReadDeadline(time.Now().Add(timeout))
-		timeoutLock.RUnlock()
+		c.conn.SetReadDeadline(time.Now().Add(c.timeout()))
 		n, err := c.conn.Read(b)
 		// Quit if there is an error.
 		if err != nil {
@@ -205,9 +218,7 @@ func (c *TCPConn) Send(msg Message) (uint64, error) {
 // whole message b in slices of size maxChunkSize.
 // In case of an error it aborts.
 func (c *TCPConn) sendRaw(b []byte) (uint64, error) {
-	timeoutLock.RLock()
-	c.conn.SetWriteDeadline(time.Now().Add(timeout))
-	timeoutLock.RUnlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout()))
 
 	// First write the size
 	packetSize := Size(len(b))
@@ -233,6 +244,16 @@ func (c *TCPConn) sendRaw(b []byte) (uint64, error) {
 	return sentLen, nil
 }
 
+// timeout returns the read and write timeout of the connection.
+func (c *TCPConn) timeout() time.Duration {
+	if c.maxIdle > 0 {
+		return c.maxIdle
+	}
+	timeoutLock.RLock()
+	defer timeoutLock.RUnlock()
+	return timeout
+}
+
 // Remote returns the name of the peer at the end point of
 // the connection.
 func (c *TCPConn) Remote() Address {
@@ -314,6 +335,9 @@ type TCPListener struct {
 
 	// suite that is given to each incoming connection
 	suite Suite
+
+	// transport holds the settings of the connections, see TransportConfig
+	transport transportSettings
 }
 
 // NewTCPListener returns a TCPListener. This function binds globally using
@@ -339,6 +363,13 @@ func NewTCPListener(addr Address, s Suite) (*TCPListener, error) {
 // address which is different if you gave it a ":0"-address.
 func NewTCPListenerWithListenAddr(addr Address,
 	s Suite, listenAddr string) (*TCPListener, error) {
+	return NewTCPListenerWithListenConfig(addr, s, listenAddr, ListenConfig{})
+}
+
+// NewTCPListenerWithListenConfig is like NewTCPListenerWithListenAddr, with
+// the socket options of lc.
+func NewTCPListenerWithListenConfig(addr Address,
+	s Suite, listenAddr string, lc ListenConfig) (*TCPListener, error) {
 	if addr.ConnType() != PlainTCP && addr.ConnType() != TLS {
 		return nil, xerrors.New("TCPListener can only listen on TCP and TLS addresses")
 	}
@@ -353,9 +384,9 @@ func NewTCPListenerWithListenAddr(addr Address,
 		return nil, xerrors.Errorf("listener: %v", err)
 	}
 	for i := 0; i < MaxRetryConnect; i++ {
-		ln, err := net.Listen("tcp", listenOn)
+		ln, err := lc.listen(listenOn)
 		if err == nil {
-			t.listener = ln
+			t.listener = keepAliveListener{ln, &t.transport}
 			break
 		} else if i == MaxRetryConnect-1 {
 			return nil, xerrors.New("Error opening listener: " + err.Error())
@@ -403,13 +434,19 @@ func (t *TCPListener) listen(fn func(Conn)) error {
 			continue
 		}
 		c := TCPConn{
-			conn:  conn,
-			suite: t.suite,
+			conn:    conn,
+			suite:   t.suite,
+			maxIdle: t.transport.get().MaxIdle,
 		}
 		fn(&c)
 	}
 }
 
+// setTransport changes the settings of the connections accepted from now on.
+func (t *TCPListener) setTransport(tc TransportConfig) {
+	t.transport.set(tc)
+}
+
 // Stop the listener. It waits till all connections are closed
 // and returned from.
 // If there is no listener it will return an error.
@@ -479,9 +516,8 @@ func getListenAddress(addr Address, listenAddr string) (string, error) {
 
 	// If 'listenAddr' only contains the host, combine it with the port
 	// of 'addr'.
-	splitted := strings.Split(listenAddr, ":")
-	if len(splitted) == 1 && port != "" {
-		return splitted[0] + ":" + port, nil
+	if host, ok := ParseHost(listenAddr); ok && port != "" {
+		return net.JoinHostPort(host, port), nil
 	}
 
 	// If host and port in `listenAddr`, choose this one.
@@ -516,15 +552,22 @@ func NewTCPHost(sid *ServerIdentity, s Suite) (*TCPHost, error) {
 // listening on the given address.
 func NewTCPHostWithListenAddr(sid *ServerIdentity, s Suite,
 	listenAddr string) (*TCPHost, error) {
+	return NewTCPHostWithListenConfig(sid, s, listenAddr, ListenConfig{})
+}
+
+// NewTCPHostWithListenConfig returns a new Host using TCP connection based
+// type listening on the given address with the socket options of lc.
+func NewTCPHostWithListenConfig(sid *ServerIdentity, s Suite,
+	listenAddr string, lc ListenConfig) (*TCPHost, error) {
 	h := &TCPHost{
 		suite: s,
 		sid:   sid,
 	}
 	var err error
 	if sid.Address.ConnType() == TLS {
-		h.TCPListener, err = NewTLSListenerWithListenAddr(sid, s, listenAddr)
+		h.TCPListener, err = NewTLSListenerWithListenConfig(sid, s, listenAddr, lc)
 	} else {
-		h.TCPListener, err = NewTCPListenerWithListenAddr(sid.Address, s, listenAddr)
+		h.TCPListener, err = NewTCPListenerWithListenConfig(sid.Address, s, listenAddr, lc)
 	}
 	if err != nil {
 		return nil, xerrors.Errorf("tcp host: %v", err)
@@ -537,13 +580,13 @@ func NewTCPHostWithListenAddr(sid *ServerIdentity, s Suite,
 func (t *TCPHost) Connect(si *ServerIdentity) (Conn, error) {
 	switch si.Address.ConnType() {
 	case PlainTCP:
-		c, err := NewTCPConn(si.Address, t.suite)
+		c, err := newTCPConn(si.Address, t.suite, t.transport.get())
 		if err != nil {
 			return nil, xerrors.Errorf("tcp connection: %v", err)
 		}
 		return c, nil
 	case TLS:
-		c, err := NewTLSConn(t.sid, si, t.suite)
+		c, err := newTLSConn(t.sid, si, t.suite, t.transport.get())
 		if err != nil {
 			return nil, xerrors.Errorf("tcp connection: %v", err)
 		}
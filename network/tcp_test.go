@@ -493,6 +493,10 @@ func TestTCPListenerWithListenAddr(t *testing.T) {
 		{NewAddress(PlainTCP, "1.2.3.4:1234"), "4.3.2.1", "4.3.2.1:1234"},
 		{NewAddress(PlainTCP, "1.2.3.4:1234"), "4.3.2.1:4321", "4.3.2.1:4321"},
 		{NewAddress(PlainTCP, "1.2.3.4:1234"), "", ":1234"},
+		{NewAddress(PlainTCP, "[2001:db8::1]:1234"), "2001:db8::2", "[2001:db8::2]:1234"},
+		{NewAddress(PlainTCP, "[2001:db8::1]:1234"), "[2001:db8::2]", "[2001:db8::2]:1234"},
+		{NewAddress(PlainTCP, "[2001:db8::1]:1234"), "[::1]:4321", "[::1]:4321"},
+		{NewAddress(PlainTCP, "[2001:db8::1]:1234"), "", ":1234"},
 	}
 	for _, tv := range testVectorStaticPort {
 		// using directly 'getListenAddress' which is used by
@@ -232,7 +232,14 @@ func NewTLSListener(si *ServerIdentity, suite Suite) (*TCPListener, error) {
 // the ConnType from the ServerIdentity?
 func NewTLSListenerWithListenAddr(si *ServerIdentity, suite Suite,
 	listenAddr string) (*TCPListener, error) {
-	tcp, err := NewTCPListenerWithListenAddr(si.Address, suite, listenAddr)
+	return NewTLSListenerWithListenConfig(si, suite, listenAddr, ListenConfig{})
+}
+
+// NewTLSListenerWithListenConfig is like NewTLSListenerWithListenAddr, with
+// the socket options of lc.
+func NewTLSListenerWithListenConfig(si *ServerIdentity, suite Suite,
+	listenAddr string, lc ListenConfig) (*TCPListener, error) {
+	tcp, err := NewTCPListenerWithListenConfig(si.Address, suite, listenAddr, lc)
 	if err != nil {
 		return nil, xerrors.Errorf("tls listener: %v", err)
 	}
@@ -425,6 +432,12 @@ func tlsConfig(suite Suite, us *ServerIdentity) (*tls.Config, error) {
 // it holds the given Public key by self-signing a certificate
 // linked to that key.
 func NewTLSConn(us *ServerIdentity, them *ServerIdentity, suite Suite) (conn *TCPConn, err error) {
+	return newTLSConn(us, them, suite, TransportConfig{})
+}
+
+// newTLSConn is like NewTLSConn, but with the settings of tc.
+func newTLSConn(us *ServerIdentity, them *ServerIdentity, suite Suite,
+	tc TransportConfig) (conn *TCPConn, err error) {
 	log.Lvl2("NewTLSConn to:", them)
 	if them.Address.ConnType() != TLS {
 		return nil, xerrors.New("not a tls server")
@@ -442,14 +455,18 @@ func NewTLSConn(us *ServerIdentity, them *ServerIdentity, suite Suite) (conn *TC
 	cfg.VerifyPeerCertificate = vrf
 
 	netAddr := them.Address.NetworkAddress()
+	timeoutLock.RLock()
+	dialer := tc.dialer(timeout)
+	timeoutLock.RUnlock()
 	for i := 1; i <= MaxRetryConnect; i++ {
 		var c net.Conn
 		cfg.ServerName = string(nonce)
-		c, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", netAddr, cfg)
+		c, err = tls.DialWithDialer(dialer, "tcp", netAddr, cfg)
 		if err == nil {
 			conn = &TCPConn{
-				conn:  c,
-				suite: suite,
+				conn:    c,
+				suite:   suite,
+				maxIdle: tc.MaxIdle,
 			}
 			return
 		}
@@ -0,0 +1,104 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// TransportConfig holds the settings of the TCP connections of a TCPHost,
+// both the ones it opens and the ones it accepts. The zero values keep the
+// defaults of the package, so that a zero TransportConfig changes nothing.
+type TransportConfig struct {
+	// DialTimeout is the time given to a connection to another node to be
+	// established. If zero, the timeout of SetTCPDialTimeout is used.
+	DialTimeout time.Duration
+	// KeepAlive is the interval between the TCP keepalive probes of the
+	// connections. If zero, the default of the net package is used, and if
+	// negative, the keepalives are disabled.
+	KeepAlive time.Duration
+	// MaxIdle is the time after which a connection that receives nothing
+	// is closed, which is also the time given to a send to complete. If
+	// zero, the default of one minute is used.
+	MaxIdle time.Duration
+}
+
+// transportHost is implemented by the hosts whose connections can be
+// configured with a TransportConfig.
+type transportHost interface {
+	SetTransport(TransportConfig)
+}
+
+// SetTransport changes the settings of the connections opened or accepted
+// from now on by the host. The existing connections are not changed.
+func (t *TCPHost) SetTransport(tc TransportConfig) {
+	t.TCPListener.setTransport(tc)
+}
+
+// SetTransport changes the settings of the connections of the host of the
+// router, see TransportConfig. It returns an error if the host doesn't use
+// TCP connections.
+func (r *Router) SetTransport(tc TransportConfig) error {
+	h, ok := r.host.(transportHost)
+	if !ok {
+		return xerrors.New("the host of the router doesn't support transport settings")
+	}
+	h.SetTransport(tc)
+	return nil
+}
+
+// transportSettings holds the TransportConfig of a TCPListener, shared with
+// the listener accepting its connections.
+type transportSettings struct {
+	sync.Mutex
+	config TransportConfig
+}
+
+func (ts *transportSettings) get() TransportConfig {
+	ts.Lock()
+	defer ts.Unlock()
+	return ts.config
+}
+
+func (ts *transportSettings) set(tc TransportConfig) {
+	ts.Lock()
+	defer ts.Unlock()
+	ts.config = tc
+}
+
+// dialer returns the dialer of the connections to other nodes, with
+// defaultTimeout if DialTimeout is not set.
+func (tc TransportConfig) dialer(defaultTimeout time.Duration) *net.Dialer {
+	d := &net.Dialer{
+		Timeout:   defaultTimeout,
+		KeepAlive: tc.KeepAlive,
+	}
+	if tc.DialTimeout > 0 {
+		d.Timeout = tc.DialTimeout
+	}
+	return d
+}
+
+// keepAliveListener sets the keepalive of the TCP connections it accepts,
+// before they are wrapped in TLS if the listener is a TLS one.
+type keepAliveListener struct {
+	net.Listener
+	settings *transportSettings
+}
+
+func (l keepAliveListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	keepAlive := l.settings.get().KeepAlive
+	if tcp, ok := c.(*net.TCPConn); ok && keepAlive != 0 {
+		tcp.SetKeepAlive(keepAlive > 0)
+		if keepAlive > 0 {
+			tcp.SetKeepAlivePeriod(keepAlive)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,73 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTCPHost_SetTransport(t *testing.T) {
+	h1, err := NewTestTCPHost(0)
+	require.NoError(t, err)
+	h2, err := NewTestTCPHost(0)
+	require.NoError(t, err)
+	maxIdle := 100 * time.Millisecond
+	h1.SetTransport(TransportConfig{
+		DialTimeout: time.Second,
+		KeepAlive:   time.Second,
+		MaxIdle:     maxIdle,
+	})
+
+	received := make(chan error)
+	ready := make(chan bool)
+	go func() {
+		ready <- true
+		require.NoError(t, h1.Listen(func(c Conn) {
+			_, err := c.Receive()
+			received <- err
+		}))
+	}()
+	<-ready
+	go h2.Listen(func(c Conn) {})
+
+	// The connections accepted by h1 are closed once idle for maxIdle.
+	si1 := NewTestServerIdentity(h1.Address())
+	c, err := h2.Connect(si1)
+	require.NoError(t, err)
+	select {
+	case err := <-received:
+		require.Error(t, err)
+	case <-time.After(10 * maxIdle):
+		require.Fail(t, "the idle connection should have been closed")
+	}
+	require.NoError(t, c.Close())
+
+	// The connections opened by h1 too, but not the ones of h2.
+	si2 := NewTestServerIdentity(h2.Address())
+	c, err = h1.Connect(si2)
+	require.NoError(t, err)
+	require.Equal(t, maxIdle, c.(*TCPConn).timeout())
+	require.NoError(t, c.Close())
+	c, err = h2.Connect(si1)
+	require.NoError(t, err)
+	require.NotEqual(t, maxIdle, c.(*TCPConn).timeout())
+	<-received
+	require.NoError(t, c.Close())
+
+	require.NoError(t, h1.Stop())
+	require.NoError(t, h2.Stop())
+}
+
+func TestRouter_SetTransport(t *testing.T) {
+	r, err := NewTestRouterTCP(0)
+	require.NoError(t, err)
+	defer r.Stop()
+	require.NoError(t, r.SetTransport(TransportConfig{MaxIdle: time.Second}))
+	require.Equal(t, time.Second, r.host.(*TCPHost).transport.get().MaxIdle)
+
+	l, err := NewTestRouterLocal(0)
+	require.NoError(t, err)
+	defer l.Stop()
+	require.Error(t, l.SetTransport(TransportConfig{MaxIdle: time.Second}))
+}
@@ -0,0 +1,308 @@
+package onet
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+var contentTyperType = reflect.TypeOf((*ContentTyper)(nil)).Elem()
+
+// OpenAPISpec returns an OpenAPI 3.0 specification, in JSON, of the
+// endpoints registered with RegisterRESTHandler and
+// RegisterStreamingRESTHandler. The schemas of the requests and of the
+// responses are derived from the fields of the messages, the way they are
+// encoded in JSON, along with the rules of their validate tags and their
+// deprecation. The changelog of AddChangelog is in the x-changelog extension
+// of the info object.
+func (p *ServiceProcessor) OpenAPISpec() ([]byte, error) {
+	title := ""
+	if p.Context != nil {
+		title = ServiceFactory.Name(p.ServiceID())
+	}
+	if title == "" {
+		title = "onet service"
+	}
+
+	s := newSchemaGenerator(p.RESTTagName)
+	paths := make(map[string]interface{})
+
+	p.handlersLock.RLock()
+	patterns := make([]string, 0, len(p.restRoutes))
+	for pattern, route := range p.restRoutes {
+		if route.handler != nil {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		route := p.restRoutes[pattern]
+		path, op := s.operation(pattern, route)
+		paths[path] = map[string]interface{}{
+			strings.ToLower(route.method): op,
+		}
+	}
+	info := map[string]interface{}{
+		"title":   title,
+		"version": "v3",
+	}
+	if len(p.changelog) > 0 {
+		info["x-changelog"] = append([]ChangelogEntry{}, p.changelog...)
+	}
+	p.handlersLock.RUnlock()
+
+	spec := map[string]interface{}{
+		"openapi": "3.0.0",
+		"info":    info,
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": s.schemas,
+		},
+	}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+// MessageSchema returns a JSON Schema (draft-07) document of the message of
+// the handler registered under name, describing it the way it is encoded in
+// JSON by the REST API. The named structs of its fields are in the
+// definitions of the document.
+func (p *ServiceProcessor) MessageSchema(name string) ([]byte, error) {
+	sh, ok := p.getHandler(name)
+	if !ok {
+		return nil, xerrors.Errorf("no handler registered for %s", name)
+	}
+	s := newSchemaGenerator(p.RESTTagName)
+	s.jsonSchema = true
+	doc := s.structSchema(sh.msgType)
+	doc["$schema"] = "http://json-schema.org/draft-07/schema#"
+	doc["title"] = name
+	if len(s.schemas) > 0 {
+		doc["definitions"] = s.schemas
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// schemaGenerator collects the schemas of the messages of the endpoints.
+type schemaGenerator struct {
+	schemas map[string]interface{}
+	// tag gives the names of the fields
+	tag string
+	// jsonSchema is set to follow JSON Schema instead of OpenAPI, for the
+	// references to the schemas and the encoding of the bytes.
+	jsonSchema bool
+}
+
+func newSchemaGenerator(tag string) *schemaGenerator {
+	if tag == "" {
+		tag = "json"
+	}
+	return &schemaGenerator{schemas: make(map[string]interface{}), tag: tag}
+}
+
+// operation returns the path and the description of the operation of the
+// route registered on the pattern.
+func (s *schemaGenerator) operation(pattern string, route *restRoute) (string, map[string]interface{}) {
+	op := map[string]interface{}{
+		"operationId": strings.Trim(strings.Replace(pattern, "/", "_", -1), "_"),
+	}
+
+	path := pattern
+	if route.get != nil && len(route.get.segments) > 0 {
+		var params []interface{}
+		for _, seg := range route.get.segments {
+			if route.get.kind != templateGET {
+				// the placeholders of the templates are in the pattern
+				path += "{" + seg.field + "}/"
+			}
+			param := map[string]interface{}{"type": "integer"}
+			if seg.kind == sliceGET {
+				param = map[string]interface{}{"type": "string", "pattern": "^[0-9a-f]+$"}
+				if route.get.maxIDLen > 0 {
+					// two hex characters per byte
+					param["minLength"] = 2 * route.get.minIDLen
+					param["maxLength"] = 2 * route.get.maxIDLen
+				}
+			}
+			params = append(params, map[string]interface{}{
+				"name":     seg.field,
+				"in":       "path",
+				"required": true,
+				"schema":   param,
+			})
+		}
+		path = strings.TrimSuffix(path, "/")
+		op["parameters"] = params
+	}
+
+	if route.method == "POST" || route.method == "PUT" {
+		content := make(map[string]interface{})
+		for _, ct := range route.opts.contentTypes {
+			content[ct] = map[string]interface{}{
+				"schema": s.schema(route.msgType),
+			}
+		}
+		if route.msgType == nil {
+			// the body of an upload is given as is to the handler
+			content["application/octet-stream"] = map[string]interface{}{
+				"schema": map[string]interface{}{"type": "string", "format": "binary"},
+			}
+		}
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  content,
+		}
+	}
+
+	contentType := "application/json"
+	var replySchema map[string]interface{}
+	switch {
+	case route.streaming:
+		contentType = "text/event-stream"
+		replySchema = s.schema(route.replyType)
+	case route.replyType.Implements(contentTyperType):
+		// the content type is only known once the handler replied
+		contentType = "*/*"
+		replySchema = map[string]interface{}{"type": "string", "format": "binary"}
+	default:
+		replySchema = s.schema(route.replyType)
+	}
+	op["responses"] = map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "reply of the service",
+			"content": map[string]interface{}{
+				contentType: map[string]interface{}{
+					"schema": replySchema,
+				},
+			},
+		},
+		"default": map[string]interface{}{
+			"description": "error",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"message": map[string]interface{}{"type": "string"},
+							"error":   map[string]interface{}{"type": "string"},
+							"code":    map[string]interface{}{"type": "integer"},
+							"details": map[string]interface{}{},
+						},
+					},
+				},
+			},
+		},
+	}
+	return path, op
+}
+
+// schema returns the schema of t. The named structs are added to the
+// components and referenced.
+func (s *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.PkgPath() == "time" && t.Name() == "Time" {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			if s.jsonSchema {
+				return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+			}
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": s.schema(t.Elem()),
+		}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		if _, ok := s.schemas[t.Name()]; !ok {
+			// placeholder to stop the recursion of recursive types
+			s.schemas[t.Name()] = nil
+			s.schemas[t.Name()] = s.structSchema(t)
+		}
+		if s.jsonSchema {
+			return map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	// interfaces and anything else can hold any value
+	return map[string]interface{}{}
+}
+
+// structSchema returns the schema of the struct t, following the rules of
+// encoding/json for the names of the fields. The validation rules of the
+// fields are added to their schemas.
+func (s *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	var required []string
+	for _, f := range taggedFields(t, s.tag) {
+		sf := t.FieldByIndex(f.index)
+		schema := s.schema(sf.Type)
+		// the tags are checked when the handlers are registered
+		rules, _ := parseRules(sf)
+		if rules.required {
+			required = append(required, f.name)
+		}
+		addRules(schema, rules)
+		props[f.name] = schema
+	}
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addRules adds the validation rules, except required, and the deprecation
+// to the schema of a field.
+func addRules(schema map[string]interface{}, rules fieldRules) {
+	bounds := []struct {
+		keyword string
+		value   int
+	}{
+		{"minLength", rules.minLen},
+		{"maxLength", rules.maxLen},
+		{"minItems", rules.minItems},
+		{"maxItems", rules.maxItems},
+	}
+	for _, b := range bounds {
+		if b.value >= 0 {
+			if schema["type"] == "object" {
+				// the items of a map are its properties
+				schema[strings.Replace(b.keyword, "Items", "Properties", 1)] = b.value
+			} else {
+				schema[b.keyword] = b.value
+			}
+		}
+	}
+	if rules.def.IsValid() {
+		schema["default"] = rules.def.Interface()
+	}
+	if rules.deprecated {
+		schema["deprecated"] = true
+	}
+}
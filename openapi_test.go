@@ -0,0 +1,120 @@
+package onet
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type openAPIReply struct {
+	Name     string `json:"name"`
+	Data     []byte `json:"data,omitempty"`
+	Children []*openAPIReply
+	Hidden   int `json:"-"`
+	private  int
+	testMsg
+}
+
+func TestServiceProcessor_OpenAPISpec(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+
+	require.NoError(t, p.RegisterRESTHandler(func(*restMsgGET2) (*openAPIReply, error) {
+		return nil, nil
+	}, "dummyService", "GET", 3, 4))
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgPOSTString, "dummyService", "POST", 3, 3))
+	require.NoError(t, p.RegisterStreamingRESTHandler(func(*restMsgGET1) (chan *testMsg, chan bool, error) {
+		return nil, nil, nil
+	}, "dummyService", 3, 3))
+
+	buf, err := p.OpenAPISpec()
+	require.NoError(t, err)
+	var spec struct {
+		OpenAPI string
+		Paths   map[string]map[string]struct {
+			Parameters []struct {
+				Name string
+				In   string
+			}
+			RequestBody *struct {
+				Content map[string]struct {
+					Schema map[string]interface{}
+				}
+			}
+			Responses map[string]struct {
+				Content map[string]struct {
+					Schema map[string]interface{}
+				}
+			}
+		}
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]interface{}
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal(buf, &spec))
+	require.Equal(t, "3.0.0", spec.OpenAPI)
+	require.Len(t, spec.Paths, 4)
+
+	get := spec.Paths["/v4/dummyService/restMsgGET2/{X}"]["get"]
+	require.Equal(t, "X", get.Parameters[0].Name)
+	require.Equal(t, "path", get.Parameters[0].In)
+	require.Equal(t, "#/components/schemas/openAPIReply",
+		get.Responses["200"].Content["application/json"].Schema["$ref"])
+
+	post := spec.Paths["/v3/dummyService/restMsgPOSTString"]["post"]
+	require.Equal(t, "#/components/schemas/restMsgPOSTString",
+		post.RequestBody.Content["application/json"].Schema["$ref"])
+
+	stream := spec.Paths["/v3/dummyService/restMsgGET1"]["get"]
+	require.Equal(t, "#/components/schemas/testMsg",
+		stream.Responses["200"].Content["text/event-stream"].Schema["$ref"])
+
+	props := spec.Components.Schemas["openAPIReply"].Properties
+	require.Len(t, props, 4)
+	require.Equal(t, "string", props["name"]["type"])
+	require.Equal(t, "byte", props["data"]["format"])
+	require.Equal(t, "array", props["Children"]["type"])
+	require.Equal(t, "integer", props["I"]["type"])
+}
+
+func TestServiceProcessor_MessageSchema(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(t, p.RegisterHandler(func(*openAPIReply) (*testMsg, error) {
+		return nil, nil
+	}))
+
+	buf, err := p.MessageSchema("openAPIReply")
+	require.NoError(t, err)
+	var schema struct {
+		Schema      string `json:"$schema"`
+		Title       string
+		Type        string
+		Properties  map[string]map[string]interface{}
+		Definitions map[string]struct {
+			Properties map[string]map[string]interface{}
+		}
+	}
+	require.NoError(t, json.Unmarshal(buf, &schema))
+	require.Equal(t, "http://json-schema.org/draft-07/schema#", schema.Schema)
+	require.Equal(t, "openAPIReply", schema.Title)
+	require.Equal(t, "object", schema.Type)
+	require.Equal(t, map[string]interface{}{"type": "string"}, schema.Properties["name"])
+	require.Equal(t, map[string]interface{}{"type": "string", "contentEncoding": "base64"},
+		schema.Properties["data"])
+	// The fields of the embedded struct are inlined.
+	require.Equal(t, map[string]interface{}{"type": "integer"}, schema.Properties["I"])
+	require.Equal(t, "#/definitions/openAPIReply",
+		schema.Properties["Children"]["items"].(map[string]interface{})["$ref"])
+	require.Contains(t, schema.Definitions["openAPIReply"].Properties, "Children")
+	require.NotContains(t, schema.Properties, "Hidden")
+	require.NotContains(t, schema.Properties, "private")
+
+	_, err = p.MessageSchema("unknown")
+	require.Error(t, err)
+}
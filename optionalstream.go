@@ -0,0 +1,155 @@
+package onet
+
+import (
+	"reflect"
+
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+// RegisterOptionalStreamingHandler registers a handler that chooses, for each
+// request, to stream its reply or to send a single message, e.g. to stream
+// only the large result sets. f must be in the following form:
+// func(msg interface{})(retChan chan interface{}, single interface{}, err error)
+//
+// Exactly one of retChan and single must be non-nil. ProcessClientRequest
+// returns the encoded single reply, or a StreamingTunnel that forwards the
+// messages of retChan until the handler closes it. If the client goes away
+// before, the messages left in retChan are read and discarded for
+// StreamStopGracePeriod.
+//
+// The handler is only reached on the websocket.
+func (p *ServiceProcessor) RegisterOptionalStreamingHandler(f interface{}) error {
+	sh, err := newOptionalStreamingHandler(f)
+	if err != nil {
+		return err
+	}
+	if err := p.checkReplyTypes(f); err != nil {
+		return err
+	}
+
+	log.Lvl4("Registering optional streaming handler", sh.msgType.String())
+	pm, err := messageName(sh.msgType)
+	if err != nil {
+		return err
+	}
+	p.handlersLock.Lock()
+	p.handlers[pm] = sh
+	p.handlersLock.Unlock()
+	return nil
+}
+
+// newOptionalStreamingHandler checks that f is an optional streaming handler
+// and returns it.
+func newOptionalStreamingHandler(f interface{}) (serviceHandler, error) {
+	if err := handlerInputCheck(f); err != nil {
+		return serviceHandler{}, err
+	}
+	if err := optionalStreamingOutputCheck(f); err != nil {
+		return serviceHandler{}, err
+	}
+	msgType := reflect.TypeOf(f).In(0).Elem()
+	if err := checkMessageType(msgType); err != nil {
+		return serviceHandler{}, err
+	}
+	return serviceHandler{handler: f, msgType: msgType, optional: true}, nil
+}
+
+// optionalStreamingOutputCheck checks that f returns a channel of messages, a
+// message and an error.
+func optionalStreamingOutputCheck(f interface{}) error {
+	ft := reflect.TypeOf(f)
+	if ft.NumOut() != 3 {
+		return xerrors.New("Need 3 return values: chan interface{}, interface{} and error")
+	}
+	ret0 := ft.Out(0)
+	if ret0.Kind() != reflect.Chan || ret0.ChanDir()&reflect.RecvDir == 0 {
+		return xerrors.New("1st return value must be a channel")
+	}
+	if !isMessageType(ret0.Elem()) {
+		return xerrors.New("1st return value must be a channel of a pointer to a struct")
+	}
+	if !isMessageType(ft.Out(1)) {
+		return xerrors.New("2nd return value must be a pointer to a struct or an interface")
+	}
+	if !ft.Out(2).Implements(errType) {
+		return xerrors.New("3rd return value has to implement error, but is: " +
+			ft.Out(2).String())
+	}
+	return nil
+}
+
+// isMessageType returns true for the interfaces and the pointers to a struct.
+func isMessageType(t reflect.Type) bool {
+	return t.Kind() == reflect.Interface ||
+		(t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct)
+}
+
+// streamReply is returned by callInterfaceFunc when a handler of
+// RegisterOptionalStreamingHandler chose to stream its reply.
+type streamReply struct {
+	ch reflect.Value
+}
+
+// optionalReply returns the reply of a handler of
+// RegisterOptionalStreamingHandler, whose return values are ret.
+func optionalReply(ret []reflect.Value) (interface{}, error) {
+	if ierr := ret[2].Interface(); ierr != nil {
+		return nil, xerrors.Errorf("processing error: %w", ierr.(error))
+	}
+	stream, single := !ret[0].IsNil(), !ret[1].IsNil()
+	switch {
+	case stream && single:
+		return nil, xerrors.New("handler returned both a channel and a single reply")
+	case stream:
+		return streamReply{ch: ret[0]}, nil
+	case single:
+		return ret[1].Interface(), nil
+	}
+	return nil, xerrors.New("handler returned neither a channel nor a single reply")
+}
+
+// newTunnel returns the StreamingTunnel of the messages of ch, a channel
+// returned by the handler of msgName, encoded with codec.
+func (p *ServiceProcessor) newTunnel(ch reflect.Value, codec Codec, msgName string) *StreamingTunnel {
+	tun := &StreamingTunnel{out: make(chan []byte, 100), close: make(chan bool)}
+	go func() {
+		defer close(tun.out)
+		if !p.DisablePanicRecovery {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Errorf("Streaming %s panicked with '%v' at %s",
+						msgName, r, log.Stack())
+					p.drainStream(ch, msgName)
+				}
+			}()
+		}
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: ch},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(tun.close)},
+		}
+		for {
+			chosen, v, ok := reflect.Select(cases)
+			if chosen == 1 {
+				p.drainStream(ch, msgName)
+				return
+			}
+			if !ok {
+				return
+			}
+			buf, err := codec.Encode(v.Interface())
+			if err != nil {
+				log.Error(xerrors.Errorf("encoding reply of %s: %v", msgName, err))
+				p.drainStream(ch, msgName)
+				return
+			}
+			select {
+			case tun.out <- buf:
+			case <-tun.close:
+				p.drainStream(ch, msgName)
+				return
+			}
+		}
+	}()
+	return tun
+}
@@ -0,0 +1,169 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+// optionalStream streams msg.Val replies if it is bigger than one, and sends
+// a single reply otherwise.
+func optionalStream(msg *SimpleRequest) (chan *SimpleResponse, *SimpleResponse, error) {
+	switch {
+	case msg.Val < 0:
+		return nil, nil, nil
+	case msg.Val <= 1:
+		return nil, &SimpleResponse{msg.Val}, nil
+	}
+	out := make(chan *SimpleResponse, msg.Val)
+	for i := int64(0); i < msg.Val; i++ {
+		out <- &SimpleResponse{i}
+	}
+	close(out)
+	return out, nil, nil
+}
+
+func newOptionalStreamingService(c *Context) (Service, error) {
+	s := &StreamingService{ServiceProcessor: NewServiceProcessor(c)}
+	if err := s.RegisterOptionalStreamingHandler(optionalStream); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func TestServiceProcessor_OptionalStreaming(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+
+	require.Error(t, p.RegisterOptionalStreamingHandler(
+		func(*SimpleRequest) (chan *SimpleResponse, error) { return nil, nil }))
+	require.Error(t, p.RegisterOptionalStreamingHandler(
+		func(*SimpleRequest) (*SimpleResponse, *SimpleResponse, error) { return nil, nil, nil }))
+	require.NoError(t, p.RegisterOptionalStreamingHandler(optionalStream))
+	streaming, err := p.IsStreaming("SimpleRequest")
+	require.NoError(t, err)
+	require.False(t, streaming)
+
+	buf, err := protobuf.Encode(&SimpleRequest{Val: 1})
+	require.NoError(t, err)
+	reply, tun, err := p.ProcessClientRequest(nil, "SimpleRequest", buf)
+	require.NoError(t, err)
+	require.Nil(t, tun)
+	var sr SimpleResponse
+	require.NoError(t, protobuf.Decode(reply, &sr))
+	require.Equal(t, int64(1), sr.Val)
+
+	buf, err = protobuf.Encode(&SimpleRequest{Val: 3})
+	require.NoError(t, err)
+	reply, tun, err = p.ProcessClientRequest(nil, "SimpleRequest", buf)
+	require.NoError(t, err)
+	require.Nil(t, reply)
+	require.NotNil(t, tun)
+	var vals []int64
+	for buf := range tun.out {
+		require.NoError(t, protobuf.Decode(buf, &sr))
+		vals = append(vals, sr.Val)
+	}
+	require.Equal(t, []int64{0, 1, 2}, vals)
+
+	buf, err = protobuf.Encode(&SimpleRequest{Val: -1})
+	require.NoError(t, err)
+	_, _, err = p.ProcessClientRequest(nil, "SimpleRequest", buf)
+	require.Error(t, err)
+}
+
+func TestWebSocket_OptionalStreaming(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+
+	serName := "optionalStreamingService"
+	_, err := RegisterNewService(serName, newOptionalStreamingService)
+	require.NoError(t, err)
+	defer UnregisterService(serName)
+
+	servers, _, _ := local.GenTree(1, false)
+	client := local.NewClientKeep(serName)
+	defer client.Close()
+
+	var sr SimpleResponse
+	require.NoError(t, client.SendProtobuf(servers[0].ServerIdentity,
+		&SimpleRequest{Val: 1}, &sr))
+	require.Equal(t, int64(1), sr.Val)
+
+	conn, err := client.Stream(servers[0].ServerIdentity, &SimpleRequest{Val: 3})
+	require.NoError(t, err)
+	for i := int64(0); i < 3; i++ {
+		require.NoError(t, conn.ReadMessage(&sr))
+		require.Equal(t, i, sr.Val)
+	}
+	// The connection is closed once the handler closed its channel.
+	require.Error(t, conn.ReadMessage(&sr))
+}
+
+func TestStreamingTunnel_Close(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(t, p.RegisterOptionalStreamingHandler(optionalStream))
+
+	buf, err := protobuf.Encode(&SimpleRequest{Val: 1000})
+	require.NoError(t, err)
+	_, tun, err := p.ProcessClientRequest(nil, "SimpleRequest", buf)
+	require.NoError(t, err)
+	var sr SimpleResponse
+	require.NoError(t, protobuf.Decode(<-tun.Out(), &sr))
+	require.Equal(t, int64(0), sr.Val)
+
+	// Once the client is gone, the rest of the stream is discarded.
+	close(tun.Close())
+	n := 0
+	for range tun.Out() {
+		n++
+	}
+	require.True(t, n < 999, n)
+}
+
+func TestWebSocket_OptionalStreaming_shutdown(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+
+	// The handler streams more messages than the connection buffers, and
+	// ends once they are all read.
+	done := make(chan bool)
+	serName := "optionalStreamingShutdown"
+	_, err := RegisterNewService(serName, func(c *Context) (Service, error) {
+		s := &StreamingService{ServiceProcessor: NewServiceProcessor(c)}
+		return s, s.RegisterOptionalStreamingHandler(func(*SimpleRequest) (chan *SimpleResponse, *SimpleResponse, error) {
+			out := make(chan *SimpleResponse)
+			go func() {
+				for i := int64(0); i < 1000000; i++ {
+					out <- &SimpleResponse{i}
+				}
+				close(out)
+				close(done)
+			}()
+			return out, nil, nil
+		})
+	})
+	require.NoError(t, err)
+	defer UnregisterService(serName)
+
+	servers, _, _ := local.GenTree(1, false)
+	client := local.NewClientKeep(serName)
+	defer client.Close()
+	conn, err := client.Stream(servers[0].ServerIdentity, &SimpleRequest{Val: 2})
+	require.NoError(t, err)
+	var sr SimpleResponse
+	require.NoError(t, conn.ReadMessage(&sr))
+
+	// Stopping the websocket stops the tunnel, whose handler is drained.
+	servers[0].WebSocket.stop()
+	select {
+	case <-done:
+	case <-time.After(DefaultStreamStopGracePeriod):
+		require.Fail(t, "the stream of the handler should have been drained")
+	}
+}
@@ -1,17 +1,21 @@
 package onet
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"path"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
@@ -19,12 +23,176 @@ import (
 	"golang.org/x/xerrors"
 )
 
+const (
+	// CurrentAPIVersion is the latest version of the REST API.
+	CurrentAPIVersion = 3
+	// LatestAPIVersion can be given as the maxVersion of the REST handlers so
+	// that they are registered up to CurrentAPIVersion.
+	LatestAPIVersion = -1
+)
+
+// DefaultMaxBodySize is the maximum size of the requests when
+// ServiceProcessor.MaxBodySize is not set.
+const DefaultMaxBodySize = 4 * 1024 * 1024
+
+// DefaultStreamStopGracePeriod is the grace period of the streaming handlers
+// when ServiceProcessor.StreamStopGracePeriod is not set.
+const DefaultStreamStopGracePeriod = 5 * time.Second
+
 // ServiceProcessor allows for an easy integration of external messages
 // into the Services. You have to embed it into your Service-struct as
 // a pointer. It will process client requests that have been registered
 // with RegisterMessage.
 type ServiceProcessor struct {
 	handlers map[string]serviceHandler
+	// restRoutes maps the patterns registered on the mux to the handler
+	// currently serving them.
+	restRoutes map[string]*restRoute
+	// templatePrefixes maps the patterns registered on the mux for the
+	// path templates to the patterns of the routes below them.
+	templatePrefixes map[string][]string
+	handlersLock     sync.RWMutex
+	// DisablePanicRecovery lets a panic in a handler, or in the encoding
+	// of the messages of a stream, crash the server instead of returning
+	// it as an error to the client or stopping the stream. This is useful
+	// in tests that prefer to fail fast.
+	DisablePanicRecovery bool
+	// WebSocketNamespace, if not empty, namespaces the handlers on the
+	// websocket: they are reached at "ws://service_name/namespace/struct_name"
+	// instead of "ws://service_name/struct_name". It must be set before the
+	// first request.
+	WebSocketNamespace string
+	// HandlerTimeout, if not zero, is the deadline of the context given to
+	// the handlers registered with RegisterHandlerWithContext. The handlers
+	// registered with RegisterHandlerWithTimeout use their own timeout.
+	HandlerTimeout time.Duration
+	// CompressionMinSize, if not zero, enables the permessage-deflate
+	// extension on the websocket for the clients that support it. Only the
+	// replies of at least CompressionMinSize bytes are compressed.
+	CompressionMinSize int
+	// MaxBodySize is the maximum size of the body of the REST requests, as
+	// sent by the client, and of the messages received on the websocket.
+	// Bigger requests are refused with 413 Request Entity Too Large, and
+	// bigger messages close the websocket connection. The messages given to
+	// ProcessClientRequest and ProcessClientStreamRequest are checked too,
+	// before they are decoded. If zero, DefaultMaxBodySize is used, and if
+	// negative, there is no limit.
+	MaxBodySize int64
+	// MaxDecodedSize is the maximum memory, as estimated, taken by a decoded
+	// message. A small message can decode into a much bigger one, e.g. with
+	// many empty items, so the bigger ones are refused with a StatusError of
+	// code 413. If zero, DefaultMaxDecodedSize is used, and if negative,
+	// there is no limit.
+	MaxDecodedSize int64
+	// MaxReplySize, if positive, is the maximum size of the encoded replies
+	// of the handlers, on the websocket and on the REST API. The bigger
+	// replies are logged and not sent: the clients get a StatusError of
+	// code 500 instead. The messages of the streams are not concerned.
+	MaxReplySize int64
+	// FairQueue, if not nil, limits the number of requests handled at the
+	// same time, and shares the workers fairly between the handlers.
+	FairQueue *FairQueue
+	// RESTTagName, if not empty, is the struct tag giving the names of the
+	// fields in the JSON messages of the REST API, e.g. `onet:"field_name"`,
+	// so that they can differ from the names used by protobuf. The fields
+	// without this tag use their json tag or their name. With "onet", the
+	// tag can also deprecate the field, e.g. `onet:"field_name,deprecated"`.
+	RESTTagName string
+	// Authenticator, if not nil, is called with the HTTP request of the
+	// websocket or of the REST API and the name of the message before it
+	// is decoded, e.g. to check a bearer token or the client certificate.
+	// An error rejects the request with its StatusError code, or with 403
+	// Forbidden if it isn't a StatusError. The calls of
+	// ProcessClientRequest without a request are not checked.
+	Authenticator func(req *http.Request, msgName string) error
+	// RateLimiter, if not nil, throttles the requests of the websocket and
+	// of the REST API, which are rejected with a StatusError of code 429
+	// when the client exceeds its rate. The clients are told apart by
+	// RateLimitKey, or by their IP address if it is nil.
+	RateLimiter  RateLimiter
+	RateLimitKey func(*http.Request) string
+	// MaxRESTVersions, if positive, is the maximum number of versions a
+	// REST handler can be registered for. The registrations over a wider
+	// range of versions fail, as a guard against a wrong minVersion.
+	MaxRESTVersions int
+	// CORS, if not nil, sets the CORS headers of the responses of the REST
+	// API and answers the preflight requests, for the origins it allows.
+	CORS *CORSConfig
+	// WrapHandlerErrors, if set, wraps the errors of the handlers in a
+	// HandlerError, so that the clients see the name of the handler and the
+	// ID of the request, see RequestIDHeader, in the message.
+	// The StatusError codes are kept.
+	WrapHandlerErrors bool
+	// PartialResults, if set, keeps the reply of the handlers that return
+	// both a reply and an error, instead of discarding it: the error
+	// returned to the caller is then a PartialError carrying the reply. On
+	// the REST API, the reply is sent in the "partial" field of the JSON
+	// error body, next to the "message" field. ProcessClientRequest returns
+	// the encoded reply along with the error, but the websocket only sends
+	// the error, as it cannot carry both.
+	PartialResults bool
+	// Codec, if not nil, replaces protobuf as the encoding of the requests
+	// and the replies of the websocket, including the streaming ones. It
+	// must be set before the first request. The clients asking for JSON,
+	// with the format query parameter or the Accept header as on the REST
+	// API, get JSON instead.
+	Codec Codec
+	// SuiteForRequest, if not nil, returns the suite used to decode the
+	// points and scalars of the protobuf requests, for the services whose
+	// messages depend on the request, e.g. on a header. The request is nil
+	// for the calls of ProcessClientRequest that don't come from a client.
+	// The suite of the server is used if it is nil or returns nil.
+	SuiteForRequest func(req *http.Request) network.Suite
+	// CheckReplyTypes, if set before the handlers are registered, makes the
+	// registration of the websocket handlers fail if the types of their
+	// replies can't be encoded by protobuf, e.g. because of a uint16 or a
+	// func field, instead of their requests failing. It is ignored with a
+	// custom Codec.
+	CheckReplyTypes bool
+	// OnConnect, if not nil, is called once a client opened a websocket
+	// connection to the service, before its first request, with the
+	// address of the client and the common name of its verified TLS
+	// certificate, empty if it didn't authenticate. An error closes the
+	// connection, with the code 4000+Code of a StatusError.
+	OnConnect func(remoteAddr, principal string) error
+	// OnDisconnect, if not nil, is called once a websocket connection
+	// accepted by OnConnect, if any, is closed, to clean up what was
+	// allocated for it.
+	OnDisconnect func(remoteAddr, principal string)
+	// StreamStopGracePeriod is how long the channel of a streaming handler
+	// is still read, and its messages discarded, after the client went away
+	// and its closeChan was closed. This lets the handler see closeChan and
+	// close its channel without blocking on a send. After it, the channel is
+	// not read anymore, so that a handler ignoring closeChan blocks on its
+	// next send instead of producing for nobody. If zero,
+	// DefaultStreamStopGracePeriod is used.
+	StreamStopGracePeriod time.Duration
+	// StreamBudget, if not nil, bounds the total size of the messages of the
+	// streaming handlers waiting to be written to the websocket. It can be
+	// shared by the services of a server.
+	StreamBudget *StreamBudget
+	// TraceSampling, if not nil, reports a sample of the requests of the
+	// websocket and of the REST API to the tracing.
+	TraceSampling *TraceSampling
+	// AdaptiveTimeout, if not nil, sets the timeout of the requests of each
+	// handler from its recent latencies. It overrides HandlerTimeout once a
+	// handler has enough latencies.
+	AdaptiveTimeout *AdaptiveTimeout
+	// Metrics, if not nil, records the requests of the websocket, and the
+	// ones of the REST API registered with RegisterRESTHandler, for
+	// Prometheus.
+	Metrics *ProcessorMetrics
+	// deprecationsLogged holds the deprecated fields whose use was logged
+	deprecationsLogged sync.Map
+	// changelog of the REST API, set by AddChangelog
+	changelog []ChangelogEntry
+	// errorBudgets of the handlers, set by SetErrorBudget
+	errorBudgets map[string]*errorBudget
+	// middlewares added with Use, run around the handlers
+	middlewares []func(next Handler) Handler
+	// constructors caches the protobuf constructors by name of suite
+	constructors     map[string]protobuf.Constructors
+	constructorsLock sync.Mutex
 	*Context
 }
 
@@ -33,17 +201,102 @@ type serviceHandler struct {
 	handler   interface{}
 	msgType   reflect.Type
 	streaming bool
+	// inType is the type of the inbound channel of the bidirectional
+	// streaming handlers, nil for the other handlers.
+	inType reflect.Type
+	// flow is set for the streaming handlers that get a StreamFlow.
+	flow bool
+	// metadata is set for the streaming handlers that get a ConnMetadata.
+	metadata bool
+	// optional is set for the handlers of RegisterOptionalStreamingHandler,
+	// which reply with a single message or a stream.
+	optional bool
+	// noMessage is set for the handlers without argument, which get no
+	// message decoded from the request.
+	noMessage bool
+	// timeout, if not zero, runs the handler on its own goroutine and
+	// gives up waiting for it after this duration.
+	timeout time.Duration
+	// roles required on the node to serve the handler
+	roles []string
+}
+
+// restRoute stores the REST handler of a pattern of the mux. As the mux
+// doesn't allow to remove a pattern, an unregistered route keeps its entry
+// with a nil handler and answers with a 404.
+type restRoute struct {
+	msgName string
+	handler http.HandlerFunc
+	// method, msgType, replyType, get and streaming describe the route
+	// for the OpenAPI specification. msgType is nil for the upload routes.
+	method    string
+	msgType   reflect.Type
+	replyType reflect.Type
+	get       *getParser
+	streaming bool
+	opts      restOptions
+	// roles required on the node to serve the route
+	roles []string
+}
+
+// RESTOption configures a handler registered with RegisterRESTHandler.
+type RESTOption func(*restOptions)
+
+type restOptions struct {
+	contentTypes []string
+	// minIDLen and maxIDLen bound the length of the IDs of a byte slice
+	// GET, if maxIDLen is not zero.
+	minIDLen, maxIDLen int
+}
+
+// WithContentTypes sets the content types accepted in the body of the POST
+// and PUT requests, instead of only application/json. The supported content
+// types are application/json, application/protobuf and application/msgpack,
+// and their x- variants. The requests with another content type are refused
+// with 415 Unsupported Media Type.
+func WithContentTypes(types ...string) RESTOption {
+	return func(o *restOptions) {
+		o.contentTypes = types
+	}
+}
+
+// WithIDLength sets the range of lengths, in bytes, of the IDs of a GET
+// handler whose message is a byte slice, e.g. 32 and 32 for the hashes. The
+// requests with IDs of another length are refused with 400 Bad Request.
+func WithIDLength(min, max int) RESTOption {
+	return func(o *restOptions) {
+		o.minIDLen = min
+		o.maxIDLen = max
+	}
+}
+
+// acceptedFormat returns the format of a request body of the given content
+// type, if it is accepted.
+func (o restOptions) acceptedFormat(contentType string) (string, bool) {
+	format, ok := contentTypeFormat(contentType)
+	if !ok {
+		return "", false
+	}
+	for _, ct := range o.contentTypes {
+		if f, _ := contentTypeFormat(ct); f == format {
+			return format, true
+		}
+	}
+	return "", false
 }
 
 // NewServiceProcessor initializes your ServiceProcessor.
 func NewServiceProcessor(c *Context) *ServiceProcessor {
 	return &ServiceProcessor{
-		handlers: make(map[string]serviceHandler),
-		Context:  c,
+		handlers:   make(map[string]serviceHandler),
+		restRoutes: make(map[string]*restRoute),
+		Context:    c,
 	}
 }
 
 var errType = reflect.TypeOf((*error)(nil)).Elem()
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+var readerType = reflect.TypeOf((*io.Reader)(nil)).Elem()
 
 // RegisterHandler will store the given handler that will be used by the service.
 // WebSocket will then forward requests to "ws://service_name/struct_name"
@@ -54,8 +307,12 @@ var errType = reflect.TypeOf((*error)(nil)).Elem()
 //  * ret is a pointer to a struct of the return-message.
 //  * err is an error, it can be nil, or any type that implements error.
 //
+// If msg implements Defaulter, its Defaults method is called after it is
+// decoded.
+//
 // struct_name is stripped of its package-name, so a structure like
-// network.Body will be converted to Body.
+// network.Body will be converted to Body. If WebSocketNamespace is set, the
+// handler is reached at "ws://service_name/namespace/struct_name".
 func (p *ServiceProcessor) RegisterHandler(f interface{}) error {
 	if err := handlerInputCheck(f); err != nil {
 		return xerrors.Errorf("input check: %v", err)
@@ -65,11 +322,441 @@ func (p *ServiceProcessor) RegisterHandler(f interface{}) error {
 	if err != nil {
 		return xerrors.Errorf("creating handler: %v", err)
 	}
+	if err := p.checkReplyTypes(f); err != nil {
+		return err
+	}
+	p.handlersLock.Lock()
+	p.handlers[pm] = sh
+	p.handlersLock.Unlock()
+
+	return nil
+}
+
+// RegisterHandlerWithName works like RegisterHandler, but the handler is
+// stored under name instead of the name of the message struct, and is reached
+// at "ws://service_name/name". This is useful when messages of different
+// packages have the same name, or when the message is not a named struct.
+//
+// f can also take no argument, for the actions that need no input:
+// func()(ret interface{}, err error)
+// The content of the requests is then ignored instead of being decoded.
+func (p *ServiceProcessor) RegisterHandlerWithName(name string, f interface{}) error {
+	if name == "" || strings.Contains(name, "/") {
+		return xerrors.Errorf("invalid handler name: '%s'", name)
+	}
+	if isNoMessageHandler(f) {
+		sh, err := newNoMessageHandler(f)
+		if err != nil {
+			return err
+		}
+		log.Lvl4("Registering handler without message", name)
+		p.handlersLock.Lock()
+		p.handlers[name] = sh
+		p.handlersLock.Unlock()
+		return nil
+	}
+	if err := handlerInputCheck(f); err != nil {
+		return xerrors.Errorf("input check: %v", err)
+	}
+	if err := handlerOutputCheck(f); err != nil {
+		return xerrors.Errorf("output check: %v", err)
+	}
+	msgType := reflect.TypeOf(f).In(0).Elem()
+	if err := checkMessageType(msgType); err != nil {
+		return err
+	}
+	if err := p.checkReplyTypes(f); err != nil {
+		return err
+	}
+
+	log.Lvl4("Registering handler", name)
+	p.handlersLock.Lock()
+	p.handlers[name] = serviceHandler{handler: f, msgType: msgType}
+	p.handlersLock.Unlock()
+
+	return nil
+}
+
+// RegisterHandlerWithContext works like RegisterHandler, but f must take a
+// context as first argument:
+// func(ctx context.Context, msg interface{})(ret interface{}, err error)
+//
+// The context is cancelled when the client closes the connection, or when
+// HandlerTimeout elapses if it is set, so that long-running handlers can
+// abort cleanly. RequestID returns the correlation ID of the request from
+// the context, to tie the logs of the handler to the request.
+func (p *ServiceProcessor) RegisterHandlerWithContext(f interface{}) error {
+	if err := handlerContextInputCheck(f); err != nil {
+		return xerrors.Errorf("input check: %v", err)
+	}
+
+	pm, sh, err := createServiceHandler(f)
+	if err != nil {
+		return xerrors.Errorf("creating handler: %v", err)
+	}
+	if err := p.checkReplyTypes(f); err != nil {
+		return err
+	}
+	p.handlersLock.Lock()
+	p.handlers[pm] = sh
+	p.handlersLock.Unlock()
+
+	return nil
+}
+
+// RegisterHandlerWithTimeout works like RegisterHandlerWithContext, but f is
+// run on its own goroutine and the reply is waited for at most timeout. When
+// the timeout elapses or the client goes away, the context of f is cancelled
+// and the request fails immediately, even if f has not yet returned. It
+// overrides HandlerTimeout for f.
+func (p *ServiceProcessor) RegisterHandlerWithTimeout(f interface{}, timeout time.Duration) error {
+	if timeout <= 0 {
+		return xerrors.New("timeout must be positive")
+	}
+	if err := handlerContextInputCheck(f); err != nil {
+		return xerrors.Errorf("input check: %v", err)
+	}
+
+	pm, sh, err := createServiceHandler(f)
+	if err != nil {
+		return xerrors.Errorf("creating handler: %v", err)
+	}
+	if err := p.checkReplyTypes(f); err != nil {
+		return err
+	}
+	sh.timeout = timeout
+	p.handlersLock.Lock()
 	p.handlers[pm] = sh
+	p.handlersLock.Unlock()
+
+	return nil
+}
 
+// RequireRoles restricts the handler of the message msgName, on the websocket
+// and on the REST API, to the nodes that have all the given roles, as set by
+// Server.SetRoles. On the other nodes, the requests are refused with a
+// StatusError of code 404. It returns an error if nothing is registered under
+// msgName.
+func (p *ServiceProcessor) RequireRoles(msgName string, roles ...string) error {
+	p.handlersLock.Lock()
+	defer p.handlersLock.Unlock()
+
+	mh, found := p.handlers[msgName]
+	if found {
+		mh.roles = roles
+		p.handlers[msgName] = mh
+	}
+	for _, route := range p.restRoutes {
+		if route.msgName == msgName && route.handler != nil {
+			route.roles = roles
+			found = true
+		}
+	}
+	if !found {
+		return xerrors.Errorf("no handler registered for %s", msgName)
+	}
+	return nil
+}
+
+// checkRoles returns an error if the node doesn't have all the roles.
+func (p *ServiceProcessor) checkRoles(roles []string) error {
+	if len(roles) == 0 {
+		return nil
+	}
+	if p.Context == nil || p.server == nil || !p.server.HasRoles(roles...) {
+		return StatusError{Code: http.StatusNotFound,
+			Msg: "handler requires the roles: " + strings.Join(roles, ", ")}
+	}
 	return nil
 }
 
+// UnregisterHandler removes the handler of the message msgName, so that
+// further requests for it are refused as if it was never registered. The
+// REST routes of the message are removed too and answer with a 404. It
+// returns an error if nothing is registered under msgName.
+func (p *ServiceProcessor) UnregisterHandler(msgName string) error {
+	p.handlersLock.Lock()
+	defer p.handlersLock.Unlock()
+
+	_, found := p.handlers[msgName]
+	delete(p.handlers, msgName)
+	for _, route := range p.restRoutes {
+		if route.msgName == msgName && route.handler != nil {
+			route.handler = nil
+			found = true
+		}
+	}
+	if !found {
+		return xerrors.New("no handler registered for " + msgName)
+	}
+	return nil
+}
+
+// ReloadHandlers replaces all the handlers of the websocket by the given
+// ones, stored under their name as with RegisterHandlerWithName. A handler
+// can be in any of the forms accepted by RegisterHandlerWithName,
+// RegisterHandlerWithContext, RegisterStreamingHandler,
+// RegisterStreamingHandlerWithFlow, RegisterStreamingHandlerWithMetadata,
+// RegisterBidirectionalStreamingHandler and
+// RegisterOptionalStreamingHandler. If one of them is invalid, an error is
+// returned and the current handlers are kept.
+//
+// A handler replacing one of the same name keeps the roles set by
+// RequireRoles and, unless it is streaming, the timeout set by
+// RegisterHandlerWithTimeout.
+//
+// The switch is atomic: the requests that are already being processed finish
+// with the old handlers, and the new requests use the new ones. The REST
+// routes are not changed.
+func (p *ServiceProcessor) ReloadHandlers(handlers map[string]interface{}) error {
+	newHandlers := make(map[string]serviceHandler, len(handlers))
+	for name, f := range handlers {
+		if name == "" || strings.Contains(name, "/") {
+			return xerrors.Errorf("invalid handler name: '%s'", name)
+		}
+		sh, err := newServiceHandler(f)
+		if err != nil {
+			return xerrors.Errorf("handler %s: %v", name, err)
+		}
+		if err := p.checkReplyTypes(f); err != nil {
+			return xerrors.Errorf("handler %s: %v", name, err)
+		}
+		newHandlers[name] = sh
+	}
+
+	p.handlersLock.Lock()
+	for name, sh := range newHandlers {
+		old, ok := p.handlers[name]
+		if !ok {
+			continue
+		}
+		sh.roles = old.roles
+		if !sh.streaming {
+			sh.timeout = old.timeout
+		}
+		newHandlers[name] = sh
+	}
+	p.handlers = newHandlers
+	p.handlersLock.Unlock()
+	return nil
+}
+
+// newServiceHandler checks that f is in one of the forms of the handlers of
+// the websocket and returns it.
+func newServiceHandler(f interface{}) (serviceHandler, error) {
+	ft := reflect.TypeOf(f)
+	if ft == nil || ft.Kind() != reflect.Func {
+		return serviceHandler{}, xerrors.New("Input is not a function")
+	}
+	switch {
+	case ft.NumIn() == 0:
+		return newNoMessageHandler(f)
+	case ft.NumIn() == 2 && ft.In(1) == streamFlowType:
+		return newFlowHandler(f)
+	case ft.NumIn() == 2 && ft.In(1) == connMetadataType:
+		return newMetadataHandler(f)
+	case ft.NumIn() == 2 && ft.In(1).Kind() == reflect.Chan:
+		return newBidirectionalHandler(f)
+	case ft.NumIn() == 1 && ft.NumOut() == 3 && ft.Out(1).Kind() == reflect.Chan:
+		return newStreamingHandler(f)
+	case ft.NumIn() == 1 && ft.NumOut() == 3:
+		return newOptionalStreamingHandler(f)
+	}
+	if err := handlerInputCheck(f); err != nil {
+		if handlerContextInputCheck(f) != nil {
+			return serviceHandler{}, xerrors.Errorf("input check: %v", err)
+		}
+	}
+	sh := serviceHandler{handler: f, msgType: ft.In(ft.NumIn() - 1).Elem()}
+	if err := checkMessageType(sh.msgType); err != nil {
+		return serviceHandler{}, err
+	}
+	if err := handlerOutputCheck(f); err != nil {
+		return serviceHandler{}, xerrors.Errorf("output check: %v", err)
+	}
+	return sh, nil
+}
+
+// emptyMessage is the message type of the handlers without argument.
+type emptyMessage struct{}
+
+// isNoMessageHandler returns true if f is a function without argument.
+func isNoMessageHandler(f interface{}) bool {
+	ft := reflect.TypeOf(f)
+	return ft != nil && ft.Kind() == reflect.Func && ft.NumIn() == 0
+}
+
+// newNoMessageHandler checks that the handler without argument f returns a
+// message and an error, and returns it.
+func newNoMessageHandler(f interface{}) (serviceHandler, error) {
+	if err := handlerOutputCheck(f); err != nil {
+		return serviceHandler{}, xerrors.Errorf("output check: %v", err)
+	}
+	return serviceHandler{handler: f, msgType: reflect.TypeOf(emptyMessage{}),
+		noMessage: true}, nil
+}
+
+// RegisteredHandlers returns the sorted names of the messages that currently
+// have a handler, either for the websocket or for the REST API.
+func (p *ServiceProcessor) RegisteredHandlers() []string {
+	p.handlersLock.RLock()
+	defer p.handlersLock.RUnlock()
+
+	names := make(map[string]bool)
+	for name := range p.handlers {
+		names[name] = true
+	}
+	for _, route := range p.restRoutes {
+		if route.handler != nil {
+			names[route.msgName] = true
+		}
+	}
+	ret := make([]string, 0, len(names))
+	for name := range names {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// HandlerInfo describes a handler of the websocket, for the generators of
+// clients.
+type HandlerInfo struct {
+	// Name is the name of the message, as in the path of the websocket.
+	Name string
+	// Streaming is set if the handler replies with a stream of messages.
+	Streaming bool
+	// OptionalStreaming is set if the handler, registered with
+	// RegisterOptionalStreamingHandler, replies either with a single
+	// message or with a stream of messages.
+	OptionalStreaming bool
+	// RequestType is the Go type of the message, e.g. "onet.testMsg",
+	// empty for the handlers without argument.
+	RequestType string
+	// ResponseType is the Go type of the reply, or of the messages of the
+	// stream of a streaming handler, empty if the handler returns an
+	// interface. For an optional streaming handler, it is the type of the
+	// single reply.
+	ResponseType string
+	// StreamType is the Go type of the messages of the stream of an
+	// optional streaming handler, empty for the other handlers or if it is
+	// an interface.
+	StreamType string
+}
+
+// HandlerInfo returns the descriptions of the handlers of the websocket,
+// sorted by name. The handlers of the REST API are described by
+// OpenAPISpec.
+func (p *ServiceProcessor) HandlerInfo() []HandlerInfo {
+	p.handlersLock.RLock()
+	defer p.handlersLock.RUnlock()
+
+	infos := make([]HandlerInfo, 0, len(p.handlers))
+	for name, mh := range p.handlers {
+		ft := reflect.TypeOf(mh.handler)
+		info := HandlerInfo{Name: name, Streaming: mh.streaming,
+			ResponseType: typeName(ft.Out(0))}
+		if mh.optional {
+			info.OptionalStreaming = true
+			info.ResponseType = typeName(ft.Out(1))
+			info.StreamType = typeName(ft.Out(0))
+		}
+		if !mh.noMessage {
+			info.RequestType = typeName(mh.msgType)
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// typeName returns the name of the Go type t, without the pointer and the
+// channel, or an empty string if it is an interface.
+func typeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Chan {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Interface {
+		return ""
+	}
+	return t.String()
+}
+
+// getHandler returns the handler registered for the given message name.
+func (p *ServiceProcessor) getHandler(msgName string) (serviceHandler, bool) {
+	p.handlersLock.RLock()
+	defer p.handlersLock.RUnlock()
+	mh, ok := p.handlers[msgName]
+	return mh, ok
+}
+
+// suiteConstructors returns the protobuf constructors of the suite. They are
+// created once per suite and shared by the requests, as DecodeWithConstructors
+// only reads them.
+func (p *ServiceProcessor) suiteConstructors(suite network.Suite) protobuf.Constructors {
+	name := ""
+	if suite != nil {
+		name = suite.String()
+	}
+	p.constructorsLock.Lock()
+	defer p.constructorsLock.Unlock()
+	cons, ok := p.constructors[name]
+	if !ok {
+		if p.constructors == nil {
+			p.constructors = make(map[string]protobuf.Constructors)
+		}
+		cons = network.DefaultConstructors(suite)
+		p.constructors[name] = cons
+	}
+	return cons
+}
+
+// maxMessageSize implements the wsReadLimiter interface.
+func (p *ServiceProcessor) maxMessageSize() int64 {
+	switch {
+	case p.MaxBodySize == 0:
+		return DefaultMaxBodySize
+	case p.MaxBodySize < 0:
+		return 0
+	}
+	return p.MaxBodySize
+}
+
+// acquireStreamBytes counts a message of n bytes of the stream out in the
+// StreamBudget, if any. It returns false if the client is gone first.
+func (p *ServiceProcessor) acquireStreamBytes(n int, out chan []byte,
+	clientGone <-chan struct{}) bool {
+	if p.StreamBudget == nil {
+		return true
+	}
+	return p.StreamBudget.acquire(int64(n), func() int { return len(out) }, clientGone)
+}
+
+// releaseStreamBytes implements the wsStreamReleaser interface.
+func (p *ServiceProcessor) releaseStreamBytes(n int) {
+	if p.StreamBudget != nil {
+		p.StreamBudget.release(int64(n))
+	}
+}
+
+// compressionMinSize implements the wsCompressor interface.
+func (p *ServiceProcessor) compressionMinSize() int {
+	return p.CompressionMinSize
+}
+
+// lookupHandler returns the handler for the path of a websocket request,
+// which is prefixed by the namespace if there is one.
+func (p *ServiceProcessor) lookupHandler(path string) (serviceHandler, bool) {
+	if p.WebSocketNamespace != "" {
+		prefix := p.WebSocketNamespace + "/"
+		if !strings.HasPrefix(path, prefix) {
+			return serviceHandler{}, false
+		}
+		path = strings.TrimPrefix(path, prefix)
+	}
+	return p.getHandler(path)
+}
+
 // RegisterStreamingHandler stores a handler that is responsible for streaming
 // messages to the client via a channel. Websocket will accept requests for
 // this handler at "ws://service_name/struct_name", where struct_name is
@@ -84,14 +771,60 @@ func (p *ServiceProcessor) RegisterHandler(f interface{}) error {
 //    the handler must stop sending messages and close retChan.
 //  * err is an error, it can be nil, or any type that implements error.
 //
+// closeChan is closed when the client goes away. retChan is then only read
+// for StreamStopGracePeriod, after which the sends of a handler that ignores
+// closeChan block forever, so the handler must select on closeChan when it
+// sends into retChan. The handlers registered with
+// RegisterStreamingHandlerWithFlow can also see when the client is slow.
+//
+// The messages of retChan are compressed one by one if the client asked for
+// it with the StreamEncodingHeader, e.g. with Client.StreamCompression.
+//
+// Every message the client sends on the same connection calls the handler
+// again. RegisterBidirectionalStreamingHandler instead gives them to the
+// running handler.
+//
 // struct_name is stripped of its package-name, so a structure like
 // network.Body will be converted to Body.
 func (p *ServiceProcessor) RegisterStreamingHandler(f interface{}) error {
-	if err := handlerInputCheck(f); err != nil {
+	sh, err := newStreamingHandler(f)
+	if err != nil {
+		return err
+	}
+	if err := p.checkReplyTypes(f); err != nil {
+		return err
+	}
+
+	log.Lvl4("Registering streaming handler", sh.msgType.String())
+	pm, err := messageName(sh.msgType)
+	if err != nil {
 		return err
 	}
+	p.handlersLock.Lock()
+	p.handlers[pm] = sh
+	p.handlersLock.Unlock()
 
-	// check output
+	return nil
+}
+
+// newStreamingHandler checks that f is a streaming handler and returns it.
+func newStreamingHandler(f interface{}) (serviceHandler, error) {
+	if err := handlerInputCheck(f); err != nil {
+		return serviceHandler{}, err
+	}
+	if err := streamingOutputCheck(f); err != nil {
+		return serviceHandler{}, err
+	}
+	msgType := reflect.TypeOf(f).In(0).Elem()
+	if err := checkMessageType(msgType); err != nil {
+		return serviceHandler{}, err
+	}
+	return serviceHandler{handler: f, msgType: msgType, streaming: true}, nil
+}
+
+// streamingOutputCheck checks that f returns a channel of messages, a
+// boolean channel and an error.
+func streamingOutputCheck(f interface{}) error {
 	ft := reflect.TypeOf(f)
 	if ft.NumOut() != 3 {
 		return xerrors.New("Need 3 return values: chan interface{}, chan bool and error")
@@ -122,21 +855,48 @@ func (p *ServiceProcessor) RegisterStreamingHandler(f interface{}) error {
 		return xerrors.New("3rd return value has to implement error, but is: " +
 			ft.Out(2).String())
 	}
-
-	cr := ft.In(0)
-	log.Lvl4("Registering streaming handler", cr.String())
-	pm := strings.Split(cr.Elem().String(), ".")[1]
-	p.handlers[pm] = serviceHandler{f, cr.Elem(), true}
-
 	return nil
 }
 
-// getRouter returns the gorilla mutiplexing router. If we need to support
-// arbitrary registration of REST API, we could make this method public.
+// getRouter returns the mux of the websocket, where HandleFunc and Handle
+// register arbitrary routes.
 func (p *ServiceProcessor) getRouter() *http.ServeMux {
 	return p.server.WebSocket.mux
 }
 
+// HandleFunc registers h for the pattern, in the form of http.ServeMux, on the
+// mux of the websocket, so that custom handlers such as /metrics or a static
+// file server are served next to the REST API of the services.
+//
+// The mux is shared by all the services, and the patterns are not checked
+// against their routes: a pattern below /v$version/, where the REST API is,
+// or below /$service/, where the websocket of each service is, or one of
+// /ok, /ready and /config, or /metrics if Server.HandleMetrics is used, can
+// clobber them. A path prefixed by the name of the service, such as
+// /myservice-static/, is safe. An error is only returned if the pattern is
+// invalid or already registered.
+func (p *ServiceProcessor) HandleFunc(pattern string, h http.HandlerFunc) error {
+	if h == nil {
+		return xerrors.New("nil handler")
+	}
+	return p.Handle(pattern, h)
+}
+
+// Handle is like HandleFunc, but for an http.Handler.
+func (p *ServiceProcessor) Handle(pattern string, h http.Handler) (err error) {
+	if h == nil {
+		return xerrors.New("nil handler")
+	}
+	// http.ServeMux panics on the invalid and duplicate patterns.
+	defer func() {
+		if r := recover(); r != nil {
+			err = xerrors.Errorf("registering %s: %v", pattern, r)
+		}
+	}()
+	p.getRouter().Handle(pattern, h)
+	return nil
+}
+
 type kindGET int
 
 const (
@@ -144,28 +904,199 @@ const (
 	emptyGET
 	intGET
 	sliceGET
+	multiGET
+	// templateGET sets the fields from the placeholders of a path
+	// template, see RegisterRESTHandlerWithPath
+	templateGET
 )
 
-// prepareHandlerGET check whether the first argument of f has any fields; if
-// it does then make sure the number of fields is either 0 or 1; if there is 1
-// field then it has to be an int or a slice of bytes.
-func prepareHandlerGET(f interface{}) (kindGET, string, error) {
+// getSegment is a field of the message of a GET request, set from a segment
+// of the URL.
+type getSegment struct {
+	// kind is intGET or sliceGET
+	kind  kindGET
+	field string
+	index int
+}
+
+// getParser fills the message of a REST GET request from its URL.
+type getParser struct {
+	kind kindGET
+	// segments are the fields set from the URL, in the order of their
+	// segments
+	segments   []getSegment
+	minIDLen   int
+	maxIDLen   int
+	emptyRegex *regexp.Regexp
+	intRegex   *regexp.Regexp
+	sliceRegex *regexp.Regexp
+	// multiRegex captures the segments below the resource
+	multiRegex *regexp.Regexp
+	// templateRegex captures the placeholders of a path template, and
+	// template is the path template with the names of the fields
+	templateRegex *regexp.Regexp
+	template      string
+}
+
+func newGETParser(f interface{}, namespace, resource string) (*getParser, error) {
+	k, segments, err := prepareHandlerGET(f)
+	if err != nil {
+		return nil, err
+	}
+	emptyRegex, err := regexp.Compile(fmt.Sprintf(`^/v\d+/%s/%s$`, namespace, resource))
+	if err != nil {
+		return nil, xerrors.Errorf("regex: %v", err)
+	}
+	intRegex, err := regexp.Compile(fmt.Sprintf(`^/v\d+/%s/%s/\d+$`, namespace, resource))
+	if err != nil {
+		return nil, xerrors.Errorf("regex: %v", err)
+	}
+	sliceRegex, err := regexp.Compile(fmt.Sprintf(`^/v\d+/%s/%s/[0-9a-f]+$`, namespace, resource))
+	if err != nil {
+		return nil, xerrors.Errorf("regex: %v", err)
+	}
+	multiRegex, err := regexp.Compile(fmt.Sprintf(`^/v\d+/%s/%s/(.+)$`, namespace, resource))
+	if err != nil {
+		return nil, xerrors.Errorf("regex: %v", err)
+	}
+	return &getParser{kind: k, segments: segments, emptyRegex: emptyRegex, intRegex: intRegex,
+		sliceRegex: sliceRegex, multiRegex: multiRegex}, nil
+}
+
+// finalSlash returns the suffix of the pattern to register, so that the
+// resources below the URL are also handled.
+func (g *getParser) finalSlash() string {
+	if g != nil && (g.kind == intGET || g.kind == sliceGET || g.kind == multiGET) {
+		return "/"
+	}
+	return ""
+}
+
+// parse sets the field of msg, which must be a pointer to the message, from
+// the URL of the request. On error, it also returns the HTTP status code to
+// answer with.
+func (g *getParser) parse(r *http.Request, msg reflect.Value) (int, error) {
+	switch g.kind {
+	case emptyGET:
+		if ok := g.emptyRegex.MatchString(r.URL.EscapedPath()); !ok {
+			return http.StatusNotFound, xerrors.New("invalid path")
+		}
+	case intGET:
+		if ok := g.intRegex.MatchString(r.URL.EscapedPath()); !ok {
+			return http.StatusNotFound, xerrors.New("invalid path")
+		}
+		_, num := path.Split(r.URL.EscapedPath())
+		numI64, err := strconv.Atoi(num)
+		if err != nil {
+			return http.StatusBadRequest, xerrors.New("not a number")
+		}
+		msg.Elem().Field(g.segments[0].index).SetInt(int64(numI64))
+	case sliceGET:
+		if ok := g.sliceRegex.MatchString(r.URL.EscapedPath()); !ok {
+			return http.StatusNotFound, xerrors.New("invalid path")
+		}
+		_, hexStr := path.Split(r.URL.EscapedPath())
+		byteBuf, err := g.decodeID(hexStr)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		msg.Elem().Field(g.segments[0].index).SetBytes(byteBuf)
+	case multiGET:
+		match := g.multiRegex.FindStringSubmatch(r.URL.EscapedPath())
+		if match == nil {
+			return http.StatusNotFound, xerrors.New("invalid path")
+		}
+		parts := strings.Split(match[1], "/")
+		if len(parts) != len(g.segments) {
+			names := make([]string, len(g.segments))
+			for i, seg := range g.segments {
+				names[i] = seg.field
+			}
+			return http.StatusNotFound, xerrors.Errorf("invalid path: expected %d segments "+
+				"(%s), got %d", len(g.segments), strings.Join(names, "/"), len(parts))
+		}
+		return g.setSegments(msg, parts)
+	case templateGET:
+		match := g.templateRegex.FindStringSubmatch(r.URL.EscapedPath())
+		if match == nil {
+			return http.StatusNotFound, xerrors.New("invalid path")
+		}
+		return g.setSegments(msg, match[1:])
+	default:
+		return http.StatusBadRequest, xerrors.New("invalid GET")
+	}
+	return http.StatusOK, nil
+}
+
+// setSegments sets the fields of the segments of msg from the parts of the
+// URL, one for each segment.
+func (g *getParser) setSegments(msg reflect.Value, parts []string) (int, error) {
+	for i, seg := range g.segments {
+		switch seg.kind {
+		case intGET:
+			num, err := strconv.Atoi(parts[i])
+			if err != nil {
+				return http.StatusBadRequest, xerrors.Errorf("%s: not a number", seg.field)
+			}
+			msg.Elem().Field(seg.index).SetInt(int64(num))
+		case sliceGET:
+			byteBuf, err := g.decodeID(parts[i])
+			if err != nil {
+				return http.StatusBadRequest, xerrors.Errorf("%s: %v", seg.field, err)
+			}
+			msg.Elem().Field(seg.index).SetBytes(byteBuf)
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// decodeID decodes the hex encoded ID of a byte slice and checks its length.
+func (g *getParser) decodeID(hexStr string) ([]byte, error) {
+	byteBuf, err := hex.DecodeString(hexStr)
+	if err != nil {
+		return nil, err
+	}
+	if g.maxIDLen > 0 && (len(byteBuf) < g.minIDLen || len(byteBuf) > g.maxIDLen) {
+		return nil, xerrors.Errorf("ID must have between %d and %d bytes",
+			g.minIDLen, g.maxIDLen)
+	}
+	return byteBuf, nil
+}
+
+// prepareHandlerGET checks the fields of the first argument of f and returns
+// the segments of the URL that set them. The unexported fields are ignored.
+// With 0 fields, the URL has no segment after the resource. Otherwise, every
+// field must be an int or a slice of bytes, and is set from a segment of the
+// URL, in the order of their declaration.
+func prepareHandlerGET(f interface{}) (kindGET, []getSegment, error) {
 	in0 := reflect.TypeOf(f).In(0).Elem()
 	if in0.Kind() != reflect.Struct {
-		return invalidGET, "", xerrors.New("input argument must be a struct")
+		return invalidGET, nil, xerrors.New("input argument must be a struct")
 	}
-	if in0.NumField() == 0 {
-		return emptyGET, "", nil
-	} else if in0.NumField() == 1 {
+	var segments []getSegment
+	for i := 0; i < in0.NumField(); i++ {
+		field := in0.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
 		// we support int and byte slices only
-		if in0.Field(0).Type.Kind() == reflect.Slice && in0.Field(0).Type.Elem().Kind() == reflect.Uint8 {
-			return sliceGET, in0.Field(0).Name, nil
-		} else if in0.Field(0).Type.Kind() == reflect.Int {
-			return intGET, in0.Field(0).Name, nil
+		switch {
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Uint8:
+			segments = append(segments, getSegment{kind: sliceGET, field: field.Name, index: i})
+		case field.Type.Kind() == reflect.Int:
+			segments = append(segments, getSegment{kind: intGET, field: field.Name, index: i})
+		default:
+			return invalidGET, nil, xerrors.Errorf("field %s: only byte slices and int "+
+				"are supported", field.Name)
 		}
-		return invalidGET, "", xerrors.New("only byte slices and int are supported")
 	}
-	return invalidGET, "", xerrors.New("number of fields must be 0 or 1")
+	if len(segments) == 0 {
+		return emptyGET, nil, nil
+	}
+	if len(segments) == 1 {
+		return segments[0].kind, segments, nil
+	}
+	return multiGET, segments, nil
 }
 
 // RegisterRESTHandler takes a callback of type
@@ -174,177 +1105,771 @@ func prepareHandlerGET(f interface{}) (kindGET, string, error) {
 // For POST and PUT, the callback is registered on the URL
 // /v$version/$namespace/$msgStructName. The client should serialize the request
 // using JSON and set the conent type to application/json to use the service.
-// The response is also JSON encoded.
+// The response is also JSON encoded, unless the client asks for protobuf or
+// msgpack with the Accept header or the format query parameter, e.g.
+// ?format=msgpack.
 //
 // For GET requests, the callback is registered on the same URL. But clients
 // can also query individual resources such as
 // /v$version/$namespace/$msgStructName/$id. For this to work, msg in the
 // callback must be a singleton struct with either an integer or a byte slice.
 // For integers, the client can directly query the integer resource, for byte
-// slices, the clients must query the hex encoded representation. Using an
-// empty struct for msg is also supported.
+// slices, the clients must query the hex encoded representation. A struct
+// with several integers and byte slices is addressed by as many segments, in
+// the order of the fields, e.g. /v$version/$namespace/Edge/$from/$to, and the
+// requests with another number of segments are answered with a 404. Using an
+// empty struct for msg is also supported, in which case the requests with
+// more segments after the URL are answered with a 404. The GET handlers answer the HEAD
+// requests too, with the headers of the GET reply and no body.
 //
 // The min/maxVersion argument represents the range of versions where the API
 // is present. If breaking changes must be made then they must use a new
-// version.
+// version. A maxVersion of LatestAPIVersion registers the handler up to
+// CurrentAPIVersion, so that it is served by the new versions of the API
+// without being registered again; a handler changed in a breaking way gives
+// instead the last version of its old form as maxVersion.
+//
+// The errors of the handler are answered with a JSON object, such as
+// {"message": "processing error not found", "error": "not found", "code": 404},
+// whose code is the status code of the response. The details of the errors
+// implementing ErrorDetailer are added in its "details" field.
+//
+// The handler can be configured with options, such as WithContentTypes.
 //
 // This method is experimental.
-func (p *ServiceProcessor) RegisterRESTHandler(f interface{}, namespace, method string, minVersion, maxVersion int) error {
+func (p *ServiceProcessor) RegisterRESTHandler(f interface{}, namespace, method string, minVersion, maxVersion int, options ...RESTOption) error {
+	return p.registerREST(f, namespace, "", method, minVersion, maxVersion, options)
+}
+
+// registerREST registers the REST handler f, on the path of its resource in
+// the namespace, or on the path template if it is not empty.
+func (p *ServiceProcessor) registerREST(f interface{}, namespace, template, method string,
+	minVersion, maxVersion int, options []RESTOption) error {
 	// TODO support more methods
 	if method != "GET" && method != "POST" && method != "PUT" {
 		return xerrors.New("invalid REST method")
 	}
+	maxVersion, err := p.versionRange(minVersion, maxVersion)
+	if err != nil {
+		return err
+	}
+	opts := restOptions{contentTypes: []string{"application/json"}}
+	for _, o := range options {
+		o(&opts)
+	}
+	for _, ct := range opts.contentTypes {
+		if _, ok := contentTypeFormat(ct); !ok {
+			return xerrors.Errorf("unsupported content type: %s", ct)
+		}
+	}
+	if err := handlerInputCheck(f); err != nil {
+		return xerrors.Errorf("input check: %v", err)
+	}
+	resource, sh, err := createServiceHandler(f)
+	if err != nil {
+		return xerrors.Errorf("creating handler: %v", err)
+	}
+	// params sets the fields of the placeholders of the template, for all
+	// the methods.
+	var get, params *getParser
+	if template != "" {
+		params, err = newTemplateParser(sh.msgType, template)
+		if err != nil {
+			return xerrors.Errorf("path template: %v", err)
+		}
+		if method == "GET" {
+			get = params
+		}
+	} else if method == "GET" {
+		get, err = newGETParser(f, namespace, resource)
+		if err != nil {
+			return xerrors.Errorf("preparing get handler: %v", err)
+		}
+	}
+	if opts.minIDLen != 0 || opts.maxIDLen != 0 {
+		ids := get
+		if params != nil {
+			ids = params
+		}
+		if ids == nil || !(ids.kind == sliceGET || ids.kind == templateGET && ids.hasSlice()) {
+			return xerrors.New("ID length is only supported for byte slice GET")
+		}
+		if opts.minIDLen < 0 || opts.minIDLen > opts.maxIDLen {
+			return xerrors.Errorf("invalid ID length range [%d, %d]", opts.minIDLen, opts.maxIDLen)
+		}
+		ids.minIDLen = opts.minIDLen
+		ids.maxIDLen = opts.maxIDLen
+	}
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		// HEAD runs the GET handlers but only returns the headers
+		if r.Method != method && !(method == "GET" && r.Method == http.MethodHead) {
+			http.Error(w, wrapJSONMsg("unsupported method: "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		format, err := responseFormat(r)
+		if err != nil {
+			http.Error(w, wrapJSONMsg(err.Error()), http.StatusBadRequest)
+			return
+		}
+		// A new message for each request, so that the fields of a request,
+		// or their defaults, don't leak into the next one.
+		val0 := reflect.New(sh.msgType)
+		var msgBuf []byte
+		switch r.Method {
+		case "GET", http.MethodHead:
+			if code, err := get.parse(r, val0); err != nil {
+				http.Error(w, wrapJSONMsg(err.Error()), code)
+				return
+			}
+		case "POST", "PUT":
+			reqFormat, ok := opts.acceptedFormat(r.Header.Get("Content-Type"))
+			if !ok {
+				w.Header().Set("Accept-Post", strings.Join(opts.contentTypes, ", "))
+				http.Error(w, wrapJSONMsg("content type needs to be "+
+					strings.Join(opts.contentTypes, " or ")), http.StatusUnsupportedMediaType)
+				return
+			}
+			max := p.maxMessageSize()
+			if max > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, max)
+			}
+			msgBuf, err = readBody(r, max)
+			if err != nil {
+				code := http.StatusBadRequest
+				if err == errBodyTooLarge {
+					code = http.StatusRequestEntityTooLarge
+				}
+				http.Error(w, wrapJSONMsg(err.Error()), code)
+				return
+			}
+			if err := decodeBody(reqFormat, msgBuf, val0.Interface(), p.RESTTagName,
+				p.suiteConstructors(p.requestSuite(r))); err != nil {
+				http.Error(w, wrapJSONMsg("decoding error "+err.Error()), http.StatusBadRequest)
+				return
+			}
+			if err := p.checkDecodedSize(val0.Interface()); err != nil {
+				writeHandlerError(w, err)
+				return
+			}
+			if params != nil {
+				// the placeholders of the path take precedence over
+				// the body
+				if code, err := params.parse(r, val0); err != nil {
+					http.Error(w, wrapJSONMsg(err.Error()), code)
+					return
+				}
+			}
+		default:
+			http.Error(w, wrapJSONMsg("unsupported method: "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		p.recordDecoded(val0.Interface(), len(msgBuf))
+		p.warnDeprecated(w, resource, val0.Interface())
+		if err := prepareMessage(val0.Interface()); err != nil {
+			writeHandlerError(w, err)
+			return
+		}
+
+		if sh.streaming {
+			// Nothing would read the stream of the handler.
+			http.Error(w, wrapJSONMsg("streaming requests are not supported"), http.StatusBadRequest)
+			return
+		}
+		mh := sh
+		mh.timeout = p.handlerTimeout(resource, 0)
+		timeout := p.HandlerTimeout
+		if mh.timeout > 0 {
+			timeout = mh.timeout
+		}
+		ctx, cancel := requestContext(r, timeout)
+		defer cancel()
+		out, err := p.chain(func(_ *http.Request, msg interface{}) (interface{}, error) {
+			release, err := p.waitWorker(ctx, resource)
+			if err != nil {
+				return nil, err
+			}
+			callStart := time.Now()
+			out, err := p.callHandler(ctx, mh, msg, release)
+			p.recordLatency(resource, callStart, timeout, ctx.Err())
+			return out, err
+		})(r, val0.Interface())
+		p.recordOutcome(resource, err)
+		if err != nil {
+			logRequestError(ctx, resource, err)
+			p.sampleTrace(r, resource, start, len(msgBuf), 0, err)
+			err = p.wrapHandlerError(resource, r, err)
+			p.writePartialError(w, err)
+			return
+		}
+		var reply []byte
+		var contentType string
+		if ct, ok := out.(ContentTyper); ok {
+			reply, contentType = ct.Body(), ct.ContentType()
+		} else {
+			reply, contentType, err = encodeReply(format, out, p.RESTTagName)
+			if err != nil {
+				http.Error(w, wrapJSONMsg(err.Error()), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := p.checkReplySize(resource, reply); err != nil {
+			writeHandlerError(w, err)
+			return
+		}
+		p.sampleTrace(r, resource, start, len(msgBuf), len(reply), nil)
+		setReplyHeaders(w, out)
+		w.Header().Set("Content-Type", contentType)
+		writeReply(w, r, reply)
+	}
+	route := restRoute{
+		msgName:   resource,
+		method:    method,
+		msgType:   sh.msgType,
+		replyType: reflect.TypeOf(f).Out(0),
+		get:       get,
+		opts:      opts,
+	}
+	if params != nil {
+		route.get = params
+	}
+	patterns := make([]string, 0, maxVersion-minVersion+1)
+	for v := minVersion; v <= maxVersion; v++ {
+		pattern := fmt.Sprintf("/v%d/%s/%s", v, namespace, resource) + get.finalSlash()
+		if params != nil {
+			pattern = fmt.Sprintf("/v%d/%s", v, params.template)
+		}
+		if err := p.checkPattern(pattern, get); err != nil {
+			return err
+		}
+		patterns = append(patterns, pattern)
+	}
+	for i, pattern := range patterns {
+		route.handler = p.instrumentREST(resource, minVersion+i, h)
+		p.handleREST(pattern, route)
+	}
+	return nil
+}
+
+// RegisterRESTHandlerAllVersions is like RegisterRESTHandler with a maxVersion
+// of LatestAPIVersion: f is registered for every version from since to
+// CurrentAPIVersion, with the same method, options and paths, such as the
+// $id of the GET requests, in each version. Registering a handler again for
+// a version replaces the previous one.
+//
+// This method is experimental.
+func (p *ServiceProcessor) RegisterRESTHandlerAllVersions(f interface{}, namespace, method string, since int, options ...RESTOption) error {
+	return p.RegisterRESTHandler(f, namespace, method, since, LatestAPIVersion, options...)
+}
+
+// versionRange checks the range of versions of a REST handler and returns its
+// actual maxVersion.
+func (p *ServiceProcessor) versionRange(minVersion, maxVersion int) (int, error) {
+	if maxVersion == LatestAPIVersion {
+		maxVersion = CurrentAPIVersion
+	}
 	if minVersion > maxVersion {
-		return xerrors.New("min version is greater than max version")
+		return 0, xerrors.New("min version is greater than max version")
 	}
 	if minVersion < 3 {
-		return xerrors.New("earliest supported API level must be greater or equal to 3")
+		return 0, xerrors.New("earliest supported API level must be greater or equal to 3")
+	}
+	if p.MaxRESTVersions > 0 && maxVersion-minVersion+1 > p.MaxRESTVersions {
+		return 0, xerrors.Errorf("versions %d to %d exceed the maximum of %d versions per resource",
+			minVersion, maxVersion, p.MaxRESTVersions)
+	}
+	return maxVersion, nil
+}
+
+// RegisterStreamingRESTHandler exposes a streaming handler, in the form given
+// to RegisterStreamingHandler, over HTTP using Server-Sent Events, for the
+// clients that cannot use websockets. The handler is registered on the URL
+// /v$version/$namespace/$msgStructName for GET requests, and the message is
+// read from the URL the same way as in RegisterRESTHandler.
+//
+// Every message the handler sends into its channel is JSON encoded in the data
+// field of an event of the text/event-stream response. The response ends when
+// the handler closes the channel. If the client goes away, the closeChan of
+// the handler is closed, and its channel is read for StreamStopGracePeriod as
+// with the websocket. If the client negotiated the compression of the stream
+// with the StreamEncodingHeader, the data field of each event is instead the
+// compressed JSON, encoded in base64.
+//
+// The messages implementing ServerSentEvent give the name and the ID of their
+// event, and the message of the request can implement LastEventIDSetter to
+// resume the stream of a client that reconnects.
+//
+// This method is experimental.
+func (p *ServiceProcessor) RegisterStreamingRESTHandler(f interface{}, namespace string, minVersion, maxVersion int) error {
+	maxVersion, err := p.versionRange(minVersion, maxVersion)
+	if err != nil {
+		return err
 	}
 	if err := handlerInputCheck(f); err != nil {
 		return xerrors.Errorf("input check: %v", err)
 	}
-	resource, sh, err := createServiceHandler(f)
-	if err != nil {
-		return xerrors.Errorf("creating handler: %v", err)
+	if err := streamingOutputCheck(f); err != nil {
+		return xerrors.Errorf("output check: %v", err)
+	}
+	msgType := reflect.TypeOf(f).In(0).Elem()
+	resource, err := messageName(msgType)
+	if err != nil {
+		return err
+	}
+	if err := checkMessageType(msgType); err != nil {
+		return err
+	}
+	get, err := newGETParser(f, namespace, resource)
+	if err != nil {
+		return xerrors.Errorf("preparing get handler: %v", err)
+	}
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, wrapJSONMsg("unsupported method: "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, wrapJSONMsg("streaming is not supported"), http.StatusInternalServerError)
+			return
+		}
+		msg := reflect.New(msgType)
+		if code, err := get.parse(r, msg); err != nil {
+			http.Error(w, wrapJSONMsg(err.Error()), code)
+			return
+		}
+		p.warnDeprecated(w, resource, msg.Interface())
+		if err := prepareMessage(msg.Interface()); err != nil {
+			writeHandlerError(w, err)
+			return
+		}
+		setLastEventID(r, msg.Interface())
+
+		var stopChan chan bool
+		reply, err := p.chain(func(_ *http.Request, msg interface{}) (interface{}, error) {
+			release, err := p.waitWorker(r.Context(), resource)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+			reply, stop, err := p.callInterfaceFunc(r.Context(), f, msg, true)
+			stopChan = stop
+			return reply, err
+		})(r, msg.Interface())
+		if stopChan != nil {
+			defer close(stopChan)
+		}
+		if err == nil && reflect.TypeOf(reply) != reflect.TypeOf(f).Out(0) {
+			err = xerrors.New("the middlewares replaced the stream of " + resource)
+		}
+		p.recordOutcome(resource, err)
+		if err != nil {
+			writeHandlerError(w, err)
+			return
+		}
+
+		streamEnc := streamEncoding(r)
+		if streamEnc != "" {
+			w.Header().Set(StreamEncodingHeader, streamEnc)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		inChan := reflect.ValueOf(reply)
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: inChan},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(r.Context().Done())},
+		}
+		for {
+			chosen, v, ok := reflect.Select(cases)
+			if chosen == 1 {
+				log.Lvl3("client of", resource, "went away:", r.Context().Err())
+				// Drain the channel so that the handler doesn't block
+				// until it sees the stop signal.
+				go p.drainStream(inChan, resource)
+				return
+			}
+			if !ok {
+				return
+			}
+			buf, err := marshalJSON(v.Interface(), p.RESTTagName)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			if streamEnc != "" {
+				// The events are text, so the compressed JSON is
+				// sent in base64.
+				compressed, err := compress(streamEnc, buf)
+				if err != nil {
+					log.Error(err)
+					return
+				}
+				buf = []byte(base64.StdEncoding.EncodeToString(compressed))
+			}
+			if err := writeEvent(w, v.Interface(), buf); err != nil {
+				log.Lvl3("writing event:", err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+	route := restRoute{
+		msgName:   resource,
+		handler:   h,
+		method:    "GET",
+		msgType:   msgType,
+		replyType: reflect.TypeOf(f).Out(0).Elem(),
+		get:       get,
+		streaming: true,
+	}
+	for v := minVersion; v <= maxVersion; v++ {
+		p.handleREST(fmt.Sprintf("/v%d/%s/%s", v, namespace, resource)+get.finalSlash(), route)
+	}
+	return nil
+}
+
+// RegisterUploadHandler registers f on the URL /v$version/$namespace/$name for
+// requests of the given method, which must be POST or PUT. Instead of a
+// decoded message, f gets the body of the request, so that it can process
+// large uploads as a stream with bounded memory. f must be in the form:
+// func(body io.Reader)(ret interface{}, err error)
+//
+// The body is decompressed according to its Content-Encoding. Reading more
+// than maxSize bytes from it fails, and the request is answered with a 413
+// Request Entity Too Large. The reply is encoded as in RegisterRESTHandler.
+//
+// This method is experimental.
+func (p *ServiceProcessor) RegisterUploadHandler(f interface{}, namespace, name, method string, minVersion, maxVersion int, maxSize int64) error {
+	if method != "POST" && method != "PUT" {
+		return xerrors.New("invalid upload method")
+	}
+	maxVersion, err := p.versionRange(minVersion, maxVersion)
+	if err != nil {
+		return err
+	}
+	if name == "" || strings.Contains(name, "/") {
+		return xerrors.Errorf("invalid handler name: '%s'", name)
+	}
+	if maxSize <= 0 {
+		return xerrors.New("max size must be positive")
+	}
+	ft := reflect.TypeOf(f)
+	if ft.Kind() != reflect.Func || ft.NumIn() != 1 || ft.In(0) != readerType {
+		return xerrors.New("input check: need one argument: io.Reader")
+	}
+	if err := handlerOutputCheck(f); err != nil {
+		return xerrors.Errorf("output check: %v", err)
+	}
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, wrapJSONMsg("unsupported method: "+r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		format, err := responseFormat(r)
+		if err != nil {
+			http.Error(w, wrapJSONMsg(err.Error()), http.StatusBadRequest)
+			return
+		}
+		body, err := bodyReader(r, maxSize)
+		if err != nil {
+			http.Error(w, wrapJSONMsg(err.Error()), http.StatusBadRequest)
+			return
+		}
+		defer body.Close()
+
+		var in io.Reader = body
+		out, err := p.chain(func(_ *http.Request, msg interface{}) (interface{}, error) {
+			release, err := p.waitWorker(r.Context(), name)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+			return p.callUploadFunc(f, *msg.(*io.Reader))
+		})(r, &in)
+		if err != nil && body.tooLarge() {
+			// The error of the client doesn't count in the budget.
+			p.recordOutcome(name, StatusError{Code: http.StatusRequestEntityTooLarge})
+			http.Error(w, wrapJSONMsg(errBodyTooLarge.Error()), http.StatusRequestEntityTooLarge)
+			return
+		}
+		p.recordOutcome(name, err)
+		if err != nil {
+			writeHandlerError(w, err)
+			return
+		}
+		reply, contentType, err := encodeReply(format, out, p.RESTTagName)
+		if err != nil {
+			http.Error(w, wrapJSONMsg(err.Error()), http.StatusInternalServerError)
+			return
+		}
+		if err := p.checkReplySize(name, reply); err != nil {
+			writeHandlerError(w, err)
+			return
+		}
+		setReplyHeaders(w, out)
+		w.Header().Set("Content-Type", contentType)
+		writeReply(w, r, reply)
+	}
+
+	route := restRoute{
+		msgName:   name,
+		handler:   h,
+		method:    method,
+		replyType: ft.Out(0),
+	}
+	for v := minVersion; v <= maxVersion; v++ {
+		p.handleREST(fmt.Sprintf("/v%d/%s/%s", v, namespace, name), route)
+	}
+	return nil
+}
+
+// handleREST registers the route for the pattern. The pattern is added to the mux
+// only the first time, so that an unregistered route can be registered
+// again.
+func (p *ServiceProcessor) handleREST(pattern string, r restRoute) {
+	p.handlersLock.Lock()
+	defer p.handlersLock.Unlock()
+
+	route, ok := p.restRoutes[pattern]
+	if ok {
+		if route.handler != nil {
+			// The roles required by RequireRoles outlive the handler.
+			r.roles = route.roles
+		}
+		*route = r
+		return
+	}
+	route = &r
+	p.restRoutes[pattern] = route
+	if prefix, ok := templatePrefix(pattern); ok {
+		if p.templatePrefixes == nil {
+			p.templatePrefixes = make(map[string][]string)
+		}
+		if _, ok := p.templatePrefixes[prefix]; !ok {
+			p.getRouter().HandleFunc(prefix, p.serveTemplates(prefix))
+		}
+		p.templatePrefixes[prefix] = append(p.templatePrefixes[prefix], pattern)
+		return
+	}
+	serve := p.serveRoute(route)
+	p.getRouter().HandleFunc(pattern, serve)
+	if r.get != nil && r.get.kind == emptyGET {
+		// The paths below the resource would otherwise go to the websocket
+		// catch-all handler, instead of being rejected as invalid paths.
+		p.getRouter().HandleFunc(pattern+"/", serve)
+	}
+}
+
+// serveRoute returns the handler of the requests of the route.
+func (p *ServiceProcessor) serveRoute(route *restRoute) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := ensureRequestID(r)
+		w.Header().Set(RequestIDHeader, id)
+		r = r.WithContext(withRequestID(r.Context(), id))
+		log.Lvlf3("request %s: %s %s from %s", id, r.Method, r.URL.Path, r.RemoteAddr)
+
+		p.handlersLock.RLock()
+		h := route.handler
+		roles := route.roles
+		method := route.method
+		msgName := route.msgName
+		p.handlersLock.RUnlock()
+		if h == nil {
+			http.Error(w, wrapJSONMsg("not registered"), http.StatusNotFound)
+			return
+		}
+		if p.CORS != nil && p.CORS.handle(w, r, method) {
+			return
+		}
+		if err := p.checkRoles(roles); err != nil {
+			writeHandlerError(w, err)
+			return
+		}
+		if err := p.checkAuth(r, msgName); err != nil {
+			writeHandlerError(w, err)
+			return
+		}
+		if err := p.checkRateLimit(r); err != nil {
+			writeHandlerError(w, err)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func wrapJSONMsg(s string) string {
+	return fmt.Sprintf(`{"message": "%s"}`, s)
+}
+
+func createServiceHandler(f interface{}) (string, serviceHandler, error) {
+	if err := handlerOutputCheck(f); err != nil {
+		return "", serviceHandler{}, err
+	}
+
+	// the message is always the last argument
+	ft := reflect.TypeOf(f)
+	cr := ft.In(ft.NumIn() - 1)
+	log.Lvl4("Registering handler", cr.String())
+	pm, err := messageName(cr.Elem())
+	if err != nil {
+		return "", serviceHandler{}, err
+	}
+	if err := checkMessageType(cr.Elem()); err != nil {
+		return "", serviceHandler{}, err
+	}
+
+	return pm, serviceHandler{handler: f, msgType: cr.Elem()}, nil
+}
+
+// messageName returns the name of the message type t, stripped of its
+// package-name. An error is returned for the types without a name, such as
+// anonymous structs, which need RegisterHandlerWithName.
+func messageName(t reflect.Type) (string, error) {
+	if t.Name() == "" {
+		return "", xerrors.Errorf("message type %v has no name", t)
+	}
+	name := t.String()
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[i+1:]
 	}
-	var k kindGET
-	if method == "GET" {
-		k, _, err = prepareHandlerGET(f)
-		if err != nil {
-			return xerrors.Errorf("preparing get handler: %v", err)
-		}
+	return name, nil
+}
+
+// checkMessageType returns an error if the fields of the message type t are
+// ambiguous or have invalid validation rules.
+func checkMessageType(t reflect.Type) error {
+	if err := checkFieldNames(t); err != nil {
+		return err
 	}
+	return checkRules(t)
+}
 
-	intRegex, err := regexp.Compile(fmt.Sprintf(`^/v\d/%s/%s/\d+$`, namespace, resource))
-	if err != nil {
-		return xerrors.Errorf("regex: %v", err)
+// checkFieldNames returns an error if the message type t, or one of the
+// types of its fields, has two fields that get the same name in JSON through
+// embedded structs at the same depth. The JSON decoder would silently ignore
+// them.
+func checkFieldNames(t reflect.Type) error {
+	return checkFieldNamesRec(t, make(map[reflect.Type]bool))
+}
+
+func checkFieldNamesRec(t reflect.Type, visited map[reflect.Type]bool) error {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice ||
+		t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
 	}
-	sliceRegex, err := regexp.Compile(fmt.Sprintf(`^/v\d/%s/%s/[0-9a-f]+$`, namespace, resource))
-	if err != nil {
-		return xerrors.Errorf("regex: %v", err)
+	if t.Kind() != reflect.Struct || visited[t] {
+		return nil
 	}
-	val0 := reflect.New(sh.msgType)
+	visited[t] = true
 
-	h := func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != method {
-			http.Error(w, wrapJSONMsg("unsupported method: "+r.Method), http.StatusMethodNotAllowed)
-			return
+	type field struct {
+		path   string
+		depth  int
+		tagged bool
+	}
+	fields := make(map[string][]field)
+	type level struct {
+		t     reflect.Type
+		path  string
+		depth int
+	}
+	queue := []level{{t: t}}
+	// depths gives the depth where an embedded type is first found. Its
+	// fields are shadowed deeper, but conflict at the same depth.
+	depths := make(map[reflect.Type]int)
+	for len(queue) > 0 {
+		l := queue[0]
+		queue = queue[1:]
+		if d, ok := depths[l.t]; ok && d < l.depth {
+			continue
 		}
-		var msgBuf []byte
-		switch r.Method {
-		case "GET":
-			switch k {
-			case emptyGET:
-				msgBuf = []byte("{}")
-			case intGET:
-				if ok := intRegex.MatchString(r.URL.EscapedPath()); !ok {
-					http.Error(w, wrapJSONMsg("invalid path"), http.StatusNotFound)
-					return
-				}
-				_, num := path.Split(r.URL.EscapedPath())
-				numI64, err := strconv.Atoi(num)
-				if err != nil {
-					http.Error(w, wrapJSONMsg("not a number"), http.StatusBadRequest)
-					return
-				}
-				val0.Elem().Field(0).SetInt(int64(numI64))
-			case sliceGET:
-				if ok := sliceRegex.MatchString(r.URL.EscapedPath()); !ok {
-					http.Error(w, wrapJSONMsg("invalid path"), http.StatusNotFound)
-					return
-				}
-				_, hexStr := path.Split(r.URL.EscapedPath())
-				byteBuf, err := hex.DecodeString(hexStr)
-				if err != nil {
-					http.Error(w, wrapJSONMsg(err.Error()), http.StatusBadRequest)
-					return
-				}
-				val0.Elem().Field(0).SetBytes(byteBuf)
-			default:
-				http.Error(w, wrapJSONMsg("invalid GET"), http.StatusBadRequest)
-				return
+		depths[l.t] = l.depth
+		for i := 0; i < l.t.NumField(); i++ {
+			f := l.t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
 			}
-		case "POST", "PUT":
-			if r.Header.Get("Content-Type") != "application/json" {
-				http.Error(w, wrapJSONMsg("content type needs to be application/json"), http.StatusBadRequest)
-				return
+			name := strings.Split(tag, ",")[0]
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
 			}
-			var err error
-			msgBuf, err = ioutil.ReadAll(r.Body)
-			if err != nil {
-				http.Error(w, wrapJSONMsg(err.Error()), http.StatusBadRequest)
-				return
+			if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+				queue = append(queue, level{t: ft, path: l.path + f.Name + ".", depth: l.depth + 1})
+				continue
 			}
-			if err := json.Unmarshal(msgBuf, val0.Interface()); err != nil {
-				http.Error(w, wrapJSONMsg("decoding error "+err.Error()), http.StatusBadRequest)
-				return
+			if f.PkgPath != "" {
+				// unexported
+				continue
 			}
-		default:
-			http.Error(w, wrapJSONMsg("unsupported method: "+r.Method), http.StatusMethodNotAllowed)
-			return
+			if err := checkFieldNamesRec(f.Type, visited); err != nil {
+				return xerrors.Errorf("field %s: %v", f.Name, err)
+			}
+			if name == "" {
+				name = f.Name
+			}
+			fields[name] = append(fields[name], field{path: l.path + f.Name,
+				depth: l.depth, tagged: tag != ""})
 		}
+	}
 
-		out, tun, err := callInterfaceFunc(f, val0.Interface(), false)
-		if err != nil {
-			http.Error(w, wrapJSONMsg("processing error "+err.Error()),
-				http.StatusBadRequest)
-			return
+	for name, fs := range fields {
+		// Like the JSON decoder, the shallowest fields win, and among them
+		// the only one with a tag.
+		var shallowest []field
+		for _, f := range fs {
+			if len(shallowest) == 0 || f.depth < shallowest[0].depth {
+				shallowest = []field{f}
+			} else if f.depth == shallowest[0].depth {
+				shallowest = append(shallowest, f)
+			}
 		}
-		if tun != nil {
-			http.Error(w, wrapJSONMsg("streaming requests are not supported"), http.StatusBadRequest)
-			return
+		if len(shallowest) == 1 {
+			continue
 		}
-		reply, err := json.Marshal(out)
-		if err != nil {
-			http.Error(w, wrapJSONMsg(err.Error()), http.StatusInternalServerError)
-			return
+		var tagged []field
+		for _, f := range shallowest {
+			if f.tagged {
+				tagged = append(tagged, f)
+			}
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(reply)
-	}
-	finalSlash := ""
-	if k == intGET || k == sliceGET {
-		finalSlash = "/"
-	}
-	for v := minVersion; v <= maxVersion; v++ {
-		p.getRouter().HandleFunc(fmt.Sprintf("/v%d/%s/%s", v, namespace, resource)+finalSlash, h)
+		if len(tagged) == 1 {
+			continue
+		}
+		return xerrors.Errorf("%s: ambiguous field %s between %s and %s",
+			t, name, shallowest[0].path, shallowest[1].path)
 	}
 	return nil
 }
 
-func wrapJSONMsg(s string) string {
-	return fmt.Sprintf(`{"message": "%s"}`, s)
-}
-
-func createServiceHandler(f interface{}) (string, serviceHandler, error) {
-	// check output
+// handlerOutputCheck checks that f returns a message and an error.
+func handlerOutputCheck(f interface{}) error {
 	ft := reflect.TypeOf(f)
 	if ft.NumOut() != 2 {
-		return "", serviceHandler{}, xerrors.New("Need 2 return values: network.Body and error")
+		return xerrors.New("Need 2 return values: network.Body and error")
 	}
 	// first output
 	ret := ft.Out(0)
 	if ret.Kind() != reflect.Interface {
 		if ret.Kind() != reflect.Ptr {
-			return "", serviceHandler{},
-				xerrors.New("1st return value must be a *pointer* to a struct or an interface")
+			return xerrors.New("1st return value must be a *pointer* to a struct or an interface")
 		}
 		if ret.Elem().Kind() != reflect.Struct {
-			return "", serviceHandler{},
-				xerrors.New("1st return value must be a pointer to a *struct* or an interface")
+			return xerrors.New("1st return value must be a pointer to a *struct* or an interface")
 		}
 	}
 	// second output
 	if !ft.Out(1).Implements(errType) {
-		return "", serviceHandler{},
-			xerrors.New("2nd return value has to implement error, but is: " + ft.Out(1).String())
+		return xerrors.New("2nd return value has to implement error, but is: " + ft.Out(1).String())
 	}
-
-	cr := ft.In(0)
-	log.Lvl4("Registering handler", cr.String())
-	pm := strings.Split(cr.Elem().String(), ".")[1]
-
-	return pm, serviceHandler{f, cr.Elem(), false}, nil
+	return nil
 }
 
 func handlerInputCheck(f interface{}) error {
@@ -365,6 +1890,27 @@ func handlerInputCheck(f interface{}) error {
 	return nil
 }
 
+func handlerContextInputCheck(f interface{}) error {
+	ft := reflect.TypeOf(f)
+	if ft.Kind() != reflect.Func {
+		return xerrors.New("Input is not a function")
+	}
+	if ft.NumIn() != 2 {
+		return xerrors.New("Need two arguments: context.Context and *struct")
+	}
+	if ft.In(0) != contextType {
+		return xerrors.New("1st argument must be a context.Context")
+	}
+	cr := ft.In(1)
+	if cr.Kind() != reflect.Ptr {
+		return xerrors.New("2nd argument must be a *pointer* to a struct")
+	}
+	if cr.Elem().Kind() != reflect.Struct {
+		return xerrors.New("2nd argument must be a pointer to *struct*")
+	}
+	return nil
+}
+
 // RegisterHandlers takes a vararg of messages to register and returns
 // the first error encountered or nil if everything was OK.
 func (p *ServiceProcessor) RegisterHandlers(procs ...interface{}) error {
@@ -387,6 +1933,45 @@ func (p *ServiceProcessor) RegisterStreamingHandlers(procs ...interface{}) error
 	return nil
 }
 
+// RegisterHandlersFromStruct registers the exported methods of svc that have
+// the form of the handlers of RegisterHandler:
+// func(msg *T)(ret *R, err error)
+// so that a service can give all its handlers at once, usually with
+// RegisterHandlersFromStruct(s). Each handler is reached at the name of T.
+//
+// The other methods are skipped, but the ones that take a single pointer to a
+// struct and return something look like handlers with a wrong form: they are
+// listed in the returned error, once the valid handlers are registered.
+func (p *ServiceProcessor) RegisterHandlersFromStruct(svc interface{}) error {
+	v := reflect.ValueOf(svc)
+	if !v.IsValid() {
+		return xerrors.New("no service given")
+	}
+	t := v.Type()
+	var problems []string
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		f := v.Method(i).Interface()
+		ft := m.Type
+		// The type of the method includes its receiver.
+		if ft.NumIn() != 2 || ft.In(1).Kind() != reflect.Ptr ||
+			ft.In(1).Elem().Kind() != reflect.Struct || ft.NumOut() == 0 {
+			continue
+		}
+		if err := handlerOutputCheck(f); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", m.Name, err))
+			continue
+		}
+		if err := p.RegisterHandler(f); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", m.Name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return xerrors.Errorf("invalid handlers: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
 // Process implements the Processor interface and dispatches ClientRequest
 // messages.
 func (p *ServiceProcessor) Process(env *network.Envelope) {
@@ -409,25 +1994,326 @@ type StreamingTunnel struct {
 	close chan bool
 }
 
-func callInterfaceFunc(handler, input interface{}, streaming bool) (intf interface{}, ch chan bool, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Errorf("Panicked with '%v' at %s", r, log.Stack())
-			err = xerrors.Errorf("panic: %v", r)
+// Out returns the channel of the encoded messages of the stream, in the
+// format of the Codec of the request. The service closes it once it has
+// nothing more to send, and it must be read until then, or until Close is
+// closed.
+func (t *StreamingTunnel) Out() <-chan []byte {
+	return t.out
+}
+
+// Close returns the channel to close, exactly once, when the client goes
+// away, so that the service stops streaming. Nothing must be sent on it.
+// Out may still give some messages until the service closes it, which can be
+// discarded.
+func (t *StreamingTunnel) Close() chan<- bool {
+	return t.close
+}
+
+// panicError is returned when a handler panics, so that the caller can
+// answer with an internal error instead of a client error.
+type panicError struct {
+	value interface{}
+}
+
+func (e panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// StatusError can be returned by the handlers to choose the status sent to
+// the client. On the REST API, Code is the HTTP status code of the response.
+// On the websocket, the connection is closed with the code 4000+Code, in the
+// range reserved to the applications. Code must be a client or a server
+// error, i.e. between 400 and 599.
+type StatusError struct {
+	Code int
+	Msg  string
+}
+
+func (e StatusError) Error() string {
+	return e.Msg
+}
+
+// statusErrorCode returns the code of the StatusError in the chain of err,
+// if any and if it is valid.
+func statusErrorCode(err error) (int, bool) {
+	var se StatusError
+	var pse *StatusError
+	switch {
+	case xerrors.As(err, &se):
+	case xerrors.As(err, &pse) && pse != nil:
+		se = *pse
+	default:
+		return 0, false
+	}
+	if se.Code < 400 || se.Code > 599 {
+		return 0, false
+	}
+	return se.Code, true
+}
+
+// errorStatus returns the HTTP status code for an error returned by a
+// handler: the code of a StatusError, 500 for a panic, and 400 otherwise.
+func errorStatus(err error) int {
+	if code, ok := statusErrorCode(err); ok {
+		return code
+	}
+	if isPanicError(err) {
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// ErrorDetailer can be implemented by the errors of the handlers, or by an
+// error of their chain, to send details to the REST clients, such as the
+// fields of the request that are invalid. The details are JSON encoded in the
+// "details" field of the error body.
+type ErrorDetailer interface {
+	ErrorDetails() interface{}
+}
+
+// errorBody is the JSON body of the REST replies to the requests whose
+// handler failed.
+type errorBody struct {
+	// Message is the error prefixed by its kind, as sent to the clients
+	// before the other fields were added.
+	Message string          `json:"message"`
+	Error   string          `json:"error"`
+	Code    int             `json:"code"`
+	Details interface{}     `json:"details,omitempty"`
+	Partial json.RawMessage `json:"partial,omitempty"`
+}
+
+// newErrorBody returns the body of the reply to a REST request whose handler
+// returned err, along with its status code.
+func newErrorBody(err error) (int, errorBody) {
+	code := errorStatus(err)
+	body := errorBody{Message: errorMessage(code, err), Error: err.Error(), Code: code}
+	var ed ErrorDetailer
+	if xerrors.As(err, &ed) {
+		body.Details = ed.ErrorDetails()
+	}
+	return code, body
+}
+
+// writeHandlerError answers a REST request with the error returned by a
+// handler, as a JSON object with the code of the error.
+func writeHandlerError(w http.ResponseWriter, err error) {
+	code, body := newErrorBody(err)
+	writeJSON(w, code, body)
+}
+
+// errorMessage returns the message sent to the REST clients for the error
+// of a handler answered with the code.
+func errorMessage(code int, err error) string {
+	if code >= 500 {
+		return "internal error " + err.Error()
+	}
+	return "processing error " + err.Error()
+}
+
+// HandlerError is the error returned to the clients when
+// ServiceProcessor.WrapHandlerErrors is set. Err is the error of the handler,
+// and is still found by xerrors.As and xerrors.Is, so that the code of a
+// StatusError is sent to the client.
+type HandlerError struct {
+	Handler   string
+	RequestID string
+	Err       error
+}
+
+func (e HandlerError) Error() string {
+	if e.RequestID == "" {
+		return fmt.Sprintf("handler %s: %v", e.Handler, e.Err)
+	}
+	return fmt.Sprintf("handler %s, request %s: %v", e.Handler, e.RequestID, e.Err)
+}
+
+// Unwrap returns the error of the handler.
+func (e HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// wrapHandlerError wraps the error of the handler name in a HandlerError if
+// WrapHandlerErrors is set.
+func (p *ServiceProcessor) wrapHandlerError(name string, req *http.Request, err error) error {
+	if !p.WrapHandlerErrors {
+		return err
+	}
+	he := HandlerError{Handler: name, Err: err}
+	if req != nil {
+		he.RequestID = req.Header.Get(RequestIDHeader)
+	}
+	return he
+}
+
+// PartialError is returned when ServiceProcessor.PartialResults is set and a
+// handler returns both a reply and an error. Err is the error of the handler,
+// and is still found by xerrors.As and xerrors.Is.
+type PartialError struct {
+	Reply interface{}
+	Err   error
+}
+
+func (e PartialError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error of the handler.
+func (e PartialError) Unwrap() error {
+	return e.Err
+}
+
+// writePartialError answers a REST request with the error returned by a
+// handler, along with its partial reply, if there is one, in the "partial"
+// field of the error body.
+func (p *ServiceProcessor) writePartialError(w http.ResponseWriter, err error) {
+	var pe PartialError
+	if !xerrors.As(err, &pe) {
+		writeHandlerError(w, err)
+		return
+	}
+	partial, encErr := marshalJSON(pe.Reply, p.RESTTagName)
+	if encErr != nil {
+		log.Error(encErr)
+		writeHandlerError(w, err)
+		return
+	}
+	code, body := newErrorBody(err)
+	body.Partial = partial
+	setReplyHeaders(w, pe.Reply)
+	writeJSON(w, code, body)
+}
+
+// HeaderSetter can be implemented by the replies of the REST handlers to add
+// headers to the response, e.g. caching directives. The headers are ignored
+// for the requests that don't come from the REST API, such as the websocket
+// requests. The Content-Type header of the reply can't be changed.
+type HeaderSetter interface {
+	Headers() http.Header
+}
+
+// ContentTyper can be implemented by the replies of the REST handlers to send
+// their own body, such as an image, a CSV export or a binary proof, instead
+// of the reply encoded in the format asked by the client. The body is written
+// as is, with the content type. The replies of the requests that don't come
+// from the REST API, such as the websocket requests, are encoded as usual.
+type ContentTyper interface {
+	ContentType() string
+	Body() []byte
+}
+
+// setReplyHeaders adds the headers of the reply to the response if it is a
+// HeaderSetter.
+func setReplyHeaders(w http.ResponseWriter, reply interface{}) {
+	hs, ok := reply.(HeaderSetter)
+	if !ok {
+		return
+	}
+	for key, values := range hs.Headers() {
+		for _, v := range values {
+			w.Header().Add(key, v)
 		}
+	}
+}
+
+// isPanicError returns true if err has been created by a handler that
+// panicked.
+func isPanicError(err error) bool {
+	return xerrors.As(err, &panicError{})
+}
+
+// requestContext returns the context given to the handlers for the request,
+// with the given deadline if it is not zero.
+func requestContext(req *http.Request, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if req != nil {
+		ctx = req.Context()
+	}
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// callHandler calls the handler with the message, and release once the
+// handler returns. If the handler has a timeout, it is run on its own
+// goroutine and callHandler returns as soon as the context is done, while
+// release waits for the end of the handler.
+func (p *ServiceProcessor) callHandler(ctx context.Context, mh serviceHandler, msg interface{}, release func()) (interface{}, error) {
+	if mh.timeout == 0 {
+		defer release()
+		reply, _, err := p.callInterfaceFunc(ctx, mh.handler, msg, mh.streaming)
+		return reply, err
+	}
+
+	type result struct {
+		reply interface{}
+		err   error
+	}
+	// Buffered so that the goroutine can end after we gave up on it.
+	done := make(chan result, 1)
+	go func() {
+		defer release()
+		reply, _, err := p.callInterfaceFunc(ctx, mh.handler, msg, mh.streaming)
+		done <- result{reply, err}
 	}()
 
-	to := reflect.TypeOf(handler).In(0)
+	select {
+	case res := <-done:
+		return res.reply, res.err
+	case <-ctx.Done():
+		return nil, xerrors.Errorf("waiting for handler: %v", ctx.Err())
+	}
+}
+
+// callUploadFunc calls the handler of RegisterUploadHandler with the body.
+func (p *ServiceProcessor) callUploadFunc(handler interface{}, body io.Reader) (intf interface{}, err error) {
+	if !p.DisablePanicRecovery {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("Panicked with '%v' at %s", r, log.Stack())
+				err = xerrors.Errorf("calling handler: %w", panicError{r})
+			}
+		}()
+	}
+
+	ret := reflect.ValueOf(handler).Call([]reflect.Value{reflect.ValueOf(&body).Elem()})
+	if ierr := ret[1].Interface(); ierr != nil {
+		return nil, xerrors.Errorf("processing error: %w", ierr.(error))
+	}
+	return ret[0].Interface(), nil
+}
+
+func (p *ServiceProcessor) callInterfaceFunc(ctx context.Context, handler, input interface{}, streaming bool) (intf interface{}, ch chan bool, err error) {
+	if !p.DisablePanicRecovery {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("Panicked with '%v' at %s", r, log.Stack())
+				err = xerrors.Errorf("calling handler: %w", panicError{r})
+			}
+		}()
+	}
+
+	ft := reflect.TypeOf(handler)
 	f := reflect.ValueOf(handler)
 
-	arg := reflect.New(to.Elem())
-	arg.Elem().Set(reflect.ValueOf(input).Elem())
-	ret := f.Call([]reflect.Value{arg})
+	var args []reflect.Value
+	if ft.NumIn() > 0 {
+		to := ft.In(ft.NumIn() - 1)
+		arg := reflect.New(to.Elem())
+		arg.Elem().Set(reflect.ValueOf(input).Elem())
+		args = []reflect.Value{arg}
+		if ft.NumIn() == 2 {
+			args = []reflect.Value{reflect.ValueOf(ctx), arg}
+		}
+	}
+	ret := f.Call(args)
 
 	if streaming {
 		ierr := ret[2].Interface()
 		if ierr != nil {
-			err = xerrors.Errorf("processing error: %v", ierr)
+			err = xerrors.Errorf("processing error: %w", ierr.(error))
 			return
 		}
 
@@ -435,9 +2321,16 @@ func callInterfaceFunc(handler, input interface{}, streaming bool) (intf interfa
 		ch = ret[1].Interface().(chan bool)
 		return
 	}
+	if len(ret) == 3 {
+		intf, err = optionalReply(ret)
+		return
+	}
 	ierr := ret[1].Interface()
 	if ierr != nil {
-		err = xerrors.Errorf("processing error: %v", ierr)
+		if p.PartialResults && !ret[0].IsNil() {
+			ierr = PartialError{Reply: ret[0].Interface(), Err: ierr.(error)}
+		}
+		err = xerrors.Errorf("processing error: %w", ierr.(error))
 		return
 	}
 
@@ -445,18 +2338,44 @@ func callInterfaceFunc(handler, input interface{}, streaming bool) (intf interfa
 	return
 }
 
+// callStreamingFunc calls a streaming handler that takes the argument arg
+// after the message, such as the inbound channel of the bidirectional
+// streaming handlers.
+func (p *ServiceProcessor) callStreamingFunc(handler, msg interface{},
+	arg reflect.Value) (intf interface{}, ch chan bool, err error) {
+	if !p.DisablePanicRecovery {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("Panicked with '%v' at %s", r, log.Stack())
+				err = xerrors.Errorf("calling handler: %w", panicError{r})
+			}
+		}()
+	}
+
+	ft := reflect.TypeOf(handler)
+	ret := reflect.ValueOf(handler).Call([]reflect.Value{reflect.ValueOf(msg),
+		arg.Convert(ft.In(1))})
+	if ierr := ret[2].Interface(); ierr != nil {
+		return nil, nil, xerrors.Errorf("processing error: %w", ierr.(error))
+	}
+	return ret[0].Interface(), ret[1].Interface().(chan bool), nil
+}
+
 // ProcessClientStreamRequest allows clients to push multiple messages
 // asynchronously to the same service handler with the same connection. Unlike
 // in ProcessClientRequest, we take a channel of inputs that can be filled and
 // will subsequently call the service with any new messages received in the
 // channel. The caller is responsible for closing the client input channel when
 // it is done.
+// The handler is also stopped when the context of req is done, e.g. when the
+// server shuts down.
 func (p *ServiceProcessor) ProcessClientStreamRequest(req *http.Request, path string,
 	clientInputs chan []byte) (chan []byte, error) {
 
 	outChan := make(chan []byte, 100)
 	var closeOutOnce sync.Once
-	mh, ok := p.handlers[path]
+	msgName := strings.TrimPrefix(path, p.WebSocketNamespace+"/")
+	mh, ok := p.lookupHandler(path)
 
 	if !ok {
 		err := xerrors.New("the requested message hasn't been " +
@@ -464,39 +2383,119 @@ func (p *ServiceProcessor) ProcessClientStreamRequest(req *http.Request, path st
 		log.Error(err)
 		return nil, err
 	}
+	if err := p.checkRoles(mh.roles); err != nil {
+		return nil, err
+	}
+	if err := p.checkAuth(req, msgName); err != nil {
+		return nil, err
+	}
+	if err := p.checkRateLimit(req); err != nil {
+		return nil, err
+	}
+	if p.StreamBudget != nil {
+		if err := p.StreamBudget.checkNewStream(); err != nil {
+			return nil, err
+		}
+	}
 
+	// Streaming handlers live as long as the connection, so the timeout
+	// doesn't apply to them.
+	ctx := context.Background()
+	if req != nil {
+		ctx = req.Context()
+	}
 	var stopServiceChan chan bool
 	var reply interface{}
+	codec := p.requestCodec(req)
+	var md *ConnMetadata
+	if mh.metadata {
+		md = p.newConnMetadata(req, codec)
+	}
+	// clientGone is closed with stopServiceChan when the client goes away.
+	clientGone := make(chan struct{})
+	// handlerDone is closed with outChan, when the handler is done.
+	handlerDone := make(chan struct{})
+	// inbound is the channel of a bidirectional streaming handler, once it
+	// is called.
+	var inbound reflect.Value
 
 	// This goroutine listens on any new messages from the client and executes
 	// the request. Executing the request should fill the service's channel, as
 	// the service will use the same chanel for further requests.
+	// stopped holds the closed stop channels of the handler, as they are
+	// also closed when the messages of a handler can't be forwarded.
+	var stoppedLock sync.Mutex
+	stopped := make(map[chan bool]bool)
+	closeStop := func(ch chan bool) {
+		stoppedLock.Lock()
+		defer stoppedLock.Unlock()
+		if ch != nil && !stopped[ch] {
+			stopped[ch] = true
+			close(ch)
+		}
+	}
+
 	go func() {
+		stop := func() {
+			closeStop(stopServiceChan)
+			if inbound.IsValid() {
+				inbound.Close()
+			}
+			close(clientGone)
+		}
 		for {
 			select {
+			case <-ctx.Done():
+				// The client went away, or the server is shutting down.
+				// The messages already received are processed first, so
+				// that the handler sees its closeChan.
+				if len(clientInputs) > 0 {
+					continue
+				}
+				stop()
+				return
 			case buf, ok := <-clientInputs:
 				if !ok {
-					if stopServiceChan != nil {
-						close(stopServiceChan)
-					}
+					stop()
 					return
 				}
+				if inbound.IsValid() {
+					// The handler is already running and gets the next
+					// messages on its inbound channel.
+					p.sendInbound(ctx, codec, msgName, mh, inbound, buf, handlerDone)
+					continue
+				}
 
 				msg := reflect.New(mh.msgType).Interface()
 
-				err := protobuf.DecodeWithConstructors(buf, msg,
-					network.DefaultConstructors(p.Context.server.Suite()))
+				err := p.decodeMessage(codec, buf, msg)
 				if err != nil {
 					log.Error(xerrors.Errorf("failed to decode message: %v", err))
 					return
 				}
+				p.reportDeprecated(msgName, msg)
+				if err := prepareMessage(msg); err != nil {
+					log.Error(xerrors.Errorf("invalid message: %v", err))
+					return
+				}
 
-				reply, stopServiceChan, err = callInterfaceFunc(mh.handler, msg, mh.streaming)
+				switch {
+				case mh.inType != nil:
+					inbound = reflect.MakeChan(reflect.ChanOf(reflect.BothDir, mh.inType.Elem()),
+						inboundBufferSize)
+					reply, stopServiceChan, err = p.callStreamingFunc(mh.handler, msg, inbound)
+				case mh.flow:
+					reply, stopServiceChan, err = p.callStreamingFunc(mh.handler, msg,
+						reflect.ValueOf(&StreamFlow{out: outChan, budget: p.StreamBudget}))
+				case mh.metadata:
+					reply, stopServiceChan, err = p.callStreamingFunc(mh.handler, msg,
+						reflect.ValueOf(md))
+				default:
+					reply, stopServiceChan, err = p.callInterfaceFunc(ctx, mh.handler, msg, mh.streaming)
+				}
 				if err != nil {
 					log.Error(err)
-					if stopServiceChan != nil {
-						close(stopServiceChan)
-					}
+					closeStop(stopServiceChan)
 					return
 				}
 
@@ -504,10 +2503,14 @@ func (p *ServiceProcessor) ProcessClientStreamRequest(req *http.Request, path st
 				// decoding the messages and then forwarding them to the streaming
 				// tunnel, which should then forward the message to the client. A new
 				// routine is created each time the client makes a request.
+				// The channel is taken before the next message of the client
+				// calls the handler again.
+				inChan := reflect.ValueOf(reply)
+				stopChan := stopServiceChan
 				go func() {
-					inChan := reflect.ValueOf(reply)
 					cases := []reflect.SelectCase{
 						reflect.SelectCase{Dir: reflect.SelectRecv, Chan: inChan},
+						reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(clientGone)},
 					}
 
 					// Since this goroutine is created each time the client sends a
@@ -515,8 +2518,21 @@ func (p *ServiceProcessor) ProcessClientStreamRequest(req *http.Request, path st
 					defer func() {
 						closeOutOnce.Do(func() {
 							close(outChan)
+							close(handlerDone)
 						})
 					}()
+					// A message that panics when it is encoded stops the
+					// stream instead of the server.
+					if !p.DisablePanicRecovery {
+						defer func() {
+							if r := recover(); r != nil {
+								log.Errorf("Streaming %s panicked with '%v' at %s",
+									path, r, log.Stack())
+								closeStop(stopChan)
+								p.drainStream(inChan, path)
+							}
+						}()
+					}
 
 					for {
 						chosen, v, ok := reflect.Select(cases)
@@ -527,12 +2543,25 @@ func (p *ServiceProcessor) ProcessClientStreamRequest(req *http.Request, path st
 						}
 						if chosen == 0 {
 							// Send information down to the client.
-							buf, err = protobuf.Encode(v.Interface())
+							buf, err = codec.Encode(v.Interface())
 							if err != nil {
 								log.Error(err)
 								return
 							}
-							outChan <- buf
+							if !p.acquireStreamBytes(len(buf), outChan, clientGone) {
+								p.drainStream(inChan, path)
+								return
+							}
+							select {
+							case outChan <- buf:
+							case <-clientGone:
+								p.releaseStreamBytes(len(buf))
+								p.drainStream(inChan, path)
+								return
+							}
+						} else if chosen == 1 {
+							p.drainStream(inChan, path)
+							return
 						} else {
 							panic("no such channel index")
 						}
@@ -550,10 +2579,37 @@ func (p *ServiceProcessor) ProcessClientStreamRequest(req *http.Request, path st
 	return outChan, nil
 }
 
+// drainStream reads and discards the messages of the channel of a streaming
+// handler whose client went away, until the handler closes the channel or
+// the grace period is over.
+func (p *ServiceProcessor) drainStream(ch reflect.Value, path string) {
+	grace := p.StreamStopGracePeriod
+	if grace == 0 {
+		grace = DefaultStreamStopGracePeriod
+	}
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: ch},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timer.C)},
+	}
+	for {
+		chosen, _, ok := reflect.Select(cases)
+		if chosen == 1 {
+			log.Warnf("streaming handler %s didn't stop after %s, "+
+				"its channel is not read anymore", path, grace)
+			return
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
 // IsStreaming tell if the service registered at the given path is a streaming
 // service or not. Return an error if the service is not registered.
 func (p *ServiceProcessor) IsStreaming(path string) (bool, error) {
-	mh, ok := p.handlers[path]
+	mh, ok := p.lookupHandler(path)
 	if !ok {
 		err := xerrors.New("The requested message hasn't been registered: " + path)
 		log.Error(err)
@@ -564,35 +2620,104 @@ func (p *ServiceProcessor) IsStreaming(path string) (bool, error) {
 
 // ProcessClientRequest implements the Service interface, see the interface
 // documentation.
-func (p *ServiceProcessor) ProcessClientRequest(req *http.Request, path string, buf []byte) ([]byte, *StreamingTunnel, error) {
-	mh, ok := p.handlers[path]
+func (p *ServiceProcessor) ProcessClientRequest(req *http.Request, path string, buf []byte) (out []byte, tun *StreamingTunnel, err error) {
+	msgName := strings.TrimPrefix(path, p.WebSocketNamespace+"/")
+	mh, ok := p.lookupHandler(path)
+	// The path comes from the client, so only the registered names are
+	// used as labels, to bound the number of time series.
+	label := msgName
+	if !ok {
+		label = unregisteredLabel
+	}
+	end := p.beginRequest(label)
+	defer func(start time.Time, requestSize int) {
+		end(err != nil)
+		p.sampleTrace(req, label, start, requestSize, len(out), err)
+	}(time.Now(), len(buf))
 
 	if mh.streaming {
 		return nil, nil, xerrors.Errorf("using a streaming request with " +
 			"ProcessClientRequest: Please use instead ProcessClientStreamRequest")
 	}
 
-	reply, _, err := func() (interface{}, chan bool, error) {
+	if ok {
+		mh.timeout = p.handlerTimeout(msgName, mh.timeout)
+	}
+	timeout := p.HandlerTimeout
+	if mh.timeout > 0 {
+		timeout = mh.timeout
+	}
+	ctx, cancel := requestContext(req, timeout)
+	defer cancel()
+	id := newRequestID(req)
+	ctx = withRequestID(ctx, id)
+	log.Lvlf3("request %s: %s", id, path)
+	codec := p.requestCodec(req)
+	reply, err := func() (interface{}, error) {
 		if !ok {
 			err := xerrors.New("The requested message hasn't been registered: " + path)
 			log.Error(err)
-			return nil, nil, err
+			return nil, err
+		}
+		if err := p.checkRoles(mh.roles); err != nil {
+			return nil, err
+		}
+		if err := p.checkAuth(req, msgName); err != nil {
+			return nil, err
+		}
+		if err := p.checkRateLimit(req); err != nil {
+			return nil, err
 		}
 		msg := reflect.New(mh.msgType).Interface()
-		if err := protobuf.DecodeWithConstructors(buf, msg,
-			network.DefaultConstructors(p.Context.server.Suite())); err != nil {
-			return nil, nil, xerrors.Errorf("decoding: %v", err)
+		if !mh.noMessage {
+			if err := p.decodeMessage(codec, buf, msg); err != nil {
+				return nil, xerrors.Errorf("decoding: %w", err)
+			}
+			p.reportDeprecated(msgName, msg)
+			if err := prepareMessage(msg); err != nil {
+				return nil, xerrors.Errorf("invalid message: %w", err)
+			}
+		}
+		reply, err := p.chain(func(_ *http.Request, msg interface{}) (interface{}, error) {
+			release, err := p.waitWorker(ctx, msgName)
+			if err != nil {
+				return nil, err
+			}
+			callStart := time.Now()
+			reply, err := p.callHandler(ctx, mh, msg, release)
+			p.recordLatency(msgName, callStart, timeout, ctx.Err())
+			return reply, err
+		})(req, msg)
+		p.recordOutcome(msgName, err)
+		if err != nil {
+			logRequestError(ctx, msgName, err)
+			return nil, p.wrapHandlerError(path, req, err)
 		}
-		return callInterfaceFunc(mh.handler, msg, mh.streaming)
+		return reply, nil
 	}()
 	if err != nil {
-		return nil, nil, err
+		var pe PartialError
+		if !xerrors.As(err, &pe) {
+			return nil, nil, err
+		}
+		buf, encErr := codec.Encode(pe.Reply)
+		if encErr != nil {
+			log.Error(encErr)
+			return nil, nil, err
+		}
+		return buf, nil, err
 	}
 
-	buf, err = protobuf.Encode(reply)
+	if sr, ok := reply.(streamReply); ok {
+		return nil, p.newTunnel(sr.ch, codec, msgName), nil
+	}
+	buf, err = codec.Encode(reply)
 	if err != nil {
 		log.Error(err)
 		return nil, nil, xerrors.Errorf("encoding: %v", err)
 	}
+	if err := p.checkReplySize(msgName, buf); err != nil {
+		return nil, nil, err
+	}
 	return buf, nil, nil
 }
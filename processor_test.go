@@ -2,22 +2,28 @@ package onet
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/group/edwards25519"
 	"go.dedis.ch/kyber/v3/pairing/bn256"
 	"go.dedis.ch/kyber/v3/sign/bls"
+	"go.dedis.ch/kyber/v3/suites"
 	"go.dedis.ch/kyber/v3/util/key"
 	"go.dedis.ch/kyber/v3/util/random"
 	"go.dedis.ch/onet/v3/log"
@@ -70,6 +76,40 @@ func TestProcessor_RegisterMessages(t *testing.T) {
 	require.Error(t, p.RegisterHandlers(procMsg3, procMsgWrong1))
 }
 
+type structService struct {
+	*ServiceProcessor
+}
+
+func (s *structService) Msg(msg *testMsg) (*testMsg, error) {
+	return msg, nil
+}
+
+func (s *structService) Msg2(msg *testMsg2) (network.Message, error) {
+	return msg, nil
+}
+
+func (s *structService) WrongMsg(msg *testMsg3) *testMsg3 {
+	return msg
+}
+
+func (s *structService) helper(msg *testMsg4) (*testMsg4, error) {
+	return msg, nil
+}
+
+func TestProcessor_RegisterHandlersFromStruct(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	s := &structService{NewServiceProcessor(&Context{server: h1})}
+	err := s.RegisterHandlersFromStruct(s)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "WrongMsg")
+	require.NotContains(t, err.Error(), "Process")
+	require.Equal(t, []string{"testMsg", "testMsg2"}, s.RegisteredHandlers())
+
+	s = &structService{NewServiceProcessor(&Context{server: h1})}
+	require.Error(t, s.RegisterHandlersFromStruct(nil))
+}
+
 func TestProcessor_RegisterStreamingMessage(t *testing.T) {
 	h1 := NewLocalServer(tSuite, 2000)
 	defer h1.Close()
@@ -159,6 +199,656 @@ func TestServiceProcessor_ProcessClientRequest(t *testing.T) {
 	require.NotEqual(t, "", log.GetStdErr())
 }
 
+func TestServiceProcessor_UnregisterHandler(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+	require.NoError(t, p.RegisterHandlers(procMsg, procMsg2))
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET1, "dummyService", "GET", 3, 3))
+	require.Equal(t, []string{"restMsgGET1", "testMsg", "testMsg2"}, p.RegisteredHandlers())
+
+	require.NoError(t, p.UnregisterHandler("testMsg"))
+	require.Error(t, p.UnregisterHandler("testMsg"))
+	require.Equal(t, []string{"restMsgGET1", "testMsg2"}, p.RegisteredHandlers())
+
+	buf, err := protobuf.Encode(&testMsg{11})
+	require.NoError(t, err)
+	log.OutputToBuf()
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	log.OutputToOs()
+	require.Error(t, err)
+
+	require.NoError(t, p.UnregisterHandler("restMsgGET1"))
+	require.Equal(t, []string{"testMsg2"}, p.RegisteredHandlers())
+
+	// An unregistered REST handler must answer 404, and registering it
+	// again must not panic on the already existing route.
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, httptest.NewRequest("GET", "/v3/dummyService/restMsgGET1", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET1, "dummyService", "GET", 3, 3))
+	w = httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, httptest.NewRequest("GET", "/v3/dummyService/restMsgGET1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestServiceProcessor_WebSocketNamespace(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+	p.WebSocketNamespace = "ns"
+	require.NoError(t, p.RegisterHandler(procMsg))
+
+	buf, err := protobuf.Encode(&testMsg{11})
+	require.NoError(t, err)
+	rep, _, err := p.ProcessClientRequest(nil, "ns/testMsg", buf)
+	require.NoError(t, err)
+	val := &testMsg{}
+	require.NoError(t, protobuf.Decode(rep, val))
+	require.Equal(t, int64(11), val.I)
+
+	log.OutputToBuf()
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	log.OutputToOs()
+	require.Error(t, err)
+	_, err = p.IsStreaming("other/testMsg")
+	require.Error(t, err)
+}
+
+func TestServiceProcessor_RegisterHandlerWithName(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+
+	require.Error(t, p.RegisterHandlerWithName("", procMsg))
+	require.Error(t, p.RegisterHandlerWithName("a/b", procMsg))
+	require.Error(t, p.RegisterHandlerWithName("name", func(*testMsg) error { return nil }))
+
+	require.NoError(t, p.RegisterHandlerWithName("first", procMsg))
+	require.NoError(t, p.RegisterHandlerWithName("second", func(msg *testMsg) (network.Message, error) {
+		return &testMsg{msg.I + 1}, nil
+	}))
+	require.NoError(t, p.RegisterHandlerWithName("anonymous", func(msg *struct{ I int64 }) (*testMsg, error) {
+		return &testMsg{msg.I + 2}, nil
+	}))
+	require.Equal(t, []string{"anonymous", "first", "second"}, p.RegisteredHandlers())
+
+	buf, err := protobuf.Encode(&testMsg{11})
+	require.NoError(t, err)
+	for i, name := range []string{"first", "second", "anonymous"} {
+		rep, _, err := p.ProcessClientRequest(nil, name, buf)
+		require.NoError(t, err)
+		val := &testMsg{}
+		require.NoError(t, protobuf.Decode(rep, val))
+		require.Equal(t, int64(11+i), val.I)
+	}
+}
+
+func TestServiceProcessor_RegisterHandlerWithName_noMessage(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+
+	require.Error(t, p.RegisterHandler(func() (*testMsg, error) { return nil, nil }))
+	require.Error(t, p.RegisterHandlerWithName("name", func() error { return nil }))
+
+	snapshots := 0
+	require.NoError(t, p.RegisterHandlerWithName("snapshot", func() (*testMsg, error) {
+		snapshots++
+		return &testMsg{int64(snapshots)}, nil
+	}))
+	require.NoError(t, p.RegisterHandlerWithName("fail", func() (network.Message, error) {
+		return nil, xerrors.New("no snapshot")
+	}))
+
+	// The content of the request is not decoded.
+	for i, buf := range [][]byte{nil, []byte("not a message")} {
+		rep, _, err := p.ProcessClientRequest(nil, "snapshot", buf)
+		require.NoError(t, err)
+		val := &testMsg{}
+		require.NoError(t, protobuf.Decode(rep, val))
+		require.Equal(t, int64(i+1), val.I)
+	}
+	_, _, err := p.ProcessClientRequest(nil, "fail", nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no snapshot")
+
+	require.NoError(t, p.ReloadHandlers(map[string]interface{}{
+		"reloaded": func() (*testMsg, error) { return &testMsg{42}, nil },
+	}))
+	rep, _, err := p.ProcessClientRequest(nil, "reloaded", nil)
+	require.NoError(t, err)
+	val := &testMsg{}
+	require.NoError(t, protobuf.Decode(rep, val))
+	require.Equal(t, int64(42), val.I)
+}
+
+func TestServiceProcessor_AnonymousMessage(t *testing.T) {
+	p := NewServiceProcessor(&Context{})
+	require.Error(t, p.RegisterHandler(func(*struct{ I int64 }) (*testMsg, error) {
+		return nil, nil
+	}))
+	require.Error(t, p.RegisterStreamingHandler(func(*struct{ I int64 }) (chan *testMsg, chan bool, error) {
+		return nil, nil, nil
+	}))
+
+	name, err := messageName(reflect.TypeOf(testMsg{}))
+	require.NoError(t, err)
+	require.Equal(t, "testMsg", name)
+}
+
+func TestServiceProcessor_LatestAPIVersion(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", 3, LatestAPIVersion))
+	require.Error(t, p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", CurrentAPIVersion+1, LatestAPIVersion))
+	require.Error(t, p.RegisterStreamingRESTHandler(func(*restMsgGET1) (chan *testMsg, chan bool, error) {
+		return nil, nil, nil
+	}, "dummyService", 2, LatestAPIVersion))
+
+	get := func(v int) int {
+		r := httptest.NewRequest("GET", fmt.Sprintf("/v%d/dummyService/restMsgGET2/42", v), nil)
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w.Code
+	}
+	require.Equal(t, http.StatusOK, get(CurrentAPIVersion))
+	require.NotEqual(t, http.StatusOK, get(CurrentAPIVersion+1))
+}
+
+func TestServiceProcessor_MaxRESTVersions(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	p.MaxRESTVersions = 2
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET1, "dummyService", "GET", 3, 4))
+	err := p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", 3, 5)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "maximum of 2 versions")
+	require.Error(t, p.RegisterStreamingRESTHandler(func(*restMsgGET1) (chan *testMsg, chan bool, error) {
+		return nil, nil, nil
+	}, "dummyService", 3, 5))
+
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, httptest.NewRequest("GET", "/v4/dummyService/restMsgGET1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, httptest.NewRequest("GET", "/v5/dummyService/restMsgGET2/7", nil))
+	require.NotEqual(t, http.StatusOK, w.Code)
+}
+
+func TestServiceProcessor_RegisterRESTHandlerAllVersions(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(t, p.RegisterRESTHandlerAllVersions(procRestMsgGET1, "dummyService", "GET", 3))
+	require.NoError(t, p.RegisterRESTHandlerAllVersions(procRestMsgGET2, "dummyService", "GET", 3))
+	require.NoError(t, p.RegisterRESTHandlerAllVersions(procRestMsgPOSTString, "dummyService", "POST", 3))
+	// Registering again replaces the handlers.
+	require.NoError(t, p.RegisterRESTHandlerAllVersions(procRestMsgGET2, "dummyService", "GET", 3))
+	require.Error(t, p.RegisterRESTHandlerAllVersions(procRestMsgGET2, "dummyService", "GET", CurrentAPIVersion+1))
+	require.Error(t, p.RegisterRESTHandlerAllVersions(procRestMsgGET2, "dummyService", "DELETE", 3))
+
+	request := func(method, path, body string) (int, string) {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+	for v := 3; v <= CurrentAPIVersion; v++ {
+		prefix := fmt.Sprintf("/v%d/dummyService/", v)
+		code, body := request("GET", prefix+"restMsgGET1", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, `{"I":42}`, strings.TrimSpace(body))
+		code, _ = request("GET", prefix+"restMsgGET1/42", "")
+		require.Equal(t, http.StatusNotFound, code)
+
+		code, body = request("GET", prefix+"restMsgGET2/7", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, `{"I":7}`, strings.TrimSpace(body))
+		code, _ = request("GET", prefix+"restMsgGET2/x", "")
+		require.NotEqual(t, http.StatusOK, code)
+
+		code, _ = request("POST", prefix+"restMsgPOSTString", `{"S": "42"}`)
+		require.Equal(t, http.StatusOK, code)
+	}
+	code, _ := request("GET", fmt.Sprintf("/v%d/dummyService/restMsgGET2/7", CurrentAPIVersion+1), "")
+	require.NotEqual(t, http.StatusOK, code)
+
+	// The paths are parsed the same way past the 9th version.
+	get, err := newGETParser(procRestMsgGET2, "dummyService", "restMsgGET2")
+	require.NoError(t, err)
+	msg := reflect.New(reflect.TypeOf(restMsgGET2{}))
+	code, err = get.parse(httptest.NewRequest("GET", "/v12/dummyService/restMsgGET2/7", nil), msg)
+	require.NoError(t, err, code)
+	require.Equal(t, 7, msg.Interface().(*restMsgGET2).X)
+}
+
+func TestServiceProcessor_IDLength(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.Error(t, p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", 3, 3, WithIDLength(4, 4)))
+	require.Error(t, p.RegisterRESTHandler(procRestMsgGET3, "dummyService", "GET", 3, 3, WithIDLength(4, 2)))
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET3, "dummyService", "GET", 3, 3, WithIDLength(2, 4)))
+
+	get := func(id string) int {
+		r := httptest.NewRequest("GET", "/v3/dummyService/restMsgGET3/"+id, nil)
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w.Code
+	}
+	require.Equal(t, http.StatusBadRequest, get("de"))
+	require.Equal(t, http.StatusOK, get("dead"))
+	require.Equal(t, http.StatusOK, get("deadbeef"))
+	require.Equal(t, http.StatusBadRequest, get("deadbeef00"))
+}
+
+type restMsgEdge struct {
+	From int
+	To   []byte
+}
+
+func TestServiceProcessor_MultiSegmentGET(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	type badEdge struct {
+		A int
+		B string
+	}
+	require.Error(t, p.RegisterRESTHandler(func(*badEdge) (*testMsg, error) {
+		return nil, nil
+	}, "dummyService", "GET", 3, 3))
+	require.NoError(t, p.RegisterRESTHandler(func(msg *restMsgEdge) (*testMsg, error) {
+		return &testMsg{int64(msg.From)*1000 + int64(len(msg.To))}, nil
+	}, "dummyService", "GET", 3, 3))
+
+	get := func(path string) (int, string) {
+		r := httptest.NewRequest("GET", "/v3/dummyService/restMsgEdge/"+path, nil)
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+	code, body := get("7/deadbeef")
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"I": 7004}`, body)
+
+	code, body = get("7")
+	require.Equal(t, http.StatusNotFound, code)
+	require.Contains(t, body, "expected 2 segments (From/To), got 1")
+	code, _ = get("7/dead/beef")
+	require.Equal(t, http.StatusNotFound, code)
+	code, body = get("seven/dead")
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Contains(t, body, "From: not a number")
+	code, _ = get("7/xyz")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	buf, err := p.OpenAPISpec()
+	require.NoError(t, err)
+	require.Contains(t, string(buf), `"/v3/dummyService/restMsgEdge/{From}/{To}"`)
+}
+
+type headerReply struct {
+	I int64
+}
+
+func (r *headerReply) Headers() http.Header {
+	h := http.Header{}
+	h.Set("Cache-Control", "max-age=60")
+	h.Set("X-Count", strconv.FormatInt(r.I, 10))
+	h.Set("Content-Type", "text/plain")
+	return h
+}
+
+func TestServiceProcessor_HeaderSetter(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(t, p.RegisterRESTHandler(func(msg *restMsgGET2) (*headerReply, error) {
+		return &headerReply{I: int64(msg.X)}, nil
+	}, "dummyService", "GET", 3, 3))
+
+	r := httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/42", nil)
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "max-age=60", w.Header().Get("Cache-Control"))
+	require.Equal(t, "42", w.Header().Get("X-Count"))
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&headerReply{}))
+}
+
+type csvReply struct {
+	Rows [][]string
+}
+
+func (r *csvReply) ContentType() string {
+	return "text/csv"
+}
+
+func (r *csvReply) Body() []byte {
+	var buf bytes.Buffer
+	for _, row := range r.Rows {
+		buf.WriteString(strings.Join(row, ",") + "\n")
+	}
+	return buf.Bytes()
+}
+
+func TestServiceProcessor_ContentTyper(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(t, p.RegisterRESTHandler(func(msg *restMsgGET2) (*csvReply, error) {
+		return &csvReply{Rows: [][]string{{"x", strconv.Itoa(msg.X)}, {"y", "1"}}}, nil
+	}, "dummyService", "GET", 3, 3))
+
+	// The body is written as is, whatever the format asked by the client.
+	for _, path := range []string{"/v3/dummyService/restMsgGET2/42",
+		"/v3/dummyService/restMsgGET2/42?format=protobuf"} {
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+		require.Equal(t, "x,42\ny,1\n", w.Body.String())
+	}
+
+	buf, err := p.OpenAPISpec()
+	require.NoError(t, err)
+	require.Contains(t, string(buf), `"*/*"`)
+	require.NotContains(t, string(buf), "csvReply")
+}
+
+type ambiguousA struct{ Name string }
+type ambiguousB struct{ Name string }
+type ambiguousTagged struct {
+	Other string `json:"Name"`
+}
+type ambiguousWrapA struct{ ambiguousA }
+type ambiguousWrapB struct{ ambiguousA }
+
+type ambiguousMsg struct {
+	ambiguousA
+	ambiguousB
+}
+type ambiguousTwiceMsg struct {
+	ambiguousWrapA
+	ambiguousWrapB
+}
+type ambiguousNestedMsg struct {
+	Inner []ambiguousMsg
+}
+type shadowedMsg struct {
+	ambiguousA
+	ambiguousB
+	Name string
+}
+type ambiguousTaggedMsg struct {
+	ambiguousA
+	ambiguousTagged
+}
+type recursiveMsg struct {
+	*recursiveMsg
+	ambiguousWrapA
+	Next *recursiveMsg
+}
+
+func TestServiceProcessor_AmbiguousFields(t *testing.T) {
+	p := NewServiceProcessor(&Context{})
+	err := p.RegisterHandler(func(*ambiguousMsg) (*testMsg, error) { return nil, nil })
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "ambiguous field Name between ambiguousA.Name and ambiguousB.Name")
+	require.Error(t, p.RegisterHandler(func(*ambiguousTwiceMsg) (*testMsg, error) { return nil, nil }))
+	require.Error(t, p.RegisterHandler(func(*ambiguousNestedMsg) (*testMsg, error) { return nil, nil }))
+	require.Error(t, p.RegisterStreamingHandler(func(*ambiguousMsg) (chan *testMsg, chan bool, error) {
+		return nil, nil, nil
+	}))
+	require.Error(t, p.ReloadHandlers(map[string]interface{}{
+		"ambiguousMsg": func(*ambiguousMsg) (*testMsg, error) { return nil, nil },
+	}))
+	require.Error(t, p.RegisterHandlerWithName("ambiguous",
+		func(*ambiguousMsg) (*testMsg, error) { return nil, nil }))
+
+	require.NoError(t, p.RegisterHandler(func(*shadowedMsg) (*testMsg, error) { return nil, nil }))
+	require.NoError(t, p.RegisterHandler(func(*ambiguousTaggedMsg) (*testMsg, error) { return nil, nil }))
+	require.NoError(t, p.RegisterHandler(func(*recursiveMsg) (*testMsg, error) { return nil, nil }))
+}
+
+func TestServiceProcessor_RequireRoles(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+	require.NoError(t, p.RegisterHandler(procMsg))
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", 3, 3))
+
+	require.Error(t, p.RequireRoles("unknown", "indexer"))
+	require.NoError(t, p.RequireRoles("testMsg", "indexer"))
+	require.NoError(t, p.RequireRoles("restMsgGET2", "indexer", "archive"))
+
+	buf, err := protobuf.Encode(&testMsg{11})
+	require.NoError(t, err)
+	get := func() int {
+		r := httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/42", nil)
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w.Code
+	}
+
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	code, ok := statusErrorCode(err)
+	require.True(t, ok)
+	require.Equal(t, http.StatusNotFound, code)
+	require.Equal(t, http.StatusNotFound, get())
+
+	h1.SetRoles("indexer")
+	require.Equal(t, "indexer", h1.GetStatus().Field["Roles"])
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, get())
+	// Registering the route again keeps its roles.
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", 3, 3))
+	require.Equal(t, http.StatusNotFound, get())
+
+	h1.SetRoles("indexer", "archive")
+	require.True(t, h1.HasRoles("archive", "indexer"))
+	require.Equal(t, []string{"archive", "indexer"}, h1.Roles())
+	require.Equal(t, http.StatusOK, get())
+}
+
+func TestServiceProcessor_ReloadHandlers(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+
+	started := make(chan bool)
+	release := make(chan bool)
+	require.NoError(t, p.RegisterHandler(func(msg *testMsg) (*testMsg, error) {
+		started <- true
+		<-release
+		return &testMsg{msg.I}, nil
+	}))
+
+	buf, err := protobuf.Encode(&testMsg{11})
+	require.NoError(t, err)
+	call := func(name string) int64 {
+		rep, _, err := p.ProcessClientRequest(nil, name, buf)
+		require.NoError(t, err)
+		val := &testMsg{}
+		require.NoError(t, protobuf.Decode(rep, val))
+		return val.I
+	}
+
+	// The request in flight finishes with the old handler.
+	old := make(chan int64)
+	go func() { old <- call("testMsg") }()
+	<-started
+
+	require.Error(t, p.ReloadHandlers(map[string]interface{}{
+		"testMsg": func(*testMsg) error { return nil },
+	}))
+	require.Equal(t, []string{"testMsg"}, p.RegisteredHandlers())
+
+	require.NoError(t, p.ReloadHandlers(map[string]interface{}{
+		"testMsg": func(msg *testMsg) (*testMsg, error) {
+			return &testMsg{msg.I + 1}, nil
+		},
+		"withContext": func(_ context.Context, msg *testMsg) (*testMsg, error) {
+			return &testMsg{msg.I + 2}, nil
+		},
+		"streaming": func(*testMsg) (chan *testMsg, chan bool, error) {
+			return nil, nil, nil
+		},
+	}))
+	require.Equal(t, []string{"streaming", "testMsg", "withContext"}, p.RegisteredHandlers())
+	require.Equal(t, int64(12), call("testMsg"))
+	require.Equal(t, int64(13), call("withContext"))
+	streaming, err := p.IsStreaming("streaming")
+	require.NoError(t, err)
+	require.True(t, streaming)
+
+	close(release)
+	require.Equal(t, int64(11), <-old)
+}
+
+func TestServiceProcessor_ReloadHandlersKeepSettings(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+	require.NoError(t, p.RegisterHandlerWithTimeout(func(_ context.Context, msg *testMsg) (*testMsg, error) {
+		return msg, nil
+	}, time.Second))
+	require.NoError(t, p.RequireRoles("testMsg", "indexer"))
+
+	stream := func(*testMsg) (chan *testMsg, chan bool, error) { return nil, nil, nil }
+	require.NoError(t, p.ReloadHandlers(map[string]interface{}{
+		"testMsg": func(msg *testMsg) (*testMsg, error) { return msg, nil },
+		"flow": func(*testMsg, *StreamFlow) (chan *testMsg, chan bool, error) {
+			return nil, nil, nil
+		},
+		"metadata": func(*testMsg, *ConnMetadata) (chan *testMsg, chan bool, error) {
+			return nil, nil, nil
+		},
+		"bidirectional": func(*testMsg, <-chan *testMsg) (chan *testMsg, chan bool, error) {
+			return nil, nil, nil
+		},
+		"optional": func(msg *testMsg) (chan *testMsg, *testMsg, error) {
+			return nil, &testMsg{msg.I + 1}, nil
+		},
+		"streaming": stream,
+	}))
+
+	buf, err := protobuf.Encode(&testMsg{11})
+	require.NoError(t, err)
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	code, ok := statusErrorCode(err)
+	require.True(t, ok)
+	require.Equal(t, http.StatusNotFound, code)
+
+	p.handlersLock.RLock()
+	handlers := p.handlers
+	p.handlersLock.RUnlock()
+	require.Equal(t, time.Second, handlers["testMsg"].timeout)
+	require.True(t, handlers["flow"].streaming && handlers["flow"].flow)
+	require.True(t, handlers["metadata"].streaming && handlers["metadata"].metadata)
+	require.True(t, handlers["bidirectional"].streaming)
+	require.NotNil(t, handlers["bidirectional"].inType)
+	require.True(t, handlers["optional"].optional && !handlers["optional"].streaming)
+	require.True(t, handlers["streaming"].streaming)
+
+	out, tun, err := p.ProcessClientRequest(nil, "optional", buf)
+	require.NoError(t, err)
+	require.Nil(t, tun)
+	var reply testMsg
+	require.NoError(t, protobuf.Decode(out, &reply))
+	require.Equal(t, int64(12), reply.I)
+}
+
+func TestServiceProcessor_HandlerInfo(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(t, p.RegisterHandler(procMsg))
+	require.NoError(t, p.RegisterHandler(func(msg *testMsg2) (*restMsgGET2, error) {
+		return nil, nil
+	}))
+	require.NoError(t, p.RegisterHandlerWithName("noArgument", func() (*testMsg, error) {
+		return nil, nil
+	}))
+	require.NoError(t, p.RegisterStreamingHandler(func(m *restMsgGET1) (chan *testMsg, chan bool, error) {
+		return nil, nil, nil
+	}))
+	require.NoError(t, p.RegisterOptionalStreamingHandler(func(m *restMsgGET2) (chan *testMsg, *testMsg2, error) {
+		return nil, nil, nil
+	}))
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", 3, 3))
+
+	require.Equal(t, []HandlerInfo{
+		{Name: "noArgument", ResponseType: "onet.testMsg"},
+		{Name: "restMsgGET1", Streaming: true, RequestType: "onet.restMsgGET1",
+			ResponseType: "onet.testMsg"},
+		{Name: "restMsgGET2", OptionalStreaming: true, RequestType: "onet.restMsgGET2",
+			ResponseType: "onet.testMsg2", StreamType: "onet.testMsg"},
+		{Name: "testMsg", RequestType: "onet.testMsg"},
+		{Name: "testMsg2", RequestType: "onet.testMsg2", ResponseType: "onet.restMsgGET2"},
+	}, p.HandlerInfo())
+}
+
+func TestServiceProcessor_RegisterHandlerWithContext(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+
+	require.Error(t, p.RegisterHandlerWithContext(procMsg))
+	require.Error(t, p.RegisterHandlerWithContext(
+		func(int, *testMsg) (network.Message, error) { return nil, nil }))
+	require.Error(t, p.RegisterHandlerWithContext(
+		func(context.Context, testMsg) (network.Message, error) { return nil, nil }))
+
+	// The handler waits for the context to be done when I == 0.
+	require.NoError(t, p.RegisterHandlerWithContext(
+		func(ctx context.Context, msg *testMsg) (network.Message, error) {
+			if msg.I == 0 {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return msg, nil
+		}))
+
+	buf, err := protobuf.Encode(&testMsg{11})
+	require.NoError(t, err)
+	rep, _, err := p.ProcessClientRequest(nil, "testMsg", buf)
+	require.NoError(t, err)
+	val := &testMsg{}
+	require.NoError(t, protobuf.Decode(rep, val))
+	require.Equal(t, int64(11), val.I)
+
+	buf, err = protobuf.Encode(&testMsg{0})
+	require.NoError(t, err)
+
+	// cancelled by the request
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, _, err = p.ProcessClientRequest(req, "testMsg", buf)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), context.Canceled.Error())
+
+	// cancelled by the timeout
+	p.HandlerTimeout = 10 * time.Millisecond
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+}
+
 func TestServiceProcessor_ProcessClientRequest_Streaming(t *testing.T) {
 	h1 := NewLocalServer(tSuite, 2000)
 	defer h1.Close()
@@ -201,6 +891,118 @@ func TestServiceProcessor_ProcessClientRequest_Streaming(t *testing.T) {
 	close(inputChan)
 }
 
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(msg interface{}) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonCodec) Decode(buf []byte, msg interface{}) error {
+	return json.Unmarshal(buf, msg)
+}
+
+func TestServiceProcessor_Codec(t *testing.T) {
+	p := NewServiceProcessor(&Context{})
+	p.Codec = jsonCodec{}
+	require.NoError(t, p.RegisterHandler(procMsg))
+	require.NoError(t, p.RegisterStreamingHandler(func(m *testMsg2) (chan *testMsg2, chan bool, error) {
+		outChan := make(chan *testMsg2, 1)
+		outChan <- m
+		close(outChan)
+		return outChan, make(chan bool), nil
+	}))
+
+	rep, _, err := p.ProcessClientRequest(nil, "testMsg", []byte(`{"I": 11}`))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"I": 11}`, string(rep))
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", []byte(`{"I": "eleven"}`))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "decoding")
+
+	inputs := make(chan []byte, 1)
+	inputs <- []byte(`{"I": 12}`)
+	outChan, err := p.ProcessClientStreamRequest(nil, "testMsg2", inputs)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"I": 12}`, string(<-outChan))
+	close(inputs)
+}
+
+// panicCodec panics when it encodes a testMsg2 of I == 1.
+type panicCodec struct {
+	jsonCodec
+}
+
+func (c panicCodec) Encode(msg interface{}) ([]byte, error) {
+	if m, ok := msg.(*testMsg2); ok && m.I == 1 {
+		panic("cannot encode")
+	}
+	return c.jsonCodec.Encode(msg)
+}
+
+func TestServiceProcessor_StreamEncodePanic(t *testing.T) {
+	p := NewServiceProcessor(&Context{})
+	p.Codec = panicCodec{}
+	p.StreamStopGracePeriod = time.Second
+	stopped := make(chan bool)
+	require.NoError(t, p.RegisterStreamingHandler(func(m *testMsg2) (chan *testMsg2, chan bool, error) {
+		out := make(chan *testMsg2)
+		stop := make(chan bool)
+		go func() {
+			defer close(out)
+			for i := int64(0); ; i++ {
+				select {
+				case out <- &testMsg2{I: i}:
+				case <-stop:
+					close(stopped)
+					return
+				}
+			}
+		}()
+		return out, stop, nil
+	}))
+
+	inputs := make(chan []byte, 1)
+	inputs <- []byte(`{}`)
+	outChan, err := p.ProcessClientStreamRequest(nil, "testMsg2", inputs)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"I": 0}`, string(<-outChan))
+	// The stream stops at the message that panics, and the handler is
+	// told to stop.
+	_, ok := <-outChan
+	require.False(t, ok)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler wasn't stopped")
+	}
+	close(inputs)
+}
+
+func TestServiceProcessor_NegotiateJSON(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(t, p.RegisterHandler(procMsg))
+
+	// protobuf by default
+	buf, err := protobuf.Encode(&testMsg{I: 11})
+	require.NoError(t, err)
+	rep, _, err := p.ProcessClientRequest(httptest.NewRequest("GET", "/", nil), "testMsg", buf)
+	require.NoError(t, err)
+	require.Equal(t, buf, rep)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/json; q=0.9")
+	rep, _, err = p.ProcessClientRequest(req, "testMsg", []byte(`{"I": 12}`))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"I": 12}`, string(rep))
+
+	req = httptest.NewRequest("GET", "/?format=json", nil)
+	rep, _, err = p.ProcessClientRequest(req, "testMsg", []byte(`{"I": 13}`))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"I": 13}`, string(rep))
+}
+
 func TestServiceProcessor_ProcessClientStreamRequest(t *testing.T) {
 	h1 := NewLocalServer(tSuite, 2000)
 	defer h1.Close()
@@ -304,6 +1106,60 @@ func TestServiceProcessor_ProcessClientStreamRequest_close_early(t *testing.T) {
 	require.NoError(t, err)
 }
 
+// A streaming handler ignoring its closeChan must block once its client went
+// away, instead of producing messages nobody reads.
+func TestServiceProcessor_ProcessClientStreamRequest_ignoredStop(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	srv := local.GenServers(1)[0]
+
+	p := NewServiceProcessor(&Context{server: srv})
+	p.StreamStopGracePeriod = 50 * time.Millisecond
+
+	var sent int64
+	stopped := make(chan bool)
+	// quit ends the handler at the end of the test.
+	quit := make(chan bool)
+	h := func(m *testMsg) (chan network.Message, chan bool, error) {
+		outChan := make(chan network.Message)
+		closeChan := make(chan bool)
+		go func() {
+			for {
+				select {
+				case outChan <- m:
+					atomic.AddInt64(&sent, 1)
+				case <-quit:
+					return
+				}
+			}
+		}()
+		go func() {
+			<-closeChan
+			close(stopped)
+		}()
+		return outChan, closeChan, nil
+	}
+	require.NoError(t, p.RegisterStreamingHandler(h))
+
+	clientInputs := make(chan []byte, 1)
+	buf, err := protobuf.Encode(&testMsg{1})
+	require.NoError(t, err)
+	clientInputs <- buf
+	outChan, err := p.ProcessClientStreamRequest(nil, "testMsg", clientInputs)
+	require.NoError(t, err)
+	<-outChan
+
+	// The client goes away without reading the other messages.
+	close(clientInputs)
+	<-stopped
+	for range outChan {
+	}
+	n := atomic.LoadInt64(&sent)
+	time.Sleep(100 * time.Millisecond)
+	require.True(t, atomic.LoadInt64(&sent) <= n+1)
+	close(quit)
+}
+
 func TestProcessor_ProcessClientRequest(t *testing.T) {
 	local := NewTCPTest(tSuite)
 
@@ -336,6 +1192,318 @@ func TestProcessor_PanicClientRequest(t *testing.T) {
 	require.Contains(t, err.Error(), "deadbeef")
 }
 
+func TestServiceProcessor_RegisterHandlerWithTimeout(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+
+	handler := func(ctx context.Context, msg *testMsg) (network.Message, error) {
+		return msg, nil
+	}
+	require.Error(t, p.RegisterHandlerWithTimeout(handler, 0))
+	require.Error(t, p.RegisterHandlerWithTimeout(procMsg, time.Second))
+
+	// The handler ignores its context when I == 0 and only returns once
+	// released, but observes the cancellation when I == 1.
+	release := make(chan bool)
+	cancelled := make(chan error, 1)
+	require.NoError(t, p.RegisterHandlerWithTimeout(
+		func(ctx context.Context, msg *testMsg) (network.Message, error) {
+			switch msg.I {
+			case 0:
+				<-release
+			case 1:
+				<-ctx.Done()
+				cancelled <- ctx.Err()
+				return nil, ctx.Err()
+			}
+			return msg, nil
+		}, 50*time.Millisecond))
+	// The timeout of the handler has precedence.
+	p.HandlerTimeout = time.Hour
+
+	buf, err := protobuf.Encode(&testMsg{11})
+	require.NoError(t, err)
+	rep, _, err := p.ProcessClientRequest(nil, "testMsg", buf)
+	require.NoError(t, err)
+	val := &testMsg{}
+	require.NoError(t, protobuf.Decode(rep, val))
+	require.Equal(t, int64(11), val.I)
+
+	buf, err = protobuf.Encode(&testMsg{0})
+	require.NoError(t, err)
+	start := time.Now()
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+	require.True(t, time.Since(start) < 5*time.Second)
+	close(release)
+
+	buf, err = protobuf.Encode(&testMsg{1})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, _, err = p.ProcessClientRequest(req, "testMsg", buf)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), context.Canceled.Error())
+	require.Equal(t, context.Canceled, <-cancelled)
+}
+
+func TestServiceProcessor_PanicRecovery(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+	require.NoError(t, p.RegisterHandler(func(*testPanicMsg) (network.Message, error) {
+		panic("deadbeef")
+	}))
+
+	buf, err := protobuf.Encode(&testPanicMsg{})
+	require.NoError(t, err)
+	log.OutputToBuf()
+	_, _, err = p.ProcessClientRequest(nil, "testPanicMsg", buf)
+	log.OutputToOs()
+	require.Error(t, err)
+	require.True(t, isPanicError(err))
+	require.Contains(t, err.Error(), "deadbeef")
+
+	p.DisablePanicRecovery = true
+	require.Panics(t, func() {
+		p.ProcessClientRequest(nil, "testPanicMsg", buf)
+	})
+}
+
+func TestServiceProcessor_StatusError(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+	require.NoError(t, p.RegisterRESTHandler(func(msg *restMsgGET2) (*testMsg, error) {
+		switch msg.X {
+		case 1:
+			return nil, StatusError{Code: http.StatusNotFound, Msg: "not found"}
+		case 2:
+			return nil, &StatusError{Code: http.StatusConflict, Msg: "conflict"}
+		case 3:
+			return nil, StatusError{Code: http.StatusOK, Msg: "not an error code"}
+		}
+		return nil, xerrors.New("plain error")
+	}, "dummyService", "GET", 3, 3))
+
+	for x, code := range []int{http.StatusBadRequest, http.StatusNotFound,
+		http.StatusConflict, http.StatusBadRequest} {
+		r := httptest.NewRequest("GET", fmt.Sprintf("/v3/dummyService/restMsgGET2/%d", x), nil)
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		require.Equal(t, code, w.Code)
+	}
+
+	// The code is kept through the wrapping of the error.
+	err := xerrors.Errorf("wrapped: %w", StatusError{Code: http.StatusNotFound})
+	code, ok := statusErrorCode(err)
+	require.True(t, ok)
+	require.Equal(t, http.StatusNotFound, code)
+	_, ok = statusErrorCode(xerrors.New("plain error"))
+	require.False(t, ok)
+}
+
+// detailedError is a StatusError with details for the clients.
+type detailedError struct {
+	StatusError
+	fields []string
+}
+
+func (e detailedError) Unwrap() error {
+	return e.StatusError
+}
+
+func (e detailedError) ErrorDetails() interface{} {
+	return map[string][]string{"fields": e.fields}
+}
+
+func TestServiceProcessor_ErrorBody(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+	require.NoError(t, p.RegisterRESTHandler(func(msg *restMsgGET2) (*testMsg, error) {
+		if msg.X == 1 {
+			return nil, detailedError{StatusError{Code: http.StatusUnprocessableEntity,
+				Msg: "invalid fields"}, []string{"A", "B"}}
+		}
+		return nil, xerrors.New("plain error")
+	}, "dummyService", "GET", 3, 3))
+
+	get := func(x int) (int, map[string]interface{}) {
+		r := httptest.NewRequest("GET", fmt.Sprintf("/v3/dummyService/restMsgGET2/%d", x), nil)
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		body := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	code, body := get(0)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Equal(t, "processing error: plain error", body["error"])
+	require.Equal(t, "processing error processing error: plain error", body["message"])
+	require.Equal(t, float64(http.StatusBadRequest), body["code"])
+	require.NotContains(t, body, "details")
+
+	code, body = get(1)
+	require.Equal(t, http.StatusUnprocessableEntity, code)
+	require.Equal(t, float64(http.StatusUnprocessableEntity), body["code"])
+	require.Equal(t, map[string]interface{}{"fields": []interface{}{"A", "B"}}, body["details"])
+}
+
+func TestServiceProcessor_WrapHandlerErrors(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+	require.NoError(t, p.RegisterHandler(func(msg *testMsg) (*testMsg, error) {
+		return nil, StatusError{Code: http.StatusNotFound, Msg: "not found"}
+	}))
+	require.NoError(t, p.RegisterRESTHandler(func(msg *restMsgGET2) (*testMsg, error) {
+		return nil, StatusError{Code: http.StatusConflict, Msg: "conflict"}
+	}, "dummyService", "GET", 3, 3))
+
+	buf, err := protobuf.Encode(&testMsg{11})
+	require.NoError(t, err)
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	require.Equal(t, "processing error: not found", err.Error())
+
+	p.WrapHandlerErrors = true
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-Id", "42")
+	_, _, err = p.ProcessClientRequest(r, "testMsg", buf)
+	require.Equal(t, "handler testMsg, request 42: processing error: not found", err.Error())
+	code, ok := statusErrorCode(err)
+	require.True(t, ok)
+	require.Equal(t, http.StatusNotFound, code)
+
+	r = httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/1", nil)
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, r)
+	require.Equal(t, http.StatusConflict, w.Code)
+	// The REST requests always have an ID.
+	require.Contains(t, w.Body.String(), "handler restMsgGET2, request "+
+		w.Header().Get(RequestIDHeader)+": processing error: conflict")
+}
+
+func TestServiceProcessor_PartialResults(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+	require.NoError(t, p.RegisterHandler(func(msg *testMsg) (*testMsg, error) {
+		return &testMsg{msg.I}, xerrors.New("stopped early")
+	}))
+	require.NoError(t, p.RegisterRESTHandler(func(msg *restMsgGET2) (*testMsg, error) {
+		return &testMsg{int64(msg.X)}, StatusError{Code: http.StatusServiceUnavailable, Msg: "stopped early"}
+	}, "dummyService", "GET", 3, 3))
+
+	buf, err := protobuf.Encode(&testMsg{11})
+	require.NoError(t, err)
+	rep, _, err := p.ProcessClientRequest(nil, "testMsg", buf)
+	require.Error(t, err)
+	require.Nil(t, rep)
+
+	p.PartialResults = true
+	rep, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	require.Error(t, err)
+	var pe PartialError
+	require.True(t, xerrors.As(err, &pe))
+	require.Equal(t, &testMsg{11}, pe.Reply)
+	val := &testMsg{}
+	require.NoError(t, protobuf.Decode(rep, val))
+	require.Equal(t, int64(11), val.I)
+
+	r := httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/42", nil)
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, r)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var body struct {
+		Message string
+		Partial testMsg
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	require.Equal(t, "internal error processing error: stopped early", body.Message)
+	require.Equal(t, int64(42), body.Partial.I)
+}
+
+func TestServiceProcessor_RegisterStreamingRESTHandler(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+
+	stopped := make(chan bool, 1)
+	f := func(msg *restMsgGET2) (chan *testMsg, chan bool, error) {
+		out := make(chan *testMsg)
+		stop := make(chan bool)
+		go func() {
+			defer close(out)
+			for i := 0; msg.X == 0 || i < msg.X; i++ {
+				select {
+				case out <- &testMsg{int64(i)}:
+				case <-stop:
+					stopped <- true
+					return
+				}
+			}
+		}()
+		return out, stop, nil
+	}
+	require.Error(t, p.RegisterStreamingRESTHandler(procMsg, "dummyService", 3, 3))
+	require.NoError(t, p.RegisterStreamingRESTHandler(f, "dummyService", 3, 3))
+
+	r := httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/3", nil)
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	require.Equal(t, "data: {\"I\":0}\n\ndata: {\"I\":1}\n\ndata: {\"I\":2}\n\n", w.Body.String())
+
+	// An infinite stream is stopped when the client goes away.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	r = httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/0", nil).WithContext(ctx)
+	w = httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "handler has not been stopped")
+	}
+}
+
+func TestServiceProcessor_HandleFunc(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+
+	require.NoError(t, p.HandleFunc("/dummyService-custom/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("custom " + r.URL.Path))
+	}))
+	require.NoError(t, p.Handle("/dummyService-metrics", http.NotFoundHandler()))
+
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, httptest.NewRequest("GET", "/dummyService-custom/file", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "custom /dummyService-custom/file", w.Body.String())
+	w = httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, httptest.NewRequest("GET", "/dummyService-metrics", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	// The existing routes are not clobbered.
+	require.Error(t, p.HandleFunc("/dummyService-custom/", http.NotFound))
+	require.Error(t, p.Handle("/ok", http.NotFoundHandler()))
+	require.Error(t, p.Handle("", http.NotFoundHandler()))
+	require.Error(t, p.HandleFunc("/dummyService-nil", nil))
+	require.Error(t, p.Handle("/dummyService-nil", nil))
+	w = httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, httptest.NewRequest("GET", "/ok", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
 type testMsg struct {
 	I int64
 }
@@ -616,6 +1784,31 @@ func TestProcessor_REST_Handler(t *testing.T) {
 	require.NoError(t, json.NewDecoder(resp.Body).Decode(&msg))
 	require.Equal(t, int64(0xde), msg.I)
 
+	// head mirrors get without the body
+	for _, path := range []string{"restMsgGET1", "restMsgGET2/99", "restMsgGET3/deadbeef"} {
+		do := func(method string) *http.Response {
+			req, err := http.NewRequest(method, addr+"/v3/testService/"+path, nil)
+			require.NoError(t, err)
+			req.Header.Set("Accept-Encoding", "identity")
+			resp, err := c.Do(req)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			return resp
+		}
+		resp = do("GET")
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp = do("HEAD")
+		require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		require.Equal(t, int64(len(body)), resp.ContentLength)
+		head, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Empty(t, head)
+	}
+	resp, err = c.Head(addr + "/v3/testService/restMsgPOSTString")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
 	// wrong url
 	// NOTE: the error code is 400 because the websocket upgrade failed
 	// usually it should be http.StatusNotFound
@@ -629,6 +1822,14 @@ func TestProcessor_REST_Handler(t *testing.T) {
 	require.Equal(t, resp.StatusCode, http.StatusNotFound)
 	checkJSONMsg(t, resp.Body, "invalid path")
 
+	// unexpected segments after an empty get
+	for _, path := range []string{"restMsgGET1/", "restMsgGET1/extra", "restMsgGET1/extra/more"} {
+		resp, err = c.Get(addr + "/v3/testService/" + path)
+		require.NoError(t, err)
+		require.Equal(t, resp.StatusCode, http.StatusNotFound)
+		checkJSONMsg(t, resp.Body, "invalid path")
+	}
+
 	// wrong encoding of integer
 	resp, err = c.Get(addr + "/v3/testService/restMsgGET2/one")
 	require.NoError(t, err)
@@ -650,7 +1851,8 @@ func TestProcessor_REST_Handler(t *testing.T) {
 	// wrong content type
 	resp, err = c.Post(addr+"/v3/testService/restMsgPOSTString", "application/text", bytes.NewReader([]byte(`{"S": "42"}`)))
 	require.NoError(t, err)
-	require.Equal(t, resp.StatusCode, http.StatusBadRequest)
+	require.Equal(t, resp.StatusCode, http.StatusUnsupportedMediaType)
+	require.Equal(t, "application/json", resp.Header.Get("Accept-Post"))
 	checkJSONMsg(t, resp.Body, "content type needs to be application/json")
 
 	// wrong value in body
@@ -761,3 +1963,133 @@ type restMsgPOSTString struct {
 type restMsgPOSTPoint struct {
 	bnPoint
 }
+
+func TestServiceProcessor_RegisterUploadHandler(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+
+	upload := func(body io.Reader) (*testMsg, error) {
+		n, err := io.Copy(ioutil.Discard, body)
+		if err != nil {
+			return nil, err
+		}
+		return &testMsg{n}, nil
+	}
+	require.Error(t, p.RegisterUploadHandler(upload, "dummyService", "upload", "GET", 3, 3, 10))
+	require.Error(t, p.RegisterUploadHandler(upload, "dummyService", "", "POST", 3, 3, 10))
+	require.Error(t, p.RegisterUploadHandler(upload, "dummyService", "upload", "POST", 3, 3, 0))
+	require.Error(t, p.RegisterUploadHandler(procMsg, "dummyService", "upload", "POST", 3, 3, 10))
+	require.NoError(t, p.RegisterUploadHandler(upload, "dummyService", "upload", "POST", 3, 3, 10))
+
+	post := func(body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/v3/dummyService/upload", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w
+	}
+
+	w := post(make([]byte, 10))
+	require.Equal(t, http.StatusOK, w.Code)
+	msg := testMsg{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &msg))
+	require.Equal(t, int64(10), msg.I)
+
+	w = post(make([]byte, 11))
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestServiceProcessor_MaxBodySize(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgPOSTString, "dummyService", "POST", 3, 3))
+
+	post := func(body []byte) int {
+		r := httptest.NewRequest("POST", "/v3/dummyService/restMsgPOSTString", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w.Code
+	}
+	big := []byte(`{"S": "42", "Padding": "` + strings.Repeat("x", 2048) + `"}`)
+
+	require.Equal(t, http.StatusOK, post(big))
+	p.MaxBodySize = 1024
+	require.Equal(t, http.StatusRequestEntityTooLarge, post(big))
+	require.Equal(t, http.StatusOK, post([]byte(`{"S": "42"}`)))
+	p.MaxBodySize = -1
+	require.Equal(t, http.StatusOK, post(big))
+}
+
+func TestServiceProcessor_SuiteConstructors(t *testing.T) {
+	p := NewServiceProcessor(&Context{})
+	ed := p.suiteConstructors(tSuite)
+	require.Equal(t, reflect.ValueOf(ed).Pointer(),
+		reflect.ValueOf(p.suiteConstructors(suites.MustFind("Ed25519"))).Pointer())
+
+	// Another suite has its own constructors.
+	var point kyber.Point
+	pointType := reflect.TypeOf(&point).Elem()
+	pairing := p.suiteConstructors(pairingSuite)
+	require.NotEqual(t, reflect.ValueOf(ed).Pointer(), reflect.ValueOf(pairing).Pointer())
+	require.NotEqual(t, reflect.TypeOf(ed[pointType]()), reflect.TypeOf(pairing[pointType]()))
+	require.Empty(t, p.suiteConstructors(nil))
+}
+
+type pointMsg struct {
+	P kyber.Point
+}
+
+func TestServiceProcessor_SuiteForRequest(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(t, p.RegisterHandler(func(msg *pointMsg) (*testMsg, error) {
+		return &testMsg{int64(msg.P.MarshalSize())}, nil
+	}))
+	p.SuiteForRequest = func(req *http.Request) network.Suite {
+		if req != nil && req.Header.Get("X-Suite") == pairingSuite.String() {
+			return pairingSuite
+		}
+		return nil
+	}
+
+	point := pairingSuite.Point().Pick(pairingSuite.RandomStream())
+	buf, err := protobuf.Encode(&pointMsg{point})
+	require.NoError(t, err)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Suite", pairingSuite.String())
+	rep, _, err := p.ProcessClientRequest(req, "pointMsg", buf)
+	require.NoError(t, err)
+	reply := &testMsg{}
+	require.NoError(t, protobuf.Decode(rep, reply))
+	require.Equal(t, int64(point.MarshalSize()), reply.I)
+
+	// Without the header, the suite of the server is used.
+	point = tSuite.Point().Pick(tSuite.RandomStream())
+	buf, err = protobuf.Encode(&pointMsg{point})
+	require.NoError(t, err)
+	rep, _, err = p.ProcessClientRequest(nil, "pointMsg", buf)
+	require.NoError(t, err)
+	require.NoError(t, protobuf.Decode(rep, reply))
+	require.Equal(t, int64(point.MarshalSize()), reply.I)
+}
+
+func BenchmarkServiceProcessor_ProcessClientRequest(b *testing.B) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(b, p.RegisterHandler(procMsg))
+	buf, err := protobuf.Encode(&testMsg{11})
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, err := p.ProcessClientRequest(nil, "testMsg", buf)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package onet
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// ProtoDefinition returns a proto2 definition of the handlers of the
+// ServiceProcessor as a gRPC service called serviceName, in the package pkg.
+// Every handler is a method of the service taking its message and returning
+// its reply, which is streamed for the streaming handlers. The messages are
+// described the way go.dedis.ch/protobuf encodes them, the interfaces such
+// as kyber.Point being encoded as bytes.
+//
+// The handlers that return an interface have no fixed reply type, so their
+// method is only given as a comment.
+func (p *ServiceProcessor) ProtoDefinition(pkg, serviceName string) (string, error) {
+	p.handlersLock.RLock()
+	names := make([]string, 0, len(p.handlers))
+	for name := range p.handlers {
+		names = append(names, name)
+	}
+	handlers := make(map[string]serviceHandler, len(p.handlers))
+	for name, mh := range p.handlers {
+		handlers[name] = mh
+	}
+	p.handlersLock.RUnlock()
+	sort.Strings(names)
+
+	g := newProtoGenerator()
+	var rpcs bytes.Buffer
+	for _, name := range names {
+		mh := handlers[name]
+		if err := g.addMessage(mh.msgType); err != nil {
+			return "", xerrors.Errorf("message of %s: %v", name, err)
+		}
+
+		reply := reflect.TypeOf(mh.handler).Out(0)
+		stream := ""
+		if mh.streaming {
+			reply = reply.Elem()
+			stream = "stream "
+		}
+		if reply.Kind() == reflect.Interface {
+			fmt.Fprintf(&rpcs, "    // rpc %s(%s) returns (%s?); reply type unknown\n",
+				name, mh.msgType.Name(), stream)
+			continue
+		}
+		reply = reply.Elem()
+		if err := g.addMessage(reply); err != nil {
+			return "", xerrors.Errorf("reply of %s: %v", name, err)
+		}
+		fmt.Fprintf(&rpcs, "    rpc %s(%s) returns (%s%s);\n",
+			name, mh.msgType.Name(), stream, reply.Name())
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "syntax = \"proto2\";\n\npackage %s;\n\n", pkg)
+	fmt.Fprintf(&out, "service %s {\n%s}\n", serviceName, rpcs.String())
+	for _, msg := range g.sortedNames() {
+		fmt.Fprintf(&out, "\nmessage %s {\n%s}\n", msg, g.messages[msg])
+	}
+	return out.String(), nil
+}
+
+// protoGenerator collects the definitions of the messages reachable from
+// the handlers.
+type protoGenerator struct {
+	// types detects two different types with the same name.
+	types    map[string]reflect.Type
+	messages map[string]string
+	namer    protobuf.DefaultGeneratorNamer
+}
+
+func newProtoGenerator() *protoGenerator {
+	return &protoGenerator{
+		types:    make(map[string]reflect.Type),
+		messages: make(map[string]string),
+	}
+}
+
+func (g *protoGenerator) sortedNames() []string {
+	names := make([]string, 0, len(g.messages))
+	for name := range g.messages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addMessage adds the definition of the struct t and of the structs of its
+// fields.
+func (g *protoGenerator) addMessage(t reflect.Type) error {
+	if t.Kind() != reflect.Struct || t.Name() == "" {
+		return xerrors.Errorf("%v is not a named struct", t)
+	}
+	if other, ok := g.types[t.Name()]; ok {
+		if other != t {
+			return xerrors.Errorf("%v and %v have the same name", t, other)
+		}
+		return nil
+	}
+	g.types[t.Name()] = t
+
+	var def bytes.Buffer
+	for _, f := range protobuf.ProtoFields(t) {
+		if f.Field.PkgPath != "" {
+			// unexported fields are not encoded
+			continue
+		}
+		typ, err := g.fieldType(f)
+		if err != nil {
+			return xerrors.Errorf("field %s of %s: %v", f.Field.Name, t.Name(), err)
+		}
+		fmt.Fprintf(&def, "    %s %s = %d;\n", typ, g.namer.FieldName(*f), f.ID)
+	}
+	g.messages[t.Name()] = def.String()
+	return nil
+}
+
+// fieldType returns the label and the type of a field.
+func (g *protoGenerator) fieldType(f *protobuf.ProtoField) (string, error) {
+	t := f.Field.Type
+	label := "required "
+	if f.Prefix == protobuf.TagOptional || t.Kind() == reflect.Ptr {
+		label = "optional "
+	}
+
+	switch {
+	case isBytes(t):
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		label = "repeated "
+		t = t.Elem()
+	case t.Kind() == reflect.Map:
+		label = ""
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	typ, err := g.typeName(t)
+	if err != nil {
+		return "", err
+	}
+	return label + typ, nil
+}
+
+// typeName returns the protobuf type of t, adding the definition of t if it
+// is a struct.
+func (g *protoGenerator) typeName(t reflect.Type) (string, error) {
+	if isBytes(t) {
+		return "bytes", nil
+	}
+	if t.PkgPath() == "time" {
+		switch t.Name() {
+		case "Time":
+			return "sfixed64", nil
+		case "Duration":
+			return "sint64", nil
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Float64:
+		return "double", nil
+	case reflect.Float32:
+		return "float", nil
+	case reflect.Int32:
+		return "sint32", nil
+	case reflect.Int, reflect.Int64:
+		return "sint64", nil
+	case reflect.Bool:
+		return "bool", nil
+	case reflect.Uint32:
+		return "uint32", nil
+	case reflect.Uint, reflect.Uint64:
+		return "uint64", nil
+	case reflect.String:
+		return "string", nil
+	case reflect.Interface:
+		// interfaces are marshalled to their binary representation
+		return "bytes", nil
+	case reflect.Struct:
+		if err := g.addMessage(t); err != nil {
+			return "", err
+		}
+		return t.Name(), nil
+	case reflect.Map:
+		key, err := g.typeName(t.Key())
+		if err != nil {
+			return "", err
+		}
+		elem := t.Elem()
+		if !isBytes(elem) && elem.Kind() == reflect.Slice {
+			elem = elem.Elem()
+		}
+		for elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		val, err := g.typeName(elem)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("map<%s, %s>", key, val), nil
+	}
+	return "", xerrors.Errorf("unsupported type %v", t)
+}
+
+// isBytes returns true if t is a slice or an array of bytes.
+func isBytes(t reflect.Type) bool {
+	return (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) &&
+		t.Elem().Kind() == reflect.Uint8
+}
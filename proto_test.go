@@ -0,0 +1,88 @@
+package onet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3/network"
+)
+
+type protoRequest struct {
+	ID     []byte
+	Count  int
+	Nested *protoNested
+	Points []kyber.Point
+}
+
+type protoNested struct {
+	Names  []string
+	Scores map[string]int32
+	Flag   bool `protobuf:"opt"`
+}
+
+type protoUnsupported struct {
+	C chan int
+}
+
+type protoReply struct {
+	Nested protoNested
+}
+
+func TestServiceProcessor_ProtoDefinition(t *testing.T) {
+	p := NewServiceProcessor(&Context{})
+
+	require.NoError(t, p.RegisterHandler(func(*protoRequest) (*protoReply, error) {
+		return nil, nil
+	}))
+	require.NoError(t, p.RegisterStreamingHandler(func(*testMsg) (chan *protoNested, chan bool, error) {
+		return nil, nil, nil
+	}))
+	require.NoError(t, p.RegisterHandler(func(*testMsg2) (network.Message, error) {
+		return nil, nil
+	}))
+
+	def, err := p.ProtoDefinition("test", "Test")
+	require.NoError(t, err)
+	require.Equal(t, `syntax = "proto2";
+
+package test;
+
+service Test {
+    rpc protoRequest(protoRequest) returns (protoReply);
+    rpc testMsg(testMsg) returns (stream protoNested);
+    // rpc testMsg2(testMsg2) returns (?); reply type unknown
+}
+
+message protoNested {
+    repeated string names = 1;
+    map<string, sint32> scores = 2;
+    optional bool flag = 3;
+}
+
+message protoReply {
+    required protoNested nested = 1;
+}
+
+message protoRequest {
+    required bytes id = 1;
+    required sint64 count = 2;
+    optional protoNested nested = 3;
+    repeated bytes points = 4;
+}
+
+message testMsg {
+    required sint64 i = 1;
+}
+
+message testMsg2 {
+    required sint64 i = 1;
+}
+`, def)
+
+	require.NoError(t, p.RegisterHandler(func(*protoUnsupported) (*testMsg, error) {
+		return nil, nil
+	}))
+	_, err = p.ProtoDefinition("test", "Test")
+	require.Error(t, err)
+}
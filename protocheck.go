@@ -0,0 +1,78 @@
+package onet
+
+import (
+	"encoding"
+	"reflect"
+
+	"golang.org/x/xerrors"
+)
+
+var binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+
+// checkReplyTypes returns an error if a reply of the handler f, or a message
+// of its channels, can't be encoded by protobuf, when CheckReplyTypes is set
+// and the default Codec is used. The interfaces are not checked, as the
+// types behind them are only known when the replies are encoded.
+func (p *ServiceProcessor) checkReplyTypes(f interface{}) error {
+	if !p.CheckReplyTypes || p.Codec != nil {
+		return nil
+	}
+	ft := reflect.TypeOf(f)
+	for i := 0; i < ft.NumOut(); i++ {
+		t := ft.Out(i)
+		if t.Kind() == reflect.Chan {
+			t = t.Elem()
+		}
+		if err := checkProtobufType(t, t.String(), make(map[reflect.Type]bool)); err != nil {
+			return xerrors.Errorf("reply not encodable by protobuf: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkProtobufType returns an error pointing at the field of t, at path,
+// whose type is not supported by protobuf.
+func checkProtobufType(t reflect.Type, path string, visited map[reflect.Type]bool) error {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int32, reflect.Int64,
+		reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64,
+		reflect.String, reflect.Interface:
+		return nil
+	case reflect.Ptr:
+		return checkProtobufType(t.Elem(), path, visited)
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		return checkProtobufType(t.Elem(), path+"[]", visited)
+	case reflect.Map:
+		if err := checkProtobufType(t.Key(), path+"[key]", visited); err != nil {
+			return err
+		}
+		v := t.Elem()
+		if (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) &&
+			v.Elem().Kind() != reflect.Uint8 {
+			return xerrors.Errorf("%s: maps only support []byte as repeated value, not %s",
+				path, v)
+		}
+		return checkProtobufType(v, path+"[value]", visited)
+	case reflect.Struct:
+		// The types encoding themselves, such as time.Time, are not
+		// inspected.
+		if visited[t] || t.Implements(binaryMarshalerType) {
+			return nil
+		}
+		visited[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			if err := checkProtobufType(field.Type, path+"."+field.Name, visited); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return xerrors.Errorf("%s: unsupported type %s", path, t)
+}
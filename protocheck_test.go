@@ -0,0 +1,51 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type encReplyInner struct {
+	Counts map[string]uint16
+}
+
+type encReply struct {
+	When  time.Time
+	Inner []*encReplyInner
+}
+
+type encChanReply struct {
+	Callback func()
+}
+
+type encMapReply struct {
+	Lists map[string][]string
+}
+
+func TestServiceProcessor_CheckReplyTypes(t *testing.T) {
+	p := NewServiceProcessor(&Context{})
+	h := func(*testMsg) (*encReply, error) { return nil, nil }
+	require.NoError(t, p.RegisterHandler(h))
+
+	p.CheckReplyTypes = true
+	require.NoError(t, p.RegisterHandler(procMsg))
+	err := p.RegisterHandler(h)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Inner[].Counts[value]: unsupported type uint16")
+
+	err = p.RegisterStreamingHandler(func(*testMsg2) (chan *encChanReply, chan bool, error) {
+		return nil, nil, nil
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Callback: unsupported type func()")
+
+	err = p.RegisterHandlerWithName("maps", func(*testMsg3) (*encMapReply, error) { return nil, nil })
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Lists")
+
+	// A custom Codec may encode anything.
+	p.Codec = jsonCodec{}
+	require.NoError(t, p.RegisterHandler(h))
+}
@@ -0,0 +1,167 @@
+package onet
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+// RateLimiter throttles the requests of the clients. Allow is called with the
+// key of the client before its request is decoded, and the request is
+// rejected if it returns false.
+type RateLimiter interface {
+	Allow(key string) bool
+}
+
+// RemoteAddrKey is the default key of the clients for the RateLimiter: the
+// IP address the request comes from.
+func RemoteAddrKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// checkRateLimit returns a StatusError with the code 429 if the client of
+// the request exceeds the rate limit. The requests that don't come from HTTP
+// are not limited.
+func (p *ServiceProcessor) checkRateLimit(r *http.Request) error {
+	if p.RateLimiter == nil || r == nil {
+		return nil
+	}
+	key := RemoteAddrKey
+	if p.RateLimitKey != nil {
+		key = p.RateLimitKey
+	}
+	if !p.RateLimiter.Allow(key(r)) {
+		return StatusError{Code: http.StatusTooManyRequests, Msg: "rate limit exceeded"}
+	}
+	return nil
+}
+
+// tokenBucketLimiter is a RateLimiter with a token bucket per client.
+type tokenBucketLimiter struct {
+	sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucketLimiter returns a RateLimiter allowing every client rate
+// requests per second on average, and bursts of up to burst requests.
+func NewTokenBucketLimiter(rate float64, burst int) RateLimiter {
+	return &tokenBucketLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow implements RateLimiter.
+func (l *tokenBucketLimiter) Allow(key string) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now, l.rate, l.burst)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep removes the buckets that are full again, which are the same as new
+// ones, so that the memory doesn't grow with the number of clients seen.
+func (l *tokenBucketLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		b.refill(now, l.rate, l.burst)
+		if b.tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
+
+// KVStore is a key-value store shared by the conodes of a cluster, such as
+// redis or etcd, used to enforce the rate limits cluster-wide.
+type KVStore interface {
+	// Incr increments the counter stored at key, creating it with the value
+	// 1 if it doesn't exist, and returns its new value. A new counter
+	// expires after ttl.
+	Incr(key string, ttl time.Duration) (int64, error)
+}
+
+// kvLimiter is a RateLimiter counting the requests of every client in a
+// KVStore, over fixed windows of time.
+type kvLimiter struct {
+	store     KVStore
+	limit     int64
+	window    time.Duration
+	failClose bool
+	now       func() time.Time
+}
+
+// NewKVStoreLimiter returns a RateLimiter allowing every client limit
+// requests per window, counted in store so that the limit holds for the
+// requests sent to all the conodes sharing the store. If the store cannot be
+// reached, the requests are allowed, unless failClose is true. It returns an
+// error if limit or window is not positive.
+func NewKVStoreLimiter(store KVStore, limit int, window time.Duration, failClose bool) (RateLimiter, error) {
+	if limit <= 0 {
+		return nil, xerrors.New("limit must be positive")
+	}
+	if window <= 0 {
+		return nil, xerrors.New("window must be positive")
+	}
+	return &kvLimiter{
+		store:     store,
+		limit:     int64(limit),
+		window:    window,
+		failClose: failClose,
+		now:       time.Now,
+	}, nil
+}
+
+// Allow implements RateLimiter.
+func (l *kvLimiter) Allow(key string) bool {
+	index := l.now().UnixNano() / int64(l.window)
+	count, err := l.store.Incr(fmt.Sprintf("ratelimit/%s/%d", key, index), l.window)
+	if err != nil {
+		log.Warnf("rate limiter store unavailable: %v", err)
+		return !l.failClose
+	}
+	return count <= l.limit
+}
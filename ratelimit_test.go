@@ -0,0 +1,123 @@
+package onet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+func TestTokenBucketLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewTokenBucketLimiter(2, 3).(*tokenBucketLimiter)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		require.True(t, l.Allow("a"))
+	}
+	require.False(t, l.Allow("a"))
+	require.True(t, l.Allow("b"))
+
+	now = now.Add(500 * time.Millisecond)
+	require.True(t, l.Allow("a"))
+	require.False(t, l.Allow("a"))
+
+	// The full buckets are forgotten.
+	now = now.Add(time.Hour)
+	require.True(t, l.Allow("a"))
+	require.Len(t, l.buckets, 1)
+}
+
+// memKVStore is a KVStore shared by the limiters of the test.
+type memKVStore struct {
+	counters map[string]int64
+	down     bool
+}
+
+func (s *memKVStore) Incr(key string, ttl time.Duration) (int64, error) {
+	if s.down {
+		return 0, xerrors.New("store down")
+	}
+	s.counters[key]++
+	return s.counters[key], nil
+}
+
+func TestKVStoreLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	store := &memKVStore{counters: make(map[string]int64)}
+	var nodes []*kvLimiter
+	for i := 0; i < 2; i++ {
+		l, err := NewKVStoreLimiter(store, 3, time.Second, false)
+		require.NoError(t, err)
+		l.(*kvLimiter).now = func() time.Time { return now }
+		nodes = append(nodes, l.(*kvLimiter))
+	}
+	_, err := NewKVStoreLimiter(store, 3, 0, false)
+	require.Error(t, err)
+	_, err = NewKVStoreLimiter(store, 0, time.Second, false)
+	require.Error(t, err)
+
+	// The requests sent to all the nodes count.
+	for i := 0; i < 3; i++ {
+		require.True(t, nodes[i%2].Allow("a"))
+	}
+	require.False(t, nodes[0].Allow("a"))
+	require.False(t, nodes[1].Allow("a"))
+	require.True(t, nodes[1].Allow("b"))
+
+	now = now.Add(time.Second)
+	require.True(t, nodes[0].Allow("a"))
+
+	store.down = true
+	require.True(t, nodes[0].Allow("a"))
+	nodes[0].failClose = true
+	require.False(t, nodes[0].Allow("a"))
+}
+
+func TestServiceProcessor_RateLimiter(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	require.NoError(t, p.RegisterHandler(procMsg))
+	require.NoError(t, p.RegisterStreamingHandler(func(*testMsg2) (chan *testMsg2, chan bool, error) {
+		return make(chan *testMsg2), make(chan bool), nil
+	}))
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", 3, 3))
+	p.RateLimiter = NewTokenBucketLimiter(0.001, 1)
+
+	buf, err := protobuf.Encode(&testMsg{11})
+	require.NoError(t, err)
+	r := httptest.NewRequest("GET", "/", nil)
+	_, _, err = p.ProcessClientRequest(r, "testMsg", buf)
+	require.NoError(t, err)
+	_, _, err = p.ProcessClientRequest(r, "testMsg", buf)
+	code, ok := statusErrorCode(err)
+	require.True(t, ok)
+	require.Equal(t, http.StatusTooManyRequests, code)
+	// The streams are limited too.
+	_, err = p.ProcessClientStreamRequest(r, "testMsg2", make(chan []byte))
+	code, ok = statusErrorCode(err)
+	require.True(t, ok)
+	require.Equal(t, http.StatusTooManyRequests, code)
+	// The requests that don't come from HTTP are not limited.
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	require.NoError(t, err)
+
+	get := func(user string) int {
+		r := httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/42", nil)
+		r.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w.Code
+	}
+	require.Equal(t, http.StatusTooManyRequests, get("alice"))
+
+	p.RateLimitKey = func(r *http.Request) string { return r.Header.Get("X-User") }
+	require.Equal(t, http.StatusOK, get("alice"))
+	require.Equal(t, http.StatusTooManyRequests, get("alice"))
+	require.Equal(t, http.StatusOK, get("bob"))
+}
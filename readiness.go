@@ -0,0 +1,66 @@
+package onet
+
+import (
+	"net/http"
+	"sync"
+)
+
+// ReadinessProbe returns nil if a service is ready to serve the requests,
+// e.g. once its caches are warmed, or an error telling why it isn't.
+type ReadinessProbe func() error
+
+// readinessProbes holds the probes of the services, checked by the /ready
+// and /readyz endpoints of the websocket.
+type readinessProbes struct {
+	probes map[string]ReadinessProbe
+	sync.Mutex
+}
+
+func newReadinessProbes() *readinessProbes {
+	return &readinessProbes{probes: make(map[string]ReadinessProbe)}
+}
+
+// register adds the probe under name, replacing the previous one if any.
+func (rp *readinessProbes) register(name string, probe ReadinessProbe) {
+	rp.Lock()
+	rp.probes[name] = probe
+	rp.Unlock()
+}
+
+// check runs all the probes and returns whether they are all ready, along
+// with the outcome of each probe.
+func (rp *readinessProbes) check() (bool, map[string]string) {
+	rp.Lock()
+	probes := make(map[string]ReadinessProbe, len(rp.probes))
+	for name, probe := range rp.probes {
+		probes[name] = probe
+	}
+	rp.Unlock()
+
+	ready := true
+	details := make(map[string]string, len(probes))
+	for name, probe := range probes {
+		if err := probe(); err != nil {
+			ready = false
+			details[name] = err.Error()
+		} else {
+			details[name] = "ready"
+		}
+	}
+	return ready, details
+}
+
+// ServeHTTP answers with 200 OK if all the probes are ready, and with
+// 503 Service Unavailable otherwise. The body gives the outcome of each
+// probe.
+func (rp *readinessProbes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ready, details := rp.check()
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{
+		"ready":  ready,
+		"probes": details,
+	})
+}
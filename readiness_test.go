@@ -0,0 +1,55 @@
+package onet
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestWebSocket_Readiness(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	srv := local.GenServers(1)[0]
+	c := &Context{server: srv}
+
+	ready := func() (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		srv.WebSocket.mux.ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+		body := make(map[string]interface{})
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		return w.Code, body
+	}
+
+	// Without probes, the server is ready.
+	code, body := ready()
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, true, body["ready"])
+
+	var warm int32
+	c.RegisterReadinessProbe("cache", func() error {
+		if atomic.LoadInt32(&warm) == 0 {
+			return xerrors.New("cache is cold")
+		}
+		return nil
+	})
+	c.RegisterReadinessProbe("db", func() error { return nil })
+
+	code, body = ready()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, false, body["ready"])
+	require.Equal(t, map[string]interface{}{"cache": "cache is cold", "db": "ready"}, body["probes"])
+
+	atomic.StoreInt32(&warm, 1)
+	code, body = ready()
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, true, body["ready"])
+
+	for _, name := range []string{"ok", "ready", "config", "metrics", "healthz", "readyz"} {
+		require.Error(t, srv.WebSocket.registerService(name, nil))
+	}
+}
@@ -0,0 +1,74 @@
+package onet
+
+import (
+	"context"
+	"net/http"
+
+	"go.dedis.ch/onet/v3/log"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+// RequestIDHeader is the header of the correlation ID of the requests. The ID
+// given by the client is kept, so that it can tie its logs to the logs of the
+// nodes, and a new one is generated otherwise. It is sent back in the same
+// header of the REST replies, and of the websocket upgrades, where it is the
+// ID of all the requests of the connection.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLen is the maximum length of the IDs given by the clients.
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// RequestID returns the correlation ID of the request of the context given
+// to the handlers registered with RegisterHandlerWithContext, or an empty
+// string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID returns ctx with the correlation ID id.
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// ensureRequestID returns the correlation ID of req, which it sets in its
+// header if the client didn't give a valid one. The IDs are only accepted if
+// they can't break the log lines.
+func ensureRequestID(req *http.Request) string {
+	id := req.Header.Get(RequestIDHeader)
+	if validRequestID(id) {
+		return id
+	}
+	id = uuid.NewV4().String()
+	req.Header.Set(RequestIDHeader, id)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range []byte(id) {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns the correlation ID of a request of the websocket,
+// req being nil for the requests that don't come from a client.
+func newRequestID(req *http.Request) string {
+	if req == nil {
+		return uuid.NewV4().String()
+	}
+	return ensureRequestID(req)
+}
+
+// logRequestError logs the error err of the handler of name, with the
+// correlation ID of ctx.
+func logRequestError(ctx context.Context, name string, err error) {
+	log.Lvlf2("request %s: handler %s failed: %v", RequestID(ctx), name, err)
+}
@@ -0,0 +1,53 @@
+package onet
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+func TestServiceProcessor_RequestID(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+
+	ids := make(chan string, 1)
+	require.NoError(t, p.RegisterHandlerWithContext(func(ctx context.Context, msg *testMsg) (*testMsg, error) {
+		ids <- RequestID(ctx)
+		return msg, nil
+	}))
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", 3, 3))
+
+	buf, err := protobuf.Encode(&testMsg{})
+	require.NoError(t, err)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIDHeader, "client-id")
+	_, _, err = p.ProcessClientRequest(req, "testMsg", buf)
+	require.NoError(t, err)
+	require.Equal(t, "client-id", <-ids)
+	_, _, err = p.ProcessClientRequest(nil, "testMsg", buf)
+	require.NoError(t, err)
+	require.NotEmpty(t, <-ids)
+
+	// The ID is sent back, and replaced if it could break the logs.
+	get := func(id string) string {
+		r := httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/2", nil)
+		if id != "" {
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w.Header().Get(RequestIDHeader)
+	}
+	require.Equal(t, "client-id", get("client-id"))
+	require.NotEmpty(t, get(""))
+	require.NotEqual(t, get(""), get(""))
+	id := get("bad id")
+	require.NotEmpty(t, id)
+	require.NotContains(t, id, " ")
+	require.NotEqual(t, strings.Repeat("a", 200), get(strings.Repeat("a", 200)))
+}
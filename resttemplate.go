@@ -0,0 +1,159 @@
+package onet
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// RegisterRESTHandlerWithPath is like RegisterRESTHandler, but registers f on
+// /v$version/$template instead of /v$version/$namespace/$msgStructName, so
+// that the service chooses the shape of its URLs, e.g. with the template
+// skipchain/blocks/{id}. The template is made of segments separated by
+// slashes, the first of which must be a literal. A placeholder, such as
+// {id}, is a whole segment naming an int or a byte slice field of the
+// message, regardless of the case, and sets it the way the segments of the
+// GET requests of RegisterRESTHandler do. The fields that are not in the
+// template are left empty in the GET requests, and are read from the body of
+// the POST and PUT requests, whose placeholders take precedence over it.
+//
+// The templates whose placeholders start after the same literal segments
+// are tried in the order of their registration, and registering the same
+// template again replaces its handler.
+//
+// This method is experimental.
+func (p *ServiceProcessor) RegisterRESTHandlerWithPath(f interface{}, method, template string,
+	minVersion, maxVersion int, options ...RESTOption) error {
+	return p.registerREST(f, "", template, method, minVersion, maxVersion, options)
+}
+
+// newTemplateParser returns the parser of the URLs of the path template of
+// the messages of type msgType. Its template is the given one with the names
+// of the fields in the placeholders.
+func newTemplateParser(msgType reflect.Type, template string) (*getParser, error) {
+	parts := strings.Split(strings.Trim(template, "/"), "/")
+	var segments []getSegment
+	exprs := make([]string, len(parts))
+	used := make(map[int]bool)
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			if part == "" || strings.ContainsAny(part, "{}") {
+				return nil, xerrors.Errorf("invalid segment %q", part)
+			}
+			exprs[i] = regexp.QuoteMeta(part)
+			continue
+		}
+		if i == 0 {
+			return nil, xerrors.New("the first segment must be a literal")
+		}
+		seg, err := templateSegment(msgType, part[1:len(part)-1])
+		if err != nil {
+			return nil, err
+		}
+		if used[seg.index] {
+			return nil, xerrors.Errorf("field %s is in several placeholders", seg.field)
+		}
+		used[seg.index] = true
+		segments = append(segments, seg)
+		parts[i] = "{" + seg.field + "}"
+		exprs[i] = "([^/]+)"
+	}
+	templateRegex, err := regexp.Compile(`^/v\d+/` + strings.Join(exprs, "/") + "$")
+	if err != nil {
+		return nil, xerrors.Errorf("regex: %v", err)
+	}
+	return &getParser{kind: templateGET, segments: segments, templateRegex: templateRegex,
+		template: strings.Join(parts, "/")}, nil
+}
+
+// templateSegment returns the segment of the exported field of msgType whose
+// name is, regardless of the case, the one of the placeholder.
+func templateSegment(msgType reflect.Type, name string) (getSegment, error) {
+	for i := 0; i < msgType.NumField(); i++ {
+		field := msgType.Field(i)
+		if field.PkgPath != "" || !strings.EqualFold(field.Name, name) {
+			continue
+		}
+		switch {
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Uint8:
+			return getSegment{kind: sliceGET, field: field.Name, index: i}, nil
+		case field.Type.Kind() == reflect.Int:
+			return getSegment{kind: intGET, field: field.Name, index: i}, nil
+		}
+		return getSegment{}, xerrors.Errorf("field %s: only byte slices and int "+
+			"are supported", field.Name)
+	}
+	return getSegment{}, xerrors.Errorf("no field for the placeholder {%s}", name)
+}
+
+// hasSlice returns true if a segment of the parser sets a byte slice.
+func (g *getParser) hasSlice() bool {
+	for _, seg := range g.segments {
+		if seg.kind == sliceGET {
+			return true
+		}
+	}
+	return false
+}
+
+// templatePrefix returns the part of the pattern of a path template before
+// its first placeholder, which is registered on the mux, and false if the
+// pattern has no placeholder.
+func templatePrefix(pattern string) (string, bool) {
+	i := strings.Index(pattern, "{")
+	if i < 0 {
+		return "", false
+	}
+	return pattern[:i], true
+}
+
+// checkPattern returns an error if the pattern of a REST route, whose GET
+// parser is get, can't be registered on the mux next to the routes of the
+// path templates.
+func (p *ServiceProcessor) checkPattern(pattern string, get *getParser) error {
+	p.handlersLock.RLock()
+	defer p.handlersLock.RUnlock()
+	prefix, isTemplate := templatePrefix(pattern)
+	for other, route := range p.restRoutes {
+		otherPrefix, otherIsTemplate := templatePrefix(other)
+		switch {
+		case isTemplate && !otherIsTemplate && prefixConflict(other, route.get, prefix),
+			!isTemplate && otherIsTemplate && prefixConflict(pattern, get, otherPrefix):
+			return xerrors.Errorf("%s conflicts with the route %s", pattern, other)
+		}
+	}
+	return nil
+}
+
+// prefixConflict returns true if the plain pattern of a route, whose GET
+// parser is get, is also registered on the mux as the prefix of a template.
+func prefixConflict(pattern string, get *getParser, prefix string) bool {
+	return pattern == prefix || get != nil && get.kind == emptyGET && pattern+"/" == prefix
+}
+
+// serveTemplates returns the handler of the requests below the prefix of
+// path templates, which serves them with the first of their routes matching
+// the path.
+func (p *ServiceProcessor) serveTemplates(prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.EscapedPath()
+		var route *restRoute
+		p.handlersLock.RLock()
+		for _, pattern := range p.templatePrefixes[prefix] {
+			rt := p.restRoutes[pattern]
+			if rt.handler != nil && rt.get.templateRegex.MatchString(path) {
+				route = rt
+				break
+			}
+		}
+		p.handlersLock.RUnlock()
+		if route == nil {
+			http.Error(w, wrapJSONMsg("invalid path"), http.StatusNotFound)
+			return
+		}
+		p.serveRoute(route)(w, r)
+	}
+}
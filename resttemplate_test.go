@@ -0,0 +1,92 @@
+package onet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type blockRequest struct {
+	ID    []byte
+	Index int
+	Note  string
+}
+
+type blockReply struct {
+	ID    string
+	Index int
+	Note  string
+}
+
+func TestServiceProcessor_RegisterRESTHandlerWithPath(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+
+	handler := func(req *blockRequest) (*blockReply, error) {
+		return &blockReply{hex.EncodeToString(req.ID), req.Index, req.Note}, nil
+	}
+	require.NoError(t, p.RegisterRESTHandlerWithPath(handler, "GET",
+		"skipchain/blocks/{id}", 3, 3))
+	require.NoError(t, p.RegisterRESTHandlerWithPath(handler, "PUT",
+		"skipchain/blocks/{id}/notes/{index}", 3, 3))
+	require.NoError(t, p.RegisterRESTHandlerWithPath(procRestMsgGET2, "GET",
+		"skipchain/heights/{X}", 3, 3))
+
+	request := func(method, path, body string) (int, blockReply) {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		var reply blockReply
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+		}
+		return w.Code, reply
+	}
+
+	code, reply := request("GET", "/v3/skipchain/blocks/abcd", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, blockReply{ID: "abcd"}, reply)
+	code, _ = request("GET", "/v3/skipchain/blocks/xyz", "")
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _ = request("GET", "/v3/skipchain/blocks/abcd/more", "")
+	require.Equal(t, http.StatusNotFound, code)
+
+	// The placeholders take precedence over the body.
+	code, reply = request("PUT", "/v3/skipchain/blocks/abcd/notes/2",
+		`{"Index": 7, "Note": "hello"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, blockReply{ID: "abcd", Index: 2, Note: "hello"}, reply)
+	code, _ = request("PUT", "/v3/skipchain/blocks/abcd/notes/two", `{}`)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, httptest.NewRequest("GET", "/v3/skipchain/heights/5", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `{"I":5}`, strings.TrimSpace(w.Body.String()))
+
+	// The templates are in the OpenAPI specification with their parameters.
+	buf, err := p.OpenAPISpec()
+	require.NoError(t, err)
+	require.Contains(t, string(buf), `"/v3/skipchain/blocks/{ID}/notes/{Index}"`)
+
+	for _, template := range []string{"{id}/blocks", "skipchain/blocks/{Note}",
+		"skipchain/blocks/{unknown}", "skipchain/{id}/{ID}", "skipchain//{id}",
+		"skipchain/b{id}"} {
+		require.Error(t, p.RegisterRESTHandlerWithPath(handler, "GET", template, 3, 3), template)
+	}
+	require.Error(t, p.RegisterRESTHandlerWithPath(handler, "DELETE", "skipchain/blocks/{id}", 3, 3))
+
+	// The prefix of the templates can't be a route of RegisterRESTHandler.
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET1, "dummyService", "GET", 3, 3))
+	require.Error(t, p.RegisterRESTHandlerWithPath(procRestMsgGET2, "GET",
+		"dummyService/restMsgGET1/{X}", 3, 3))
+}
@@ -43,6 +43,10 @@ type Server struct {
 	IsStarted      bool
 
 	suite network.Suite
+
+	// roles of the node in the deployment, e.g. "indexer"
+	roles     []string
+	rolesLock sync.RWMutex
 }
 
 func dbPathFromEnv() string {
@@ -98,6 +102,18 @@ func NewServerTCPWithListenAddr(e *network.ServerIdentity, suite network.Suite,
 	return newServer(suite, "", r, e.GetPrivate())
 }
 
+// NewServerTCPWithListenConfig is like NewServerTCPWithListenAddr, with the
+// socket options of lc for the listener of the Router. Unlike it, it returns
+// an error if the Router cannot listen.
+func NewServerTCPWithListenConfig(e *network.ServerIdentity, suite network.Suite,
+	listenAddr string, lc network.ListenConfig) (*Server, error) {
+	r, err := network.NewTCPRouterWithListenConfig(e, suite, listenAddr, lc)
+	if err != nil {
+		return nil, xerrors.Errorf("router: %v", err)
+	}
+	return newServer(suite, "", r, e.GetPrivate()), nil
+}
+
 // Suite can (and should) be used to get the underlying Suite.
 // Currently the suite is hardcoded into the network library.
 // Don't use network.Suite but Host's Suite function instead if possible.
@@ -105,6 +121,51 @@ func (c *Server) Suite() network.Suite {
 	return c.suite
 }
 
+// HandleConfig exposes the configuration returned by config, encoded in
+// JSON, on the /config endpoint of the websocket, so that the operators can
+// check the configuration the node actually runs with. The requests must give
+// the token in an "Authorization: Bearer <token>" header, but config must
+// still not return any secret. An empty token disables the endpoint.
+func (c *Server) HandleConfig(token string, config func() interface{}) {
+	c.WebSocket.Lock()
+	defer c.WebSocket.Unlock()
+	if token == "" {
+		config = nil
+	}
+	c.WebSocket.config = config
+	c.WebSocket.configToken = token
+}
+
+// SetRoles sets the roles of the node in the deployment. The handlers that
+// require roles the node doesn't have are rejected, see
+// ServiceProcessor.RequireRoles.
+func (c *Server) SetRoles(roles ...string) {
+	c.rolesLock.Lock()
+	c.roles = append([]string{}, roles...)
+	sort.Strings(c.roles)
+	c.rolesLock.Unlock()
+}
+
+// Roles returns the roles of the node, sorted by name.
+func (c *Server) Roles() []string {
+	c.rolesLock.RLock()
+	defer c.rolesLock.RUnlock()
+	return append([]string{}, c.roles...)
+}
+
+// HasRoles tells if the node has all the given roles.
+func (c *Server) HasRoles(roles ...string) bool {
+	c.rolesLock.RLock()
+	defer c.rolesLock.RUnlock()
+	for _, role := range roles {
+		i := sort.SearchStrings(c.roles, role)
+		if i == len(c.roles) || c.roles[i] != role {
+			return false
+		}
+	}
+	return true
+}
+
 var gover version.Version
 var goverOnce sync.Once
 var goverOk = false
@@ -126,6 +187,7 @@ func (c *Server) GetStatus() *Status {
 		"Description": c.ServerIdentity.Description,
 		"ConnType":    string(c.ServerIdentity.Address.ConnType()),
 		"GoRoutines":  fmt.Sprintf("%v", runtime.NumGoroutine()),
+		"Roles":       strings.Join(c.Roles(), ","),
 	}}
 
 	goverOnce.Do(func() {
@@ -1,6 +1,8 @@
 package onet
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -46,6 +48,37 @@ func TestServer_Database(t *testing.T) {
 	c.Close()
 }
 
+func TestServer_HandleConfig(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	srv := local.GenServers(1)[0]
+
+	get := func(auth string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/config", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		srv.WebSocket.mux.ServeHTTP(w, r)
+		return w
+	}
+	require.Equal(t, http.StatusNotFound, get("").Code)
+
+	srv.HandleConfig("secret", func() interface{} {
+		return map[string]interface{}{"Roles": srv.Roles()}
+	})
+	require.Equal(t, http.StatusUnauthorized, get("").Code)
+	require.Equal(t, http.StatusUnauthorized, get("Bearer wrong").Code)
+
+	srv.SetRoles("indexer")
+	w := get("Bearer secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"Roles": ["indexer"]}`, w.Body.String())
+
+	srv.HandleConfig("", func() interface{} { return nil })
+	require.Equal(t, http.StatusNotFound, get("Bearer ").Code)
+}
+
 type ServerProtocol struct {
 	*TreeNodeInstance
 }
@@ -0,0 +1,62 @@
+package onet
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ServerSentEvent can be implemented by the messages of the streaming
+// handlers registered with RegisterStreamingRESTHandler, so that their events
+// are named, for the listeners of the EventSource clients, and have an ID,
+// which the clients send back in the Last-Event-ID header when they
+// reconnect. An empty name or ID is left out of the event.
+type ServerSentEvent interface {
+	EventName() string
+	EventID() string
+}
+
+// LastEventIDSetter can be implemented by the messages of the handlers
+// registered with RegisterStreamingRESTHandler, to get the Last-Event-ID
+// header of the clients that reconnect, so that the handler resumes the
+// stream after this event. SetLastEventID is called before the handler,
+// and only if the client sent the header.
+type LastEventIDSetter interface {
+	SetLastEventID(id string)
+}
+
+// setLastEventID gives the Last-Event-ID header of the request to msg if it
+// is a LastEventIDSetter.
+func setLastEventID(r *http.Request, msg interface{}) {
+	s, ok := msg.(LastEventIDSetter)
+	if !ok {
+		return
+	}
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		s.SetLastEventID(id)
+	}
+}
+
+// sseFieldReplacer removes the line breaks of the fields of the events, which
+// would end them.
+var sseFieldReplacer = strings.NewReplacer("\r", "", "\n", "")
+
+// writeEvent writes the event of the message msg, whose data is data, in the
+// text/event-stream format.
+func writeEvent(w io.Writer, msg interface{}, data []byte) error {
+	if ev, ok := msg.(ServerSentEvent); ok {
+		if name := sseFieldReplacer.Replace(ev.EventName()); name != "" {
+			if _, err := fmt.Fprintf(w, "event: %s\n", name); err != nil {
+				return err
+			}
+		}
+		if id := sseFieldReplacer.Replace(ev.EventID()); id != "" {
+			if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
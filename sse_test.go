@@ -0,0 +1,64 @@
+package onet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type sseRequest struct {
+	Topic       int
+	lastEventID string
+}
+
+func (r *sseRequest) SetLastEventID(id string) {
+	r.lastEventID = id
+}
+
+type sseEvent struct {
+	Seq  int
+	name string
+}
+
+func (e *sseEvent) EventName() string {
+	return e.name
+}
+
+func (e *sseEvent) EventID() string {
+	return strconv.Itoa(e.Seq)
+}
+
+func TestServiceProcessor_ServerSentEvents(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+
+	require.NoError(t, p.RegisterStreamingRESTHandler(func(msg *sseRequest) (chan *sseEvent, chan bool, error) {
+		from := 0
+		if msg.lastEventID != "" {
+			last, err := strconv.Atoi(msg.lastEventID)
+			if err != nil {
+				return nil, nil, err
+			}
+			from = last + 1
+		}
+		out := make(chan *sseEvent, 3)
+		for i := from; i < 3; i++ {
+			out <- &sseEvent{Seq: i, name: "update\nbroken"}
+		}
+		out <- &sseEvent{Seq: 3}
+		close(out)
+		return out, make(chan bool), nil
+	}, "dummyService", 3, 3))
+
+	r := httptest.NewRequest("GET", "/v3/dummyService/sseRequest/1", nil)
+	r.Header.Set("Last-Event-ID", "1")
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "event: updatebroken\nid: 2\ndata: {\"Seq\":2}\n\n"+
+		"id: 3\ndata: {\"Seq\":3}\n\n", w.Body.String())
+}
@@ -0,0 +1,91 @@
+package onet
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// StreamBudget bounds the total size of the messages of the streaming
+// handlers that wait to be written to their clients. The buffer of each
+// stream is bounded, but many streams with slow clients can still hold a lot
+// of memory together. Give the same StreamBudget to the ServiceProcessor of
+// each service to bound the streams of the whole server.
+//
+// Once the budget is exceeded, the new streams are refused with a
+// StatusError of code 503, and the streams whose client hasn't read all the
+// previous messages wait for it before sending the next one, instead of
+// waiting for their buffer to be full.
+type StreamBudget struct {
+	max int64
+	// inFlight is the number of bytes waiting to be written, updated
+	// atomically.
+	inFlight int64
+	// released is closed and replaced each time bytes are released, to
+	// wake up the streams waiting for room.
+	released     chan struct{}
+	releasedLock sync.Mutex
+}
+
+// NewStreamBudget returns a StreamBudget of max bytes.
+func NewStreamBudget(max int64) *StreamBudget {
+	return &StreamBudget{max: max, released: make(chan struct{})}
+}
+
+// Max returns the number of bytes of the budget.
+func (b *StreamBudget) Max() int64 {
+	return b.max
+}
+
+// InFlight returns the number of bytes waiting to be written to the clients.
+func (b *StreamBudget) InFlight() int64 {
+	return atomic.LoadInt64(&b.inFlight)
+}
+
+// Exceeded tells if the bytes waiting to be written reach the budget.
+func (b *StreamBudget) Exceeded() bool {
+	return b.InFlight() >= b.max
+}
+
+// checkNewStream returns a StatusError if the budget is exceeded.
+func (b *StreamBudget) checkNewStream() error {
+	if b.Exceeded() {
+		return StatusError{Code: http.StatusServiceUnavailable,
+			Msg: "too many bytes waiting to be written in the streams"}
+	}
+	return nil
+}
+
+// acquire counts n more bytes in flight. If they don't fit in the budget, it
+// waits for room while the stream has a backlog, so that a stream whose
+// client is up to date can always send. It returns false, without counting
+// the bytes, if done is closed first.
+func (b *StreamBudget) acquire(n int64, backlog func() int, done <-chan struct{}) bool {
+	for {
+		b.releasedLock.Lock()
+		released := b.released
+		b.releasedLock.Unlock()
+
+		cur := atomic.LoadInt64(&b.inFlight)
+		if cur+n > b.max && backlog() > 0 {
+			select {
+			case <-released:
+				continue
+			case <-done:
+				return false
+			}
+		}
+		if atomic.CompareAndSwapInt64(&b.inFlight, cur, cur+n) {
+			return true
+		}
+	}
+}
+
+// release counts n bytes that are not in flight anymore.
+func (b *StreamBudget) release(n int64) {
+	atomic.AddInt64(&b.inFlight, -n)
+	b.releasedLock.Lock()
+	close(b.released)
+	b.released = make(chan struct{})
+	b.releasedLock.Unlock()
+}
@@ -0,0 +1,100 @@
+package onet
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+func TestStreamBudget(t *testing.T) {
+	b := NewStreamBudget(10)
+	done := make(chan struct{})
+	noBacklog := func() int { return 0 }
+	backlog := func() int { return 1 }
+
+	require.True(t, b.acquire(8, backlog, done))
+	// A stream without backlog can always send.
+	require.True(t, b.acquire(8, noBacklog, done))
+	require.Equal(t, int64(16), b.InFlight())
+	require.True(t, b.Exceeded())
+	require.Error(t, b.checkNewStream())
+
+	acquired := make(chan bool)
+	go func() { acquired <- b.acquire(3, backlog, done) }()
+	b.release(8)
+	select {
+	case <-acquired:
+		t.Fatal("the budget is still exceeded")
+	case <-time.After(50 * time.Millisecond):
+	}
+	b.release(8)
+	require.True(t, <-acquired)
+	require.Equal(t, int64(3), b.InFlight())
+	require.NoError(t, b.checkNewStream())
+
+	go func() { acquired <- b.acquire(20, backlog, done) }()
+	close(done)
+	require.False(t, <-acquired)
+	require.Equal(t, int64(3), b.InFlight())
+}
+
+func TestServiceProcessor_StreamBudget(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+	p.StreamBudget = NewStreamBudget(1)
+
+	require.NoError(t, p.RegisterStreamingHandler(func(msg *testMsg) (chan *testMsg, chan bool, error) {
+		out := make(chan *testMsg)
+		stop := make(chan bool)
+		go func() {
+			defer close(out)
+			for i := 0; ; i++ {
+				select {
+				case out <- &testMsg{int64(i + 1)}:
+				case <-stop:
+					return
+				}
+			}
+		}()
+		return out, stop, nil
+	}))
+
+	buf, err := protobuf.Encode(&testMsg{})
+	require.NoError(t, err)
+	inputs := make(chan []byte, 1)
+	inputs <- buf
+	outChan, err := p.ProcessClientStreamRequest(nil, "testMsg", inputs)
+	require.NoError(t, err)
+
+	// The first message fits as the stream has no backlog, and the next
+	// ones wait for the client.
+	require.Eventually(t, func() bool { return len(outChan) == 1 },
+		5*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 1, len(outChan))
+	require.True(t, p.StreamBudget.Exceeded())
+
+	inputs2 := make(chan []byte, 1)
+	inputs2 <- buf
+	_, err = p.ProcessClientStreamRequest(nil, "testMsg", inputs2)
+	var se StatusError
+	require.True(t, xerrors.As(err, &se), err)
+	require.Equal(t, http.StatusServiceUnavailable, se.Code)
+
+	// Taking a message lets the next one in.
+	reply := <-outChan
+	p.releaseStreamBytes(len(reply))
+	require.Eventually(t, func() bool { return len(outChan) == 1 },
+		5*time.Second, 10*time.Millisecond)
+
+	close(inputs)
+	for reply := range outChan {
+		p.releaseStreamBytes(len(reply))
+	}
+	require.Equal(t, int64(0), p.StreamBudget.InFlight())
+}
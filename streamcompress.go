@@ -0,0 +1,23 @@
+package onet
+
+import (
+	"net/http"
+)
+
+// The messages of the streams, on the websocket as well as in the events of
+// RegisterStreamingRESTHandler, can be compressed one by one, so that the
+// streams of large messages take less bandwidth whatever the transport. The
+// client lists the encodings it accepts in the StreamEncodingHeader of its
+// request, in the form of Accept-Encoding, and the server answers with the
+// encoding it chose in the same header. Each message is then compressed on
+// its own, so that the client can decode it as soon as it is received.
+
+// StreamEncodingHeader is the header negotiating the compression of the
+// messages of the streams, with "zstd" or "gzip".
+const StreamEncodingHeader = "X-Stream-Encoding"
+
+// streamEncoding returns the encoding of the messages of the streams of the
+// request, or an empty string if they are not compressed.
+func streamEncoding(r *http.Request) string {
+	return preferredEncoding(r.Header.Get(StreamEncodingHeader))
+}
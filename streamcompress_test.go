@@ -0,0 +1,109 @@
+package onet
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamCompression_WebSocket(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+
+	serName := "streamingService"
+	_, err := RegisterNewService(serName, newStreamingService)
+	require.NoError(t, err)
+	defer UnregisterService(serName)
+
+	servers, el, _ := local.GenTree(4, false)
+	n := 5
+	for _, compression := range []bool{false, true} {
+		client := local.NewClientKeep(serName)
+		client.StreamCompression = compression
+		conn, err := client.Stream(servers[0].ServerIdentity, &SimpleRequest{
+			ServerIdentities: el,
+			Val:              int64(n),
+		})
+		require.NoError(t, err)
+		if compression {
+			require.Equal(t, encodingZstd, conn.encoding)
+		} else {
+			require.Equal(t, "", conn.encoding)
+		}
+
+		for i := 0; i < n; i++ {
+			sr := &SimpleResponse{}
+			require.NoError(t, conn.ReadMessage(sr))
+			require.Equal(t, int64(n), sr.Val)
+		}
+		require.NoError(t, client.Close())
+	}
+}
+
+func TestStreamCompression_REST(t *testing.T) {
+	h1 := NewLocalServer(tSuite, 2000)
+	defer h1.Close()
+	p := NewServiceProcessor(&Context{server: h1})
+
+	f := func(msg *restMsgGET2) (chan *testMsg, chan bool, error) {
+		out := make(chan *testMsg, msg.X)
+		for i := 0; i < msg.X; i++ {
+			out <- &testMsg{int64(i)}
+		}
+		close(out)
+		return out, make(chan bool), nil
+	}
+	require.NoError(t, p.RegisterStreamingRESTHandler(f, "dummyService", 3, 3))
+
+	for _, enc := range []string{encodingGzip, encodingZstd} {
+		r := httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/3", nil)
+		r.Header.Set(StreamEncodingHeader, "br, "+enc)
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, enc, w.Header().Get(StreamEncodingHeader))
+
+		events := strings.Split(strings.TrimSuffix(w.Body.String(), "\n\n"), "\n\n")
+		require.Equal(t, 3, len(events))
+		for i, event := range events {
+			require.True(t, strings.HasPrefix(event, "data: "))
+			compressed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(event, "data: "))
+			require.NoError(t, err)
+			buf, err := decompress(enc, compressed)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf(`{"I":%d}`, i), string(buf))
+		}
+	}
+
+	// Without a supported encoding, the stream is not compressed.
+	r := httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/1", nil)
+	r.Header.Set(StreamEncodingHeader, "br")
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, r)
+	require.Equal(t, "", w.Header().Get(StreamEncodingHeader))
+	require.Equal(t, "data: {\"I\":0}\n\n", w.Body.String())
+}
+
+func TestDecompress(t *testing.T) {
+	for _, enc := range supportedEncodings {
+		buf, err := compress(enc, []byte("message"))
+		require.NoError(t, err)
+		out, err := decompress(enc, buf)
+		require.NoError(t, err)
+		require.Equal(t, "message", string(out))
+
+		buf, err = compress(enc, make([]byte, maxDecompressedSize+1))
+		require.NoError(t, err)
+		_, err = decompress(enc, buf)
+		require.Error(t, err)
+	}
+	_, err := decompress("br", []byte("message"))
+	require.Error(t, err)
+	_, err = decompress(encodingGzip, []byte("message"))
+	require.Error(t, err)
+}
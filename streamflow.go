@@ -0,0 +1,105 @@
+package onet
+
+import (
+	"reflect"
+
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+// StreamFlow tells a streaming handler registered with
+// RegisterStreamingHandlerWithFlow how far its client lags behind. The
+// messages of the handler wait in a buffer until they are written to the
+// websocket, and once the buffer is full, the sends into retChan block until
+// the client reads. A handler of real-time data can check Congested before
+// sending, and drop or coalesce its messages instead, as they would be stale
+// by the time the client gets them.
+type StreamFlow struct {
+	out    chan []byte
+	budget *StreamBudget
+}
+
+// Backlog returns the number of messages waiting to be written to the
+// client.
+func (f *StreamFlow) Backlog() int {
+	return len(f.out)
+}
+
+// Capacity returns the number of messages that can wait to be written to the
+// client.
+func (f *StreamFlow) Capacity() int {
+	return cap(f.out)
+}
+
+// Congested tells if the backlog is full, or not empty while the
+// StreamBudget of the ServiceProcessor is exceeded, so that the next messages
+// sent into retChan wait for the client to read.
+func (f *StreamFlow) Congested() bool {
+	if f.budget != nil && f.budget.Exceeded() && len(f.out) > 0 {
+		return true
+	}
+	return len(f.out) >= cap(f.out)
+}
+
+// RegisterStreamingHandlerWithFlow is like RegisterStreamingHandler, but f
+// also takes a *StreamFlow after the message, to watch the backlog of its
+// client:
+// func(msg interface{}, flow *StreamFlow)(retChan chan interface{}, closeChan chan bool, err error)
+//
+// The handler is only reached on the websocket.
+//
+// This method is experimental.
+func (p *ServiceProcessor) RegisterStreamingHandlerWithFlow(f interface{}) error {
+	sh, err := newFlowHandler(f)
+	if err != nil {
+		return err
+	}
+	if err := p.checkReplyTypes(f); err != nil {
+		return err
+	}
+
+	log.Lvl4("Registering streaming handler with flow", sh.msgType.String())
+	pm, err := messageName(sh.msgType)
+	if err != nil {
+		return err
+	}
+	p.handlersLock.Lock()
+	p.handlers[pm] = sh
+	p.handlersLock.Unlock()
+	return nil
+}
+
+// newFlowHandler checks that f is a streaming handler with a StreamFlow and
+// returns it.
+func newFlowHandler(f interface{}) (serviceHandler, error) {
+	if err := flowInputCheck(f); err != nil {
+		return serviceHandler{}, err
+	}
+	if err := streamingOutputCheck(f); err != nil {
+		return serviceHandler{}, err
+	}
+	msgType := reflect.TypeOf(f).In(0).Elem()
+	if err := checkMessageType(msgType); err != nil {
+		return serviceHandler{}, err
+	}
+	return serviceHandler{handler: f, msgType: msgType, streaming: true,
+		flow: true}, nil
+}
+
+var streamFlowType = reflect.TypeOf(&StreamFlow{})
+
+// flowInputCheck checks that f takes a pointer to a struct and a
+// *StreamFlow.
+func flowInputCheck(f interface{}) error {
+	ft := reflect.TypeOf(f)
+	if ft.Kind() != reflect.Func || ft.NumIn() != 2 {
+		return xerrors.New("Need a function with two arguments")
+	}
+	if ft.In(0).Kind() != reflect.Ptr || ft.In(0).Elem().Kind() != reflect.Struct {
+		return xerrors.New("1st argument must be a pointer to a struct")
+	}
+	if ft.In(1) != streamFlowType {
+		return xerrors.New("2nd argument must be a *StreamFlow")
+	}
+	return nil
+}
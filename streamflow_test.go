@@ -0,0 +1,76 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+func TestServiceProcessor_StreamFlow(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+
+	require.Error(t, p.RegisterStreamingHandlerWithFlow(
+		func(*testMsg, *StreamFlow, int) (chan *testMsg, chan bool, error) { return nil, nil, nil }))
+	require.Error(t, p.RegisterStreamingHandlerWithFlow(
+		func(*testMsg, StreamFlow) (chan *testMsg, chan bool, error) { return nil, nil, nil }))
+
+	// The handler drops the messages while the client is backed up, and
+	// tells how many it sent before.
+	sent := make(chan int, 1)
+	require.NoError(t, p.RegisterStreamingHandlerWithFlow(func(msg *testMsg, flow *StreamFlow) (chan *testMsg, chan bool, error) {
+		out := make(chan *testMsg)
+		stop := make(chan bool)
+		go func() {
+			defer close(out)
+			for i := 0; ; i++ {
+				if flow.Congested() {
+					sent <- i
+					break
+				}
+				select {
+				case out <- &testMsg{int64(i)}:
+				case <-stop:
+					return
+				}
+			}
+			<-stop
+		}()
+		return out, stop, nil
+	}))
+
+	buf, err := protobuf.Encode(&testMsg{})
+	require.NoError(t, err)
+	inputs := make(chan []byte, 1)
+	inputs <- buf
+	outChan, err := p.ProcessClientStreamRequest(nil, "testMsg", inputs)
+	require.NoError(t, err)
+
+	select {
+	case n := <-sent:
+		// One more message can wait to be forwarded to the buffer.
+		require.True(t, n == cap(outChan) || n == cap(outChan)+1, n)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler didn't see the congestion")
+	}
+	require.Equal(t, cap(outChan), len(outChan))
+
+	// The stream ends when the client goes away.
+	close(inputs)
+	for range outChan {
+	}
+}
+
+func TestStreamFlow(t *testing.T) {
+	flow := &StreamFlow{out: make(chan []byte, 2)}
+	require.Equal(t, 2, flow.Capacity())
+	require.False(t, flow.Congested())
+	flow.out <- nil
+	require.Equal(t, 1, flow.Backlog())
+	require.False(t, flow.Congested())
+	flow.out <- nil
+	require.True(t, flow.Congested())
+}
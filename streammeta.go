@@ -0,0 +1,154 @@
+package onet
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+// ConnMetadata describes the connection of a streaming handler registered
+// with RegisterStreamingHandlerWithMetadata. The same ConnMetadata is given
+// to every call of the handler on a connection, so that the handler can
+// tailor its messages to the connection, and keep its own values with Set
+// for the lifetime of the stream.
+type ConnMetadata struct {
+	// RemoteAddr is the address of the client, empty if unknown.
+	RemoteAddr string
+	// Header is the header of the request that opened the connection.
+	Header http.Header
+	// Principal is the common name of the verified TLS certificate of the
+	// client, empty if the client didn't authenticate.
+	Principal string
+	// Codec is the format of the messages of the connection: "protobuf",
+	// "json", or "custom" if the ServiceProcessor has its own Codec.
+	Codec string
+	// Start is the time the connection was opened.
+	Start time.Time
+
+	values     map[string]interface{}
+	valuesLock sync.Mutex
+}
+
+// newConnMetadata returns the ConnMetadata of the request req, which may be
+// nil, whose messages are encoded with codec.
+func (p *ServiceProcessor) newConnMetadata(req *http.Request, codec Codec) *ConnMetadata {
+	md := &ConnMetadata{Header: http.Header{}, Start: time.Now(),
+		values: make(map[string]interface{})}
+	switch codec.(type) {
+	case jsonTagCodec:
+		md.Codec = formatJSON
+	case protobufCodec:
+		md.Codec = formatProtobuf
+	default:
+		md.Codec = "custom"
+	}
+	if req == nil {
+		return md
+	}
+	md.RemoteAddr = req.RemoteAddr
+	md.Header = req.Header.Clone()
+	md.Principal = principal(req)
+	return md
+}
+
+// principal returns the common name of the verified TLS certificate of the
+// client of req, or an empty string.
+func principal(req *http.Request) string {
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		return req.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	return ""
+}
+
+// onConnect implements the wsConnectHook interface.
+func (p *ServiceProcessor) onConnect(r *http.Request) error {
+	if p.OnConnect == nil {
+		return nil
+	}
+	return p.OnConnect(r.RemoteAddr, principal(r))
+}
+
+// onDisconnect implements the wsConnectHook interface.
+func (p *ServiceProcessor) onDisconnect(r *http.Request) {
+	if p.OnDisconnect != nil {
+		p.OnDisconnect(r.RemoteAddr, principal(r))
+	}
+}
+
+// Set stores the value of key for the lifetime of the connection.
+func (md *ConnMetadata) Set(key string, value interface{}) {
+	md.valuesLock.Lock()
+	md.values[key] = value
+	md.valuesLock.Unlock()
+}
+
+// Get returns the value of key stored with Set, or nil.
+func (md *ConnMetadata) Get(key string) interface{} {
+	md.valuesLock.Lock()
+	defer md.valuesLock.Unlock()
+	return md.values[key]
+}
+
+// RegisterStreamingHandlerWithMetadata is like RegisterStreamingHandler, but
+// f also takes the *ConnMetadata of the connection after the message:
+// func(msg interface{}, md *ConnMetadata)(retChan chan interface{}, closeChan chan bool, err error)
+//
+// The handler is only reached on the websocket.
+func (p *ServiceProcessor) RegisterStreamingHandlerWithMetadata(f interface{}) error {
+	sh, err := newMetadataHandler(f)
+	if err != nil {
+		return err
+	}
+	if err := p.checkReplyTypes(f); err != nil {
+		return err
+	}
+
+	log.Lvl4("Registering streaming handler with metadata", sh.msgType.String())
+	pm, err := messageName(sh.msgType)
+	if err != nil {
+		return err
+	}
+	p.handlersLock.Lock()
+	p.handlers[pm] = sh
+	p.handlersLock.Unlock()
+	return nil
+}
+
+// newMetadataHandler checks that f is a streaming handler with a
+// ConnMetadata and returns it.
+func newMetadataHandler(f interface{}) (serviceHandler, error) {
+	if err := metadataInputCheck(f); err != nil {
+		return serviceHandler{}, err
+	}
+	if err := streamingOutputCheck(f); err != nil {
+		return serviceHandler{}, err
+	}
+	msgType := reflect.TypeOf(f).In(0).Elem()
+	if err := checkMessageType(msgType); err != nil {
+		return serviceHandler{}, err
+	}
+	return serviceHandler{handler: f, msgType: msgType, streaming: true,
+		metadata: true}, nil
+}
+
+var connMetadataType = reflect.TypeOf(&ConnMetadata{})
+
+// metadataInputCheck checks that f takes a pointer to a struct and a
+// *ConnMetadata.
+func metadataInputCheck(f interface{}) error {
+	ft := reflect.TypeOf(f)
+	if ft.Kind() != reflect.Func || ft.NumIn() != 2 {
+		return xerrors.New("Need a function with two arguments")
+	}
+	if ft.In(0).Kind() != reflect.Ptr || ft.In(0).Elem().Kind() != reflect.Struct {
+		return xerrors.New("1st argument must be a pointer to a struct")
+	}
+	if ft.In(1) != connMetadataType {
+		return xerrors.New("2nd argument must be a *ConnMetadata")
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package onet
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceProcessor_StreamMetadata(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+
+	require.Error(t, p.RegisterStreamingHandlerWithMetadata(
+		func(*testMsg, *StreamFlow) (chan *testMsg, chan bool, error) { return nil, nil, nil }))
+
+	// The handler counts the messages of the connection in its metadata,
+	// and keeps using the channels of the first call.
+	mds := make(chan *ConnMetadata, 2)
+	require.NoError(t, p.RegisterStreamingHandlerWithMetadata(func(msg *testMsg, md *ConnMetadata) (chan *testMsg, chan bool, error) {
+		n, _ := md.Get("count").(int64)
+		md.Set("count", n+1)
+		mds <- md
+		out, _ := md.Get("out").(chan *testMsg)
+		stop, _ := md.Get("stop").(chan bool)
+		if out == nil {
+			out = make(chan *testMsg, 2)
+			stop = make(chan bool)
+			md.Set("out", out)
+			md.Set("stop", stop)
+			go func() {
+				<-stop
+				close(out)
+			}()
+		}
+		out <- &testMsg{n + 1}
+		return out, stop, nil
+	}))
+
+	req := httptest.NewRequest("GET", "/testMsg?format=json", nil)
+	req.Header.Set("X-Client", "test")
+	inputs := make(chan []byte, 2)
+	inputs <- []byte(`{"I": 1}`)
+	inputs <- []byte(`{"I": 2}`)
+	outChan, err := p.ProcessClientStreamRequest(req, "testMsg", inputs)
+	require.NoError(t, err)
+	defer close(inputs)
+
+	for i := 1; i <= 2; i++ {
+		select {
+		case buf := <-outChan:
+			require.Contains(t, string(buf), `"I":`)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reply from the handler")
+		}
+	}
+	md1, md2 := <-mds, <-mds
+	require.True(t, md1 == md2)
+	require.Equal(t, int64(2), md1.Get("count"))
+	require.Equal(t, formatJSON, md1.Codec)
+	require.Equal(t, req.RemoteAddr, md1.RemoteAddr)
+	require.Equal(t, "test", md1.Header.Get("X-Client"))
+	require.Empty(t, md1.Principal)
+	require.False(t, md1.Start.IsZero())
+}
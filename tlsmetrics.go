@@ -0,0 +1,161 @@
+package onet
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TLSMetrics records the TLS handshakes of the clients of the websocket, to
+// diagnose the clients that can't connect and to follow the versions and the
+// cipher suites in use. It is a prometheus.Collector, to be registered by the
+// caller, and given to WebSocket.TLSMetrics before the server starts. The
+// metrics are:
+//  * tls_client_hellos_total: the number of handshakes started by the
+//    clients
+//  * tls_handshakes_total: the number of handshakes that succeeded, labeled
+//    by the negotiated version and cipher suite
+//  * tls_handshake_failures_total: the number of connections closed before
+//    their handshake completed
+type TLSMetrics struct {
+	hellos     prometheus.Counter
+	handshakes *prometheus.CounterVec
+	failures   prometheus.Counter
+
+	// recorded holds the connections whose handshake is recorded, until
+	// they are closed or hijacked.
+	recorded     map[net.Conn]bool
+	recordedLock sync.Mutex
+}
+
+// NewTLSMetrics returns the metrics of the TLS handshakes, whose names start
+// with the namespace, e.g. "onet", if it is not empty.
+func NewTLSMetrics(namespace string) *TLSMetrics {
+	return &TLSMetrics{
+		hellos: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tls_client_hellos_total",
+			Help:      "Number of TLS handshakes started by the clients.",
+		}),
+		handshakes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tls_handshakes_total",
+			Help:      "Number of TLS handshakes that succeeded.",
+		}, []string{"version", "cipher_suite"}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tls_handshake_failures_total",
+			Help:      "Number of connections closed before their TLS handshake completed.",
+		}),
+		recorded: make(map[net.Conn]bool),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *TLSMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.hellos.Describe(ch)
+	m.handshakes.Describe(ch)
+	m.failures.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *TLSMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.hellos.Collect(ch)
+	m.handshakes.Collect(ch)
+	m.failures.Collect(ch)
+}
+
+// instrument returns a copy of config that counts the handshakes started by
+// the clients. The GetConfigForClient of config, if any, is still called.
+func (m *TLSMetrics) instrument(config *tls.Config) *tls.Config {
+	c := config.Clone()
+	getConfig := config.GetConfigForClient
+	c.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		m.hellos.Inc()
+		if getConfig != nil {
+			return getConfig(hello)
+		}
+		return nil, nil
+	}
+	return c
+}
+
+// connState is the http.Server.ConnState hook that records the outcome of
+// the handshakes. A connection is active, or hijacked by the websocket, only
+// once its handshake is complete.
+func (m *TLSMetrics) connState(conn net.Conn, state http.ConnState) {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return
+	}
+	m.recordedLock.Lock()
+	defer m.recordedLock.Unlock()
+	switch state {
+	case http.StateActive, http.StateHijacked:
+		if !m.recorded[conn] {
+			cs := tc.ConnectionState()
+			m.handshakes.WithLabelValues(tlsVersionName(cs.Version),
+				cipherSuiteName(cs.CipherSuite)).Inc()
+		}
+		if state == http.StateHijacked {
+			delete(m.recorded, conn)
+		} else {
+			m.recorded[conn] = true
+		}
+	case http.StateClosed:
+		if !m.recorded[conn] && !tc.ConnectionState().HandshakeComplete {
+			m.failures.Inc()
+		}
+		delete(m.recorded, conn)
+	}
+}
+
+// tlsVersionName returns the name of a version of TLS.
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// cipherSuiteNames are the names of the cipher suites supported by
+// crypto/tls, apart from the insecure ones.
+var cipherSuiteNames = map[uint16]string{
+	tls.TLS_AES_128_GCM_SHA256:                  "TLS_AES_128_GCM_SHA256",
+	tls.TLS_AES_256_GCM_SHA384:                  "TLS_AES_256_GCM_SHA384",
+	tls.TLS_CHACHA20_POLY1305_SHA256:            "TLS_CHACHA20_POLY1305_SHA256",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305:  "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA:    "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA:    "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256:   "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384:   "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305:    "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA:      "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA:      "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256:         "TLS_RSA_WITH_AES_128_GCM_SHA256",
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384:         "TLS_RSA_WITH_AES_256_GCM_SHA384",
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA:            "TLS_RSA_WITH_AES_128_CBC_SHA",
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA:            "TLS_RSA_WITH_AES_256_CBC_SHA",
+}
+
+// cipherSuiteName returns the name of a cipher suite, or its ID in
+// hexadecimal if it is not known.
+func cipherSuiteName(id uint16) string {
+	if name, ok := cipherSuiteNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", id)
+}
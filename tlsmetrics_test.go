@@ -0,0 +1,50 @@
+package onet
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSMetrics(t *testing.T) {
+	m := NewTLSMetrics("")
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.TLS = m.instrument(&tls.Config{MaxVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}})
+	srv.Config.ConnState = m.connState
+	srv.StartTLS()
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := srv.Client().Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	// The client keeps its connection.
+	require.Equal(t, 1.0, testutil.ToFloat64(m.handshakes.WithLabelValues("TLS 1.2",
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")))
+
+	// A client that doesn't trust the certificate fails its handshake.
+	_, err := http.Get(srv.URL)
+	require.Error(t, err)
+	require.Equal(t, 2.0, testutil.ToFloat64(m.hellos))
+	require.Eventually(t, func() bool { return testutil.ToFloat64(m.failures) == 1 },
+		5*time.Second, 10*time.Millisecond)
+
+	// So does a client that doesn't speak TLS.
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	conn.Close()
+	require.Eventually(t, func() bool { return testutil.ToFloat64(m.failures) == 2 },
+		5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 2.0, testutil.ToFloat64(m.hellos))
+}
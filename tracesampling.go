@@ -0,0 +1,115 @@
+package onet
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TraceSampling selects the requests of the handlers of a ServiceProcessor
+// that are reported to the tracing, so that tracing every request doesn't
+// become too expensive under load. The failing requests are always sampled,
+// as well as the ones that are slower or larger than the thresholds. The
+// other requests follow the sampling decision of the traceparent header of
+// the W3C Trace Context, if the client sent one, or are sampled with the
+// probability Rate.
+type TraceSampling struct {
+	// Rate is the fraction of the requests that are sampled, between 0
+	// and 1.
+	Rate float64
+	// SlowerThan, if not zero, samples the requests that take at least
+	// this long.
+	SlowerThan time.Duration
+	// LargerThan, if not zero, samples the requests whose message or reply
+	// has at least this number of bytes.
+	LargerThan int
+	// Report gets the sampled requests, e.g. tracing.TraceLogger.ReportRequest.
+	Report func(RequestTrace)
+}
+
+// RequestTrace describes a request sampled by TraceSampling.
+type RequestTrace struct {
+	// Handler is the name of the message of the handler.
+	Handler string
+	// TraceID is the trace ID of the traceparent header, empty if the
+	// client didn't send one.
+	TraceID string
+	// Start is the time the request was received at.
+	Start time.Time
+	// Duration is the time it took to answer the request.
+	Duration time.Duration
+	// RequestSize and ReplySize are the sizes of the encoded message and
+	// reply, zero for a failed request.
+	RequestSize int
+	ReplySize   int
+	// Err is the error of the handler, nil if it succeeded.
+	Err error
+	// Reason tells why the request was sampled: "error", "slow", "large",
+	// "parent" or "rate".
+	Reason string
+}
+
+// sampleTrace reports the request to TraceSampling if it is sampled.
+func (p *ServiceProcessor) sampleTrace(req *http.Request, handler string, start time.Time,
+	requestSize, replySize int, err error) {
+	ts := p.TraceSampling
+	if ts == nil || ts.Report == nil {
+		return
+	}
+	rt := RequestTrace{
+		Handler:     handler,
+		Start:       start,
+		Duration:    time.Since(start),
+		RequestSize: requestSize,
+		ReplySize:   replySize,
+		Err:         err,
+	}
+	traceID, sampled, hasParent := "", false, false
+	if req != nil {
+		traceID, sampled, hasParent = parseTraceParent(req.Header.Get("traceparent"))
+	}
+	rt.TraceID = traceID
+
+	switch {
+	case err != nil:
+		rt.Reason = "error"
+	case ts.SlowerThan > 0 && rt.Duration >= ts.SlowerThan:
+		rt.Reason = "slow"
+	case ts.LargerThan > 0 && (requestSize >= ts.LargerThan || replySize >= ts.LargerThan):
+		rt.Reason = "large"
+	case hasParent:
+		if !sampled {
+			return
+		}
+		rt.Reason = "parent"
+	case ts.Rate > 0 && rand.Float64() < ts.Rate:
+		rt.Reason = "rate"
+	default:
+		return
+	}
+	ts.Report(rt)
+}
+
+// parseTraceParent returns the trace ID and the sampled flag of a
+// traceparent header, in the form version-traceid-parentid-flags. ok is false
+// if the header is missing or invalid.
+func parseTraceParent(header string) (traceID string, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 ||
+		len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", false, false
+	}
+	// version ff is forbidden, and the later versions can have more parts
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return "", false, false
+	}
+	for _, part := range parts[:4] {
+		if _, err := hex.DecodeString(part); err != nil {
+			return "", false, false
+		}
+	}
+	flags, _ := hex.DecodeString(parts[3])
+	return parts[1], flags[0]&1 == 1, true
+}
@@ -0,0 +1,110 @@
+package onet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	const id = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		header          string
+		sampled, ok     bool
+		expectedTraceID string
+	}{
+		{"00-" + id + "-00f067aa0ba902b7-01", true, true, id},
+		{"00-" + id + "-00f067aa0ba902b7-00", false, true, id},
+		{"01-" + id + "-00f067aa0ba902b7-03-future", true, true, id},
+		{"", false, false, ""},
+		{"00-" + id + "-00f067aa0ba902b7-01-extra", false, false, ""},
+		{"ff-" + id + "-00f067aa0ba902b7-01", false, false, ""},
+		{"00-" + id[1:] + "-00f067aa0ba902b7-01", false, false, ""},
+		{"00-" + id + "-00f067aa0ba902bz-01", false, false, ""},
+	}
+	for _, test := range tests {
+		traceID, sampled, ok := parseTraceParent(test.header)
+		require.Equal(t, test.ok, ok, test.header)
+		require.Equal(t, test.sampled, sampled, test.header)
+		require.Equal(t, test.expectedTraceID, traceID, test.header)
+	}
+}
+
+func TestServiceProcessor_TraceSampling(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	srv := local.GenServers(1)[0]
+	p := NewServiceProcessor(&Context{server: srv})
+	require.NoError(t, p.RegisterHandler(func(msg *testMsg) (*testMsg, error) {
+		if msg.I == 1 {
+			return nil, xerrors.New("internal failure")
+		}
+		return msg, nil
+	}))
+	require.NoError(t, p.RegisterRESTHandler(procRestMsgGET2, "dummyService", "GET", 3, 3))
+
+	var reports []RequestTrace
+	ts := &TraceSampling{Report: func(rt RequestTrace) {
+		reports = append(reports, rt)
+	}}
+	request := func(i int64, traceParent string) {
+		req := httptest.NewRequest("GET", "/testMsg", nil)
+		if traceParent != "" {
+			req.Header.Set("traceparent", traceParent)
+		}
+		buf, err := protobuf.Encode(&testMsg{i})
+		require.NoError(t, err)
+		p.ProcessClientRequest(req, "testMsg", buf)
+	}
+	const sampled = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	const notSampled = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"
+
+	// Without TraceSampling, nothing is reported.
+	request(1, "")
+	p.TraceSampling = ts
+	request(0, "")
+	require.Equal(t, 0, len(reports))
+
+	request(1, "")
+	require.Equal(t, 1, len(reports))
+	require.Equal(t, "error", reports[0].Reason)
+	require.Equal(t, "testMsg", reports[0].Handler)
+	require.Error(t, reports[0].Err)
+
+	request(0, sampled)
+	require.Equal(t, 2, len(reports))
+	require.Equal(t, "parent", reports[1].Reason)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", reports[1].TraceID)
+	require.NotZero(t, reports[1].ReplySize)
+
+	ts.Rate = 1
+	request(0, notSampled)
+	require.Equal(t, 2, len(reports))
+	request(0, "")
+	require.Equal(t, 3, len(reports))
+	require.Equal(t, "rate", reports[2].Reason)
+
+	ts.Rate = 0
+	ts.LargerThan = 1
+	request(0, notSampled)
+	require.Equal(t, 4, len(reports))
+	require.Equal(t, "large", reports[3].Reason)
+
+	ts.LargerThan = 0
+	ts.SlowerThan = time.Nanosecond
+	request(0, "")
+	require.Equal(t, 5, len(reports))
+	require.Equal(t, "slow", reports[4].Reason)
+
+	w := httptest.NewRecorder()
+	p.getRouter().ServeHTTP(w, httptest.NewRequest("GET", "/v3/dummyService/restMsgGET2/42", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, 6, len(reports))
+	require.Equal(t, "restMsgGET2", reports[5].Handler)
+	require.Equal(t, w.Body.Len(), reports[5].ReplySize)
+}
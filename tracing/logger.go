@@ -141,6 +141,35 @@ func (logger *TraceLogger) AddStats(c *onet.Context, repeat time.Duration) {
 	}()
 }
 
+// ReportRequest sends a trace with the request sampled by an
+// onet.TraceSampling, so that it can be given as its Report:
+//   p.TraceSampling = &onet.TraceSampling{Rate: 0.01, Report: logger.ReportRequest}
+func (logger *TraceLogger) ReportRequest(rt onet.RequestTrace) {
+	t, _ := logger.newTrace(context.TODO(), "",
+		stackEntry{pkgPath: "go.dedis.ch/onet/v3", method: rt.Handler})
+	logger.logMutex.Lock()
+	for k, v := range logger.defaultFields {
+		t.hcTrace.AddField(k, v)
+	}
+	logger.logMutex.Unlock()
+	req := map[string]interface{}{
+		"handler":      rt.Handler,
+		"start":        rt.Start,
+		"durationMS":   float64(rt.Duration) / float64(time.Millisecond),
+		"requestSize":  rt.RequestSize,
+		"replySize":    rt.ReplySize,
+		"sampleReason": rt.Reason,
+	}
+	if rt.TraceID != "" {
+		req["traceParentID"] = rt.TraceID
+	}
+	if rt.Err != nil {
+		req["error"] = rt.Err.Error()
+	}
+	t.add("request", req)
+	t.send()
+}
+
 // AddEnvironment reads the environment variables defined to initialize the
 // variables.
 // The following environmental variables are available:
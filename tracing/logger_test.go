@@ -1,11 +1,14 @@
 package tracing
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
 
 	"go.dedis.ch/kyber/v3/suites"
@@ -76,3 +79,25 @@ func subgo(wg *sync.WaitGroup) {
 	log.Lvl3("sub-goroutine")
 	wg.Done()
 }
+
+func TestReportRequest(t *testing.T) {
+	sc, tr := newSimulLogger()
+	log.UnregisterLogger(tr.loggerID)
+	tr.defaultFields["nodeName"] = "node"
+	tr.ReportRequest(onet.RequestTrace{
+		Handler:     "testMsg",
+		Duration:    1500 * time.Microsecond,
+		RequestSize: 12,
+		Err:         errors.New("failure"),
+		Reason:      "error",
+	})
+	sc.Wg.Wait()
+	require.Equal(t, 1, len(sc.Traces))
+	root := sc.Traces[0][0]
+	require.Equal(t, `"testMsg"`, root["method"])
+	require.Equal(t, `"node"`, root["nodeName"])
+	require.Equal(t, `"failure"`, root["request.error"])
+	require.Equal(t, `"error"`, root["request.sampleReason"])
+	require.Equal(t, "12", root["request.requestSize"])
+	require.Equal(t, "1.5", root["request.durationMS"])
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"math/rand"
 	"sort"
+	"strings"
 
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/onet/v3/log"
@@ -802,6 +803,56 @@ func (ro *Roster) Contains(pubs []kyber.Point) bool {
 	return true
 }
 
+// RosterConstraints describes the requirements a roster must fulfil before
+// a protocol is started over it.
+type RosterConstraints struct {
+	// MinSize is the minimum number of server identities in the roster.
+	MinSize int
+	// Required are the public keys that must be present in the roster.
+	Required []kyber.Point
+	// Reachable, if not nil, is called for every server identity of the
+	// roster and must return an error if the server cannot be reached.
+	Reachable func(si *network.ServerIdentity) error
+}
+
+// Validate checks the roster against the given constraints. The returned
+// error lists every constraint that is not met, so that the caller can fix
+// the roster before starting a protocol that would fail partway.
+func (ro *Roster) Validate(c RosterConstraints) error {
+	if ro == nil {
+		return xerrors.New("roster is nil")
+	}
+
+	var problems []string
+	if len(ro.List) < c.MinSize {
+		problems = append(problems, fmt.Sprintf("roster has %d nodes but at least %d are required",
+			len(ro.List), c.MinSize))
+	}
+
+	table := make(map[string]bool)
+	for _, p := range ro.Publics() {
+		table[p.String()] = true
+	}
+	for _, p := range c.Required {
+		if !table[p.String()] {
+			problems = append(problems, fmt.Sprintf("missing required public key %v", p))
+		}
+	}
+
+	if c.Reachable != nil {
+		for _, si := range ro.List {
+			if err := c.Reachable(si); err != nil {
+				problems = append(problems, fmt.Sprintf("node %v is not reachable: %v", si, err))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return xerrors.Errorf("invalid roster: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
 // Equal checks if two roster are the same by checking the generated ID
 func (ro *Roster) Equal(other *Roster) (bool, error) {
 	roID, err := ro.GetID()
@@ -14,6 +14,7 @@ import (
 	"go.dedis.ch/kyber/v3/util/key"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
+	"golang.org/x/xerrors"
 )
 
 var prefix = "127.0.0.1:"
@@ -612,6 +613,39 @@ func TestRoster_Contains(t *testing.T) {
 	require.False(t, roster.Contains(pubs[1:]))
 }
 
+func TestRoster_Validate(t *testing.T) {
+	_, roster := genLocalTree(5, 2000)
+	_, other := genLocalTree(1, 2100)
+
+	require.NoError(t, roster.Validate(RosterConstraints{}))
+	require.NoError(t, roster.Validate(RosterConstraints{
+		MinSize:  5,
+		Required: roster.Publics()[1:3],
+	}))
+
+	err := roster.Validate(RosterConstraints{MinSize: 6})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "at least 6")
+
+	err = roster.Validate(RosterConstraints{Required: other.Publics()})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), other.Publics()[0].String())
+
+	err = roster.Validate(RosterConstraints{
+		Reachable: func(si *network.ServerIdentity) error {
+			if si.Equal(roster.List[2]) {
+				return xerrors.New("timeout")
+			}
+			return nil
+		},
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "timeout")
+
+	var nilRoster *Roster
+	require.Error(t, nilRoster.Validate(RosterConstraints{}))
+}
+
 // Checks that you can concatenate two rosters together
 // without duplicates
 func TestRoster_Concat(t *testing.T) {
@@ -0,0 +1,315 @@
+package onet
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/xerrors"
+)
+
+// The fields of the messages can be given validation rules with the validate
+// struct tag, e.g. `validate:"required,maxlen=64"`. The rules are checked on
+// every decoded message, from the websocket or the REST API, after the
+// defaults are applied, and the messages that break them are rejected with a
+// 400 Bad Request StatusError. They also appear in the schemas of
+// OpenAPISpec, so that the clients can check their messages before sending
+// them. The rules are:
+//  * required: the field must not be empty, i.e. neither zero, nil, nor an
+//    empty string, slice or map
+//  * minlen=N, maxlen=N: bounds of the number of characters of a string
+//  * minitems=N, maxitems=N: bounds of the number of items of a slice, an
+//    array or a map, except []byte
+//  * default=V: the value given to the field if it is empty, for the
+//    strings, booleans, numbers and time.Duration
+// The rules apply to the fields of the structs found anywhere in the message.
+
+// fieldRules are the validation rules of a field.
+type fieldRules struct {
+	// deprecated is set by the deprecated option of the onet tag, see
+	// isDeprecated
+	deprecated         bool
+	required           bool
+	minLen, maxLen     int
+	minItems, maxItems int
+	// def is the default value, invalid if there is none
+	def reflect.Value
+}
+
+// empty tells if there is no rule.
+func (r fieldRules) empty() bool {
+	return !r.deprecated && !r.required && r.minLen < 0 && r.maxLen < 0 && r.minItems < 0 &&
+		r.maxItems < 0 && !r.def.IsValid()
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// parseRules returns the rules of the validate tag of the field f.
+func parseRules(f reflect.StructField) (fieldRules, error) {
+	r := fieldRules{minLen: -1, maxLen: -1, minItems: -1, maxItems: -1,
+		deprecated: isDeprecated(f)}
+	tag, ok := f.Tag.Lookup("validate")
+	if !ok {
+		return r, nil
+	}
+	t := f.Type
+	isBytes := t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+	for _, rule := range strings.Split(tag, ",") {
+		name, value := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, value = rule[:i], rule[i+1:]
+		}
+		var bound *int
+		switch name {
+		case "required":
+			r.required = true
+			continue
+		case "default":
+			def, err := parseDefault(t, value)
+			if err != nil {
+				return r, xerrors.Errorf("default of %s: %v", f.Name, err)
+			}
+			r.def = def
+			continue
+		case "minlen", "maxlen":
+			if t.Kind() != reflect.String {
+				return r, xerrors.Errorf("%s of %s: not a string", name, f.Name)
+			}
+			bound = &r.minLen
+			if name == "maxlen" {
+				bound = &r.maxLen
+			}
+		case "minitems", "maxitems":
+			k := t.Kind()
+			if isBytes || k != reflect.Slice && k != reflect.Array && k != reflect.Map {
+				return r, xerrors.Errorf("%s of %s: not a slice, an array or a map", name, f.Name)
+			}
+			bound = &r.minItems
+			if name == "maxitems" {
+				bound = &r.maxItems
+			}
+		default:
+			return r, xerrors.Errorf("unknown rule %q of %s", rule, f.Name)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return r, xerrors.Errorf("%s of %s: invalid bound %q", name, f.Name, value)
+		}
+		*bound = n
+	}
+	if (r.maxLen >= 0 && r.minLen > r.maxLen) || (r.maxItems >= 0 && r.minItems > r.maxItems) {
+		return r, xerrors.Errorf("rules of %s: minimum greater than the maximum", f.Name)
+	}
+	return r, nil
+}
+
+// parseDefault parses the default value of a field of type t.
+func parseDefault(t reflect.Type, value string) (reflect.Value, error) {
+	def := reflect.New(t).Elem()
+	var err error
+	switch t.Kind() {
+	case reflect.String:
+		def.SetString(value)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		def.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if t == durationType {
+			var d time.Duration
+			d, err = time.ParseDuration(value)
+			def.SetInt(int64(d))
+			break
+		}
+		var i int64
+		i, err = strconv.ParseInt(value, 10, t.Bits())
+		def.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		u, err = strconv.ParseUint(value, 10, t.Bits())
+		def.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(value, t.Bits())
+		def.SetFloat(f)
+	default:
+		return reflect.Value{}, xerrors.Errorf("unsupported type %s", t)
+	}
+	if err != nil {
+		return reflect.Value{}, xerrors.Errorf("parsing %q: %v", value, err)
+	}
+	return def, nil
+}
+
+// ruledField is a field of a struct with its rules.
+type ruledField struct {
+	name  string
+	index []int
+	rules fieldRules
+}
+
+// structRules caches the fields of the structs, see rulesOf.
+var structRules sync.Map
+
+// rulesOf returns the exported fields of the struct t with their rules.
+func rulesOf(t reflect.Type) ([]ruledField, error) {
+	if fields, ok := structRules.Load(t); ok {
+		return fields.([]ruledField), nil
+	}
+	var fields []ruledField
+	for _, tf := range taggedFields(t, "json") {
+		f := t.FieldByIndex(tf.index)
+		rules, err := parseRules(f)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, ruledField{name: f.Name, index: tf.index, rules: rules})
+	}
+	structRules.Store(t, fields)
+	return fields, nil
+}
+
+// checkRules returns an error if the validate tags of the fields of the
+// message type t, or of the types it contains, are invalid.
+func checkRules(t reflect.Type) error {
+	return checkRulesRec(t, make(map[reflect.Type]bool))
+}
+
+func checkRulesRec(t reflect.Type, visited map[reflect.Type]bool) error {
+	if visited[t] {
+		return nil
+	}
+	visited[t] = true
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return checkRulesRec(t.Elem(), visited)
+	case reflect.Struct:
+		fields, err := rulesOf(t)
+		if err != nil {
+			return xerrors.Errorf("%s: %v", t, err)
+		}
+		for _, f := range fields {
+			if err := checkRulesRec(t.FieldByIndex(f.index).Type, visited); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// prepareMessage applies the defaults of the validate tags and of the
+// Defaulter interface to the decoded message msg, and then checks its rules.
+func prepareMessage(msg interface{}) error {
+	v := reflect.ValueOf(msg)
+	err := walkRules(v, "", func(f reflect.Value, path string, r fieldRules) error {
+		if r.def.IsValid() && isEmpty(f) && f.CanSet() {
+			f.Set(r.def)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	applyDefaults(msg)
+	return walkRules(v, "", checkField)
+}
+
+// checkField returns a StatusError if the field f breaks its rules.
+func checkField(f reflect.Value, path string, r fieldRules) error {
+	invalid := func(format string, args ...interface{}) error {
+		return StatusError{Code: http.StatusBadRequest,
+			Msg: path + ": " + fmt.Sprintf(format, args...)}
+	}
+	if r.required && isEmpty(f) {
+		return invalid("is required")
+	}
+	if f.Kind() == reflect.String {
+		n := utf8.RuneCountInString(f.String())
+		if r.minLen >= 0 && n < r.minLen {
+			return invalid("shorter than %d characters", r.minLen)
+		}
+		if r.maxLen >= 0 && n > r.maxLen {
+			return invalid("longer than %d characters", r.maxLen)
+		}
+	}
+	switch f.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if r.minItems >= 0 && f.Len() < r.minItems {
+			return invalid("fewer than %d items", r.minItems)
+		}
+		if r.maxItems >= 0 && f.Len() > r.maxItems {
+			return invalid("more than %d items", r.maxItems)
+		}
+	}
+	return nil
+}
+
+// isEmpty tells if the value is zero, nil, or an empty string, slice or map.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+// walkRules calls fn on the fields with rules of the structs found in v,
+// parents first. path is the path of v in the message, for the errors.
+func walkRules(v reflect.Value, path string,
+	fn func(f reflect.Value, path string, r fieldRules) error) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return walkRules(v.Elem(), path, fn)
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := walkRules(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			p := fmt.Sprintf("%s[%v]", path, iter.Key())
+			if err := walkRules(iter.Value(), p, fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields, err := rulesOf(v.Type())
+		if err != nil {
+			return err
+		}
+		for _, rf := range fields {
+			f := fieldByIndex(v, rf.index, false)
+			if !f.IsValid() {
+				continue
+			}
+			p := rf.name
+			if path != "" {
+				p = path + "." + rf.name
+			}
+			if !rf.rules.empty() {
+				if err := fn(f, p, rf.rules); err != nil {
+					return err
+				}
+			}
+			if err := walkRules(f, p, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
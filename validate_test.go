@@ -0,0 +1,163 @@
+package onet
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+type validatedItem struct {
+	ID string `validate:"required"`
+}
+
+type validatedMsg struct {
+	Name  string   `json:"name" validate:"required,maxlen=8"`
+	Tags  []string `validate:"maxitems=2"`
+	Limit int64    `validate:"default=10"`
+	Max   int64
+	Items []*validatedItem
+}
+
+func (m *validatedMsg) Defaults() {
+	if m.Max == 0 {
+		m.Max = 2 * m.Limit
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules := func(tag string, v interface{}) (fieldRules, error) {
+		return parseRules(reflect.StructField{Name: "F", Type: reflect.TypeOf(v),
+			Tag: reflect.StructTag(`validate:"` + tag + `"`)})
+	}
+
+	r, err := rules("required,minlen=1,maxlen=3,default=ab", "")
+	require.NoError(t, err)
+	require.True(t, r.required)
+	require.Equal(t, 1, r.minLen)
+	require.Equal(t, 3, r.maxLen)
+	require.Equal(t, "ab", r.def.Interface())
+	require.Equal(t, -1, r.minItems)
+
+	r, err = rules("minitems=0,maxitems=4", map[string]int{})
+	require.NoError(t, err)
+	require.Equal(t, 0, r.minItems)
+	require.Equal(t, 4, r.maxItems)
+
+	r, err = rules("default=1m30s", time.Duration(0))
+	require.NoError(t, err)
+	require.Equal(t, 90*time.Second, r.def.Interface())
+
+	for tag, v := range map[string]interface{}{
+		"optional":           "",
+		"maxlen=3":           0,
+		"maxlen=-1":          "",
+		"maxlen":             "",
+		"minlen=4,maxlen=3":  "",
+		"maxitems=3":         []byte{},
+		"default=x":          0,
+		"default=300":        int8(0),
+		"default=1":          []int{},
+		"minitems=1,default": "",
+	} {
+		_, err := rules(tag, v)
+		require.Error(t, err, tag)
+	}
+}
+
+func TestServiceProcessor_Validate(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	srv := local.GenServers(1)[0]
+	p := NewServiceProcessor(&Context{server: srv})
+
+	var received []validatedMsg
+	h := func(msg *validatedMsg) (*validatedMsg, error) {
+		received = append(received, *msg)
+		return msg, nil
+	}
+	require.NoError(t, p.RegisterHandler(h))
+	require.NoError(t, p.RegisterRESTHandler(h, "dummyService", "POST", 3, 3))
+
+	// The tags are checked when the handlers are registered.
+	type badMsg struct {
+		N int `validate:"maxlen=3"`
+	}
+	require.Error(t, p.RegisterHandler(func(*badMsg) (*badMsg, error) { return nil, nil }))
+
+	buf, err := protobuf.Encode(&validatedMsg{Name: "a", Max: 3})
+	require.NoError(t, err)
+	_, _, err = p.ProcessClientRequest(nil, "validatedMsg", buf)
+	require.NoError(t, err)
+
+	buf, err = protobuf.Encode(&validatedMsg{Name: "123456789"})
+	require.NoError(t, err)
+	_, _, err = p.ProcessClientRequest(nil, "validatedMsg", buf)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Name: longer than 8 characters")
+
+	post := func(body string) (int, string) {
+		r := httptest.NewRequest("POST", "/v3/dummyService/validatedMsg", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		p.getRouter().ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	code, body := post(`{"name": "b", "Limit": 4, "Items": [{"ID": "x"}]}`)
+	require.Equal(t, http.StatusOK, code, body)
+
+	for body, msg := range map[string]string{
+		`{"Limit": 4}`:                              "Name: is required",
+		`{"name": "c", "Tags": ["1", "2", "3"]}`:    "Tags: more than 2 items",
+		`{"name": "c", "Items": [{"ID": "x"}, {}]}`: "Items[1].ID: is required",
+	} {
+		code, resp := post(body)
+		require.Equal(t, http.StatusBadRequest, code, body)
+		require.Contains(t, resp, msg)
+	}
+
+	// The defaults of the tags are applied before the Defaults method.
+	require.Equal(t, []validatedMsg{
+		{Name: "a", Limit: 10, Max: 3},
+		{Name: "b", Limit: 4, Max: 8, Items: []*validatedItem{{ID: "x"}}},
+	}, received)
+}
+
+func TestServiceProcessor_OpenAPISpec_Rules(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	p := NewServiceProcessor(&Context{server: local.GenServers(1)[0]})
+
+	require.NoError(t, p.RegisterRESTHandler(func(msg *validatedMsg) (*validatedMsg, error) {
+		return msg, nil
+	}, "dummyService", "POST", 3, 3))
+
+	buf, err := p.OpenAPISpec()
+	require.NoError(t, err)
+	var spec struct {
+		Components struct {
+			Schemas map[string]struct {
+				Required   []string
+				Properties map[string]map[string]interface{}
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal(buf, &spec))
+
+	msg := spec.Components.Schemas["validatedMsg"]
+	require.Equal(t, []string{"name"}, msg.Required)
+	require.Equal(t, 8.0, msg.Properties["name"]["maxLength"])
+	require.NotContains(t, msg.Properties["name"], "minLength")
+	require.Equal(t, 2.0, msg.Properties["Tags"]["maxItems"])
+	require.Equal(t, 10.0, msg.Properties["Limit"]["default"])
+	require.NotContains(t, msg.Properties["Max"], "default")
+
+	require.Equal(t, []string{"ID"}, spec.Components.Schemas["validatedItem"].Required)
+}
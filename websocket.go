@@ -1,8 +1,11 @@
 package onet
 
 import (
+	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net"
@@ -24,6 +27,10 @@ import (
 
 const certificateReloaderLeeway = 1 * time.Hour
 
+// DefaultStreamDrainTimeout is how long the streams are given to stop when
+// the websocket stops, if WebSocket.StreamDrainTimeout is not set.
+const DefaultStreamDrainTimeout = 1 * time.Second
+
 // CertificateReloader takes care of reloading a TLS certificate when
 // requested.
 type CertificateReloader struct {
@@ -108,6 +115,43 @@ type WebSocket struct {
 	startstop chan bool
 	started   bool
 	TLSConfig *tls.Config // can only be modified before Start is called
+	// TLSMetrics, if not nil, records the TLS handshakes of the clients
+	// when TLSConfig is set. It can only be modified before Start is
+	// called.
+	TLSMetrics *TLSMetrics
+	// UpgradeTimeout, if not zero, is the time given to the clients to send
+	// their request and to complete the websocket handshake, after which
+	// their connection is closed. It can only be modified before Start is
+	// called.
+	UpgradeTimeout time.Duration
+	// StreamDrainTimeout is how long stop waits for the streams to end,
+	// once their handlers are told to stop, before it closes their
+	// connections. If zero, DefaultStreamDrainTimeout is used.
+	StreamDrainTimeout time.Duration
+	// conns are the open websocket connections, which are closed by stop
+	// as the http server doesn't track the hijacked connections.
+	conns map[*websocket.Conn]bool
+	// streams holds a channel for each stream being served, which is
+	// closed when it ends, and shutdown is closed by stop to end them.
+	streams   map[chan struct{}]bool
+	shutdown  chan struct{}
+	connsLock sync.Mutex
+	// readiness are the probes checked by the /ready endpoint
+	readiness *readinessProbes
+	// healthProbes is true once /healthz and /readyz are registered, see
+	// Server.HandleHealthProbes
+	healthProbes bool
+	// serving is true while the http server is listening
+	serving     bool
+	servingLock sync.Mutex
+	// config and configToken serve the /config endpoint, see
+	// Server.HandleConfig
+	config      func() interface{}
+	configToken string
+	// metrics and metricsToken serve the /metrics endpoint, see
+	// Server.HandleMetrics
+	metrics      http.Handler
+	metricsToken string
 	sync.Mutex
 }
 
@@ -117,6 +161,10 @@ func NewWebSocket(si *network.ServerIdentity) *WebSocket {
 	w := &WebSocket{
 		services:  make(map[string]Service),
 		startstop: make(chan bool),
+		conns:     make(map[*websocket.Conn]bool),
+		streams:   make(map[chan struct{}]bool),
+		shutdown:  make(chan struct{}),
+		readiness: newReadinessProbes(),
 	}
 	webHost, err := getWSHostPort(si, true)
 	log.ErrFatal(err)
@@ -126,6 +174,10 @@ func NewWebSocket(si *network.ServerIdentity) *WebSocket {
 		ok := []byte("ok\n")
 		w.Write(ok)
 	})
+	// Unlike /ok, /ready tells whether the services are ready to serve the
+	// requests, according to their readiness probes.
+	w.mux.Handle("/ready", w.readiness)
+	w.mux.HandleFunc("/config", w.serveConfig)
 
 	if allowPprof() {
 		log.Warn("HTTP pprof profiling is enabled")
@@ -183,15 +235,33 @@ func (w *WebSocket) start() {
 	w.Lock()
 	w.started = true
 	w.server.Server.TLSConfig = w.TLSConfig
+	if w.TLSConfig != nil && w.TLSMetrics != nil {
+		w.server.Server.TLSConfig = w.TLSMetrics.instrument(w.TLSConfig)
+		w.server.ConnState = w.TLSMetrics.connState
+	}
+	w.server.Server.ReadHeaderTimeout = w.UpgradeTimeout
 	log.Lvl2("Starting to listen on", w.server.Server.Addr)
 	started := make(chan bool)
 	go func() {
 		// Check if server is configured for TLS
 		started <- true
+		var l net.Listener
+		var err error
 		if w.server.Server.TLSConfig != nil && (w.server.TLSConfig.GetCertificate != nil || len(w.server.Server.TLSConfig.Certificates) >= 1) {
-			w.server.ListenAndServeTLS("", "")
+			l, err = w.server.ListenTLS("", "")
 		} else {
-			w.server.ListenAndServe()
+			l, err = net.Listen("tcp", w.server.Server.Addr)
+		}
+		if err != nil {
+			log.Error(xerrors.Errorf("websocket listen: %v", err))
+			return
+		}
+		w.setServing(true)
+		// Serve returns nil once stopped, and stop already marks the
+		// server as not listening.
+		if err := w.server.Serve(l); err != nil {
+			log.Error(xerrors.Errorf("websocket serve: %v", err))
+			w.setServing(false)
 		}
 	}()
 	<-started
@@ -199,17 +269,86 @@ func (w *WebSocket) start() {
 	w.startstop <- true
 }
 
+// isServing returns true while the http server is listening, unlike
+// Listening that is true as soon as it is started.
+func (w *WebSocket) isServing() bool {
+	w.servingLock.Lock()
+	defer w.servingLock.Unlock()
+	return w.serving
+}
+
+func (w *WebSocket) setServing(serving bool) {
+	w.servingLock.Lock()
+	w.serving = serving
+	w.servingLock.Unlock()
+}
+
+// serveConfig answers the requests of the /config endpoint with the
+// configuration given to Server.HandleConfig, if they have its token.
+func (w *WebSocket) serveConfig(wr http.ResponseWriter, r *http.Request) {
+	w.Lock()
+	config, token := w.config, w.configToken
+	w.Unlock()
+	if config == nil {
+		http.NotFound(wr, r)
+		return
+	}
+	if !checkBearer(wr, r, token) {
+		return
+	}
+	buf, err := json.MarshalIndent(config(), "", "  ")
+	if err != nil {
+		http.Error(wr, wrapJSONMsg(err.Error()), http.StatusInternalServerError)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	wr.Write(buf)
+}
+
+// checkBearer returns true if the request r gives the token in an
+// "Authorization: Bearer <token>" header, and answers with 401 Unauthorized
+// otherwise.
+func checkBearer(wr http.ResponseWriter, r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+		wr.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(wr, wrapJSONMsg("invalid token"), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// serveMetrics answers the requests of the /metrics endpoint with the
+// metrics given to Server.HandleMetrics, if they have its token.
+func (w *WebSocket) serveMetrics(wr http.ResponseWriter, r *http.Request) {
+	w.Lock()
+	metrics, token := w.metrics, w.metricsToken
+	w.Unlock()
+	if token != "" && !checkBearer(wr, r, token) {
+		return
+	}
+	metrics.ServeHTTP(wr, r)
+}
+
+// reservedServiceNames are the names of the endpoints of the websocket, that
+// a service cannot take.
+var reservedServiceNames = map[string]bool{
+	"ok": true, "ready": true, "config": true,
+	"metrics": true, "healthz": true, "readyz": true,
+}
+
 // registerService stores a service to the given path. All requests to that
 // path and it's sub-endpoints will be forwarded to ProcessClientRequest.
 func (w *WebSocket) registerService(service string, s Service) error {
-	if service == "ok" {
-		return xerrors.New("service name \"ok\" is not allowed")
+	if reservedServiceNames[service] {
+		return xerrors.Errorf("service name \"%s\" is not allowed", service)
 	}
 
 	w.services[service] = s
 	h := &wsHandler{
 		service:     s,
 		serviceName: service,
+		webSocket:   w,
 	}
 	w.mux.Handle(fmt.Sprintf("/%s/", service), h)
 	return nil
@@ -223,15 +362,130 @@ func (w *WebSocket) stop() {
 		return
 	}
 	log.Lvl3("Stopping", w.server.Server.Addr)
+	w.setServing(false)
+	w.drainStreams()
 	w.server.Stop(100 * time.Millisecond)
 	<-w.startstop
 	w.started = false
+
+	w.connsLock.Lock()
+	for ws := range w.conns {
+		ws.Close()
+	}
+	// for the next start
+	w.shutdown = make(chan struct{})
+	w.connsLock.Unlock()
+}
+
+// drainStreams tells the streams to end, so that the handlers see their
+// closeChan closed, and waits for them at most StreamDrainTimeout.
+func (w *WebSocket) drainStreams() {
+	w.connsLock.Lock()
+	close(w.shutdown)
+	streams := make([]chan struct{}, 0, len(w.streams))
+	for done := range w.streams {
+		streams = append(streams, done)
+	}
+	w.connsLock.Unlock()
+
+	timeout := w.StreamDrainTimeout
+	if timeout == 0 {
+		timeout = DefaultStreamDrainTimeout
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for _, done := range streams {
+		select {
+		case <-done:
+		case <-deadline.C:
+			log.Warnf("%d streams didn't end within %s", len(streams), timeout)
+			return
+		}
+	}
+}
+
+// trackStream adds a stream, which closes done when it ends, and returns the
+// channel closed when the websocket stops.
+func (w *WebSocket) trackStream(done chan struct{}) <-chan struct{} {
+	w.connsLock.Lock()
+	defer w.connsLock.Unlock()
+	w.streams[done] = true
+	go func() {
+		<-done
+		w.connsLock.Lock()
+		delete(w.streams, done)
+		w.connsLock.Unlock()
+	}()
+	return w.shutdown
+}
+
+// trackConn adds or removes an open connection.
+func (w *WebSocket) trackConn(ws *websocket.Conn, open bool) {
+	w.connsLock.Lock()
+	defer w.connsLock.Unlock()
+	if open {
+		w.conns[ws] = true
+	} else {
+		delete(w.conns, ws)
+	}
+}
+
+// wsCompressor is implemented by the services that want their replies to be
+// compressed using the permessage-deflate extension of the websocket.
+type wsCompressor interface {
+	// compressionMinSize returns the minimum size of a reply to be
+	// compressed, or zero to disable compression.
+	compressionMinSize() int
+}
+
+// wsReadLimiter is implemented by the services that limit the size of the
+// messages they receive on the websocket.
+type wsReadLimiter interface {
+	// maxMessageSize returns the maximum size of a message, or zero for no
+	// limit.
+	maxMessageSize() int64
+}
+
+// wsConnectHook is implemented by the services that are told when their
+// websocket connections open and close.
+type wsConnectHook interface {
+	// onConnect is called with the request of a new connection, and
+	// rejects it with an error.
+	onConnect(r *http.Request) error
+	// onDisconnect is called with the request of a connection accepted by
+	// onConnect once it is closed.
+	onDisconnect(r *http.Request)
+}
+
+// wsStreamReleaser is implemented by the services that count the bytes of
+// the messages of their streams until they are taken to be written.
+type wsStreamReleaser interface {
+	// releaseStreamBytes is called with the size of each message taken
+	// from the channel of a stream.
+	releaseStreamBytes(n int)
+}
+
+// writeMessage writes the reply to the websocket, compressing it if the
+// client negotiated compression and the reply is at least minSize bytes.
+func writeMessage(ws *websocket.Conn, mt int, reply []byte, minSize int) error {
+	ws.EnableWriteCompression(minSize > 0 && len(reply) >= minSize)
+	return ws.WriteMessage(mt, reply)
+}
+
+// errShuttingDown ends the streams when the websocket stops.
+var errShuttingDown = xerrors.New("server is shutting down")
+
+// wsMessage is a message read from a websocket connection.
+type wsMessage struct {
+	mt  int
+	buf []byte
 }
 
 // Pass the request to the websocket.
 type wsHandler struct {
 	serviceName string
 	service     Service
+	webSocket   *WebSocket
 }
 
 // Wrapper-function so that http.Requests get 'upgraded' to websockets
@@ -245,9 +499,16 @@ func (t wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		log.Lvl2("ws close", r.RemoteAddr, "n", n, "rx", rx, "tx", tx)
 	}()
 
+	// The mobile app on iOS doesn't support compression well, so it is only
+	// enabled on demand of the service.
+	compressionMinSize := 0
+	if c, ok := t.service.(wsCompressor); ok {
+		compressionMinSize = c.compressionMinSize()
+	}
+
 	u := websocket.Upgrader{
-		// The mobile app on iOS doesn't support compression well...
-		EnableCompression: false,
+		HandshakeTimeout:  t.webSocket.UpgradeTimeout,
+		EnableCompression: compressionMinSize > 0,
 		// As the website will not be served from ourselves, we
 		// need to accept _all_ origins. Cross-site scripting is
 		// required.
@@ -255,21 +516,70 @@ func (t wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return true
 		},
 	}
-	ws, err := u.Upgrade(w, r, http.Header{})
+	header := http.Header{}
+	header.Set(RequestIDHeader, ensureRequestID(r))
+	streamEnc := streamEncoding(r)
+	if streamEnc != "" {
+		header.Set(StreamEncodingHeader, streamEnc)
+	}
+	ws, err := u.Upgrade(w, r, header)
 	if err != nil {
 		log.Error(err)
 		return
 	}
 	defer ws.Close()
+	t.webSocket.trackConn(ws, true)
+	defer t.webSocket.trackConn(ws, false)
+	if l, ok := t.service.(wsReadLimiter); ok && l.maxMessageSize() > 0 {
+		ws.SetReadLimit(l.maxMessageSize())
+	}
+
+	if h, ok := t.service.(wsConnectHook); ok {
+		if cerr := h.onConnect(r); cerr != nil {
+			log.Lvlf2("ws connection %s from %s rejected: %v",
+				r.Header.Get(RequestIDHeader), r.RemoteAddr, cerr)
+			err = xerrors.Errorf("connection rejected: %w", cerr)
+		} else {
+			defer h.onDisconnect(r)
+		}
+	}
+
+	// The context of the request is cancelled as soon as the client
+	// closes the connection, so that the handlers can abort.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	// Only this goroutine reads from the connection, so that a closed
+	// connection is detected even while a request is being processed.
+	messages := make(chan wsMessage)
+	var readErr error
+	go func() {
+		defer close(messages)
+		for {
+			mt, buf, err := ws.ReadMessage()
+			if err != nil {
+				readErr = err
+				cancel()
+				return
+			}
+			select {
+			case messages <- wsMessage{mt, buf}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
 	// Loop for each message
 outerReadLoop:
 	for err == nil {
-		mt, buf, rerr := ws.ReadMessage()
-		if rerr != nil {
-			err = rerr
+		msg, ok := <-messages
+		if !ok {
+			err = readErr
 			break
 		}
+		mt, buf := msg.mt, msg.buf
 		rx += len(buf)
 		n++
 
@@ -277,7 +587,8 @@ outerReadLoop:
 		var reply []byte
 		var outChan chan []byte
 		path := strings.TrimPrefix(r.URL.Path, "/"+t.serviceName+"/")
-		log.Lvlf2("ws request from %s: %s/%s", r.RemoteAddr, t.serviceName, path)
+		log.Lvlf2("ws request %s from %s: %s/%s", r.Header.Get(RequestIDHeader),
+			r.RemoteAddr, t.serviceName, path)
 
 		isStreaming := false
 		bidirectionalStreamer, ok := s.(BidirectionalStreamer)
@@ -290,13 +601,18 @@ outerReadLoop:
 			}
 		}
 
+		var clientInputs chan []byte
+		var tun *StreamingTunnel
 		if !isStreaming {
-			reply, _, err = s.ProcessClientRequest(r, path, buf)
+			reply, tun, err = s.ProcessClientRequest(r, path, buf)
 			if err != nil {
 				log.Errorf("Got an error while executing %s/%s: %+v",
 					t.serviceName, path, err)
 				continue
 			}
+		}
+
+		if !isStreaming && tun == nil {
 
 			tx += len(reply)
 			err = ws.SetWriteDeadline(time.Now().Add(5 * time.Minute))
@@ -306,7 +622,7 @@ outerReadLoop:
 				break
 			}
 
-			err = ws.WriteMessage(mt, reply)
+			err = writeMessage(ws, mt, reply, compressionMinSize)
 			if err != nil {
 				log.Error(xerrors.Errorf("failed to write message with "+
 					"request %s/%s: %v", t.serviceName, path, err))
@@ -316,58 +632,136 @@ outerReadLoop:
 			continue
 		}
 
-		clientInputs := make(chan []byte, 10)
-		clientInputs <- buf
-		outChan, err = bidirectionalStreamer.ProcessClientStreamRequest(r,
-			path, clientInputs)
-		if err != nil {
-			log.Errorf("got an error while processing streaming "+
-				"request %s/%s: %+v", t.serviceName, path, err)
-			continue
+		var releaser wsStreamReleaser
+		// stopTunnel tells the handler of a tunnel to stop, once the client
+		// went away or the websocket stops.
+		var stopTunnel func()
+		if tun != nil {
+			// The handler chose to stream its reply. The next messages of
+			// the client are ignored.
+			clientInputs = make(chan []byte, 10)
+			outChan = tun.out
+			var once sync.Once
+			closeChan := tun.close
+			stopTunnel = func() { once.Do(func() { close(closeChan) }) }
+			go func(inputs chan []byte, stop func()) {
+				for range inputs {
+				}
+				stop()
+			}(clientInputs, stopTunnel)
+		} else {
+			clientInputs = make(chan []byte, 10)
+			clientInputs <- buf
+			outChan, err = bidirectionalStreamer.ProcessClientStreamRequest(r,
+				path, clientInputs)
+			if err != nil {
+				log.Errorf("got an error while processing streaming "+
+					"request %s/%s: %+v", t.serviceName, path, err)
+				continue
+			}
+			releaser, _ = s.(wsStreamReleaser)
+		}
+		if releaser != nil {
+			// The messages left in outChan when the loop stops are
+			// released as the stream closes it.
+			defer func(outChan chan []byte) {
+				go func() {
+					for reply := range outChan {
+						releaser.releaseStreamBytes(len(reply))
+					}
+				}()
+			}(outChan)
 		}
 
+		streamDone := make(chan struct{})
+		defer close(streamDone)
+		shutdown := t.webSocket.trackStream(streamDone)
+		// drainTimeout is set once the websocket stops, to give up on the
+		// handler if it doesn't stop in time.
+		var drainTimeout <-chan time.Time
+
+		// stopInputs is closed when the loop stops, whatever the reason, so
+		// that clientInputs is closed and the stream is told to stop.
+		stopInputs := make(chan struct{})
+		defer close(stopInputs)
 		closing := make(chan bool)
-		go func() {
-			for {
-				// Listen for incoming messages to know if the client wants to
-				// close the stream. If this is an error, we assume the client
-				// wants to close the stream, otherwise we forward the message
-				// to the service.
-				_, buf, err := ws.ReadMessage()
-				if err != nil {
-					close(closing)
+		go func(inputs chan []byte) {
+			// Listen for incoming messages to know if the client wants to
+			// close the stream. If the connection is closed, we assume the
+			// client wants to close the stream, otherwise we forward the
+			// message to the service. This goroutine is the only sender
+			// of inputs, so it closes it.
+			defer close(inputs)
+			for msg := range messages {
+				select {
+				case inputs <- msg.buf:
+				case <-stopInputs:
 					return
 				}
-				clientInputs <- buf
 			}
-		}()
+			close(closing)
+		}(clientInputs)
 
 		for {
 			select {
 			case <-closing:
-				close(clientInputs)
+				if drainTimeout != nil {
+					closing = nil
+					continue
+				}
+				break outerReadLoop
+			case <-shutdown:
+				// The request context tells the handler to stop, and the
+				// messages it sends until it does are still forwarded.
+				cancel()
+				if stopTunnel != nil {
+					stopTunnel()
+				}
+				shutdown = nil
+				timeout := t.webSocket.StreamDrainTimeout
+				if timeout == 0 {
+					timeout = DefaultStreamDrainTimeout
+				}
+				drainTimeout = time.After(timeout)
+			case <-drainTimeout:
+				err = errShuttingDown
 				break outerReadLoop
 			case reply, ok := <-outChan:
 				if !ok {
 					err = xerrors.New("service finished streaming")
-					close(clientInputs)
+					if drainTimeout != nil {
+						err = errShuttingDown
+					}
 					break outerReadLoop
 				}
+				if releaser != nil {
+					releaser.releaseStreamBytes(len(reply))
+				}
+				// An already compressed message doesn't need the
+				// permessage-deflate of the websocket.
+				minSize := compressionMinSize
+				if streamEnc != "" {
+					reply, err = compress(streamEnc, reply)
+					if err != nil {
+						log.Error(xerrors.Errorf("failed to compress next "+
+							"message in the streaming loop: %v", err))
+						break outerReadLoop
+					}
+					minSize = 0
+				}
 				tx += len(reply)
 
 				err = ws.SetWriteDeadline(time.Now().Add(5 * time.Minute))
 				if err != nil {
 					log.Error(xerrors.Errorf("failed to set the write "+
 						"deadline in the streaming loop: %v", err))
-					close(clientInputs)
 					break outerReadLoop
 				}
 
-				err = ws.WriteMessage(mt, reply)
+				err = writeMessage(ws, mt, reply, minSize)
 				if err != nil {
 					log.Error(xerrors.Errorf("failed to write next message "+
 						"in the streaming loop: %v", err))
-					close(clientInputs)
 					break outerReadLoop
 				}
 			}
@@ -376,12 +770,22 @@ outerReadLoop:
 	}
 
 	errMessage := "unexpected error: "
+	errCode := websocket.CloseProtocolError
 	if err != nil {
 		errMessage += err.Error()
+		if code, ok := statusErrorCode(err); ok {
+			errCode = 4000 + code
+		} else if err == websocket.ErrReadLimit {
+			errCode = websocket.CloseMessageTooBig
+		} else if err == errShuttingDown {
+			errCode = websocket.CloseGoingAway
+		} else if isPanicError(err) {
+			errCode = websocket.CloseInternalServerErr
+		}
 	}
 
 	ws.WriteControl(websocket.CloseMessage,
-		websocket.FormatCloseMessage(websocket.CloseProtocolError, errMessage),
+		websocket.FormatCloseMessage(errCode, errMessage),
 		time.Now().Add(time.Millisecond*500))
 	return
 }
@@ -400,6 +804,13 @@ type Client struct {
 	suite           network.Suite
 	// if not nil, use TLS
 	TLSClientConfig *tls.Config
+	// whether to negotiate the compression of the messages with the server
+	EnableCompression bool
+	// whether to ask the server to compress each message of the streams,
+	// see StreamEncodingHeader
+	StreamCompression bool
+	// the encoding of the messages of the streams of each connection
+	streamEncodings map[destination]string
 	// whether to keep the connection
 	keep bool
 	rx   uint64
@@ -414,6 +825,7 @@ func NewClient(suite network.Suite, s string) *Client {
 		service:         s,
 		connections:     make(map[destination]*websocket.Conn),
 		connectionsLock: make(map[destination]*sync.Mutex),
+		streamEncodings: make(map[destination]string),
 		suite:           suite,
 	}
 }
@@ -466,6 +878,7 @@ func (c *Client) newConnIfNotExist(dst *network.ServerIdentity, path string) (*w
 	if !connected {
 		d := &websocket.Dialer{}
 		d.TLSClientConfig = c.TLSClientConfig
+		d.EnableCompression = c.EnableCompression
 
 		var serverURL string
 		var header http.Header
@@ -513,9 +926,14 @@ func (c *Client) newConnIfNotExist(dst *network.ServerIdentity, path string) (*w
 			header = http.Header{"Origin": []string{protocol + "://" + hp}}
 		}
 
+		if c.StreamCompression {
+			header.Set(StreamEncodingHeader, strings.Join(supportedEncodings, ", "))
+		}
+
 		// Re-try to connect in case the websocket is just about to start
+		var resp *http.Response
 		for a := 0; a < network.MaxRetryConnect; a++ {
-			conn, _, err = d.Dial(serverURL, header)
+			conn, resp, err = d.Dial(serverURL, header)
 			if err == nil {
 				break
 			}
@@ -527,6 +945,7 @@ func (c *Client) newConnIfNotExist(dst *network.ServerIdentity, path string) (*w
 		}
 		c.Lock()
 		c.connections[dest] = conn
+		c.streamEncodings[dest] = resp.Header.Get(StreamEncodingHeader)
 		c.Unlock()
 	}
 	return conn, connLock, nil
@@ -783,10 +1202,12 @@ func (c *Client) SendProtobufParallel(nodes []*network.ServerIdentity, msg inter
 }
 
 // StreamingConn allows clients to read from it without sending additional
-// requests.
+// requests, and to send more messages to the bidirectional streaming handlers.
 type StreamingConn struct {
 	conn  *websocket.Conn
 	suite network.Suite
+	// encoding of the messages, empty if they are not compressed
+	encoding string
 }
 
 // ReadMessage read more data from the connection, it will block if there are
@@ -801,6 +1222,12 @@ func (c *StreamingConn) ReadMessage(ret interface{}) error {
 	if err != nil {
 		return xerrors.Errorf("connection read: %v", err)
 	}
+	if c.encoding != "" {
+		buf, err = decompress(c.encoding, buf)
+		if err != nil {
+			return xerrors.Errorf("decompressing: %v", err)
+		}
+	}
 	err = protobuf.DecodeWithConstructors(buf, ret, network.DefaultConstructors(c.suite))
 	if err != nil {
 		return xerrors.Errorf("decoding: %v", err)
@@ -808,6 +1235,23 @@ func (c *StreamingConn) ReadMessage(ret interface{}) error {
 	return nil
 }
 
+// WriteMessage sends msg to the handler of the stream, which must have been
+// registered with RegisterBidirectionalStreamingHandler. It must not be called
+// concurrently.
+func (c *StreamingConn) WriteMessage(msg interface{}) error {
+	buf, err := protobuf.Encode(msg)
+	if err != nil {
+		return xerrors.Errorf("encoding: %v", err)
+	}
+	if err := c.conn.SetWriteDeadline(time.Now().Add(5 * time.Minute)); err != nil {
+		return xerrors.Errorf("write deadline: %v", err)
+	}
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, buf); err != nil {
+		return xerrors.Errorf("connection write: %v", err)
+	}
+	return nil
+}
+
 // Stream will send a request to start streaming, it returns a connection where
 // the client can continue to read values from it.
 func (c *Client) Stream(dst *network.ServerIdentity, msg interface{}) (StreamingConn, error) {
@@ -828,8 +1272,9 @@ func (c *Client) Stream(dst *network.ServerIdentity, msg interface{}) (Streaming
 	}
 	c.Lock()
 	c.tx += uint64(len(buf))
+	encoding := c.streamEncodings[destination{dst, path}]
 	c.Unlock()
-	return StreamingConn{conn, c.Suite()}, nil
+	return StreamingConn{conn, c.Suite(), encoding}, nil
 }
 
 // SendToAll sends a message to all ServerIdentities of the Roster and returns
@@ -880,6 +1325,7 @@ func (c *Client) closeConn(dst destination) error {
 	conn, ok := c.connections[dst]
 	if ok {
 		delete(c.connections, dst)
+		delete(c.streamEncodings, dst)
 		err := conn.WriteMessage(websocket.CloseMessage,
 			websocket.FormatCloseMessage(websocket.CloseNormalClosure, "client closed"))
 		if err != nil {
@@ -2,6 +2,7 @@ package onet
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -16,7 +17,9 @@ import (
 	"net/url"
 	"os"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -150,6 +153,7 @@ func TestNewWebSocket(t *testing.T) {
 	require.Equal(t, len(c.serviceManager.services), len(c.WebSocket.services))
 	require.NotEmpty(t, c.WebSocket.services[serviceWebSocket])
 	cl := NewClientKeep(tSuite, "WebSocket")
+	defer cl.Close()
 	req := &SimpleResponse{}
 	log.Lvlf1("Sending message Request: %x", uuid.UUID(network.MessageType(req)).Bytes())
 	buf, err := protobuf.Encode(req)
@@ -564,6 +568,156 @@ func TestWebSocketTLS_Error(t *testing.T) {
 	require.NotEqual(t, "", log.GetStdErr())
 }
 
+// TestWebSocket_ContextCancelled checks that the context given to a handler
+// is cancelled when the client closes the connection.
+func TestWebSocket_ContextCancelled(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+
+	serName := "contextService"
+	cancelled := make(chan bool, 1)
+	_, err := RegisterNewService(serName, func(c *Context) (Service, error) {
+		s := &ServiceWebSocket{ServiceProcessor: NewServiceProcessor(c)}
+		err := s.RegisterHandlerWithContext(func(ctx context.Context, msg *SimpleRequest) (network.Message, error) {
+			<-ctx.Done()
+			cancelled <- true
+			return nil, ctx.Err()
+		})
+		return s, err
+	})
+	require.NoError(t, err)
+	defer UnregisterService(serName)
+
+	servers := local.GenServers(1)
+	client := local.NewClientKeep(serName)
+	buf, err := protobuf.Encode(&SimpleRequest{Val: 1})
+	require.NoError(t, err)
+	conn, connLock, err := client.newConnIfNotExist(servers[0].ServerIdentity, "SimpleRequest")
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, buf))
+	connLock.Unlock()
+	require.NoError(t, client.Close())
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "context has not been cancelled")
+	}
+}
+
+// TestWebSocket_Compression checks that compressed replies are correctly
+// received by a client that negotiated the compression.
+func TestWebSocket_Compression(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+
+	serName := "compressionService"
+	_, err := RegisterNewService(serName, func(c *Context) (Service, error) {
+		s := &ServiceWebSocket{ServiceProcessor: NewServiceProcessor(c)}
+		s.CompressionMinSize = 16
+		err := s.RegisterHandler(func(msg *SimpleRequest) (*restMsgPOSTString, error) {
+			return &restMsgPOSTString{S: strings.Repeat("a", int(msg.Val))}, nil
+		})
+		return s, err
+	})
+	require.NoError(t, err)
+	defer UnregisterService(serName)
+
+	servers := local.GenServers(1)
+	client := local.NewClientKeep(serName)
+	client.EnableCompression = true
+	defer client.Close()
+
+	// Below and above the minimum size.
+	for _, size := range []int64{4, 1024} {
+		reply := &restMsgPOSTString{}
+		err = client.SendProtobuf(servers[0].ServerIdentity, &SimpleRequest{Val: size}, reply)
+		require.NoError(t, err)
+		require.Equal(t, int(size), len(reply.S))
+	}
+}
+
+// TestWebSocket_StatusError checks that the code of a StatusError is used to
+// close the connection.
+func TestWebSocket_StatusError(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+
+	serName := "statusErrorService"
+	_, err := RegisterNewService(serName, func(c *Context) (Service, error) {
+		s := &ServiceWebSocket{ServiceProcessor: NewServiceProcessor(c)}
+		err := s.RegisterHandler(func(msg *SimpleRequest) (*SimpleResponse, error) {
+			return nil, StatusError{Code: http.StatusNotFound, Msg: "unknown"}
+		})
+		return s, err
+	})
+	require.NoError(t, err)
+	defer UnregisterService(serName)
+
+	servers := local.GenServers(1)
+	client := local.NewClient(serName)
+	err = client.SendProtobuf(servers[0].ServerIdentity, &SimpleRequest{}, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "4404")
+}
+
+func TestWebSocket_MaxBodySize(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+
+	serName := "maxBodySizeService"
+	_, err := RegisterNewService(serName, func(c *Context) (Service, error) {
+		s := &ServiceWebSocket{ServiceProcessor: NewServiceProcessor(c)}
+		s.MaxBodySize = 1024
+		err := s.RegisterHandler(func(msg *SimpleRequest) (*SimpleResponse, error) {
+			return &SimpleResponse{Val: msg.Val}, nil
+		})
+		return s, err
+	})
+	require.NoError(t, err)
+	defer UnregisterService(serName)
+
+	servers := local.GenServers(1)
+	client := local.NewClient(serName)
+	_, err = client.Send(servers[0].ServerIdentity, "SimpleRequest", make([]byte, 2048))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "1009")
+
+	reply := &SimpleResponse{}
+	err = client.SendProtobuf(servers[0].ServerIdentity, &SimpleRequest{Val: 42}, reply)
+	require.NoError(t, err)
+	require.Equal(t, int64(42), reply.Val)
+}
+
+func TestWebSocket_UpgradeTimeout(t *testing.T) {
+	si := network.NewServerIdentity(tSuite.Point().Base(), "tcp://127.0.0.1:2070")
+	ws := NewWebSocket(si)
+	ws.UpgradeTimeout = 200 * time.Millisecond
+	go ws.start()
+	defer ws.stop()
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 10; i++ {
+		conn, err = net.Dial("tcp", "127.0.0.1:2071")
+		if err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The client stalls in the middle of its request.
+	_, err = conn.Write([]byte("GET /WebSocket/SimpleRequest HTTP/1.1\r\n"))
+	require.NoError(t, err)
+	start := time.Now()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = ioutil.ReadAll(conn)
+	require.NoError(t, err)
+	require.True(t, time.Since(start) < 3*time.Second)
+}
+
 // TestWebSocket_Streaming_normal reads all messages from the service
 func TestWebSocket_Streaming_normal(t *testing.T) {
 	local := NewTCPTest(tSuite)
@@ -766,6 +920,41 @@ func TestWebSocket_Streaming_early_client(t *testing.T) {
 
 }
 
+// TestWebSocket_Streaming_shutdown stops the server during a stream.
+func TestWebSocket_Streaming_shutdown(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+
+	serName := "streamingService"
+	serID, err := RegisterNewService(serName, newStreamingService)
+	require.NoError(t, err)
+	defer UnregisterService(serName)
+
+	servers, el, _ := local.GenTree(1, false)
+	services := local.GetServices(servers, serID)
+	serviceRoot := services[0].(*StreamingService)
+	serviceRoot.gotStopChan = make(chan bool, 1)
+
+	client := local.NewClientKeep(serName)
+	defer client.Close()
+	conn, err := client.Stream(servers[0].ServerIdentity, &SimpleRequest{
+		ServerIdentities: el,
+		Val:              100,
+	})
+	require.NoError(t, err)
+	var resp SimpleResponse
+	require.NoError(t, conn.ReadMessage(&resp))
+
+	start := time.Now()
+	servers[0].WebSocket.stop()
+	require.True(t, time.Since(start) < DefaultStreamDrainTimeout+time.Second)
+	select {
+	case <-serviceRoot.gotStopChan:
+	case <-time.After(time.Second):
+		require.Fail(t, "the handler should have been stopped")
+	}
+}
+
 // TestWebSocket_Streaming_Parallel_early_client
 func TestWebSocket_Streaming_Parallel_early_client2(t *testing.T) {
 	local := NewTCPTest(tSuite)
@@ -1140,3 +1329,47 @@ func (ss *StreamingService) StreamValues(msg *SimpleRequest) (chan *SimpleRespon
 	}()
 	return streamingChan, stopChan, nil
 }
+
+func TestWebSocket_ConnectHooks(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	server := local.GenServers(1)[0]
+	s := server.Service(serviceWebSocket).(*ServiceWebSocket)
+
+	connects := make(chan string, 2)
+	disconnects := make(chan string, 2)
+	reject := int32(1)
+	s.OnConnect = func(remoteAddr, principal string) error {
+		if atomic.LoadInt32(&reject) == 1 {
+			return StatusError{Code: http.StatusForbidden, Msg: "go away"}
+		}
+		connects <- remoteAddr
+		return nil
+	}
+	s.OnDisconnect = func(remoteAddr, principal string) {
+		disconnects <- remoteAddr
+	}
+
+	cl := NewClientKeep(tSuite, serviceWebSocket)
+	var reply SimpleResponse
+	err := cl.SendProtobuf(server.ServerIdentity, &SimpleResponse{Val: 1}, &reply)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "4403")
+	require.Contains(t, err.Error(), "go away")
+	cl.Close()
+	require.Empty(t, disconnects)
+
+	atomic.StoreInt32(&reject, 0)
+	cl = NewClientKeep(tSuite, serviceWebSocket)
+	require.NoError(t, cl.SendProtobuf(server.ServerIdentity, &SimpleResponse{Val: 1}, &reply))
+	require.Equal(t, int64(2), reply.Val)
+	addr := <-connects
+	require.NotEmpty(t, addr)
+	require.NoError(t, cl.Close())
+	select {
+	case a := <-disconnects:
+		require.Equal(t, addr, a)
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnDisconnect was not called")
+	}
+}